@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/handler"
+	"github.com/spf13/cobra"
+)
+
+func buildBreakerCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "breaker",
+		Short: "mint circuit breaker commands",
+	}
+	cmd.AddCommand(buildBreakerStatusCmd())
+	cmd.AddCommand(buildBreakerPauseCmd())
+	cmd.AddCommand(buildBreakerResumeCmd())
+	return cmd
+}
+
+func buildBreakerStatusCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "show circuit breaker state",
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			return handler.InterceptConfigsPreRunHandler(cmd)
+		},
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return handler.HandleBreakerStatusCmd(GetServerContextFromCmd(cmd), cmd)
+		},
+	}
+}
+
+func buildBreakerPauseCmd() *cobra.Command {
+	var reason string
+	cmd := &cobra.Command{
+		Use:   "pause",
+		Short: "pause minting until explicitly resumed",
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			return handler.InterceptConfigsPreRunHandler(cmd)
+		},
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return handler.HandleBreakerPauseCmd(GetServerContextFromCmd(cmd), cmd, reason)
+		},
+	}
+	cmd.Flags().StringVar(&reason, "reason", "manual pause", "pause reason")
+	return cmd
+}
+
+func buildBreakerResumeCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "resume",
+		Short: "resume minting",
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			return handler.InterceptConfigsPreRunHandler(cmd)
+		},
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return handler.HandleBreakerResumeCmd(GetServerContextFromCmd(cmd), cmd)
+		},
+	}
+}
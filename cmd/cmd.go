@@ -33,6 +33,11 @@ func rootCmd() *cobra.Command {
 
 	rootCmd.CompletionOptions.DisableDefaultCmd = true
 	rootCmd.AddCommand(buildIndexCmd())
+	rootCmd.AddCommand(buildDepositCmd())
+	rootCmd.AddCommand(buildWithdrawCmd())
+	rootCmd.AddCommand(buildBreakerCmd())
+	rootCmd.AddCommand(buildReconcileCmd())
+	rootCmd.AddCommand(buildReplayCmd())
 	return rootCmd
 }
 
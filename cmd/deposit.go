@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/handler"
+	"github.com/spf13/cobra"
+)
+
+func buildDepositCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "deposit",
+		Short: "deposit operator commands",
+	}
+	cmd.AddCommand(buildDepositHeldCmd())
+	cmd.AddCommand(buildDepositReleaseCmd())
+	cmd.AddCommand(buildDepositEventsCmd())
+	return cmd
+}
+
+func buildDepositHeldCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "held",
+		Short: "list deposits held by the deposit policy",
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			return handler.InterceptConfigsPreRunHandler(cmd)
+		},
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return handler.HandleDepositHeldCmd(GetServerContextFromCmd(cmd), cmd)
+		},
+	}
+}
+
+func buildDepositReleaseCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "release [btc_tx_hash]",
+		Short: "release a held deposit after review, it will be minted in the next round",
+		Args:  cobra.ExactArgs(1),
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			return handler.InterceptConfigsPreRunHandler(cmd)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return handler.HandleDepositReleaseCmd(GetServerContextFromCmd(cmd), cmd, args[0])
+		},
+	}
+}
+
+func buildDepositEventsCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "events [btc_tx_hash]",
+		Short: "list the audit trail of a deposit: status transitions, broadcast txs and receipts",
+		Args:  cobra.ExactArgs(1),
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			return handler.InterceptConfigsPreRunHandler(cmd)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return handler.HandleDepositEventsCmd(GetServerContextFromCmd(cmd), cmd, args[0])
+		},
+	}
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/handler"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/logic/indexer"
+	"github.com/spf13/cobra"
+)
+
+func buildReconcileCmd() *cobra.Command {
+	var (
+		from   string
+		to     string
+		window time.Duration
+		format string
+		output string
+	)
+	cmd := &cobra.Command{
+		Use:   "reconcile",
+		Short: "reconcile deposit history, rollup deposit history and on-chain mint events",
+		Long: "reconcile deposits created in the window against rollup deposit events and on-chain mint events, " +
+			"report missing mints, double mints, amount mismatches and orphan rollup events, exit non-zero on findings",
+		SilenceUsage: true,
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			return handler.InterceptConfigsPreRunHandler(cmd)
+		},
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			end := time.Now()
+			if to != "" {
+				t, err := time.Parse(time.RFC3339, to)
+				if err != nil {
+					return fmt.Errorf("invalid --to: %w", err)
+				}
+				end = t
+			}
+			start := end.Add(-window)
+			if from != "" {
+				t, err := time.Parse(time.RFC3339, from)
+				if err != nil {
+					return fmt.Errorf("invalid --from: %w", err)
+				}
+				start = t
+			}
+			return handler.HandleReconcileCmd(GetServerContextFromCmd(cmd), cmd, start, end, format, output)
+		},
+	}
+	cmd.Flags().StringVar(&from, "from", "", "window start, RFC3339, default to minus window")
+	cmd.Flags().StringVar(&to, "to", "", "window end, RFC3339, default now")
+	cmd.Flags().DurationVar(&window, "window", 24*time.Hour, "window length when --from is not set")
+	cmd.Flags().StringVar(&format, "format", indexer.ReconcileFormatJSON, "report format, json or csv")
+	cmd.Flags().StringVar(&output, "output", "", "report file, default stdout")
+	return cmd
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/handler"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+func buildReplayCmd() *cobra.Command {
+	var (
+		file       string
+		db         string
+		start      string
+		startBlock int64
+	)
+	cmd := &cobra.Command{
+		Use:   "replay",
+		Short: "replay recorded rpc traffic through the indexer and bridge deposit services",
+		Long: "run the indexer and bridge deposit services against the rpc traffic recorded with BITCOIN_RPC_RECORD_FILE, " +
+			"with a fake clock and without a live node, write the resulting deposits as json lines, " +
+			"exit non-zero when the services did not replay the whole recording",
+		SilenceUsage: true,
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			return handler.InterceptReplayConfigsPreRunHandler(cmd, db)
+		},
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			begin := time.Now()
+			if start != "" {
+				t, err := time.Parse(time.RFC3339, start)
+				if err != nil {
+					return fmt.Errorf("invalid --start: %w", err)
+				}
+				begin = t
+			}
+			return handler.HandleReplayCmd(GetServerContextFromCmd(cmd), cmd, file, begin, startBlock)
+		},
+	}
+	cmd.Flags().StringVar(&file, "file", "", "recorded rpc traffic file")
+	cmd.Flags().StringVar(&db, "db", storage.SQLiteScheme+":memory:", "database source the replay writes to")
+	cmd.Flags().StringVar(&start, "start", "", "fake clock start, RFC3339, default now")
+	cmd.Flags().Int64Var(&startBlock, "start-block", -1,
+		"btc index block the recording started from, logged as btc_index_block by the indexer, default the latest recorded block")
+	_ = cmd.MarkFlagRequired("file")
+	return cmd
+}
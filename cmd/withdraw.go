@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/handler"
+	"github.com/spf13/cobra"
+)
+
+func buildWithdrawCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "withdraw",
+		Short: "withdraw co-signer and operator commands",
+	}
+	cmd.AddCommand(buildWithdrawUnsignedCmd())
+	cmd.AddCommand(buildWithdrawSubmitCmd())
+	cmd.AddCommand(buildWithdrawHeldCmd())
+	cmd.AddCommand(buildWithdrawReleaseCmd())
+	cmd.AddCommand(buildWithdrawStuckPayoutsCmd())
+	cmd.AddCommand(buildWithdrawRetryPayoutCmd())
+	cmd.AddCommand(buildWithdrawRegisterAddressCmd())
+	return cmd
+}
+
+func buildWithdrawUnsignedCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "unsigned",
+		Short: "list withdraw txs waiting for signatures, with the psbt to sign",
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			return handler.InterceptConfigsPreRunHandler(cmd)
+		},
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return handler.HandleWithdrawUnsignedCmd(GetServerContextFromCmd(cmd), cmd)
+		},
+	}
+}
+
+func buildWithdrawSubmitCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "submit [signed_psbt]",
+		Short: "submit a base64 psbt with the partial signatures of a co-signer",
+		Args:  cobra.ExactArgs(1),
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			return handler.InterceptConfigsPreRunHandler(cmd)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return handler.HandleWithdrawSubmitCmd(GetServerContextFromCmd(cmd), cmd, args[0])
+		},
+	}
+}
+
+func buildWithdrawHeldCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "held",
+		Short: "list withdraws held over the batch limits or waiting for an abelian address, with the reason",
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			return handler.InterceptConfigsPreRunHandler(cmd)
+		},
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return handler.HandleWithdrawHeldCmd(GetServerContextFromCmd(cmd), cmd)
+		},
+	}
+}
+
+func buildWithdrawReleaseCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "release [b2_tx_hash]",
+		Short: "release a held withdraw after review, raise the batch max value first or it is held again",
+		Args:  cobra.ExactArgs(1),
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			return handler.InterceptConfigsPreRunHandler(cmd)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return handler.HandleWithdrawReleaseCmd(GetServerContextFromCmd(cmd), cmd, args[0])
+		},
+	}
+}
+
+func buildWithdrawStuckPayoutsCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "stuck-payouts",
+		Short: "list abelian payouts stuck past their deadline or with an unknown transfer outcome, with the reason",
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			return handler.InterceptConfigsPreRunHandler(cmd)
+		},
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return handler.HandleWithdrawStuckPayoutsCmd(GetServerContextFromCmd(cmd), cmd)
+		},
+	}
+}
+
+func buildWithdrawRetryPayoutCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "retry-payout [idempotency_key]",
+		Short: "transfer a stuck abelian payout again, check the wallet dropped the previous transfer first",
+		Args:  cobra.ExactArgs(1),
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			return handler.InterceptConfigsPreRunHandler(cmd)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return handler.HandleWithdrawRetryPayoutCmd(GetServerContextFromCmd(cmd), cmd, args[0])
+		},
+	}
+}
+
+func buildWithdrawRegisterAddressCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "register-address [l2_address] [abel_address] [nonce] [signature]",
+		Short: "register the abelian withdraw address of an l2 address, signed by the l2 address with personal_sign",
+		Long: "register the abelian withdraw address of an l2 address. The l2 address signs with personal_sign the message\n" +
+			"  register abelian withdraw address <abel_address> for <checksummed l2_address>, nonce <nonce>\n" +
+			"the nonce is above the one of the previous registration.",
+		Args: cobra.ExactArgs(4),
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			return handler.InterceptConfigsPreRunHandler(cmd)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return handler.HandleWithdrawRegisterAddressCmd(GetServerContextFromCmd(cmd), cmd, args[0], args[1], args[2], args[3])
+		},
+	}
+}
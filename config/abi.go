@@ -951,3 +951,31 @@ var DefaultDepositAbi = `
     }
   ]
 `
+
+// BridgeEventsAbi events of the l1 -> l2 bridge contract, their hashes are configured by BITCOIN_BRIDGE_DEPOSIT
+// and BITCOIN_BRIDGE_WITHDRAW
+var BridgeEventsAbi = `
+[
+    {
+      "anonymous": false,
+      "inputs": [
+        {"indexed": true, "internalType": "address", "name": "caller", "type": "address"},
+        {"indexed": true, "internalType": "address", "name": "toAddress", "type": "address"},
+        {"indexed": false, "internalType": "uint256", "name": "amount", "type": "uint256"},
+        {"indexed": false, "internalType": "bytes32", "name": "txHash", "type": "bytes32"}
+      ],
+      "name": "DepositEvent",
+      "type": "event"
+    },
+    {
+      "anonymous": false,
+      "inputs": [
+        {"indexed": true, "internalType": "address", "name": "fromAddress", "type": "address"},
+        {"indexed": false, "internalType": "string", "name": "toAddress", "type": "string"},
+        {"indexed": false, "internalType": "uint256", "name": "amount", "type": "uint256"}
+      ],
+      "name": "WithdrawEvent",
+      "type": "event"
+    }
+  ]
+`
@@ -1,7 +1,13 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
 	"github.com/caarlos0/env/v6"
 )
 
@@ -37,9 +43,22 @@ type AppConfig struct {
 	IndexerListenAddress string `env:"BITCOIN_INDEXER_LISTEN_ADDRESS"`
 	// IndexerListenTargetConfirmations defines the number of confirmations to listen on
 	IndexerListenTargetConfirmations uint64 `env:"BITCOIN_INDEXER_LISTEN_TARGET_CONFIRMATIONS" envDefault:"1"`
+	// IndexerListenRoutes defines the watched addresses and their routes, json array, see ListenRoute
+	IndexerListenRoutes string `env:"BITCOIN_INDEXER_LISTEN_ROUTES"`
+	// IndexerNotifyWSURL defines the abec websocket url for block notifications, empty polls for new blocks
+	IndexerNotifyWSURL string `env:"BITCOIN_INDEXER_NOTIFY_WS_URL"`
+	// RPCRecordFile defines the file the abec, vault wallet, rollup and aa rpc traffic is appended to, empty disables recording
+	RPCRecordFile string `env:"BITCOIN_RPC_RECORD_FILE"`
+	// VaultWallet defines the vault wallet rpc used to verify the received deposit value
+	VaultWallet VaultWalletConfig
+	// Mempool defines the mempool watcher for early deposit detection
+	Mempool MempoolConfig
 
 	// Bridge 配置
 	Bridge BridgeConfig
+
+	// HTTP 配置
+	HTTP HTTPConfig
 }
 
 // Config is the global config.
@@ -77,6 +96,16 @@ type BitcoinConfig struct {
 	IndexerListenAddress string `env:"BITCOIN_INDEXER_LISTEN_ADDRESS"`
 	// IndexerListenTargetConfirmations defines the number of confirmations to listen on
 	IndexerListenTargetConfirmations uint64 `env:"BITCOIN_INDEXER_LISTEN_TARGET_CONFIRMATIONS" envDefault:"1"`
+	// IndexerListenRoutes defines the watched addresses and their routes, json array, see ListenRoute
+	IndexerListenRoutes string `env:"BITCOIN_INDEXER_LISTEN_ROUTES"`
+	// IndexerNotifyWSURL defines the abec websocket url for block notifications, empty polls for new blocks
+	IndexerNotifyWSURL string `env:"BITCOIN_INDEXER_NOTIFY_WS_URL"`
+	// RPCRecordFile defines the file the abec, vault wallet, rollup and aa rpc traffic is appended to, empty disables recording
+	RPCRecordFile string `env:"BITCOIN_RPC_RECORD_FILE"`
+	// VaultWallet defines the vault wallet rpc used to verify the received deposit value
+	VaultWallet VaultWalletConfig
+	// Mempool defines the mempool watcher for early deposit detection
+	Mempool MempoolConfig
 	// Bridge defines the bridge config
 	Bridge BridgeConfig
 }
@@ -104,8 +133,30 @@ type BridgeConfig struct {
 	Deposit string `env:"BITCOIN_BRIDGE_DEPOSIT"`
 	// Withdraw defines the withdraw event hash
 	Withdraw string `env:"BITCOIN_BRIDGE_WITHDRAW"`
+	// BurnWAbel defines the wabel burn event hash, empty disables the abelian withdraws
+	BurnWAbel string `env:"BITCOIN_BRIDGE_BURN_WABEL"`
+	// Events defines the abi events of the listened contracts indexed into evm_events, empty disables the event indexer
+	Events []string `env:"BITCOIN_BRIDGE_EVENTS"`
+	// EventABIFile defines the abi file the indexed events are decoded by, empty uses the bridge abi
+	EventABIFile string `env:"BITCOIN_BRIDGE_EVENT_ABI_FILE"`
 	// UnisatApiKey defines unisat api_key
 	UnisatAPIKey string `env:"BITCOIN_BRIDGE_UNISAT_API_KEY"`
+	// UnisatURL defines the unisat api url, empty uses the public api of the network
+	UnisatURL string `env:"BITCOIN_BRIDGE_UNISAT_URL"`
+	// MempoolURL defines the mempool.space api url, empty uses the public api of the network
+	MempoolURL string `env:"BITCOIN_BRIDGE_MEMPOOL_URL"`
+	// UTXOSource defines the source of the vault utxos: unisat, rpc, local
+	UTXOSource string `env:"BITCOIN_BRIDGE_UTXO_SOURCE" envDefault:"unisat"`
+	// FeeSource defines the source of the withdraw fee rate: mempool, rpc, static
+	FeeSource string `env:"BITCOIN_BRIDGE_FEE_SOURCE" envDefault:"mempool"`
+	// StaticFeeRate defines the fee rate in sat/vbyte of the static fee source
+	StaticFeeRate int64 `env:"BITCOIN_BRIDGE_STATIC_FEE_RATE" envDefault:"1"`
+	// Broadcaster defines where the signed withdraw txs are sent: rpc, mempool
+	Broadcaster string `env:"BITCOIN_BRIDGE_BROADCASTER" envDefault:"rpc"`
+	// CoinSelection defines how the vault utxos of a withdraw tx are picked: bnb, largest-first
+	CoinSelection string `env:"BITCOIN_BRIDGE_COIN_SELECTION" envDefault:"bnb"`
+	// MaxInputs defines the max vault utxos spent by one withdraw tx, 0 means no limit
+	MaxInputs int `env:"BITCOIN_BRIDGE_WITHDRAW_MAX_INPUTS" envDefault:"100"`
 	// PublicKeys defines signer publickey
 	PublicKeys []string `env:"BITCOIN_BRIDGE_PUBLICKEYS"`
 	// TimeInterval defines withdraw time interval
@@ -114,6 +165,182 @@ type BridgeConfig struct {
 	MultisigNum int `env:"BITCOIN_BRIDGE_MULTISIG_NUM"`
 	// EnableRollupListener defines rollup index server
 	EnableRollupListener bool `env:"BITCOIN_BRIDGE_ROLLUP_ENABLE_LISTENER"`
+	// SourceDecimals defines the decimals of the source chain amount, the unit of deposit btc_value
+	SourceDecimals uint8 `env:"BITCOIN_BRIDGE_SOURCE_DECIMALS" envDefault:"7"`
+	// TargetDecimals defines the decimals of the minted token
+	TargetDecimals uint8 `env:"BITCOIN_BRIDGE_TARGET_DECIMALS" envDefault:"18"`
+	// DepositPolicy defines the deposit limits, fee and rate limiting policy
+	DepositPolicy DepositPolicyConfig
+	// CircuitBreaker defines the mint circuit breaker
+	CircuitBreaker CircuitBreakerConfig
+	// Reconcile defines the periodic reconciliation job
+	Reconcile ReconcileConfig
+	// AttemptWatchInterval defines the interval in seconds to check the receipts of every broadcast mint tx, 0 disables the watcher
+	AttemptWatchInterval int64 `env:"BITCOIN_BRIDGE_ATTEMPT_WATCH_INTERVAL" envDefault:"30"`
+	// SignerAPI defines the api the withdraw co-signers sign through
+	SignerAPI SignerAPIConfig
+	// Vault defines the scan of the vault utxos for the local utxo source
+	Vault VaultConfig
+	// Batch defines which pending withdraws are paid by the next withdraw tx
+	Batch WithdrawBatchConfig
+	// Broadcast defines the confirmation and the fee bumping of the broadcast withdraw txs
+	Broadcast WithdrawBroadcastConfig
+	// AbelPayout defines the payout of the abelian withdraws
+	AbelPayout AbelPayoutConfig
+	// RollupScan defines how the rollup listener scans the b2 logs
+	RollupScan RollupScanConfig
+}
+
+// DepositPolicyConfig defines the policy evaluated before a deposit is minted.
+// Amounts are in source chain units, the same unit as deposit btc_value.
+// Each network runs its own deployment, so the policy is configured per network.
+type DepositPolicyConfig struct {
+	// Enable defines whether to evaluate the deposit policy
+	Enable bool `env:"BITCOIN_BRIDGE_DEPOSIT_POLICY_ENABLE"`
+	// MinAmount defines the minimum amount per deposit, 0 means no limit
+	MinAmount int64 `env:"BITCOIN_BRIDGE_DEPOSIT_POLICY_MIN_AMOUNT"`
+	// MaxAmount defines the maximum amount per deposit, 0 means no limit
+	MaxAmount int64 `env:"BITCOIN_BRIDGE_DEPOSIT_POLICY_MAX_AMOUNT"`
+	// AddressDailyCap defines the rolling 24h cap per from address, 0 means no limit
+	AddressDailyCap int64 `env:"BITCOIN_BRIDGE_DEPOSIT_POLICY_ADDRESS_DAILY_CAP"`
+	// GlobalDailyCap defines the rolling 24h cap for all deposits, 0 means no limit
+	GlobalDailyCap int64 `env:"BITCOIN_BRIDGE_DEPOSIT_POLICY_GLOBAL_DAILY_CAP"`
+	// FeeBasisPoints defines the bridge fee in basis points, deducted from the minted amount
+	FeeBasisPoints int64 `env:"BITCOIN_BRIDGE_DEPOSIT_POLICY_FEE_BPS"`
+	// TreasuryAddress defines the l2 address credited with the bridge fee
+	TreasuryAddress string `env:"BITCOIN_BRIDGE_DEPOSIT_POLICY_TREASURY_ADDRESS"`
+}
+
+// CircuitBreakerConfig defines the anomaly checks that pause minting.
+// Once tripped, minting stays paused until an operator resumes it.
+type CircuitBreakerConfig struct {
+	// Enable defines whether to run the anomaly checks, manual pause works regardless
+	Enable bool `env:"BITCOIN_BRIDGE_BREAKER_ENABLE"`
+	// MintWindow defines the mint volume window in seconds
+	MintWindow int64 `env:"BITCOIN_BRIDGE_BREAKER_MINT_WINDOW" envDefault:"3600"`
+	// MintThreshold defines the max mint volume in the window, in source chain units, 0 means no limit
+	MintThreshold int64 `env:"BITCOIN_BRIDGE_BREAKER_MINT_THRESHOLD"`
+	// TokenAddress defines the minted token address, empty disables the supply check
+	TokenAddress string `env:"BITCOIN_BRIDGE_BREAKER_TOKEN_ADDRESS"`
+	// SupplyBaseline defines the token supply not minted by the bridge, in token units
+	SupplyBaseline string `env:"BITCOIN_BRIDGE_BREAKER_SUPPLY_BASELINE" envDefault:"0"`
+	// SupplyTolerance defines the allowed difference between token supply and ledger, in token units
+	SupplyTolerance string `env:"BITCOIN_BRIDGE_BREAKER_SUPPLY_TOLERANCE" envDefault:"0"`
+}
+
+// ReconcileConfig defines the periodic reconciliation between deposit history,
+// rollup deposit history and on-chain mint events.
+type ReconcileConfig struct {
+	// Interval defines the reconcile interval in seconds, 0 disables the periodic job
+	Interval int64 `env:"BITCOIN_BRIDGE_RECONCILE_INTERVAL"`
+	// Window defines how far back each periodic run looks, in seconds
+	Window int64 `env:"BITCOIN_BRIDGE_RECONCILE_WINDOW" envDefault:"86400"`
+}
+
+// VaultWalletConfig defines the abewallet rpc holding the view key of the listened addresses.
+// Abelian hides amounts on chain, only the vault wallet can tell the value actually received.
+type VaultWalletConfig struct {
+	// RPCHost defines the wallet rpc url, empty disables the received value verification
+	RPCHost string `env:"BITCOIN_VAULT_WALLET_RPC_HOST"`
+	// RPCUser defines the wallet rpc user
+	RPCUser string `env:"BITCOIN_VAULT_WALLET_RPC_USER"`
+	// RPCPass defines the wallet rpc password
+	RPCPass string `env:"BITCOIN_VAULT_WALLET_RPC_PASS"`
+}
+
+// MempoolConfig defines the mempool watcher. Deposits seen in the mempool are recorded for
+// status display only, they are minted after the tx is mined and confirmed.
+type MempoolConfig struct {
+	// Enable defines whether to scan the mempool
+	Enable bool `env:"BITCOIN_INDEXER_MEMPOOL_ENABLE"`
+	// Interval defines the scan interval in seconds
+	Interval int64 `env:"BITCOIN_INDEXER_MEMPOOL_INTERVAL" envDefault:"10"`
+	// Expiry defines how long a deposit dropped from the mempool is kept before it expires, in seconds
+	Expiry int64 `env:"BITCOIN_INDEXER_MEMPOOL_EXPIRY" envDefault:"3600"`
+}
+
+// VaultConfig defines how the withdraw vault utxo set is kept from the bitcoin blocks.
+// Only blocks with enough confirmations are scanned, so the set is not rolled back on reorgs.
+type VaultConfig struct {
+	// StartHeight defines the first block scanned, the block the vault was funded in
+	StartHeight int64 `env:"BITCOIN_BRIDGE_VAULT_START_HEIGHT"`
+	// Confirmations defines the confirmations of a block before it is scanned
+	Confirmations int64 `env:"BITCOIN_BRIDGE_VAULT_CONFIRMATIONS" envDefault:"6"`
+	// ScanInterval defines the scan interval in seconds
+	ScanInterval int64 `env:"BITCOIN_BRIDGE_VAULT_SCAN_INTERVAL" envDefault:"60"`
+}
+
+// WithdrawBatchConfig defines how the pending withdraws are batched into withdraw txs.
+// The oldest withdraws are batched first, a batch is built once it is full or its oldest withdraw waited MinAge.
+type WithdrawBatchConfig struct {
+	// MaxOutputs defines the max withdraw addresses paid by one tx, 0 means no limit
+	MaxOutputs int `env:"BITCOIN_BRIDGE_WITHDRAW_BATCH_MAX_OUTPUTS" envDefault:"100"`
+	// MaxValue defines the max total value paid by one tx in satoshis, 0 means no limit
+	MaxValue int64 `env:"BITCOIN_BRIDGE_WITHDRAW_BATCH_MAX_VALUE"`
+	// MinAge defines the seconds the oldest withdraw of a batch not full waits before the batch is built
+	MinAge int64 `env:"BITCOIN_BRIDGE_WITHDRAW_BATCH_MIN_AGE"`
+}
+
+// WithdrawBroadcastConfig defines how the broadcast withdraw txs are confirmed.
+// A tx not mined StuckTimeout after its broadcast is replaced by fee, the replacement
+// spends the same vault utxos at a higher fee rate and is signed by the co-signers again.
+type WithdrawBroadcastConfig struct {
+	// Confirmations defines the confirmations of a withdraw tx before its withdraws are settled
+	Confirmations int64 `env:"BITCOIN_BRIDGE_WITHDRAW_CONFIRMATIONS" envDefault:"6"`
+	// ConfirmInterval defines the interval in seconds to check the broadcast withdraw txs
+	ConfirmInterval int64 `env:"BITCOIN_BRIDGE_WITHDRAW_CONFIRM_INTERVAL" envDefault:"300"`
+	// StuckTimeout defines the seconds a broadcast tx stays unconfirmed before it is replaced, 0 disables replacing
+	StuckTimeout int64 `env:"BITCOIN_BRIDGE_WITHDRAW_STUCK_TIMEOUT" envDefault:"3600"`
+	// FeeBumpPercent defines the fee rate increase of a replacement in percent, at least 1 sat/vbyte
+	FeeBumpPercent int64 `env:"BITCOIN_BRIDGE_WITHDRAW_FEE_BUMP_PERCENT" envDefault:"25"`
+	// MaxFeeRate defines the highest fee rate in sat/vbyte a replacement pays, 0 means no limit
+	MaxFeeRate int64 `env:"BITCOIN_BRIDGE_WITHDRAW_MAX_FEE_RATE" envDefault:"200"`
+}
+
+// AbelPayoutConfig defines how the abelian withdraws are paid. Every BurnWAbel is paid by one transfer
+// of the vault wallet, so the wallet holds the spend key of the vault besides its view key.
+type AbelPayoutConfig struct {
+	// Enable defines whether to run the abelian payout service
+	Enable bool `env:"BITCOIN_BRIDGE_ABEL_PAYOUT_ENABLE"`
+	// WalletPass defines the passphrase unlocking the vault wallet before the transfers, empty when the wallet is unlocked
+	WalletPass string `env:"BITCOIN_BRIDGE_ABEL_PAYOUT_WALLET_PASS"`
+	// Confirmations defines the confirmations of a payout tx before its withdraw is settled
+	Confirmations int64 `env:"BITCOIN_BRIDGE_ABEL_PAYOUT_CONFIRMATIONS" envDefault:"6"`
+	// Interval defines the interval in seconds of the payout rounds
+	Interval int64 `env:"BITCOIN_BRIDGE_ABEL_PAYOUT_INTERVAL" envDefault:"30"`
+	// StuckTimeout defines the seconds a payout tx stays unconfirmed or unknown to the node before it is
+	// marked stuck for the operator, 0 disables the deadline
+	StuckTimeout int64 `env:"BITCOIN_BRIDGE_ABEL_PAYOUT_STUCK_TIMEOUT" envDefault:"3600"`
+}
+
+// RollupScanConfig defines how the rollup listener scans the b2 logs. Only the blocks deeper than the
+// finality distance are scanned, in ranges halved while the node refuses them as too large.
+type RollupScanConfig struct {
+	// FinalityDistance defines the blocks below the b2 head before a block is scanned
+	FinalityDistance uint64 `env:"BITCOIN_BRIDGE_ROLLUP_FINALITY_DISTANCE" envDefault:"6"`
+	// MaxRange defines the max blocks of one log query, 0 queries the blocks one by one
+	MaxRange uint64 `env:"BITCOIN_BRIDGE_ROLLUP_SCAN_MAX_RANGE" envDefault:"1000"`
+}
+
+// SignerAPIConfig defines the http api the withdraw co-signers fetch unsigned psbts from and
+// submit partial signatures to. Every request is signed by one of the bridge PublicKeys.
+type SignerAPIConfig struct {
+	// Port defines the listen port, empty disables the api
+	Port string `env:"BITCOIN_BRIDGE_SIGNER_API_PORT"`
+	// AuthWindow defines the max difference between the request timestamp and the server clock, in seconds
+	AuthWindow int64 `env:"BITCOIN_BRIDGE_SIGNER_API_AUTH_WINDOW" envDefault:"300"`
+}
+
+// HTTPConfig defines the admin http server config
+type HTTPConfig struct {
+	// Enable defines whether to start the http server
+	Enable bool `env:"HTTP_ENABLE"`
+	// Port defines the http listen port
+	Port string `env:"HTTP_PORT" envDefault:"8080"`
+	// IPWhiteList defines the ip allowed to call the http server, comma separated, empty allows all
+	IPWhiteList string `env:"HTTP_IP_WHITE_LIST"`
+	// AdminToken defines the bearer token of the admin api, the admin api is not served without it
+	AdminToken string `env:"HTTP_ADMIN_TOKEN"`
 }
 
 const (
@@ -145,6 +372,17 @@ func LoadConfig() (*Config, error) {
 	return &config, nil
 }
 
+// LoadHTTPConfig 加载 HTTP 配置
+func LoadHTTPConfig() (*HTTPConfig, error) {
+	config := HTTPConfig{}
+
+	if err := env.Parse(&config); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
 // LoadBitcoinConfig 加载 Bitcoin 配置（保持向后兼容）
 func LoadBitcoinConfig() (*BitcoinConfig, error) {
 	config := BitcoinConfig{}
@@ -175,6 +413,32 @@ func ChainParams(network string) *chaincfg.Params {
 	}
 }
 
+// MultisigVault witness script and p2wsh address of the withdraw vault, MultisigNum of PublicKeys
+func (c BridgeConfig) MultisigVault(net *chaincfg.Params) ([]byte, btcutil.Address, error) {
+	pubKeys := make([]*btcutil.AddressPubKey, 0, len(c.PublicKeys))
+	for _, pubKey := range c.PublicKeys {
+		raw, err := hex.DecodeString(pubKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid public key %s: %w", pubKey, err)
+		}
+		address, err := btcutil.NewAddressPubKey(raw, net)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid public key %s: %w", pubKey, err)
+		}
+		pubKeys = append(pubKeys, address)
+	}
+	script, err := txscript.MultiSigScript(pubKeys, c.MultisigNum)
+	if err != nil {
+		return nil, nil, err
+	}
+	scriptHash := sha256.Sum256(script)
+	address, err := btcutil.NewAddressWitnessScriptHash(scriptHash[:], net)
+	if err != nil {
+		return nil, nil, err
+	}
+	return script, address, nil
+}
+
 func DefaultConfig() *Config {
 	return &Config{
 		RootDir:  "",
@@ -182,6 +446,12 @@ func DefaultConfig() *Config {
 	}
 }
 
+func DefaultHTTPConfig() *HTTPConfig {
+	return &HTTPConfig{
+		Port: "8080",
+	}
+}
+
 func DefaultBitcoinConfig() *BitcoinConfig {
 	return &BitcoinConfig{
 		EnableIndexer: false,
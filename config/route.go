@@ -0,0 +1,77 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	DefaultListenProtocol = "Mable"
+	DefaultMintMethod     = "mintWAbel"
+)
+
+// ListenRoute defines a watched vault address and where its deposits are minted.
+// The mint method must take the same arguments as mintWAbel(address,uint256,uint256).
+type ListenRoute struct {
+	// Address defines the watched vault address, memo.to
+	Address string `json:"address"`
+	// Protocol defines the accepted memo protocol
+	Protocol string `json:"protocol"`
+	// ContractAddress defines the contract minting the deposits
+	ContractAddress string `json:"contract_address"`
+	// MintMethod defines the contract mint method
+	MintMethod string `json:"mint_method"`
+}
+
+// ListenRoutes parse IndexerListenRoutes, falls back to a single route of
+// IndexerListenAddress and the bridge contract when no route is configured
+func (cfg *BitcoinConfig) ListenRoutes() ([]ListenRoute, error) {
+	if strings.TrimSpace(cfg.IndexerListenRoutes) == "" {
+		if cfg.IndexerListenAddress == "" {
+			return nil, fmt.Errorf("listen address and listen routes are both empty")
+		}
+		return []ListenRoute{{
+			Address:         cfg.IndexerListenAddress,
+			Protocol:        DefaultListenProtocol,
+			ContractAddress: cfg.Bridge.ContractAddress,
+			MintMethod:      DefaultMintMethod,
+		}}, nil
+	}
+
+	var routes []ListenRoute
+	if err := json.Unmarshal([]byte(cfg.IndexerListenRoutes), &routes); err != nil {
+		return nil, fmt.Errorf("parse listen routes err:%w", err)
+	}
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("listen routes is empty")
+	}
+	seen := make(map[string]struct{}, len(routes))
+	for i := range routes {
+		route := &routes[i]
+		if route.Address == "" {
+			return nil, fmt.Errorf("listen route %d address is empty", i)
+		}
+		key := NormalizeListenAddress(route.Address)
+		if _, ok := seen[key]; ok {
+			return nil, fmt.Errorf("duplicate listen route address: %s", route.Address)
+		}
+		seen[key] = struct{}{}
+		if route.Protocol == "" {
+			route.Protocol = DefaultListenProtocol
+		}
+		if route.ContractAddress == "" {
+			route.ContractAddress = cfg.Bridge.ContractAddress
+		}
+		if route.MintMethod == "" {
+			route.MintMethod = DefaultMintMethod
+		}
+	}
+	return routes, nil
+}
+
+// NormalizeListenAddress lower case address without 0x prefix, used as route key
+func NormalizeListenAddress(address string) string {
+	address = strings.ToLower(strings.TrimSpace(address))
+	return strings.TrimPrefix(address, "0x")
+}
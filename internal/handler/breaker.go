@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"encoding/json"
+
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/logic/indexer"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	logger "github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
+	"github.com/spf13/cobra"
+)
+
+// newCircuitBreakerFromCmd circuit breaker without bridge, only used to read and update the paused state
+func newCircuitBreakerFromCmd(ctx *model.Context, cmd *cobra.Command) (*indexer.CircuitBreaker, error) {
+	store, err := GetStoreFromCmd(cmd)
+	if err != nil {
+		logger.Errorw("failed to get db context", "error", err.Error())
+		return nil, err
+	}
+	if err := store.Migrate(&model.CircuitBreaker{}); err != nil {
+		return nil, err
+	}
+	return indexer.NewCircuitBreaker(ctx.BitcoinConfig.Bridge.CircuitBreaker, store, nil, nil, newLogger(ctx, "[circuit-breaker]"))
+}
+
+// HandleBreakerStatusCmd print circuit breaker state
+func HandleBreakerStatusCmd(ctx *model.Context, cmd *cobra.Command) error {
+	breaker, err := newCircuitBreakerFromCmd(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	state, err := breaker.State()
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(cmd.OutOrStdout()).Encode(state)
+}
+
+// HandleBreakerPauseCmd manually pause minting
+func HandleBreakerPauseCmd(ctx *model.Context, cmd *cobra.Command, reason string) error {
+	breaker, err := newCircuitBreakerFromCmd(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	return breaker.Pause(reason, "cli")
+}
+
+// HandleBreakerResumeCmd resume minting after operator review
+func HandleBreakerResumeCmd(ctx *model.Context, cmd *cobra.Command) error {
+	breaker, err := newCircuitBreakerFromCmd(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	return breaker.Resume("cli")
+}
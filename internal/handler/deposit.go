@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"encoding/json"
+
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/logic/indexer"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/clock"
+	logger "github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
+	"github.com/spf13/cobra"
+)
+
+// HandleDepositHeldCmd print deposits held by the deposit policy
+func HandleDepositHeldCmd(_ *model.Context, cmd *cobra.Command) error {
+	store, err := GetStoreFromCmd(cmd)
+	if err != nil {
+		logger.Errorw("failed to get db context", "error", err.Error())
+		return err
+	}
+
+	deposits, err := store.Deposits().ListByStatus([]int{model.DepositB2TxStatusHeld}, 0)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(cmd.OutOrStdout())
+	for _, deposit := range deposits {
+		if err := encoder.Encode(deposit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HandleDepositReleaseCmd approve a held deposit after operator review
+func HandleDepositReleaseCmd(ctx *model.Context, cmd *cobra.Command, btcTxHash string) error {
+	store, err := GetStoreFromCmd(cmd)
+	if err != nil {
+		logger.Errorw("failed to get db context", "error", err.Error())
+		return err
+	}
+
+	policy, err := indexer.NewDepositPolicy(ctx.BitcoinConfig.Bridge.DepositPolicy)
+	if err != nil {
+		return err
+	}
+
+	if err := indexer.ReleaseHeldDeposit(store, policy, btcTxHash, clock.Real.Now()); err != nil {
+		return err
+	}
+	logger.Infow("held deposit released", "btcTxHash", btcTxHash)
+	return nil
+}
+
+// HandleDepositEventsCmd print the audit trail of a deposit, oldest first
+func HandleDepositEventsCmd(_ *model.Context, cmd *cobra.Command, btcTxHash string) error {
+	store, err := GetStoreFromCmd(cmd)
+	if err != nil {
+		logger.Errorw("failed to get db context", "error", err.Error())
+		return err
+	}
+
+	events, err := store.DepositEvents().ListByBtcTxHash(btcTxHash)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(cmd.OutOrStdout())
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/qday-io/qday-abel-bridge-indexer/config"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/logic/indexer"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
+	logger "github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
+)
+
+const httpReadHeaderTimeout = 10 * time.Second
+
+var ErrAdminTokenRequired = errors.New("admin http api needs HTTP_ADMIN_TOKEN")
+
+// NewAdminHTTPHandler admin http api, every request carries the admin token: Authorization: Bearer <token>
+// GET  /v1/breaker         circuit breaker state
+// POST /v1/breaker/pause   pause minting, body: {"reason": "..."}
+// POST /v1/breaker/resume  resume minting
+// GET  /v1/deposits/{btc_tx_hash}/events  audit trail of the deposit
+// POST /v1/abel/withdraw-addresses  register the abelian withdraw address signed by the l2 address,
+// body: {"l2_address": "...", "abel_address": "...", "nonce": 1, "signature": "0x..."}
+func NewAdminHTTPHandler(cfg *config.HTTPConfig, breaker *indexer.CircuitBreaker, store storage.Store) (http.Handler, error) {
+	if cfg.AdminToken == "" {
+		return nil, ErrAdminTokenRequired
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/breaker", func(w http.ResponseWriter, _ *http.Request) {
+		state, err := breaker.State()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, state)
+	})
+	mux.HandleFunc("POST /v1/breaker/pause", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Reason == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "reason is required"})
+			return
+		}
+		if err := breaker.Pause(req.Reason, "http:"+clientIP(r)); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]bool{"paused": true})
+	})
+	mux.HandleFunc("POST /v1/breaker/resume", func(w http.ResponseWriter, r *http.Request) {
+		if err := breaker.Resume("http:" + clientIP(r)); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]bool{"paused": false})
+	})
+	mux.HandleFunc("GET /v1/deposits/{btc_tx_hash}/events", func(w http.ResponseWriter, r *http.Request) {
+		events, err := store.DepositEvents().ListByBtcTxHash(r.PathValue("btc_tx_hash"))
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, events)
+	})
+	mux.HandleFunc("POST /v1/abel/withdraw-addresses", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			L2Address   string `json:"l2_address"`
+			AbelAddress string `json:"abel_address"`
+			Nonce       int64  `json:"nonce"`
+			Signature   string `json:"signature"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		address, err := indexer.RegisterAbelWithdrawAddress(store, req.L2Address, req.AbelAddress, req.Nonce, req.Signature)
+		switch {
+		case errors.Is(err, indexer.ErrAbelAddressInvalid), errors.Is(err, indexer.ErrAbelAddressSignature):
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		case errors.Is(err, storage.ErrConflict):
+			writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+			return
+		case err != nil:
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, address)
+	})
+	return ipWhiteList(cfg.IPWhiteList, bearerToken(cfg.AdminToken, mux)), nil
+}
+
+// runAdminHTTPServer blocks until the server exits
+func runAdminHTTPServer(cfg *config.HTTPConfig, breaker *indexer.CircuitBreaker, store storage.Store) error {
+	handler, err := NewAdminHTTPHandler(cfg, breaker, store)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           handler,
+		ReadHeaderTimeout: httpReadHeaderTimeout,
+	}
+	logger.Infow("admin http server starting", "port", cfg.Port)
+	return server.ListenAndServe()
+}
+
+// ipWhiteList comma separated ip list, empty allows all
+func ipWhiteList(list string, next http.Handler) http.Handler {
+	allowed := make(map[string]struct{})
+	for _, ip := range strings.Split(list, ",") {
+		if ip = strings.TrimSpace(ip); ip != "" {
+			allowed[ip] = struct{}{}
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(allowed) > 0 {
+			if _, ok := allowed[clientIP(r)]; !ok {
+				writeJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bearerToken requests without the token are unauthorized
+func bearerToken(token string, next http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Errorw("write http response err", "error", err)
+	}
+}
@@ -10,9 +10,14 @@ import (
 
 	_interface "github.com/qday-io/qday-abel-bridge-indexer/internal/interface"
 
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/qday-io/qday-abel-bridge-indexer/config"
 	"github.com/qday-io/qday-abel-bridge-indexer/internal/logic/indexer"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/logic/rollup"
 	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/amount"
 	logger "github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
 	"github.com/spf13/cobra"
 	"gorm.io/gorm"
@@ -23,26 +28,35 @@ func HandleIndexCmd(ctx *model.Context, cmd *cobra.Command) (err error) {
 	bitcoinCfg := ctx.BitcoinConfig
 	context, cancel := osContext.WithCancel(osContext.Background())
 	defer cancel()
-	if bitcoinCfg.EnableIndexer {
-		err = runIndexerService(ctx, cmd, context)
+
+	// the withdraw service creates the withdraw tables the rollup listener writes to
+	if bitcoinCfg.Bridge.EnableWithdrawListener {
+		err = runWithDrawService(ctx, cmd)
 		if err != nil {
 			return err
 		}
 	}
 
-	//if bitcoinCfg.Bridge.EnableRollupListener {
-	//	err = runRollupListenerService(ctx, cmd)
-	//	if err != nil {
-	//		return err
-	//	}
-	//}
+	if bitcoinCfg.Bridge.AbelPayout.Enable {
+		err = runAbelPayoutService(ctx, cmd)
+		if err != nil {
+			return err
+		}
+	}
 
-	//if bitcoinCfg.Bridge.EnableWithdrawListener {
-	//	err = runWithDrawService(ctx, cmd)
-	//	if err != nil {
-	//		return err
-	//	}
-	//}
+	if bitcoinCfg.Bridge.EnableRollupListener {
+		err = runRollupListenerService(ctx, cmd)
+		if err != nil {
+			return err
+		}
+	}
+
+	if bitcoinCfg.EnableIndexer {
+		err = runIndexerService(ctx, cmd, context)
+		if err != nil {
+			return err
+		}
+	}
 
 	// wait quit
 	code := WaitForQuitSignals()
@@ -58,7 +72,12 @@ func runIndexerService(ctx *model.Context, cmd *cobra.Command, context osContext
 	bidxLogger := newLogger(ctx, "[bitcoin-indexer]")
 	//bidxer, err := indexer.NewBitcoinIndexer(bidxLogger, ctx, bitcoinCfg.IndexerListenAddress, bitcoinCfg.IndexerListenTargetConfirmations)
 
-	bidxer, err := indexer.NewAbelianIndexer(bidxLogger, bitcoinCfg, bitcoinCfg.IndexerListenAddress, bitcoinCfg.IndexerListenTargetConfirmations)
+	routes, err := bitcoinCfg.ListenRoutes()
+	if err != nil {
+		logger.Errorw("failed to load listen routes", "error", err.Error())
+		return err
+	}
+	bidxer, err := indexer.NewAbelianIndexer(bidxLogger, bitcoinCfg, routes, bitcoinCfg.IndexerListenTargetConfirmations)
 	if err != nil {
 		logger.Errorw("failed to new bitcoin indexer indexer", "error", err.Error())
 		return err
@@ -68,8 +87,19 @@ func runIndexerService(ctx *model.Context, cmd *cobra.Command, context osContext
 		bidxer.Stop()
 	}()
 
+	clients, closeRecord, err := newRecordClients(bitcoinCfg.RPCRecordFile)
+	if err != nil {
+		logger.Errorw("failed to open rpc record file", "error", err.Error())
+		return err
+	}
+	defer closeRecord()
+	clients.applyIndexer(bidxer)
+
+	// websocket block notifications, polling when not configured
+	notifier := indexer.NewBlockNotifier(bitcoinCfg.IndexerNotifyWSURL, bitcoinCfg.RPCUser, bitcoinCfg.RPCPass, bidxLogger)
+
 	//go func() {
-	err = startIndexProvider(bidxer, bidxLogger, cmd)
+	err = startIndexProvider(bidxer, notifier, bidxLogger, cmd)
 	if err != nil {
 		return err
 	}
@@ -77,13 +107,20 @@ func runIndexerService(ctx *model.Context, cmd *cobra.Command, context osContext
 
 	<-time.After(2 * time.Second) // assume server started successfully
 
+	if bitcoinCfg.Mempool.Enable {
+		err = startMempoolWatcher(bitcoinCfg.Mempool, bidxer, newLogger(ctx, "[mempool-watcher]"), cmd)
+		if err != nil {
+			return err
+		}
+	}
+
 	if bidxer == nil {
 		return fmt.Errorf("failed to new bitcoin indexer indexer:%v", err.Error())
 	}
 
 	// start l1->l2 bridge service
 	//go func() {
-	err = startBridgeProvider(ctx, bitcoinCfg, context, bidxer, cmd)
+	err = startBridgeProvider(ctx, bitcoinCfg, routes, context, bidxer, clients, cmd)
 	if err != nil {
 		return err
 	}
@@ -93,22 +130,70 @@ func runIndexerService(ctx *model.Context, cmd *cobra.Command, context osContext
 	return nil
 }
 
-func startBridgeProvider(ctx *model.Context, bitcoinCfg *config.BitcoinConfig, context osContext.Context, bidxer _interface.BitcoinTxIndexer, cmd *cobra.Command) error {
-	home := ctx.Config.RootDir
-	//bitcoinParam := config.ChainParams(bitcoinCfg.NetworkName)
+func startBridgeProvider(
+	ctx *model.Context,
+	bitcoinCfg *config.BitcoinConfig,
+	routes []config.ListenRoute,
+	context osContext.Context,
+	bidxer _interface.BitcoinTxIndexer,
+	clients rpcClients,
+	cmd *cobra.Command,
+) error {
 	db, err := GetDBContextFromCmd(cmd)
 	if err != nil {
 		logger.Errorw("failed to get db context", "error", err.Error())
 		return err
 	}
-	bridgeLogger := newLogger(ctx, "[bridge-deposit]")
-	bridge, err := indexer.NewBridge(bitcoinCfg.Bridge, home, bridgeLogger, bitcoinCfg.NetworkName)
+	store, err := storage.New(db)
 	if err != nil {
-		logger.Errorw("failed to create bitcoin bridge", "error", err.Error())
 		return err
 	}
 
-	bridgeService := indexer.NewBridgeDepositService(bridge, bidxer, db, bridgeLogger, bitcoinCfg.Bridge)
+	bridgeService, bridge, breaker, err := newBridgeDepositService(ctx, routes, bidxer, store, clients)
+	if err != nil {
+		return err
+	}
+
+	if ctx.HTTPConfig != nil && ctx.HTTPConfig.Enable {
+		go func() {
+			if err := runAdminHTTPServer(ctx.HTTPConfig, breaker, store); err != nil {
+				logger.Errorw("admin http server stopped", "error", err.Error())
+			}
+		}()
+	}
+
+	if bitcoinCfg.Bridge.Reconcile.Interval > 0 {
+		reconciler := indexer.NewReconciler(store, bridge, bitcoinCfg.Bridge, routes)
+		reconcileService := indexer.NewReconcileService(bitcoinCfg.Bridge.Reconcile, reconciler, newLogger(ctx, "[reconcile]"))
+		if err := reconcileService.Start(); err != nil {
+			logger.Errorw("failed to start reconcile service", "error", err.Error())
+			return err
+		}
+		defer func() {
+			if err := reconcileService.Stop(); err != nil {
+				logger.Errorf("stop err:%v", err.Error())
+			}
+		}()
+	}
+
+	if bitcoinCfg.Bridge.AttemptWatchInterval > 0 {
+		attemptWatcher := indexer.NewAttemptWatcher(
+			time.Duration(bitcoinCfg.Bridge.AttemptWatchInterval)*time.Second,
+			bridge,
+			store,
+			newLogger(ctx, "[attempt-watcher]"),
+		)
+		if err := attemptWatcher.Start(); err != nil {
+			logger.Errorw("failed to start attempt watcher", "error", err.Error())
+			return err
+		}
+		defer func() {
+			if err := attemptWatcher.Stop(); err != nil {
+				logger.Errorf("stop err:%v", err.Error())
+			}
+		}()
+	}
+
 	bridgeErrCh := make(chan error)
 	go func() {
 		if err := bridgeService.Start(); err != nil {
@@ -133,7 +218,57 @@ func startBridgeProvider(ctx *model.Context, bitcoinCfg *config.BitcoinConfig, c
 	return nil
 }
 
-func startIndexProvider(bidxer _interface.BitcoinTxIndexer, bidxLogger logger.Logger, cmd *cobra.Command) error {
+// newBridgeDepositService bridge deposit service minting with the route bridges, sending the rpc with the clients
+func newBridgeDepositService(
+	ctx *model.Context,
+	routes []config.ListenRoute,
+	bidxer _interface.BitcoinTxIndexer,
+	store storage.Store,
+	clients rpcClients,
+) (*indexer.BridgeDepositService, *indexer.Bridge, *indexer.CircuitBreaker, error) {
+	bitcoinCfg := ctx.BitcoinConfig
+	home := ctx.Config.RootDir
+	bridgeLogger := newLogger(ctx, "[bridge-deposit]")
+	bridge, err := indexer.NewBridge(bitcoinCfg.Bridge, home, bridgeLogger, bitcoinCfg.NetworkName)
+	if err != nil {
+		logger.Errorw("failed to create bitcoin bridge", "error", err.Error())
+		return nil, nil, nil, err
+	}
+	clients.applyBridge(bridge)
+
+	routeBridges := make(map[string]_interface.BitcoinBridge, len(routes))
+	for _, route := range routes {
+		routeBridge, err := bridge.WithRoute(route)
+		if err != nil {
+			logger.Errorw("failed to create route bridge", "error", err.Error(), "address", route.Address)
+			return nil, nil, nil, err
+		}
+		routeBridges[config.NormalizeListenAddress(route.Address)] = routeBridge
+	}
+
+	policy, err := indexer.NewDepositPolicy(bitcoinCfg.Bridge.DepositPolicy)
+	if err != nil {
+		logger.Errorw("failed to create deposit policy", "error", err.Error())
+		return nil, nil, nil, err
+	}
+
+	breaker, err := indexer.NewCircuitBreaker(
+		bitcoinCfg.Bridge.CircuitBreaker,
+		store,
+		bridge,
+		amount.NewConverter(bitcoinCfg.Bridge.SourceDecimals, bitcoinCfg.Bridge.TargetDecimals),
+		bridgeLogger,
+	)
+	if err != nil {
+		logger.Errorw("failed to create circuit breaker", "error", err.Error())
+		return nil, nil, nil, err
+	}
+
+	bridgeService := indexer.NewBridgeDepositService(bridge, routeBridges, bidxer, policy, breaker, store, bridgeLogger, bitcoinCfg.Bridge)
+	return bridgeService, bridge, breaker, nil
+}
+
+func startIndexProvider(bidxer _interface.BitcoinTxIndexer, notifier *indexer.BlockNotifier, bidxLogger logger.Logger, cmd *cobra.Command) error {
 
 	//bitcoinParam := config.ChainParams(bitcoinCfg.NetworkName)
 	//bidxLogger := newLogger(ctx, "[bitcoin-indexer]")
@@ -149,13 +284,13 @@ func startIndexProvider(bidxer _interface.BitcoinTxIndexer, bidxLogger logger.Lo
 		return err
 	}
 
-	db, err := GetDBContextFromCmd(cmd)
+	store, err := GetStoreFromCmd(cmd)
 	if err != nil {
 		logger.Errorw("failed to get db context", "error", err.Error())
 		return err
 	}
 
-	bindexerService := indexer.NewIndexerService(bidxer, db, bidxLogger)
+	bindexerService := indexer.NewIndexerService(bidxer, notifier, store, bidxLogger)
 
 	err = bindexerService.CheckDb()
 	if err != nil {
@@ -180,6 +315,26 @@ func startIndexProvider(bidxer _interface.BitcoinTxIndexer, bidxLogger logger.Lo
 	return nil
 }
 
+func startMempoolWatcher(cfg config.MempoolConfig, bidxer _interface.BitcoinTxIndexer, mempoolLogger logger.Logger, cmd *cobra.Command) error {
+	mempoolIdxr, ok := bidxer.(_interface.MempoolTxIndexer)
+	if !ok {
+		return fmt.Errorf("indexer does not support mempool scan")
+	}
+
+	store, err := GetStoreFromCmd(cmd)
+	if err != nil {
+		logger.Errorw("failed to get db context", "error", err.Error())
+		return err
+	}
+
+	mempoolWatcher := indexer.NewMempoolWatcher(cfg, mempoolIdxr, store, mempoolLogger)
+	if err := mempoolWatcher.Start(); err != nil {
+		logger.Errorw("failed to start mempool watcher", "error", err.Error())
+		return err
+	}
+	return nil
+}
+
 func runEpsService(ctx *model.Context, cmd *cobra.Command) error {
 	//	epsLoggerOpt := logger.NewOptions()
 	//	epsLoggerOpt.Format = ctx.Config.LogFormat
@@ -215,108 +370,134 @@ func runEpsService(ctx *model.Context, cmd *cobra.Command) error {
 }
 
 func runRollupListenerService(ctx *model.Context, cmd *cobra.Command) error {
-	//	logger.Infow("rollup indexer service starting...")
-	//	db, err := GetDBContextFromCmd(cmd)
-	//	if err != nil {
-	//		logger.Errorw("failed to get db context", "error", err.Error())
-	//		return err
-	//	}
-	//
-	//	//btclient, err := rpcclient.New(&rpcclient.ConnConfig{
-	//	//	Host:         bitcoinCfg.RPCHost + ":" + bitcoinCfg.RPCPort,
-	//	//	User:         bitcoinCfg.RPCUser,
-	//	//	Pass:         bitcoinCfg.RPCPass,
-	//	//	HTTPPostMode: true, // Bitcoin core only supports HTTP POST mode
-	//	//	DisableTLS:   true, // Bitcoin core does not provide TLS by default
-	//	//}, nil)
-	//	//if err != nil {
-	//	//	logger.Errorw("EVMListenerService failed to create bitcoin client", "error", err.Error())
-	//	//	return err
-	//	//}
-	//	//defer func() {
-	//	//	btclient.Shutdown()
-	//	//}()
-	//
-	//	ethlient, err := ethclient.Dial(bitcoinCfg.Bridge.EthRPCURL)
-	//	if err != nil {
-	//		logger.Errorw("EVMListenerService failed to create eth client", "error", err.Error())
-	//		return err
-	//	}
-	//	defer func() {
-	//		ethlient.Close()
-	//	}()
-	//
-	//	rollupLogger := newLogger(ctx, "[rollup-service]")
-	//	if err != nil {
-	//		return err
-	//	}
-	//	rollupService := rollup.NewIndexerService(ethlient, bitcoinCfg, db, rollupLogger)
-	//
-	//	epsErrCh := make(chan error)
-	//	go func() {
-	//		if err := rollupService.OnStart(); err != nil {
-	//			epsErrCh <- err
-	//		}
-	//	}()
-	//
-	//	select {
-	//	case err := <-epsErrCh:
-	//		return err
-	//	case <-time.After(5 * time.Second): // assume server started successfully
-	//	}
+	bitcoinCfg := ctx.BitcoinConfig
+	logger.Infow("rollup indexer service starting...")
+	store, err := GetStoreFromCmd(cmd)
+	if err != nil {
+		logger.Errorw("failed to get db context", "error", err.Error())
+		return err
+	}
+
+	ethClient, err := ethclient.Dial(bitcoinCfg.Bridge.EthRPCURL)
+	if err != nil {
+		logger.Errorw("rollup indexer service failed to create eth client", "error", err.Error())
+		return err
+	}
+
+	rollupService := rollup.NewRollupService(ethClient, bitcoinCfg, store, newLogger(ctx, "[rollup-service]"))
+	events, err := rollup.NewEventIndexer(bitcoinCfg.Bridge, store)
+	if err != nil {
+		ethClient.Close()
+		logger.Errorw("rollup indexer service failed to load the indexed events", "error", err.Error())
+		return err
+	}
+	if events != nil {
+		if err := rollupService.SetEventIndexer(events); err != nil {
+			ethClient.Close()
+			logger.Errorw("rollup indexer service failed to subscribe the bridge events", "error", err.Error())
+			return err
+		}
+	}
+
+	errCh := make(chan error)
+	go func() {
+		if err := rollupService.OnStart(); err != nil {
+			errCh <- err
+		}
+	}()
+
+	select {
+	case err := <-errCh:
+		ethClient.Close()
+		return err
+	case <-time.After(5 * time.Second): // assume server started successfully
+	}
 	return nil
 }
 
 func runWithDrawService(ctx *model.Context, cmd *cobra.Command) error {
-	//	logger.Infow("withdraw service starting...")
-	//	db, err := GetDBContextFromCmd(cmd)
-	//	if err != nil {
-	//		logger.Errorw("failed to get db context", "error", err.Error())
-	//		return err
-	//	}
-	//
-	//	btclient, err := rpcclient.New(&rpcclient.ConnConfig{
-	//		Host:         bitcoinCfg.RPCHost + ":" + bitcoinCfg.RPCPort,
-	//		User:         bitcoinCfg.RPCUser,
-	//		Pass:         bitcoinCfg.RPCPass,
-	//		HTTPPostMode: true, // Bitcoin core only supports HTTP POST mode
-	//		DisableTLS:   true, // Bitcoin core does not provide TLS by default
-	//	}, nil)
-	//	if err != nil {
-	//		logger.Errorw("EVMListenerService failed to create bitcoin client", "error", err.Error())
-	//		return err
-	//	}
-	//	defer func() {
-	//		btclient.Shutdown()
-	//	}()
-	//
-	//	ethlient, err := ethclient.Dial(bitcoinCfg.Bridge.EthRPCURL)
-	//	if err != nil {
-	//		logger.Errorw("EVMListenerService failed to create eth client", "error", err.Error())
-	//		return err
-	//	}
-	//	defer func() {
-	//		ethlient.Close()
-	//	}()
-	//
-	//	bridgeLogger := newLogger(ctx, "[bridge-withdraw]")
-	//	if err != nil {
-	//		return err
-	//	}
-	//	withdrawService := bitcoin.NewBridgeWithdrawService(btclient, ethlient, bitcoinCfg, db, bridgeLogger)
-	//
-	//	epsErrCh := make(chan error)
-	//	go func() {
-	//		if err := withdrawService.OnStart(); err != nil {
-	//			epsErrCh <- err
-	//		}
-	//	}()
-	//
-	//	select {
-	//	case err := <-epsErrCh:
-	//		return err
-	//	case <-time.After(5 * time.Second): // assume server started successfully
-	//	}
+	bitcoinCfg := ctx.BitcoinConfig
+	logger.Infow("withdraw service starting...")
+	store, err := GetStoreFromCmd(cmd)
+	if err != nil {
+		logger.Errorw("failed to get db context", "error", err.Error())
+		return err
+	}
+
+	btcClient, err := rpcclient.New(&rpcclient.ConnConfig{
+		Host:         bitcoinCfg.RPCHost + ":" + bitcoinCfg.RPCPort,
+		User:         bitcoinCfg.RPCUser,
+		Pass:         bitcoinCfg.RPCPass,
+		HTTPPostMode: true,                  // Bitcoin core only supports HTTP POST mode
+		DisableTLS:   bitcoinCfg.DisableTLS, // Bitcoin core does not provide TLS by default
+	}, nil)
+	if err != nil {
+		logger.Errorw("withdraw service failed to create bitcoin client", "error", err.Error())
+		return err
+	}
+
+	ethClient, err := ethclient.Dial(bitcoinCfg.Bridge.EthRPCURL)
+	if err != nil {
+		btcClient.Shutdown()
+		logger.Errorw("withdraw service failed to create eth client", "error", err.Error())
+		return err
+	}
+
+	backends, err := indexer.NewWithdrawBackends(bitcoinCfg, btcClient, store)
+	if err != nil {
+		btcClient.Shutdown()
+		ethClient.Close()
+		logger.Errorw("withdraw service failed to create bitcoin backends", "error", err.Error())
+		return err
+	}
+
+	if bitcoinCfg.Bridge.UTXOSource == indexer.WithdrawBackendLocal {
+		vaultScanner := indexer.NewVaultScanner(btcClient, bitcoinCfg, store, newLogger(ctx, "[vault-scanner]"))
+		if err := vaultScanner.Start(); err != nil {
+			btcClient.Shutdown()
+			ethClient.Close()
+			logger.Errorw("failed to start vault scanner", "error", err.Error())
+			return err
+		}
+	}
+
+	withdrawService := indexer.NewBridgeWithdrawService(btcClient, ethClient, backends, bitcoinCfg, store, newLogger(ctx, "[bridge-withdraw]"))
+	if err := withdrawService.Start(); err != nil {
+		btcClient.Shutdown()
+		ethClient.Close()
+		logger.Errorw("failed to start withdraw service", "error", err.Error())
+		return err
+	}
+
+	if bitcoinCfg.Bridge.SignerAPI.Port != "" {
+		go func() {
+			if err := runSignerHTTPServer(bitcoinCfg, store); err != nil {
+				logger.Errorw("signer http server stopped", "error", err.Error())
+			}
+		}()
+	}
+	return nil
+}
+
+func runAbelPayoutService(ctx *model.Context, cmd *cobra.Command) error {
+	bitcoinCfg := ctx.BitcoinConfig
+	logger.Infow("abelian payout service starting...")
+	store, err := GetStoreFromCmd(cmd)
+	if err != nil {
+		logger.Errorw("failed to get db context", "error", err.Error())
+		return err
+	}
+
+	abec, err := indexer.NewAbelianIndexer(newLogger(ctx, "[abelian-payout]"), bitcoinCfg, nil, 0)
+	if err != nil {
+		return err
+	}
+	payoutService := indexer.NewAbelPayoutService(abec, indexer.NewVaultWallet(bitcoinCfg.VaultWallet),
+		bitcoinCfg, store, newLogger(ctx, "[abelian-payout]"))
+	if err := payoutService.Start(); err != nil {
+		logger.Errorw("failed to start abelian payout service", "error", err.Error())
+		return err
+	}
 	return nil
 }
 
@@ -328,6 +509,15 @@ func GetDBContextFromCmd(cmd *cobra.Command) (*gorm.DB, error) {
 	return nil, fmt.Errorf("db context not set")
 }
 
+// GetStoreFromCmd repositories over the db of the command context
+func GetStoreFromCmd(cmd *cobra.Command) (storage.Store, error) {
+	db, err := GetDBContextFromCmd(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return storage.New(db)
+}
+
 func WaitForQuitSignals() int {
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGINT, syscall.SIGHUP)
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/logic/indexer"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	logger "github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
+	"github.com/spf13/cobra"
+)
+
+// HandleReconcileCmd reconcile deposits created in [from, to) and write the report,
+// return indexer.ErrReconcileFindings when any issue is found
+func HandleReconcileCmd(ctx *model.Context, cmd *cobra.Command, from, to time.Time, format string, output string) error {
+	bitcoinCfg := ctx.BitcoinConfig
+	store, err := GetStoreFromCmd(cmd)
+	if err != nil {
+		logger.Errorw("failed to get db context", "error", err.Error())
+		return err
+	}
+	bridge, err := indexer.NewBridge(bitcoinCfg.Bridge, ctx.Config.RootDir, newLogger(ctx, "[reconcile]"), bitcoinCfg.NetworkName)
+	if err != nil {
+		logger.Errorw("failed to create bitcoin bridge", "error", err.Error())
+		return err
+	}
+
+	routes, err := bitcoinCfg.ListenRoutes()
+	if err != nil {
+		return err
+	}
+
+	report, err := indexer.NewReconciler(store, bridge, bitcoinCfg.Bridge, routes).Run(from, to)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = cmd.OutOrStdout()
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := indexer.WriteReconcileReport(w, report, format); err != nil {
+		return err
+	}
+	if len(report.Issues) > 0 {
+		return fmt.Errorf("%w: %d issues", indexer.ErrReconcileFindings, len(report.Issues))
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	_interface "github.com/qday-io/qday-abel-bridge-indexer/internal/interface"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/logic/indexer"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/clock"
+	logger "github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/rpcrecord"
+	"github.com/spf13/cobra"
+)
+
+// rpcClients http clients of the rpc sources, nil sends with the default client
+type rpcClients struct {
+	abec  *http.Client
+	vault *http.Client
+	evm   *http.Client
+	aa    *http.Client
+}
+
+// newRecordClients clients appending their traffic to the file, the default clients when file is empty
+func newRecordClients(file string) (rpcClients, func(), error) {
+	if file == "" {
+		return rpcClients{}, func() {}, nil
+	}
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return rpcClients{}, nil, err
+	}
+	recorder := rpcrecord.NewRecorder(f)
+	logger.Infow("recording rpc traffic", "file", file)
+	return rpcClients{
+		abec:  recorder.Client(rpcrecord.SourceAbec, nil),
+		vault: recorder.Client(rpcrecord.SourceVault, nil),
+		evm:   recorder.Client(rpcrecord.SourceEVM, nil),
+		aa:    recorder.Client(rpcrecord.SourceAA, nil),
+	}, func() { f.Close() }, nil
+}
+
+// newReplayClients clients answered with the recorded traffic
+func newReplayClients(replayer *rpcrecord.Replayer) rpcClients {
+	return rpcClients{
+		abec:  replayer.Client(rpcrecord.SourceAbec),
+		vault: replayer.Client(rpcrecord.SourceVault),
+		evm:   replayer.Client(rpcrecord.SourceEVM),
+		aa:    replayer.Client(rpcrecord.SourceAA),
+	}
+}
+
+func (c rpcClients) applyIndexer(bidxer _interface.BitcoinTxIndexer) {
+	if c.abec == nil {
+		return
+	}
+	if abelian, ok := bidxer.(*indexer.AbelianIndexer); ok {
+		abelian.SetHTTPClient(c.abec, c.vault)
+	}
+}
+
+func (c rpcClients) applyBridge(bridge *indexer.Bridge) {
+	if c.evm == nil {
+		return
+	}
+	bridge.SetHTTPClient(c.evm, c.aa)
+}
+
+// HandleReplayCmd run the indexer and bridge deposit services against the recorded rpc traffic
+// with a fake clock starting at start, until the recording is exhausted, then write the deposits.
+// startBlock seeds the btc index cursor, a negative startBlock starts from the latest block like a fresh indexer
+func HandleReplayCmd(ctx *model.Context, cmd *cobra.Command, file string, start time.Time, startBlock int64) error {
+	bitcoinCfg := ctx.BitcoinConfig
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	replayer, err := rpcrecord.Load(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	clients := newReplayClients(replayer)
+	fakeClock := clock.NewFake(start)
+
+	db, err := GetDBContextFromCmd(cmd)
+	if err != nil {
+		logger.Errorw("failed to get db context", "error", err.Error())
+		return err
+	}
+	store, err := storage.New(db)
+	if err != nil {
+		return err
+	}
+
+	routes, err := bitcoinCfg.ListenRoutes()
+	if err != nil {
+		logger.Errorw("failed to load listen routes", "error", err.Error())
+		return err
+	}
+	bidxLogger := newLogger(ctx, "[bitcoin-indexer]")
+	bidxer, err := indexer.NewAbelianIndexer(bidxLogger, bitcoinCfg, routes, bitcoinCfg.IndexerListenTargetConfirmations)
+	if err != nil {
+		logger.Errorw("failed to new bitcoin indexer indexer", "error", err.Error())
+		return err
+	}
+	clients.applyIndexer(bidxer)
+
+	indexerService := indexer.NewIndexerService(bidxer, nil, store, bidxLogger)
+	indexerService.SetClock(fakeClock)
+	if err := indexerService.CheckDb(); err != nil {
+		return err
+	}
+	if startBlock >= 0 {
+		btcIndex := &model.BtcIndex{Base: model.Base{ID: 1}, BtcIndexBlock: startBlock}
+		if err := store.Cursors().SaveBtcIndex(btcIndex); err != nil {
+			return err
+		}
+	}
+	bridgeService, _, _, err := newBridgeDepositService(ctx, routes, bidxer, store, clients)
+	if err != nil {
+		return err
+	}
+	bridgeService.SetClock(fakeClock)
+
+	go func() {
+		if err := indexerService.Start(); err != nil {
+			logger.Errorw("replay indexer service stopped", "error", err.Error())
+		}
+	}()
+	if err := bridgeService.Start(); err != nil {
+		return err
+	}
+
+	<-replayer.Done()
+	if err := bridgeService.Stop(); err != nil {
+		logger.Errorf("stop err:%v", err.Error())
+	}
+	if err := indexerService.Stop(); err != nil {
+		logger.Errorf("stop err:%v", err.Error())
+	}
+	logger.Infow("replay finished", "remaining", replayer.Remaining(), "clock", fakeClock.Now())
+
+	var deposits []model.Deposit
+	if err := db.Order(model.Deposit{}.Column().BtcBlockNumber).Order(model.Deposit{}.Column().BtcTxIndex).Find(&deposits).Error; err != nil {
+		return err
+	}
+	enc := json.NewEncoder(cmd.OutOrStdout())
+	for i := range deposits {
+		if err := enc.Encode(&deposits[i]); err != nil {
+			return err
+		}
+	}
+	if err := replayer.Err(); err != nil {
+		return fmt.Errorf("replay diverged from the recording: %w", err)
+	}
+	if remaining := replayer.Remaining(); remaining > 0 {
+		return fmt.Errorf("replay diverged from the recording, %d exchanges not replayed", remaining)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/qday-io/qday-abel-bridge-indexer/config"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/logic/indexer"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/clock"
+	logger "github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
+)
+
+// signer request auth headers, the signature is a hex der ecdsa signature of SignerAuthDigest
+const (
+	SignerPubKeyHeader    = "X-Signer-Pubkey"
+	SignerTimestampHeader = "X-Signer-Timestamp"
+	SignerSignatureHeader = "X-Signer-Signature"
+)
+
+const signerMaxBodySize = 1 << 20
+
+type signerContextKey struct{}
+
+// SignerAuthDigest digest signed by a co-signer to authenticate a request
+func SignerAuthDigest(method, requestURI string, timestamp int64, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+	digest := sha256.Sum256([]byte(fmt.Sprintf("%s\n%s\n%d\n%x", method, requestURI, timestamp, bodyHash)))
+	return digest[:]
+}
+
+// SignSignerRequest set the auth headers of a request to the signer api, signed by the co-signer key
+func SignSignerRequest(r *http.Request, key *btcec.PrivateKey, body []byte, now time.Time) {
+	timestamp := now.Unix()
+	signature := ecdsa.Sign(key, SignerAuthDigest(r.Method, r.URL.RequestURI(), timestamp, body))
+	r.Header.Set(SignerPubKeyHeader, hex.EncodeToString(key.PubKey().SerializeCompressed()))
+	r.Header.Set(SignerTimestampHeader, strconv.FormatInt(timestamp, 10))
+	r.Header.Set(SignerSignatureHeader, hex.EncodeToString(signature.Serialize()))
+}
+
+// NewSignerHTTPHandler withdraw co-signer http api, every request signed by a key of the bridge PublicKeys
+// GET  /v1/withdraw/psbts                          withdraw txs waiting for signatures, with the withdraws they pay
+// POST /v1/withdraw/psbts/{btc_tx_id}/signatures   submit partial signatures, body: {"psbt": "..."}
+func NewSignerHTTPHandler(cfg *config.BitcoinConfig, store storage.Store, clk clock.Clock) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/withdraw/psbts", func(w http.ResponseWriter, r *http.Request) {
+		requests, err := indexer.ListWithdrawSigningRequests(store, cfg, signerFromContext(r.Context()))
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, requests)
+	})
+	mux.HandleFunc("POST /v1/withdraw/psbts/{btc_tx_id}/signatures", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Psbt string `json:"psbt"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Psbt == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "psbt is required"})
+			return
+		}
+		signer := signerFromContext(r.Context())
+		pack, err := psbt.NewFromRawBytes(strings.NewReader(req.Psbt), true)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if pack.UnsignedTx.TxHash().String() != r.PathValue("btc_tx_id") {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": indexer.ErrWithdrawTxMismatch.Error()})
+			return
+		}
+		withdrawTx, err := indexer.MergeWithdrawSignatures(store, cfg, req.Psbt, signer)
+		if err != nil {
+			logger.Errorw("withdraw signatures rejected", "signer", signer, "btcTxID", r.PathValue("btc_tx_id"), "error", err)
+			writeJSON(w, signerErrorStatus(err), map[string]string{"error": err.Error()})
+			return
+		}
+		logger.Infow("withdraw signatures submitted", "signer", signer, "btcTxID", withdrawTx.BtcTxID, "status", withdrawTx.Status)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"btc_tx_id": withdrawTx.BtcTxID,
+			"status":    withdrawTx.Status,
+		})
+	})
+	return signerAuth(cfg.Bridge, clk, mux)
+}
+
+// runSignerHTTPServer blocks until the server exits
+func runSignerHTTPServer(cfg *config.BitcoinConfig, store storage.Store) error {
+	server := &http.Server{
+		Addr:              ":" + cfg.Bridge.SignerAPI.Port,
+		Handler:           NewSignerHTTPHandler(cfg, store, clock.Real),
+		ReadHeaderTimeout: httpReadHeaderTimeout,
+	}
+	logger.Infow("signer http server starting", "port", cfg.Bridge.SignerAPI.Port)
+	return server.ListenAndServe()
+}
+
+// signerAuth accept requests signed by a co-signer key within the auth window
+func signerAuth(cfg config.BridgeConfig, clk clock.Clock, next http.Handler) http.Handler {
+	signers := make(map[string]*btcec.PublicKey, len(cfg.PublicKeys))
+	for _, pubKey := range cfg.PublicKeys {
+		raw, err := hex.DecodeString(pubKey)
+		if err != nil {
+			continue
+		}
+		if key, err := btcec.ParsePubKey(raw); err == nil {
+			signers[strings.ToLower(pubKey)] = key
+		}
+	}
+	window := time.Duration(cfg.SignerAPI.AuthWindow) * time.Second
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, signerMaxBodySize))
+		if err != nil {
+			writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": err.Error()})
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		signer := strings.ToLower(r.Header.Get(SignerPubKeyHeader))
+		if err := verifySignerRequest(r, signers[signer], body, clk.Now(), window); err != nil {
+			logger.Warnw("signer request unauthorized", "signer", signer, "ip", clientIP(r), "error", err)
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), signerContextKey{}, signer)))
+	})
+}
+
+func verifySignerRequest(r *http.Request, pubKey *btcec.PublicKey, body []byte, now time.Time, window time.Duration) error {
+	if pubKey == nil {
+		return errors.New("not a co-signer")
+	}
+	timestamp, err := strconv.ParseInt(r.Header.Get(SignerTimestampHeader), 10, 64)
+	if err != nil {
+		return err
+	}
+	if drift := now.Sub(time.Unix(timestamp, 0)); drift > window || drift < -window {
+		return fmt.Errorf("timestamp %d out of the auth window", timestamp)
+	}
+	raw, err := hex.DecodeString(r.Header.Get(SignerSignatureHeader))
+	if err != nil {
+		return err
+	}
+	signature, err := ecdsa.ParseDERSignature(raw)
+	if err != nil {
+		return err
+	}
+	if !signature.Verify(SignerAuthDigest(r.Method, r.URL.RequestURI(), timestamp, body), pubKey) {
+		return errors.New("signature verification failed")
+	}
+	return nil
+}
+
+func signerFromContext(ctx context.Context) string {
+	signer, _ := ctx.Value(signerContextKey{}).(string)
+	return signer
+}
+
+func signerErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, indexer.ErrWithdrawTxNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, indexer.ErrWithdrawTxNotSigning):
+		return http.StatusConflict
+	case errors.Is(err, indexer.ErrWithdrawTxMismatch),
+		errors.Is(err, indexer.ErrWithdrawUnknownSigner),
+		errors.Is(err, indexer.ErrWithdrawSignerMismatch),
+		errors.Is(err, indexer.ErrWithdrawInvalidSignature),
+		errors.Is(err, indexer.ErrWithdrawUnverified):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -9,11 +9,10 @@ import (
 
 	"github.com/qday-io/qday-abel-bridge-indexer/config"
 	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
 	logger "github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
 	"github.com/spf13/cobra"
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	gormlog "gorm.io/gorm/logger"
 )
 
 // type serverContext string
@@ -38,24 +37,39 @@ func NewDefaultContext() *model.Context {
 	return NewContext(
 		config.DefaultConfig(),
 		config.DefaultBitcoinConfig(),
+		config.DefaultHTTPConfig(),
 	)
 }
 
-func NewContext(cfg *config.Config, btcCfg *config.BitcoinConfig) *model.Context {
+func NewContext(cfg *config.Config, btcCfg *config.BitcoinConfig, httpCfg *config.HTTPConfig) *model.Context {
 	return &model.Context{
 		Config:        cfg,
 		BitcoinConfig: btcCfg,
+		HTTPConfig:    httpCfg,
 	}
 }
 
 // InterceptConfigsPreRunHandler initializes and sets up the application context before command execution.
 // It loads configurations, establishes database connection, initializes logger, and sets up server context.
 func InterceptConfigsPreRunHandler(cmd *cobra.Command) error {
+	return interceptConfigs(cmd, "")
+}
+
+// InterceptReplayConfigsPreRunHandler sets up the application context like InterceptConfigsPreRunHandler,
+// the database is opened from dbSource instead of INDEXER_DATABASE_SOURCE
+func InterceptReplayConfigsPreRunHandler(cmd *cobra.Command, dbSource string) error {
+	return interceptConfigs(cmd, dbSource)
+}
+
+func interceptConfigs(cmd *cobra.Command, dbSource string) error {
 	// Step 1: Load all configurations at once
 	appConfig, err := config.LoadAppConfig()
 	if err != nil {
 		return fmt.Errorf("failed to load application config: %w", err)
 	}
+	if dbSource != "" {
+		appConfig.DatabaseSource = dbSource
+	}
 
 	// Step 2: Initialize logger with configuration
 	logger.Init(appConfig.LogLevel, appConfig.LogFormat)
@@ -139,12 +153,20 @@ func NewContextFromAppConfig(appConfig *config.AppConfig) *model.Context {
 		EnableIndexer:                    appConfig.EnableIndexer,
 		IndexerListenAddress:             appConfig.IndexerListenAddress,
 		IndexerListenTargetConfirmations: appConfig.IndexerListenTargetConfirmations,
+		IndexerListenRoutes:              appConfig.IndexerListenRoutes,
+		IndexerNotifyWSURL:               appConfig.IndexerNotifyWSURL,
+		RPCRecordFile:                    appConfig.RPCRecordFile,
+		VaultWallet:                      appConfig.VaultWallet,
+		Mempool:                          appConfig.Mempool,
 		Bridge:                           appConfig.Bridge,
 	}
 
+	httpCfg := appConfig.HTTP
+
 	return &model.Context{
 		Config:        cfg,
 		BitcoinConfig: bitcoinCfg,
+		HTTPConfig:    &httpCfg,
 	}
 }
 
@@ -165,34 +187,12 @@ func NewDBFromAppConfig(appConfig *config.AppConfig) (*gorm.DB, error) {
 }
 
 // NewDB creates a new database connection.
-// default use postgres driver
+// postgres by default, sqlite://<file path> uses the embedded sqlite backend
 func NewDB(cfg *config.Config) (*gorm.DB, error) {
-
-	var DB *gorm.DB
-	var err error
-	for i := 0; i < 2; i++ {
-		// waiting for db server start complete
-		time.Sleep(10 * time.Second)
-
-		DB, err = gorm.Open(postgres.Open(cfg.DatabaseSource), &gorm.Config{
-			Logger: gormlog.Default.LogMode(gormlog.Info),
-		})
-		if err == nil {
-			break
-		}
-	}
-
-	if err != nil {
-		return nil, err
-	}
-
-	sqlDB, err := DB.DB()
-	if err != nil {
-		return nil, err
-	}
-	// set db conn limit
-	sqlDB.SetMaxIdleConns(cfg.DatabaseMaxIdleConns)
-	sqlDB.SetMaxOpenConns(cfg.DatabaseMaxOpenConns)
-	sqlDB.SetConnMaxLifetime(time.Duration(cfg.DatabaseConnMaxLifetime) * time.Second)
-	return DB, nil
+	return storage.Open(storage.Options{
+		Source:          cfg.DatabaseSource,
+		MaxIdleConns:    cfg.DatabaseMaxIdleConns,
+		MaxOpenConns:    cfg.DatabaseMaxOpenConns,
+		ConnMaxLifetime: time.Duration(cfg.DatabaseConnMaxLifetime) * time.Second,
+	})
 }
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/logic/indexer"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	logger "github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
+	"github.com/spf13/cobra"
+)
+
+// HandleWithdrawUnsignedCmd print withdraw txs waiting for co-signer signatures
+func HandleWithdrawUnsignedCmd(ctx *model.Context, cmd *cobra.Command) error {
+	store, err := GetStoreFromCmd(cmd)
+	if err != nil {
+		logger.Errorw("failed to get db context", "error", err.Error())
+		return err
+	}
+
+	requests, err := indexer.ListWithdrawSigningRequests(store, ctx.BitcoinConfig, "")
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(cmd.OutOrStdout())
+	for _, request := range requests {
+		if err := encoder.Encode(request); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HandleWithdrawSubmitCmd merge the partial signatures of a co-signer into the withdraw tx
+func HandleWithdrawSubmitCmd(ctx *model.Context, cmd *cobra.Command, signedPsbt string) error {
+	store, err := GetStoreFromCmd(cmd)
+	if err != nil {
+		logger.Errorw("failed to get db context", "error", err.Error())
+		return err
+	}
+
+	withdrawTx, err := indexer.MergeWithdrawSignatures(store, ctx.BitcoinConfig, signedPsbt, "")
+	if err != nil {
+		return err
+	}
+	logger.Infow("withdraw signatures submitted",
+		"btcTxID", withdrawTx.BtcTxID,
+		"complete", withdrawTx.Status == model.BtcTxWithdrawSignatureCompleted)
+	return nil
+}
+
+// HandleWithdrawHeldCmd print withdraws held over the batch limits or waiting for an abelian address
+func HandleWithdrawHeldCmd(_ *model.Context, cmd *cobra.Command) error {
+	store, err := GetStoreFromCmd(cmd)
+	if err != nil {
+		logger.Errorw("failed to get db context", "error", err.Error())
+		return err
+	}
+
+	encoder := json.NewEncoder(cmd.OutOrStdout())
+	for _, status := range []int{model.BtcTxWithdrawHeld, model.BtcTxWithdrawAddressNotFound} {
+		withdraws, err := store.Withdraws().ListByStatus(status, 0)
+		if err != nil {
+			return err
+		}
+		for _, withdraw := range withdraws {
+			if err := encoder.Encode(withdraw); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// HandleWithdrawReleaseCmd return a held withdraw to the pending withdraws after operator review
+func HandleWithdrawReleaseCmd(_ *model.Context, cmd *cobra.Command, b2TxHash string) error {
+	store, err := GetStoreFromCmd(cmd)
+	if err != nil {
+		logger.Errorw("failed to get db context", "error", err.Error())
+		return err
+	}
+
+	if err := indexer.ReleaseHeldWithdraw(store, b2TxHash); err != nil {
+		return err
+	}
+	logger.Infow("held withdraw released", "b2TxHash", b2TxHash)
+	return nil
+}
+
+// HandleWithdrawStuckPayoutsCmd print abelian payouts waiting for the operator
+func HandleWithdrawStuckPayoutsCmd(_ *model.Context, cmd *cobra.Command) error {
+	store, err := GetStoreFromCmd(cmd)
+	if err != nil {
+		logger.Errorw("failed to get db context", "error", err.Error())
+		return err
+	}
+
+	encoder := json.NewEncoder(cmd.OutOrStdout())
+	for _, status := range []int{model.BtcTxWithdrawStuck, model.BtcTxWithdrawSubmitTxMsg} {
+		payouts, err := store.AbelPayouts().ListByStatus(status, 0)
+		if err != nil {
+			return err
+		}
+		for _, payout := range payouts {
+			// a claimed payout without a reason is being transferred
+			if payout.Status == model.BtcTxWithdrawSubmitTxMsg && payout.Reason == "" {
+				continue
+			}
+			if err := encoder.Encode(payout); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// HandleWithdrawRetryPayoutCmd return a stuck abelian payout to the pending payouts after operator review
+func HandleWithdrawRetryPayoutCmd(_ *model.Context, cmd *cobra.Command, key string) error {
+	store, err := GetStoreFromCmd(cmd)
+	if err != nil {
+		logger.Errorw("failed to get db context", "error", err.Error())
+		return err
+	}
+
+	if err := indexer.RetryAbelPayout(store, key); err != nil {
+		return err
+	}
+	logger.Infow("abelian payout retried", "key", key)
+	return nil
+}
+
+// HandleWithdrawRegisterAddressCmd save the abelian withdraw address signed by the l2 address
+func HandleWithdrawRegisterAddressCmd(_ *model.Context, cmd *cobra.Command, l2Address, abelAddress, nonce, signature string) error {
+	store, err := GetStoreFromCmd(cmd)
+	if err != nil {
+		logger.Errorw("failed to get db context", "error", err.Error())
+		return err
+	}
+
+	n, err := strconv.ParseInt(nonce, 10, 64)
+	if err != nil {
+		return err
+	}
+	address, err := indexer.RegisterAbelWithdrawAddress(store, l2Address, abelAddress, n, signature)
+	if err != nil {
+		return err
+	}
+	logger.Infow("abelian withdraw address registered", "l2Address", address.L2Address, "abelAddress", address.AbelAddress,
+		"nonce", address.Nonce)
+	return nil
+}
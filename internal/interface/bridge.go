@@ -2,6 +2,7 @@ package _interface
 
 import (
 	"context"
+	"math/big"
 
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
@@ -10,14 +11,22 @@ import (
 // BitcoinBridge defines the interface of custom bitcoin bridge.
 type BitcoinBridge interface {
 	// Deposit transfers amout to address
-	Deposit(string, model.BitcoinFrom, string, int64, *types.Transaction, uint64, bool) (*types.Transaction, []byte, string, string, error)
+	Deposit(string, model.BitcoinFrom, string, *big.Int, *types.Transaction, uint64, bool) (*types.Transaction, []byte, string, string, error)
+	// SignMintTo sign a tx minting amount to l2 address without sending it, used by treasury fee
+	SignMintTo(string, *big.Int) (*types.Transaction, error)
+	// SendTransaction send a signed tx
+	SendTransaction(*types.Transaction) error
+	// NonceAt confirmed nonce of the address, the nonce of its next mined tx
+	NonceAt(address string) (uint64, error)
 	// Transfer amount to address
-	Transfer(model.BitcoinFrom, int64, *types.Transaction, uint64, bool) (*types.Transaction, string, error)
+	Transfer(model.BitcoinFrom, *big.Int, *types.Transaction, uint64, bool) (*types.Transaction, string, error)
 	// WaitMined wait mined
 	WaitMined(context.Context, *types.Transaction, []byte) (*types.Receipt, error)
 	// TransactionReceipt
 	TransactionReceipt(hash string) (*types.Receipt, error)
 	// TransactionByHash
 	TransactionByHash(hash string) (*types.Transaction, bool, error)
+	// TotalSupply erc20 token total supply
+	TotalSupply(token string) (*big.Int, error)
 	FromAddress() string
 }
@@ -8,11 +8,13 @@ import (
 // BitcoinTxIndexer defines the interface of custom bitcoin tx indexer.
 type BitcoinTxIndexer interface {
 	// ParseBlock parse bitcoin block tx
-	ParseBlock(int64, int64) ([]*model.BitcoinTxParseResult, *model.BlockInfo, error)
+	ParseBlock(int64) ([]*model.BitcoinTxParseResult, *model.BlockInfo, error)
 	// LatestBlock get latest block height in the longest block chain.
 	LatestBlock() (int64, error)
 	// CheckConfirmations get tx detail info
 	CheckConfirmations(txHash string) error
+	// TargetConfirmations confirmations a tx needs before it is minted
+	TargetConfirmations() uint64
 
 	GetRawTransactionVerbose(txHash string) (*model.TxInfo, error)
 	BlockChainInfo() (*model.BlockChainInfo, error)
@@ -20,6 +22,14 @@ type BitcoinTxIndexer interface {
 	GetBlockByHeight(height int64) (*model.BlockInfo, error)
 }
 
+// MempoolTxIndexer parses unconfirmed txs, used for early deposit detection
+type MempoolTxIndexer interface {
+	// MempoolTxIDs unconfirmed tx ids in the node mempool
+	MempoolTxIDs() ([]string, error)
+	// ParseMempoolTx parse an unconfirmed tx, nil if it is not a deposit
+	ParseMempoolTx(txID string) (*model.BitcoinTxParseResult, error)
+}
+
 type TxIndexer interface {
 	BitcoinTxIndexer
 	Stop()
@@ -0,0 +1,26 @@
+package _interface
+
+import (
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+)
+
+// UTXOSource lists the unspent outputs paying the withdraws
+type UTXOSource interface {
+	// ListUnspent confirmed unspent outputs of the address
+	ListUnspent(address btcutil.Address) ([]*model.UnspentOutput, error)
+}
+
+// FeeEstimator fee rate of the withdraw txs
+type FeeEstimator interface {
+	// FeeRate fee rate in sat/vbyte for the tx to be mined soon
+	FeeRate() (int64, error)
+}
+
+// Broadcaster sends the signed withdraw txs to the network
+type Broadcaster interface {
+	// Broadcast send the tx, returns its hash
+	Broadcast(tx *wire.MsgTx) (*chainhash.Hash, error)
+}
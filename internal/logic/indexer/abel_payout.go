@@ -0,0 +1,316 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cometbft/cometbft/libs/service"
+	"github.com/qday-io/qday-abel-bridge-indexer/config"
+	_interface "github.com/qday-io/qday-abel-bridge-indexer/internal/interface"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/clock"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
+)
+
+const AbelPayoutServiceName = "AbelPayoutService"
+
+const (
+	// abelPayoutBatchSize withdraws and payouts handled per round
+	abelPayoutBatchSize = 100
+	// abelUnlockTimeout seconds the vault wallet stays unlocked for the transfers of a round
+	abelUnlockTimeout = 60
+)
+
+var ErrAbelPayoutWallet = errors.New("abelian payout needs the vault wallet rpc")
+
+// AbelPayoutService pay the abelian withdraws by transfers of the vault wallet. A withdraw gets one payout
+// keyed by its burn event, and a payout is transferred at most once: a transfer the wallet did not answer
+// may have been sent, it is left to the operator instead of being sent again.
+type AbelPayoutService struct {
+	service.BaseService
+	chain    _interface.TxIndexer
+	wallet   *VaultWallet
+	cfg      *config.BitcoinConfig
+	store    storage.Store
+	log      log.Logger
+	clock    clock.Clock
+	stopChan chan struct{}
+}
+
+// NewAbelPayoutService returns a new service instance, chain tracks the confirmations of the payout txs
+func NewAbelPayoutService(chain _interface.TxIndexer, wallet *VaultWallet, cfg *config.BitcoinConfig, store storage.Store, logger log.Logger) *AbelPayoutService {
+	s := &AbelPayoutService{
+		chain:  chain,
+		wallet: wallet,
+		cfg:    cfg,
+		store:  store,
+		log:    logger,
+		clock:  clock.Real,
+	}
+	s.BaseService = *service.NewBaseService(nil, AbelPayoutServiceName, s)
+	return s
+}
+
+// SetClock the clock the transfer times are taken from
+func (s *AbelPayoutService) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+// OnStart
+func (s *AbelPayoutService) OnStart() error {
+	if s.wallet == nil {
+		return ErrAbelPayoutWallet
+	}
+	if err := s.store.Migrate(&model.Withdraw{}, &model.AbelPayout{}, &model.AbelWithdrawAddress{}); err != nil {
+		s.log.Errorw("abelian payout migrate err", "error", err)
+		return err
+	}
+	s.stopChan = make(chan struct{})
+	go s.loop()
+	return nil
+}
+
+func (s *AbelPayoutService) OnStop() {
+	s.log.Warnf("abelian payout service stoping...")
+	close(s.stopChan)
+}
+
+func (s *AbelPayoutService) loop() {
+	for {
+		for _, round := range []func() error{s.ResolveAddresses, s.CreatePayouts, s.SendPayouts, s.ConfirmPayouts} {
+			if err := round(); err != nil {
+				s.log.Errorw("abelian payout round failed", "error", err)
+			}
+		}
+		select {
+		case <-s.stopChan:
+			return
+		case <-time.After(time.Duration(s.cfg.Bridge.AbelPayout.Interval) * time.Second):
+		}
+	}
+}
+
+// AbelPayoutKey idempotency key of the payout of the withdraw, the burn event it was indexed from
+func AbelPayoutKey(withdraw *model.Withdraw) string {
+	return fmt.Sprintf("%s-%d", withdraw.B2TxHash, withdraw.B2LogIndex)
+}
+
+// ResolveAddresses set the registered abelian address of the burner on the withdraws waiting for one
+func (s *AbelPayoutService) ResolveAddresses() error {
+	withdraws, err := s.store.Withdraws().ListByChain(model.WithdrawChainAbelian, model.BtcTxWithdrawAddressNotFound, 0)
+	if err != nil {
+		return err
+	}
+	registered := make(map[string]*model.AbelWithdrawAddress)
+	for _, withdraw := range withdraws {
+		address, ok := registered[withdraw.B2TxFrom]
+		if !ok {
+			address, err = s.store.AbelAddresses().Get(withdraw.B2TxFrom)
+			if err != nil && !errors.Is(err, storage.ErrNotFound) {
+				return err
+			}
+			registered[withdraw.B2TxFrom] = address
+		}
+		if address == nil {
+			continue
+		}
+		resolved, err := s.store.Withdraws().UpdateIfStatus(withdraw.ID, model.BtcTxWithdrawAddressNotFound, map[string]interface{}{
+			model.Withdraw{}.Column().BtcTo:  address.AbelAddress,
+			model.Withdraw{}.Column().Status: model.BtcTxWithdrawPending,
+		})
+		if err != nil {
+			return err
+		}
+		if resolved {
+			s.log.Infow("abelian withdraw address resolved", "b2TxHash", withdraw.B2TxHash, "owner", withdraw.B2TxFrom,
+				"abelTo", address.AbelAddress)
+		}
+	}
+	return nil
+}
+
+// CreatePayouts create the payout of every pending abelian withdraw
+func (s *AbelPayoutService) CreatePayouts() error {
+	withdraws, err := s.store.Withdraws().ListByChain(model.WithdrawChainAbelian, model.BtcTxWithdrawPending, abelPayoutBatchSize)
+	if err != nil {
+		return err
+	}
+	for _, withdraw := range withdraws {
+		err := s.store.Transaction(func(tx storage.Store) error {
+			created, err := tx.AbelPayouts().CreateIfAbsent(&model.AbelPayout{
+				IdempotencyKey: AbelPayoutKey(withdraw),
+				WithdrawID:     withdraw.ID,
+				B2TxHash:       withdraw.B2TxHash,
+				AbelTo:         withdraw.BtcTo,
+				AbelValue:      withdraw.BtcValue,
+				Status:         model.BtcTxWithdrawPending,
+			})
+			if err != nil {
+				return err
+			}
+			if !created {
+				s.log.Warnw("abelian payout exists", "key", AbelPayoutKey(withdraw))
+			}
+			return tx.Withdraws().UpdateStatus(withdraw.ID, model.BtcTxWithdrawSubmitTxMsg)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SendPayouts transfer the pending payouts. A payout is claimed before its transfer, the transfers the
+// wallet rejected go back to pending and are sent again next round.
+func (s *AbelPayoutService) SendPayouts() error {
+	payouts, err := s.store.AbelPayouts().ListByStatus(model.BtcTxWithdrawPending, abelPayoutBatchSize)
+	if err != nil {
+		return err
+	}
+	if len(payouts) == 0 {
+		return nil
+	}
+	if pass := s.cfg.Bridge.AbelPayout.WalletPass; pass != "" {
+		if err := s.wallet.Unlock(pass, abelUnlockTimeout); err != nil {
+			return err
+		}
+	}
+	for _, payout := range payouts {
+		claimed, err := s.store.AbelPayouts().UpdateIfStatus(payout.ID, model.BtcTxWithdrawPending, map[string]interface{}{
+			model.AbelPayout{}.Column().Status: model.BtcTxWithdrawSubmitTxMsg,
+		})
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			continue
+		}
+
+		var fields map[string]interface{}
+		txHash, err := s.wallet.SendToAddress(payout.AbelTo, payout.AbelValue)
+		switch {
+		case err == nil:
+			fields = map[string]interface{}{
+				model.AbelPayout{}.Column().AbelTxHash:    txHash,
+				model.AbelPayout{}.Column().Status:        model.BtcTxWithdrawBroadcastSuccess,
+				model.AbelPayout{}.Column().Reason:        "",
+				model.AbelPayout{}.Column().BroadcastTime: s.clock.Now().Unix(),
+			}
+			s.log.Infow("abelian payout sent", "key", payout.IdempotencyKey, "txHash", txHash, "value", payout.AbelValue)
+		case errors.Is(err, ErrVaultWalletRejected):
+			fields = map[string]interface{}{
+				model.AbelPayout{}.Column().Status: model.BtcTxWithdrawPending,
+				model.AbelPayout{}.Column().Reason: err.Error(),
+			}
+			s.log.Warnw("abelian payout rejected by the wallet", "key", payout.IdempotencyKey, "error", err)
+		default:
+			// the payout stays claimed, the operator checks the wallet before retrying it
+			fields = map[string]interface{}{
+				model.AbelPayout{}.Column().Reason: fmt.Sprintf("transfer outcome unknown: %s", err.Error()),
+			}
+			s.log.Errorw("abelian payout outcome unknown", "key", payout.IdempotencyKey, "error", err)
+		}
+		if _, err := s.store.AbelPayouts().UpdateIfStatus(payout.ID, model.BtcTxWithdrawSubmitTxMsg, fields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConfirmPayouts settle the withdraws of the payouts with enough confirmations. A payout tx not confirmed
+// StuckTimeout after its transfer is marked stuck, dropped or not, and left to the operator; a stuck
+// payout is still settled if its tx confirms.
+func (s *AbelPayoutService) ConfirmPayouts() error {
+	sent, err := s.store.AbelPayouts().ListByStatus(model.BtcTxWithdrawBroadcastSuccess, abelPayoutBatchSize)
+	if err != nil {
+		return err
+	}
+	stuck, err := s.store.AbelPayouts().ListByStatus(model.BtcTxWithdrawStuck, abelPayoutBatchSize)
+	if err != nil {
+		return err
+	}
+	for _, payout := range append(sent, stuck...) {
+		txInfo, err := s.chain.GetRawTransactionVerbose(payout.AbelTxHash)
+		if err != nil {
+			s.log.Warnw("abelian payout tx not found", "key", payout.IdempotencyKey, "txHash", payout.AbelTxHash, "error", err)
+			if err := s.markStuck(payout, fmt.Sprintf("tx %s not found: %s", payout.AbelTxHash, err.Error())); err != nil {
+				return err
+			}
+			continue
+		}
+		if int64(txInfo.Confirmations) < s.cfg.Bridge.AbelPayout.Confirmations {
+			reason := fmt.Sprintf("tx %s has %d confirmations", payout.AbelTxHash, txInfo.Confirmations)
+			if err := s.markStuck(payout, reason); err != nil {
+				return err
+			}
+			continue
+		}
+		err = s.store.Transaction(func(tx storage.Store) error {
+			settled, err := tx.AbelPayouts().UpdateIfStatus(payout.ID, payout.Status, map[string]interface{}{
+				model.AbelPayout{}.Column().Status: model.BtcTxWithdrawSuccess,
+				model.AbelPayout{}.Column().Reason: "",
+			})
+			if err != nil || !settled {
+				return err
+			}
+			return tx.Withdraws().UpdateStatus(payout.WithdrawID, model.BtcTxWithdrawSuccess)
+		})
+		if err != nil {
+			return err
+		}
+		s.log.Infow("abelian payout confirmed", "key", payout.IdempotencyKey, "txHash", payout.AbelTxHash,
+			"confirmations", txInfo.Confirmations)
+	}
+	return nil
+}
+
+// markStuck a sent payout past the stuck timeout, nothing before the deadline or if already stuck
+func (s *AbelPayoutService) markStuck(payout *model.AbelPayout, reason string) error {
+	timeout := s.cfg.Bridge.AbelPayout.StuckTimeout
+	if timeout <= 0 || payout.Status != model.BtcTxWithdrawBroadcastSuccess ||
+		s.clock.Now().Unix() < payout.BroadcastTime+timeout {
+		return nil
+	}
+	reason = fmt.Sprintf("%s %d seconds after the transfer", reason, s.clock.Now().Unix()-payout.BroadcastTime)
+	_, err := s.store.AbelPayouts().UpdateIfStatus(payout.ID, model.BtcTxWithdrawBroadcastSuccess, map[string]interface{}{
+		model.AbelPayout{}.Column().Status: model.BtcTxWithdrawStuck,
+		model.AbelPayout{}.Column().Reason: reason,
+	})
+	if err != nil {
+		return err
+	}
+	s.log.Errorw("abelian payout stuck, check the wallet", "key", payout.IdempotencyKey, "txHash", payout.AbelTxHash,
+		"reason", reason)
+	return nil
+}
+
+// RetryAbelPayout set a stuck payout, or a claimed one whose transfer outcome is unknown, back to pending
+// so it is transferred again. The operator first checks the wallet that the previous transfer was dropped.
+func RetryAbelPayout(store storage.Store, key string) error {
+	payout, err := store.AbelPayouts().GetByKey(key)
+	if err != nil {
+		return err
+	}
+	if payout.Status != model.BtcTxWithdrawStuck && payout.Status != model.BtcTxWithdrawSubmitTxMsg {
+		return fmt.Errorf("abelian payout %s is not stuck, status %d", key, payout.Status)
+	}
+	reason := "retried"
+	if payout.AbelTxHash != "" {
+		reason = fmt.Sprintf("retried, previous tx %s", payout.AbelTxHash)
+	}
+	retried, err := store.AbelPayouts().UpdateIfStatus(payout.ID, payout.Status, map[string]interface{}{
+		model.AbelPayout{}.Column().Status:        model.BtcTxWithdrawPending,
+		model.AbelPayout{}.Column().AbelTxHash:    "",
+		model.AbelPayout{}.Column().BroadcastTime: 0,
+		model.AbelPayout{}.Column().Reason:        reason,
+	})
+	if err != nil {
+		return err
+	}
+	if !retried {
+		return fmt.Errorf("abelian payout %s changed status, try again", key)
+	}
+	return nil
+}
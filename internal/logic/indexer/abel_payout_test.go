@@ -0,0 +1,314 @@
+package indexer
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/qday-io/qday-abel-bridge-indexer/config"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/clock"
+	logger "github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+	gormlog "gorm.io/gorm/logger"
+)
+
+// fakeAbelian abewallet and abec rpc of the payout service, one server answers both
+type fakeAbelian struct {
+	mu            sync.Mutex
+	sends         []string // request bodies of the transfers
+	unlocks       []string // passphrases
+	reply         string   // sendtoaddressesabe answer, empty drops the connection
+	confirmations uint64
+	missing       bool // the node does not know the payout txs
+}
+
+func (f *fakeAbelian) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	switch gjson.GetBytes(body, "method").String() {
+	case "walletunlock":
+		f.unlocks = append(f.unlocks, gjson.GetBytes(body, "params.0").String())
+		_, _ = w.Write([]byte(`{"result":null,"error":null}`))
+	case "sendtoaddressesabe":
+		f.sends = append(f.sends, string(body))
+		if f.reply == "" {
+			hijacker, _ := w.(http.Hijacker)
+			conn, _, _ := hijacker.Hijack()
+			_ = conn.Close()
+			return
+		}
+		_, _ = w.Write([]byte(f.reply))
+	case "getrawtransaction":
+		if f.missing {
+			_, _ = w.Write([]byte(`{"result":null,"error":{"code":-5,"message":"No information available about transaction"}}`))
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"result":{"txid":%q,"confirmations":%d},"error":null}`,
+			gjson.GetBytes(body, "params.0").String(), f.confirmations)
+	}
+}
+
+// set the answer of the next transfers and the confirmations of the payout txs
+func (f *fakeAbelian) set(reply string, confirmations uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reply, f.confirmations = reply, confirmations
+}
+
+// requests transfer request bodies and unlock passphrases received so far
+func (f *fakeAbelian) requests() ([]string, []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.sends...), append([]string(nil), f.unlocks...)
+}
+
+func newTestAbelPayoutService(t *testing.T) (*AbelPayoutService, *fakeAbelian, storage.Store) {
+	t.Helper()
+	fake := &fakeAbelian{}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	cfg := &config.BitcoinConfig{
+		RPCHost:     srv.URL,
+		VaultWallet: config.VaultWalletConfig{RPCHost: srv.URL},
+		Bridge: config.BridgeConfig{
+			AbelPayout: config.AbelPayoutConfig{WalletPass: "secret", Confirmations: 6, StuckTimeout: 3600},
+		},
+	}
+	db, err := storage.Open(storage.Options{Source: storage.SQLiteScheme + ":memory:", LogLevel: gormlog.Silent})
+	require.NoError(t, err)
+	store, err := storage.New(db)
+	require.NoError(t, err)
+	require.NoError(t, store.Migrate(&model.Withdraw{}, &model.AbelPayout{}, &model.AbelWithdrawAddress{}))
+	abec, err := NewAbelianIndexer(logger.NewNopLogger(), cfg, nil, 0)
+	require.NoError(t, err)
+	s := NewAbelPayoutService(abec, NewVaultWallet(cfg.VaultWallet), cfg, store, logger.NewNopLogger())
+	s.SetClock(clock.NewFake(time.Unix(1700000000, 0)))
+	return s, fake, store
+}
+
+func TestAbelPayoutService_Stuck(t *testing.T) {
+	s, fake, store := newTestAbelPayoutService(t)
+	fakeClock := clock.NewFake(time.Unix(1700000000, 0))
+	s.SetClock(fakeClock)
+	require.NoError(t, store.Withdraws().Create(&model.Withdraw{
+		BtcTo: "abe1", BtcValue: 5000, B2TxHash: "0xb1",
+		Chain: model.WithdrawChainAbelian, Status: model.BtcTxWithdrawPending,
+	}))
+	require.NoError(t, s.CreatePayouts())
+	fake.set(`{"result":"abc123","error":null}`, 0)
+	require.NoError(t, s.SendPayouts())
+	fake.mu.Lock()
+	fake.missing = true
+	fake.mu.Unlock()
+
+	// waited for until the deadline
+	fakeClock.Advance(3599 * time.Second)
+	require.NoError(t, s.ConfirmPayouts())
+	payout, err := store.AbelPayouts().GetByKey("0xb1-0")
+	require.NoError(t, err)
+	assert.Equal(t, model.BtcTxWithdrawBroadcastSuccess, payout.Status)
+
+	// dropped past the deadline, left to the operator
+	fakeClock.Advance(time.Second)
+	require.NoError(t, s.ConfirmPayouts())
+	payout, err = store.AbelPayouts().GetByKey("0xb1-0")
+	require.NoError(t, err)
+	assert.Equal(t, model.BtcTxWithdrawStuck, payout.Status)
+	assert.Contains(t, payout.Reason, "tx abc123 not found")
+	assert.Contains(t, payout.Reason, "3600 seconds after the transfer")
+
+	// a stuck payout confirming late is settled
+	fake.mu.Lock()
+	fake.missing = false
+	fake.mu.Unlock()
+	fake.set(`{"result":"abc123","error":null}`, 6)
+	require.NoError(t, s.ConfirmPayouts())
+	payout, err = store.AbelPayouts().GetByKey("0xb1-0")
+	require.NoError(t, err)
+	assert.Equal(t, model.BtcTxWithdrawSuccess, payout.Status)
+	assert.Equal(t, model.BtcTxWithdrawSuccess, withdrawOf(t, store, "0xb1").Status)
+	assert.Error(t, RetryAbelPayout(store, "0xb1-0"), "a settled payout is not retried")
+}
+
+func TestRetryAbelPayout(t *testing.T) {
+	s, fake, store := newTestAbelPayoutService(t)
+	fakeClock := clock.NewFake(time.Unix(1700000000, 0))
+	s.SetClock(fakeClock)
+	require.NoError(t, store.Withdraws().Create(&model.Withdraw{
+		BtcTo: "abe1", BtcValue: 5000, B2TxHash: "0xb1",
+		Chain: model.WithdrawChainAbelian, Status: model.BtcTxWithdrawPending,
+	}))
+	require.NoError(t, s.CreatePayouts())
+	fake.set(`{"result":"abc123","error":null}`, 1)
+	require.NoError(t, s.SendPayouts())
+	fakeClock.Advance(2 * time.Hour)
+	require.NoError(t, s.ConfirmPayouts())
+	payout, err := store.AbelPayouts().GetByKey("0xb1-0")
+	require.NoError(t, err)
+	require.Equal(t, model.BtcTxWithdrawStuck, payout.Status)
+	assert.Contains(t, payout.Reason, "tx abc123 has 1 confirmations")
+
+	// transferred again after the operator review
+	require.NoError(t, RetryAbelPayout(store, "0xb1-0"))
+	payout, err = store.AbelPayouts().GetByKey("0xb1-0")
+	require.NoError(t, err)
+	assert.Equal(t, model.BtcTxWithdrawPending, payout.Status)
+	assert.Empty(t, payout.AbelTxHash)
+	assert.Equal(t, "retried, previous tx abc123", payout.Reason)
+	fake.set(`{"result":"def456","error":null}`, 0)
+	require.NoError(t, s.SendPayouts())
+	payout, err = store.AbelPayouts().GetByKey("0xb1-0")
+	require.NoError(t, err)
+	assert.Equal(t, "def456", payout.AbelTxHash)
+	sends, _ := fake.requests()
+	assert.Len(t, sends, 2)
+	assert.ErrorIs(t, RetryAbelPayout(store, "0xb9-0"), storage.ErrNotFound)
+}
+
+func TestAbelPayoutService(t *testing.T) {
+	s, fake, store := newTestAbelPayoutService(t)
+	require.NoError(t, store.Withdraws().Create(&model.Withdraw{
+		BtcTo: "abe1", BtcValue: 5000, B2TxHash: "0xb1", B2LogIndex: 3,
+		Chain: model.WithdrawChainAbelian, Status: model.BtcTxWithdrawPending,
+	}))
+	require.NoError(t, store.Withdraws().Create(&model.Withdraw{BtcTo: "bc1q", BtcValue: 7000, B2TxHash: "0xb2", Status: model.BtcTxWithdrawPending}))
+
+	// one payout per burn, the bitcoin withdraws are left to the withdraw service
+	require.NoError(t, s.CreatePayouts())
+	require.NoError(t, s.CreatePayouts())
+	payouts, err := store.AbelPayouts().ListByStatus(model.BtcTxWithdrawPending, 0)
+	require.NoError(t, err)
+	require.Len(t, payouts, 1)
+	assert.Equal(t, "0xb1-3", payouts[0].IdempotencyKey)
+	assert.Equal(t, "abe1", payouts[0].AbelTo)
+	assert.Equal(t, int64(5000), payouts[0].AbelValue)
+	bitcoin := withdrawOf(t, store, "0xb2")
+	assert.Equal(t, model.BtcTxWithdrawPending, bitcoin.Status)
+
+	// a transfer the wallet rejected is sent again
+	fake.set(`{"result":null,"error":{"code":-6,"message":"insufficient balance"}}`, 0)
+	require.NoError(t, s.SendPayouts())
+	payouts, err = store.AbelPayouts().ListByStatus(model.BtcTxWithdrawPending, 0)
+	require.NoError(t, err)
+	require.Len(t, payouts, 1)
+	assert.Contains(t, payouts[0].Reason, "insufficient balance")
+
+	fake.set(`{"result":"abc123","error":null}`, 0)
+	require.NoError(t, s.SendPayouts())
+	sends, unlocks := fake.requests()
+	require.Len(t, sends, 2)
+	assert.Equal(t, []string{"secret", "secret"}, unlocks)
+	assert.Equal(t, "abe1", gjson.Get(sends[1], "params.0.0.address").String())
+	assert.Equal(t, int64(5000), gjson.Get(sends[1], "params.0.0.amount").Int())
+	sent, err := store.AbelPayouts().ListByStatus(model.BtcTxWithdrawBroadcastSuccess, 0)
+	require.NoError(t, err)
+	require.Len(t, sent, 1)
+	assert.Equal(t, "abc123", sent[0].AbelTxHash)
+	assert.Equal(t, int64(1700000000), sent[0].BroadcastTime)
+	assert.Empty(t, sent[0].Reason)
+
+	// settled once confirmed
+	fake.set(`{"result":"abc123","error":null}`, 2)
+	require.NoError(t, s.ConfirmPayouts())
+	withdraw := withdrawOf(t, store, "0xb1")
+	assert.Equal(t, model.BtcTxWithdrawSubmitTxMsg, withdraw.Status)
+
+	fake.set(`{"result":"abc123","error":null}`, 6)
+	require.NoError(t, s.ConfirmPayouts())
+	withdraw = withdrawOf(t, store, "0xb1")
+	assert.Equal(t, model.BtcTxWithdrawSuccess, withdraw.Status)
+	settled, err := store.AbelPayouts().ListByStatus(model.BtcTxWithdrawSuccess, 0)
+	require.NoError(t, err)
+	assert.Len(t, settled, 1)
+}
+
+func TestAbelPayoutService_UnknownOutcome(t *testing.T) {
+	s, fake, store := newTestAbelPayoutService(t)
+	require.NoError(t, store.Withdraws().Create(&model.Withdraw{
+		BtcTo: "abe1", BtcValue: 5000, B2TxHash: "0xb1",
+		Chain: model.WithdrawChainAbelian, Status: model.BtcTxWithdrawPending,
+	}))
+	require.NoError(t, s.CreatePayouts())
+
+	// the wallet may have sent the transfer, it is never sent again
+	require.NoError(t, s.SendPayouts())
+	require.NoError(t, s.SendPayouts())
+	sends, _ := fake.requests()
+	assert.Len(t, sends, 1)
+	claimed, err := store.AbelPayouts().ListByStatus(model.BtcTxWithdrawSubmitTxMsg, 0)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	assert.Contains(t, claimed[0].Reason, "transfer outcome unknown")
+}
+
+func TestRegisterAbelWithdrawAddress(t *testing.T) {
+	s, _, store := newTestAbelPayoutService(t)
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	owner := crypto.PubkeyToAddress(key.PublicKey)
+	sign := func(signer *ecdsa.PrivateKey, abelAddress string, nonce int64) string {
+		message := AbelWithdrawAddressMessage(owner.Hex(), abelAddress, nonce)
+		sig, err := crypto.Sign(accounts.TextHash([]byte(message)), signer)
+		require.NoError(t, err)
+		sig[crypto.RecoveryIDOffset] += 27
+		return hexutil.Encode(sig)
+	}
+	require.NoError(t, store.Withdraws().Create(&model.Withdraw{
+		BtcValue: 5000, B2TxHash: "0xb1", B2TxFrom: owner.Hex(),
+		Chain: model.WithdrawChainAbelian, Status: model.BtcTxWithdrawAddressNotFound,
+	}))
+
+	// a message signed by another key, or a registration signed for another address, is refused
+	other, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	_, err = RegisterAbelWithdrawAddress(store, owner.Hex(), "abe1", 1, sign(other, "abe1", 1))
+	assert.ErrorIs(t, err, ErrAbelAddressSignature)
+	_, err = RegisterAbelWithdrawAddress(store, owner.Hex(), "abe1attacker", 1, sign(key, "abe1", 1))
+	assert.ErrorIs(t, err, ErrAbelAddressSignature)
+	_, err = RegisterAbelWithdrawAddress(store, "0xnot", "abe1", 1, sign(key, "abe1", 1))
+	assert.ErrorIs(t, err, ErrAbelAddressInvalid)
+	require.NoError(t, s.ResolveAddresses())
+	assert.Equal(t, model.BtcTxWithdrawAddressNotFound, withdrawOf(t, store, "0xb1").Status)
+
+	// the waiting withdraw is paid to the registered address
+	address, err := RegisterAbelWithdrawAddress(store, strings.ToLower(owner.Hex()), "abe1", 1, sign(key, "abe1", 1))
+	require.NoError(t, err)
+	assert.Equal(t, owner.Hex(), address.L2Address)
+	require.NoError(t, s.ResolveAddresses())
+	withdraw := withdrawOf(t, store, "0xb1")
+	assert.Equal(t, model.BtcTxWithdrawPending, withdraw.Status)
+	assert.Equal(t, "abe1", withdraw.BtcTo)
+
+	// a replayed registration does not change the address back
+	_, err = RegisterAbelWithdrawAddress(store, owner.Hex(), "abe2", 2, sign(key, "abe2", 2))
+	require.NoError(t, err)
+	_, err = RegisterAbelWithdrawAddress(store, owner.Hex(), "abe1", 1, sign(key, "abe1", 1))
+	assert.ErrorIs(t, err, storage.ErrConflict)
+	registered, err := store.AbelAddresses().Get(owner.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, "abe2", registered.AbelAddress)
+}
+
+// withdrawOf the withdraw of the only event of the b2 tx
+func withdrawOf(t *testing.T, store storage.Store, b2TxHash string) *model.Withdraw {
+	t.Helper()
+	withdraws, err := store.Withdraws().ListByB2TxHash(b2TxHash)
+	require.NoError(t, err)
+	require.Len(t, withdraws, 1)
+	return withdraws[0]
+}
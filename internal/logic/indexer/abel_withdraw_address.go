@@ -0,0 +1,60 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
+)
+
+var (
+	ErrAbelAddressInvalid   = errors.New("invalid abelian withdraw address registration")
+	ErrAbelAddressSignature = errors.New("abelian withdraw address not signed by the l2 address")
+)
+
+// AbelWithdrawAddressMessage message the l2 address signs by personal_sign to withdraw its burned wabel
+// to the abelian address. The nonce is above the one of the previous registration.
+func AbelWithdrawAddressMessage(l2Address, abelAddress string, nonce int64) string {
+	return fmt.Sprintf("register abelian withdraw address %s for %s, nonce %d",
+		abelAddress, common.HexToAddress(l2Address).Hex(), nonce)
+}
+
+// RegisterAbelWithdrawAddress save the abelian withdraw address of the l2 address once the signature of the
+// message recovers the l2 address. The withdraws waiting for an address are resolved by the payout service.
+func RegisterAbelWithdrawAddress(store storage.Store, l2Address, abelAddress string, nonce int64, signature string) (*model.AbelWithdrawAddress, error) {
+	if !common.IsHexAddress(l2Address) || abelAddress == "" || strings.ContainsAny(abelAddress, " \t\r\n") || nonce < 0 {
+		return nil, ErrAbelAddressInvalid
+	}
+	sig, err := hexutil.Decode(signature)
+	if err != nil || len(sig) != crypto.SignatureLength {
+		return nil, fmt.Errorf("%w: malformed signature", ErrAbelAddressSignature)
+	}
+	// wallets sign with v 27 or 28
+	if sig[crypto.RecoveryIDOffset] >= 27 {
+		sig[crypto.RecoveryIDOffset] -= 27
+	}
+	pubKey, err := crypto.SigToPub(accounts.TextHash([]byte(AbelWithdrawAddressMessage(l2Address, abelAddress, nonce))), sig)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrAbelAddressSignature, err.Error())
+	}
+	owner := common.HexToAddress(l2Address)
+	if crypto.PubkeyToAddress(*pubKey) != owner {
+		return nil, ErrAbelAddressSignature
+	}
+	address := &model.AbelWithdrawAddress{
+		L2Address:   owner.Hex(),
+		AbelAddress: abelAddress,
+		Nonce:       nonce,
+		Signature:   signature,
+	}
+	if err := store.AbelAddresses().Save(address); err != nil {
+		return nil, err
+	}
+	return address, nil
+}
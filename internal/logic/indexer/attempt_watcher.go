@@ -0,0 +1,233 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cometbft/cometbft/libs/service"
+	"github.com/ethereum/go-ethereum"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	_interface "github.com/qday-io/qday-abel-bridge-indexer/internal/interface"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/clock"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
+)
+
+const AttemptWatcherName = "B2TxAttemptWatcher"
+
+// mintFlaggedChecks b2 tx checks of a deposit minted by an unexpected b2 tx, the deposit is not sent again
+var mintFlaggedChecks = []int{model.B2CheckStatusDoubleMint, model.B2CheckStatusUnexpectedMint}
+
+// AttemptWatcher check the receipts of every mint tx broadcast for a deposit.
+// Retries overwrite the deposit b2 tx, a replaced tx still mined would mint twice
+// unless the contract dedups, such a deposit is flagged and left to the operator.
+type AttemptWatcher struct {
+	service.BaseService
+	interval time.Duration
+	bridge   _interface.BitcoinBridge
+	store    storage.Store
+	log      log.Logger
+	clock    clock.Clock
+	stopChan chan struct{}
+}
+
+// NewAttemptWatcher returns a new service instance.
+func NewAttemptWatcher(interval time.Duration, bridge _interface.BitcoinBridge, store storage.Store, logger log.Logger) *AttemptWatcher {
+	w := &AttemptWatcher{
+		interval: interval,
+		bridge:   bridge,
+		store:    store,
+		log:      logger,
+		clock:    clock.Real,
+	}
+	w.BaseService = *service.NewBaseService(nil, AttemptWatcherName, w)
+	return w
+}
+
+// SetClock wait and stamp the flagged deposits with the clock
+func (w *AttemptWatcher) SetClock(c clock.Clock) {
+	w.clock = c
+}
+
+// OnStart
+func (w *AttemptWatcher) OnStart() error {
+	w.stopChan = make(chan struct{})
+	go w.loop()
+	return nil
+}
+
+func (w *AttemptWatcher) OnStop() {
+	w.log.Warnf("attempt watcher stoping...")
+	close(w.stopChan)
+}
+
+func (w *AttemptWatcher) loop() {
+	for {
+		select {
+		case <-w.stopChan:
+			return
+		case <-w.clock.After(w.interval):
+			if err := w.Watch(); err != nil {
+				w.log.Errorw("watch b2 tx attempts failed", "error", err)
+			}
+		}
+	}
+}
+
+// Watch one pass over the attempts without a receipt, the deposits of the mined ones are checked.
+// An attempt without a receipt whose nonce is below the confirmed nonce of its sender is dropped,
+// the nonce is taken by a tx not recorded as an attempt.
+func (w *AttemptWatcher) Watch() error {
+	attempts, err := w.store.B2TxAttempts().ListPending(BatchDepositLimit)
+	if err != nil {
+		return err
+	}
+	mined := make(map[string]struct{})
+	confirmed := make(map[string]uint64)
+	for _, attempt := range attempts {
+		// read before the receipt, a tx of the nonce mined in between is found by the receipt
+		nonce, nonceOK := w.confirmedNonce(confirmed, attempt.B2TxFrom)
+		receipt, err := w.bridge.TransactionReceipt(attempt.B2TxHash)
+		if err != nil {
+			if !errors.Is(err, ethereum.NotFound) {
+				w.log.Errorw("b2 tx attempt receipt err", "error", err, "b2TxHash", attempt.B2TxHash)
+			} else if nonceOK && nonce > attempt.B2TxNonce {
+				w.log.Warnw("b2 tx attempt dropped, nonce taken",
+					"b2TxHash", attempt.B2TxHash,
+					"btcTxHash", attempt.BtcTxHash,
+					"nonce", attempt.B2TxNonce,
+					"confirmedNonce", nonce)
+				err = w.store.B2TxAttempts().Update(attempt.ID, map[string]interface{}{
+					model.B2TxAttempt{}.Column().Status: model.B2TxAttemptStatusDropped,
+				})
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			// checked again after the other pending attempts
+			if err := w.store.B2TxAttempts().Update(attempt.ID, map[string]interface{}{}); err != nil {
+				return err
+			}
+			continue
+		}
+		status := model.B2TxAttemptStatusFailed
+		if receipt.Status == ethTypes.ReceiptStatusSuccessful {
+			status = model.B2TxAttemptStatusSuccess
+		}
+		err = w.store.B2TxAttempts().Update(attempt.ID, map[string]interface{}{
+			model.B2TxAttempt{}.Column().Status:       status,
+			model.B2TxAttempt{}.Column().ReceiptBlock: receipt.BlockNumber.Uint64(),
+		})
+		if err != nil {
+			return err
+		}
+		mined[attempt.BtcTxHash] = struct{}{}
+	}
+	for btcTxHash := range mined {
+		if err := w.checkDeposit(btcTxHash); err != nil {
+			w.log.Errorw("check b2 tx attempts err", "error", err, "btcTxHash", btcTxHash)
+		}
+	}
+	return nil
+}
+
+// confirmedNonce confirmed nonce of the sender, read once per pass, false for the attempts
+// recorded without a sender or when the nonce can not be read
+func (w *AttemptWatcher) confirmedNonce(nonces map[string]uint64, from string) (uint64, bool) {
+	if from == "" {
+		return 0, false
+	}
+	key := strings.ToLower(from)
+	if nonce, ok := nonces[key]; ok {
+		return nonce, true
+	}
+	nonce, err := w.bridge.NonceAt(from)
+	if err != nil {
+		w.log.Errorw("b2 tx attempt sender nonce err", "error", err, "from", from)
+		return 0, false
+	}
+	nonces[key] = nonce
+	return nonce, true
+}
+
+// checkDeposit drop the attempts whose nonce is used by a mined attempt,
+// flag the deposit if more than one attempt or an attempt other than the deposit b2 tx succeeded
+func (w *AttemptWatcher) checkDeposit(btcTxHash string) error {
+	attempts, err := w.store.B2TxAttempts().ListByBtcTxHash(btcTxHash)
+	if err != nil {
+		return err
+	}
+	deposit, err := w.store.Deposits().GetByBtcTxHash(btcTxHash)
+	if err != nil {
+		return err
+	}
+
+	var succeeded []string
+	for _, attempt := range attempts {
+		switch attempt.Status {
+		case model.B2TxAttemptStatusSuccess:
+			succeeded = append(succeeded, attempt.B2TxHash)
+		case model.B2TxAttemptStatusPending:
+			if !nonceMined(attempts, attempt) {
+				continue
+			}
+			err = w.store.B2TxAttempts().Update(attempt.ID, map[string]interface{}{
+				model.B2TxAttempt{}.Column().Status: model.B2TxAttemptStatusDropped,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	var check int
+	var reason string
+	switch {
+	case len(succeeded) > 1:
+		check = model.B2CheckStatusDoubleMint
+		reason = fmt.Sprintf("deposit minted by %d b2 txs: %s", len(succeeded), strings.Join(succeeded, ","))
+	case len(succeeded) == 1 && !strings.EqualFold(succeeded[0], deposit.B2TxHash):
+		check = model.B2CheckStatusUnexpectedMint
+		reason = fmt.Sprintf("deposit minted by b2 tx %s, deposit b2 tx %s", succeeded[0], deposit.B2TxHash)
+	default:
+		return nil
+	}
+	if deposit.B2TxCheck == check {
+		return nil
+	}
+
+	w.log.Errorw("deposit minted by an unexpected b2 tx",
+		"btcTxHash", deposit.BtcTxHash,
+		"reason", reason,
+		"data", deposit)
+	return w.store.Transaction(func(tx storage.Store) error {
+		err := tx.Deposits().Update(deposit.ID, map[string]interface{}{
+			model.Deposit{}.Column().B2TxCheck: check,
+			model.Deposit{}.Column().FlaggedAt: w.clock.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		deposit.B2TxCheck = check
+		event := model.NewDepositEvent(deposit, model.DepositEventCheck, deposit.B2TxStatus)
+		event.Error = reason
+		return tx.DepositEvents().Append(event)
+	})
+}
+
+// nonceMined another attempt with the same sender and nonce is mined, the attempt can no longer be
+func nonceMined(attempts []*model.B2TxAttempt, attempt *model.B2TxAttempt) bool {
+	for _, other := range attempts {
+		if other.Status != model.B2TxAttemptStatusSuccess && other.Status != model.B2TxAttemptStatusFailed {
+			continue
+		}
+		if other.B2TxNonce == attempt.B2TxNonce && strings.EqualFold(other.B2TxFrom, attempt.B2TxFrom) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package indexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
+	logger "github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gormlog "gorm.io/gorm/logger"
+)
+
+func TestAttemptWatcher_DropTakenNonce(t *testing.T) {
+	db, err := storage.Open(storage.Options{Source: storage.SQLiteScheme + ":memory:", LogLevel: gormlog.Silent})
+	require.NoError(t, err)
+	store, err := storage.New(db)
+	require.NoError(t, err)
+	require.NoError(t, store.Migrate(&model.Deposit{}, &model.DepositEvent{}, &model.B2TxAttempt{}))
+
+	const from = "0x176F283DcD00b75334f643a8a8C72E42EBF96755"
+	attempts := []*model.B2TxAttempt{
+		{DepositID: 1, BtcTxHash: "a", B2TxHash: "0xa1", B2TxNonce: 3, B2TxFrom: from},
+		{DepositID: 2, BtcTxHash: "b", B2TxHash: "0xb1", B2TxNonce: 5, B2TxFrom: from},
+		// recorded without a sender, left pending
+		{DepositID: 3, BtcTxHash: "c", B2TxHash: "0xc1", B2TxNonce: 1},
+	}
+	for _, attempt := range attempts {
+		require.NoError(t, store.B2TxAttempts().Create(attempt))
+	}
+
+	// nonce 3 taken by a tx not recorded as an attempt, nonce 5 not mined yet
+	chain := &fakeBridge{confirmed: 5}
+	watcher := NewAttemptWatcher(time.Second, chain, store, logger.NewNopLogger())
+	require.NoError(t, watcher.Watch())
+
+	statuses := make(map[string]int)
+	for _, btcTxHash := range []string{"a", "b", "c"} {
+		list, err := store.B2TxAttempts().ListByBtcTxHash(btcTxHash)
+		require.NoError(t, err)
+		require.Len(t, list, 1)
+		statuses[btcTxHash] = list[0].Status
+	}
+	assert.Equal(t, map[string]int{
+		"a": model.B2TxAttemptStatusDropped,
+		"b": model.B2TxAttemptStatusPending,
+		"c": model.B2TxAttemptStatusPending,
+	}, statuses)
+}
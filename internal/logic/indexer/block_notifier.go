@@ -0,0 +1,186 @@
+package indexer
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	NotifierMinBackoff   = 1 * time.Second
+	NotifierMaxBackoff   = 60 * time.Second
+	NotifierWriteTimeout = 10 * time.Second
+	// NotifierReadTimeout no message within the timeout is a dead connection, abec pings periodically
+	NotifierReadTimeout = 5 * time.Minute
+)
+
+var ErrNotifierHandshake = errors.New("block notifier handshake err")
+
+// BlockNotifier subscribes to the abec websocket block notifications, modeled after btcd's
+// notifyblocks. New block heights are pushed to Notify, reconnecting with backoff on disconnect.
+type BlockNotifier struct {
+	url        string
+	user       string
+	pass       string
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	connected  atomic.Bool
+	blocks     chan int64
+	logger     log.Logger
+}
+
+// NewBlockNotifier new block notifier, nil if the websocket url is not configured
+func NewBlockNotifier(url string, user string, pass string, logger log.Logger) *BlockNotifier {
+	if url == "" {
+		return nil
+	}
+	return &BlockNotifier{
+		url:        url,
+		user:       user,
+		pass:       pass,
+		minBackoff: NotifierMinBackoff,
+		maxBackoff: NotifierMaxBackoff,
+		blocks:     make(chan int64, 1),
+		logger:     logger,
+	}
+}
+
+// Notify heights of connected blocks, only the latest pending height is kept
+func (n *BlockNotifier) Notify() <-chan int64 {
+	return n.blocks
+}
+
+// Connected whether the subscription is live, callers poll while it is not
+func (n *BlockNotifier) Connected() bool {
+	return n.connected.Load()
+}
+
+// Run keep the subscription alive until quit is closed
+func (n *BlockNotifier) Run(quit <-chan struct{}) {
+	backoff := n.minBackoff
+	for {
+		start := time.Now()
+		err := n.subscribe(quit)
+		n.connected.Store(false)
+		select {
+		case <-quit:
+			return
+		default:
+		}
+		// a connection that stayed up resets the backoff
+		if time.Since(start) > n.maxBackoff {
+			backoff = n.minBackoff
+		}
+		n.logger.Warnw("block notifier disconnected, fallback to polling", "error", err, "retry", backoff)
+		select {
+		case <-quit:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > n.maxBackoff {
+			backoff = n.maxBackoff
+		}
+	}
+}
+
+func (n *BlockNotifier) subscribe(quit <-chan struct{}) error {
+	header := http.Header{}
+	if n.user != "" || n.pass != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte(n.user + ":" + n.pass))
+		header.Set("Authorization", "Basic "+auth)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(n.url, header)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// close the connection on quit to unblock the reader
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-quit:
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+
+	_ = conn.SetWriteDeadline(time.Now().Add(NotifierWriteTimeout))
+	err = conn.WriteJSON(&AbecJSONRPCRequest{
+		JSONRPC: "1.0",
+		Method:  "notifyblocks",
+		Params:  []interface{}{},
+		ID:      fmt.Sprintf("%d", time.Now().UnixNano()),
+	})
+	if err != nil {
+		return err
+	}
+
+	conn.SetPingHandler(func(data string) error {
+		_ = conn.SetReadDeadline(time.Now().Add(NotifierReadTimeout))
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(NotifierWriteTimeout))
+	})
+
+	subscribed := false
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(NotifierReadTimeout))
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		root := gjson.ParseBytes(msg)
+
+		method := root.Get("method").String()
+		if method == "" {
+			// reply of notifyblocks
+			if rpcErr := root.Get("error"); rpcErr.Exists() && rpcErr.Type != gjson.Null {
+				return fmt.Errorf("%w: %s", ErrNotifierHandshake, rpcErr.Raw)
+			}
+			if !subscribed {
+				subscribed = true
+				n.connected.Store(true)
+				n.logger.Infow("block notifier subscribed", "url", n.url)
+			}
+			continue
+		}
+
+		var height int64
+		switch method {
+		case "blockconnected":
+			// [hash, height, time]
+			height = root.Get("params.1").Int()
+		case "filteredblockconnected":
+			// [height, header, txs]
+			height = root.Get("params.0").Int()
+		default:
+			continue
+		}
+		if height > 0 {
+			n.push(height)
+		}
+	}
+}
+
+// push keep only the latest height, the indexer catches up to the tip anyway
+func (n *BlockNotifier) push(height int64) {
+	for {
+		select {
+		case n.blocks <- height:
+			return
+		default:
+		}
+		select {
+		case <-n.blocks:
+		default:
+		}
+	}
+}
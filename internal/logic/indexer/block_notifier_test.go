@@ -0,0 +1,110 @@
+package indexer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/clock"
+	logger "github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+// abecStub websocket stub of abec, replies notifyblocks and pushes the given notifications
+type abecStub struct {
+	conns         atomic.Int32
+	notifications chan string
+	drop          chan struct{}
+}
+
+func (s *abecStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	s.conns.Add(1)
+
+	_, msg, err := conn.ReadMessage()
+	if err != nil || gjson.GetBytes(msg, "method").String() != "notifyblocks" {
+		return
+	}
+	id := gjson.GetBytes(msg, "id").Raw
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"result":null,"error":null,"id":`+id+`}`)); err != nil {
+		return
+	}
+	for {
+		select {
+		case n := <-s.notifications:
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(n)); err != nil {
+				return
+			}
+		case <-s.drop:
+			return
+		}
+	}
+}
+
+func TestBlockNotifier(t *testing.T) {
+	stub := &abecStub{notifications: make(chan string), drop: make(chan struct{})}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	n := NewBlockNotifier("ws"+strings.TrimPrefix(srv.URL, "http"), "user", "pass", logger.NewNopLogger())
+	n.minBackoff = 10 * time.Millisecond
+	n.maxBackoff = 20 * time.Millisecond
+	quit := make(chan struct{})
+	defer close(quit)
+	go n.Run(quit)
+
+	require.Eventually(t, n.Connected, time.Second, 5*time.Millisecond)
+
+	stub.notifications <- `{"jsonrpc":"1.0","method":"blockconnected","params":["00ab",101,1700000000],"id":null}`
+	assert.Equal(t, int64(101), <-n.Notify())
+	stub.notifications <- `{"jsonrpc":"1.0","method":"filteredblockconnected","params":[102,"00ab",[]],"id":null}`
+	assert.Equal(t, int64(102), <-n.Notify())
+
+	// disconnect, reconnect with backoff
+	stub.drop <- struct{}{}
+	require.Eventually(t, func() bool { return stub.conns.Load() == 2 && n.Connected() }, time.Second, 5*time.Millisecond)
+	stub.notifications <- `{"jsonrpc":"1.0","method":"blockconnected","params":["00ac",103,1700000001],"id":null}`
+	assert.Equal(t, int64(103), <-n.Notify())
+}
+
+func TestBlockNotifier_KeepLatest(t *testing.T) {
+	n := NewBlockNotifier("ws://127.0.0.1:0", "", "", logger.NewNopLogger())
+	n.push(1)
+	n.push(2)
+	n.push(3)
+	assert.Equal(t, int64(3), <-n.Notify())
+	assert.False(t, n.Connected())
+
+	assert.Nil(t, NewBlockNotifier("", "", "", logger.NewNopLogger()))
+}
+
+func TestIndexerService_WaitNewBlock(t *testing.T) {
+	n := NewBlockNotifier("ws://127.0.0.1:0", "", "", logger.NewNopLogger())
+	bis := &IndexerService{notifier: n, log: logger.NewNopLogger(), clock: clock.Real}
+	n.push(10)
+
+	done := make(chan struct{})
+	go func() {
+		bis.waitNewBlock()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("notified block did not wake the indexer")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
@@ -21,9 +22,11 @@ import (
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
 	config2 "github.com/qday-io/qday-abel-bridge-indexer/config"
 	b2types "github.com/qday-io/qday-abel-bridge-indexer/internal/model"
 	"github.com/qday-io/qday-abel-bridge-indexer/pkg/aa"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/amount"
 	"github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
 	"github.com/tidwall/gjson"
 )
@@ -52,6 +55,14 @@ type Bridge struct {
 	//enableEoaTransfer bool
 	// aa server
 	AAPubKeyAPI string
+	// converter source chain amount to token amount
+	converter *amount.Converter
+	// mintMethod contract method minting the deposit
+	mintMethod string
+	// rpcClient sends the rollup rpc, nil dials with the default client
+	rpcClient *http.Client
+	// aaClient sends the aa api requests
+	aaClient *http.Client
 }
 type B2ExplorerStatus struct {
 	GasPrices struct {
@@ -118,15 +129,53 @@ func NewBridge(bridgeCfg config2.BridgeConfig, abiFileDir string, log log.Logger
 		AAPubKeyAPI:          bridgeCfg.AAB2PI,
 		BaseGasPriceMultiple: bridgeCfg.GasPriceMultiple,
 		B2ExplorerURL:        bridgeCfg.B2ExplorerURL,
+		converter:            amount.NewConverter(bridgeCfg.SourceDecimals, bridgeCfg.TargetDecimals),
+		mintMethod:           config2.DefaultMintMethod,
 	}, nil
 }
 
+// WithRoute copy of the bridge minting to the route contract with the route mint method
+func (b *Bridge) WithRoute(route config2.ListenRoute) (*Bridge, error) {
+	if !common.IsHexAddress(route.ContractAddress) {
+		return nil, fmt.Errorf("invalid route contract address: %s", route.ContractAddress)
+	}
+	contractAbi, err := abi.JSON(strings.NewReader(b.ABI))
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := contractAbi.Methods[route.MintMethod]; !ok {
+		return nil, fmt.Errorf("route mint method %s not found in abi", route.MintMethod)
+	}
+	routed := *b
+	routed.ContractAddress = common.HexToAddress(route.ContractAddress)
+	routed.mintMethod = route.MintMethod
+	return &routed, nil
+}
+
+// SetHTTPClient send the rollup rpc and the aa api requests with the clients, e.g. to record the traffic
+func (b *Bridge) SetHTTPClient(rpcClient *http.Client, aaClient *http.Client) {
+	b.rpcClient = rpcClient
+	b.aaClient = aaClient
+}
+
+// dial rollup rpc client
+func (b *Bridge) dial() (*ethclient.Client, error) {
+	if b.rpcClient == nil {
+		return ethclient.Dial(b.EthRPCURL)
+	}
+	client, err := rpc.DialOptions(context.Background(), b.EthRPCURL, rpc.WithHTTPClient(b.rpcClient))
+	if err != nil {
+		return nil, err
+	}
+	return ethclient.NewClient(client), nil
+}
+
 // Deposit to ethereum
 func (b *Bridge) Deposit(
 	hash string,
 	bitcoinAddress b2types.BitcoinFrom,
 	tos string,
-	amount int64,
+	value *big.Int,
 	oldTx *types.Transaction,
 	nonce uint64,
 	resetNonce bool,
@@ -156,13 +205,12 @@ func (b *Bridge) Deposit(
 		//rewardRatio = list[0].Get("Memo.rewardRatio").Uint()
 	}
 
-	mintNum := new(big.Int).SetInt64(amount)
-	powerOfTen := new(big.Int).SetInt64(1e11)
-	mintNum = new(big.Int).Mul(mintNum, powerOfTen)
-
-	//log.Infof("amount:%v,mint:%v,rant:%v", amount, mintNum.String(), powerOfTen.String())
+	mintNum, err := b.converter.ToTarget(value)
+	if err != nil {
+		return nil, nil, toAddress, "", err
+	}
 
-	data, err := b.ABIPack(b.ABI, "mintWAbel", common.HexToAddress(toAddress), mintNum, new(big.Int).SetUint64(lockupPeriod))
+	data, err := b.ABIPack(b.ABI, b.mintMethod, common.HexToAddress(toAddress), mintNum, new(big.Int).SetUint64(lockupPeriod))
 	if err != nil {
 		return nil, nil, toAddress, "", fmt.Errorf("abi pack err:%w", err)
 	}
@@ -185,10 +233,58 @@ func (b *Bridge) Deposit(
 	return tx, data, toAddress, b.FromAddress(), nil
 }
 
+// SignMintTo sign a tx minting amount to a l2 address without lockup, used to credit the bridge fee to the
+// treasury. The tx is signed with the pending nonce and not sent, so it is recorded before it is broadcast.
+func (b *Bridge) SignMintTo(toAddress string, value *big.Int) (*types.Transaction, error) {
+	if !common.IsHexAddress(toAddress) {
+		return nil, fmt.Errorf("invalid mint to address: %s", toAddress)
+	}
+
+	mintNum, err := b.converter.ToTarget(value)
+	if err != nil {
+		return nil, err
+	}
+	data, err := b.ABIPack(b.ABI, b.mintMethod, common.HexToAddress(toAddress), mintNum, new(big.Int))
+	if err != nil {
+		return nil, fmt.Errorf("abi pack err:%w", err)
+	}
+
+	txLock.Lock()
+	defer txLock.Unlock()
+	client, err := b.dial()
+	if err != nil {
+		return nil, err
+	}
+	tx, err := b.signTransaction(context.Background(), client, b.EthPrivKey, b.ContractAddress, data, new(big.Int), 0, false)
+	if err != nil {
+		return nil, err
+	}
+	b.logger.Infof("mint to signed: to:%v, hash:%v, nonce:%v", toAddress, tx.Hash().String(), tx.Nonce())
+	return tx, nil
+}
+
+// SendTransaction send a signed tx
+func (b *Bridge) SendTransaction(tx *types.Transaction) error {
+	client, err := b.dial()
+	if err != nil {
+		return err
+	}
+	return client.SendTransaction(context.Background(), tx)
+}
+
+// NonceAt confirmed nonce of the address, a tx of a lower nonce not mined is dropped
+func (b *Bridge) NonceAt(address string) (uint64, error) {
+	client, err := b.dial()
+	if err != nil {
+		return 0, err
+	}
+	return client.NonceAt(context.Background(), common.HexToAddress(address), nil)
+}
+
 // Transfer to ethereum
 // TODO: temp handle, future remove
 func (b *Bridge) Transfer(bitcoinAddress b2types.BitcoinFrom,
-	amount int64,
+	value *big.Int,
 	oldTx *types.Transaction,
 	nonce uint64,
 	resetNonce bool,
@@ -217,11 +313,15 @@ func (b *Bridge) Transfer(bitcoinAddress b2types.BitcoinFrom,
 		return receipt, b.FromAddress(), nil
 	}
 
+	transferValue, err := b.converter.ToTarget(value)
+	if err != nil {
+		return nil, "", err
+	}
 	receipt, err := b.sendTransaction(ctx,
 		b.EthPrivKey,
 		common.HexToAddress(toAddress),
 		nil,
-		new(big.Int).Mul(new(big.Int).SetInt64(amount), new(big.Int).SetInt64(10000000000)),
+		transferValue,
 		nonce,
 		resetNonce,
 	)
@@ -237,10 +337,28 @@ func (b *Bridge) sendTransaction(ctx context.Context, fromPriv *ecdsa.PrivateKey
 ) (*types.Transaction, error) {
 	txLock.Lock()
 	defer txLock.Unlock()
-	client, err := ethclient.Dial(b.EthRPCURL)
+	client, err := b.dial()
 	if err != nil {
 		return nil, err
 	}
+	signedTx, err := b.signTransaction(ctx, client, fromPriv, toAddress, data, value, oldNonce, resetNonce)
+	if err != nil {
+		return nil, err
+	}
+
+	// send tx
+	err = client.SendTransaction(ctx, signedTx)
+	if err != nil {
+		return nil, err
+	}
+
+	return signedTx, nil
+}
+
+// signTransaction sign a tx with the pending nonce, or the old nonce unless it is reset, the caller holds txLock
+func (b *Bridge) signTransaction(ctx context.Context, client *ethclient.Client, fromPriv *ecdsa.PrivateKey,
+	toAddress common.Address, data []byte, value *big.Int, oldNonce uint64, resetNonce bool,
+) (*types.Transaction, error) {
 	fromAddress := crypto.PubkeyToAddress(fromPriv.PublicKey)
 
 	nonce, err := client.PendingNonceAt(ctx, fromAddress)
@@ -327,18 +445,7 @@ func (b *Bridge) sendTransaction(ctx context.Context, fromPriv *ecdsa.PrivateKey
 		return nil, err
 	}
 	// sign tx
-	signedTx, err := types.SignTx(tx, types.NewEIP155Signer(chainID), fromPriv)
-	if err != nil {
-		return nil, err
-	}
-
-	// send tx
-	err = client.SendTransaction(ctx, signedTx)
-	if err != nil {
-		return nil, err
-	}
-
-	return signedTx, nil
+	return types.SignTx(tx, types.NewEIP155Signer(chainID), fromPriv)
 }
 
 func (b *Bridge) retrySendTransaction(
@@ -349,7 +456,7 @@ func (b *Bridge) retrySendTransaction(
 ) (*types.Transaction, error) {
 	txLock.Lock()
 	defer txLock.Unlock()
-	client, err := ethclient.Dial(b.EthRPCURL)
+	client, err := b.dial()
 	if err != nil {
 		return nil, err
 	}
@@ -452,7 +559,7 @@ func (b *Bridge) ABIPack(abiData string, method string, args ...interface{}) ([]
 
 // BitcoinAddressToEthAddress bitcoin address to eth address
 func (b *Bridge) BitcoinAddressToEthAddress(hash string, bitcoinAddress b2types.BitcoinFrom) (string, error) {
-	pubKeyResp, err := aa.GetPubKey(b.AAPubKeyAPI, hash, bitcoinAddress.Address, b.network)
+	pubKeyResp, err := aa.GetPubKey(b.aaClient, b.AAPubKeyAPI, hash, bitcoinAddress.Address, b.network)
 	if err != nil {
 		b.logger.Errorw("Get AAAddress:", "error", err.Error())
 		return "", err
@@ -482,7 +589,7 @@ func (b *Bridge) BitcoinAddressToEthAddress(hash string, bitcoinAddress b2types.
 
 // WaitMined wait tx mined
 func (b *Bridge) WaitMined(ctx context.Context, tx *types.Transaction, _ []byte) (*types.Receipt, error) {
-	client, err := ethclient.Dial(b.EthRPCURL)
+	client, err := b.dial()
 	if err != nil {
 		return nil, err
 	}
@@ -499,7 +606,7 @@ func (b *Bridge) WaitMined(ctx context.Context, tx *types.Transaction, _ []byte)
 }
 
 func (b *Bridge) TransactionReceipt(hash string) (*types.Receipt, error) {
-	client, err := ethclient.Dial(b.EthRPCURL)
+	client, err := b.dial()
 	if err != nil {
 		return nil, err
 	}
@@ -512,7 +619,7 @@ func (b *Bridge) TransactionReceipt(hash string) (*types.Receipt, error) {
 }
 
 func (b *Bridge) TransactionByHash(hash string) (*types.Transaction, bool, error) {
-	client, err := ethclient.Dial(b.EthRPCURL)
+	client, err := b.dial()
 	if err != nil {
 		return nil, false, err
 	}
@@ -524,6 +631,30 @@ func (b *Bridge) TransactionByHash(hash string) (*types.Transaction, bool, error
 	return tx, isPending, nil
 }
 
+// TotalSupply erc20 token total supply
+func (b *Bridge) TotalSupply(token string) (*big.Int, error) {
+	if !common.IsHexAddress(token) {
+		return nil, fmt.Errorf("invalid token address: %s", token)
+	}
+	client, err := b.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	tokenAddress := common.HexToAddress(token)
+	// totalSupply()
+	data := crypto.Keccak256([]byte("totalSupply()"))[:4]
+	result, err := client.CallContract(context.Background(), ethereum.CallMsg{
+		To:   &tokenAddress,
+		Data: data,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(result), nil
+}
+
 func (b *Bridge) FromAddress() string {
 	fromAddress := crypto.PubkeyToAddress(b.EthPrivKey.PublicKey)
 	return fromAddress.String()
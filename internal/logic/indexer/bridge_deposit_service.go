@@ -11,8 +11,11 @@ import (
 
 	"github.com/cometbft/cometbft/libs/service"
 	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	ethTypes "github.com/ethereum/go-ethereum/core/types"
 	"github.com/qday-io/qday-abel-bridge-indexer/config"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/clock"
 	"github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
 	"gorm.io/gorm"
 )
@@ -32,11 +35,19 @@ var ErrServerStop = errors.New("server stop")
 // BridgeDepositService l1->l2
 type BridgeDepositService struct {
 	service.BaseService
-	bridgeCfg  config.BridgeConfig
-	bridge     _interface.BitcoinBridge
-	btcIndexer _interface.BitcoinTxIndexer
-	db         *gorm.DB
-	log        log.Logger
+	bridgeCfg config.BridgeConfig
+	bridge    _interface.BitcoinBridge
+	// routeBridges normalized listen address -> bridge of its route
+	routeBridges map[string]_interface.BitcoinBridge
+	btcIndexer   _interface.BitcoinTxIndexer
+	tip          *TipCache
+	policy       *DepositPolicy
+	breaker      *CircuitBreaker
+	store        storage.Store
+	log          log.Logger
+	// waitMinedTimeout how long a sent deposit tx is waited for before it is left to UnconfirmedDeposit
+	waitMinedTimeout time.Duration
+	clock            clock.Clock
 	//wg         sync.WaitGroup
 	stopChan chan struct{}
 }
@@ -44,22 +55,37 @@ type BridgeDepositService struct {
 // NewBridgeDepositService returns a new service instance.
 func NewBridgeDepositService(
 	bridge _interface.BitcoinBridge,
+	routeBridges map[string]_interface.BitcoinBridge,
 	btcIndexer _interface.BitcoinTxIndexer,
-	db *gorm.DB,
+	policy *DepositPolicy,
+	breaker *CircuitBreaker,
+	store storage.Store,
 	logger log.Logger,
 	bridgeCfg config.BridgeConfig,
 ) *BridgeDepositService {
 	is := &BridgeDepositService{
-		bridge:     bridge,
-		btcIndexer: btcIndexer,
-		db:         db,
-		log:        logger,
-		bridgeCfg:  bridgeCfg,
+		bridge:       bridge,
+		routeBridges: routeBridges,
+		btcIndexer:   btcIndexer,
+		tip:          NewTipCache(btcIndexer),
+		policy:       policy,
+		breaker:      breaker,
+		store:        store,
+		log:          logger,
+		bridgeCfg:    bridgeCfg,
+
+		waitMinedTimeout: WaitMinedTimeout,
+		clock:            clock.Real,
 	}
 	is.BaseService = *service.NewBaseService(nil, BridgeDepositServiceName, is)
 	return is
 }
 
+// SetClock wait with the clock, a fake clock replays recorded traffic without waiting
+func (bis *BridgeDepositService) SetClock(c clock.Clock) {
+	bis.clock = c
+}
+
 // OnStart
 func (bis *BridgeDepositService) OnStart() error {
 	go bis.Deposit()
@@ -84,65 +110,360 @@ func (bis *BridgeDepositService) Deposit() {
 		case <-bis.stopChan:
 			bis.log.Warnf("deposit stopping...")
 			return
-		case <-time.After(BatchDepositWaitTimeout):
-			// Priority processing UnconfirmedDeposit
-			err := bis.UnconfirmedDeposit()
-			if err != nil {
-				bis.log.Warnf("unconfirmed deposit err: %s", err)
-				if errors.Is(err, ErrServerStop) {
-					return
-				}
-				continue
+		case <-bis.clock.After(BatchDepositWaitTimeout):
+			if errors.Is(bis.depositRound(), ErrServerStop) {
+				return
 			}
+		}
+	}
+}
 
-			//handle deposit
-			deposits, err := bis.GetTxToDeposit()
-			if err != nil {
-				bis.log.Errorw("failed find tx from db", "error", err)
-			}
-			bis.log.Infow("start handle deposit", "deposit batch num", len(deposits))
-			for _, deposit := range deposits {
-				err = bis.HandleDeposit(deposit, nil, deposit.B2TxNonce, false)
-				if err != nil {
-					bis.log.Errorw("handle deposit failed", "error", err, "deposit", deposit)
-					if errors.Is(err, ErrServerStop) {
-						return
-					}
-					//break DEPOSIT
-					//todo  add feature by vike
-					continue
-				}
-				select {
-				case <-bis.stopChan:
-					bis.log.Warnf("handle deposit stopping...")
-					return
-				case <-time.After(HandleDepositTimeout):
-				}
+// depositRound one pass over the unconfirmed, pending, aa not found and fee deposits
+func (bis *BridgeDepositService) depositRound() error {
+	// minting paused, wait for an explicit resume
+	if !bis.mintAllowed() {
+		return nil
+	}
+
+	// one tip query per loop, confirmations are derived from the stored block height
+	if _, err := bis.tip.Refresh(); err != nil {
+		bis.log.Errorw("refresh btc tip failed", "error", err, "cached tip", bis.tip.Height())
+	}
+
+	// Priority processing UnconfirmedDeposit
+	err := bis.UnconfirmedDeposit()
+	if err != nil {
+		bis.log.Warnf("unconfirmed deposit err: %s", err)
+		return err
+	}
+
+	//handle deposit
+	deposits, err := bis.GetTxToDeposit()
+	if err != nil {
+		bis.log.Errorw("failed find tx from db", "error", err)
+	}
+	bis.log.Infow("start handle deposit", "deposit batch num", len(deposits))
+	for _, deposit := range deposits {
+		allowed, err := bis.ApplyDepositPolicy(deposit)
+		if err != nil {
+			bis.log.Errorw("apply deposit policy failed", "error", err, "deposit", deposit)
+			continue
+		}
+		if !allowed {
+			continue
+		}
+		err = bis.HandleDeposit(deposit, nil, deposit.B2TxNonce, false)
+		if err != nil {
+			bis.log.Errorw("handle deposit failed", "error", err, "deposit", deposit)
+			if errors.Is(err, ErrServerStop) {
+				return err
 			}
+			//break DEPOSIT
+			//todo  add feature by vike
+			continue
+		}
+		select {
+		case <-bis.stopChan:
+			bis.log.Warnf("handle deposit stopping...")
+			return ErrServerStop
+		case <-bis.clock.After(HandleDepositTimeout):
+		}
+	}
+
+	// handle aa not found err
+	err = bis.handleAADeposit()
+	if err != nil {
+		bis.log.Warnf("handleAADeposit err: %s", err)
+		return err
+	}
+
+	// credit bridge fee to treasury
+	err = bis.HandleFeeDeposit()
+	if err != nil {
+		bis.log.Warnf("handle fee deposit err: %s", err)
+		return err
+	}
+	return nil
+}
+
+// bridgeFor bridge of the route the deposit is sent to, default bridge when the address has no route
+func (bis *BridgeDepositService) bridgeFor(btcTo string) _interface.BitcoinBridge {
+	if bridge, ok := bis.routeBridges[config.NormalizeListenAddress(btcTo)]; ok {
+		return bridge
+	}
+	return bis.bridge
+}
 
-			// handle aa not found err
-			err = bis.handleAADeposit()
+// updateDeposit update the deposit columns and append the audit event in one transaction, nil event only updates,
+// the broadcast attempts are recorded along, an attempt already recorded is kept. The first update to success
+// stamps the mint time.
+func (bis *BridgeDepositService) updateDeposit(
+	deposit *model.Deposit,
+	fields map[string]interface{},
+	event *model.DepositEvent,
+	attempts ...*model.B2TxAttempt,
+) error {
+	if status, ok := fields[model.Deposit{}.Column().B2TxStatus]; ok && status == model.DepositB2TxStatusSuccess {
+		fields[model.Deposit{}.Column().MintedAt] = gorm.Expr(
+			fmt.Sprintf("COALESCE(%s, ?)", model.Deposit{}.Column().MintedAt), bis.clock.Now())
+	}
+	return bis.store.Transaction(func(tx storage.Store) error {
+		err := tx.Deposits().Update(deposit.ID, fields)
+		if err != nil {
+			return err
+		}
+		for _, attempt := range attempts {
+			_, err = tx.B2TxAttempts().CreateIfAbsent(attempt)
 			if err != nil {
-				bis.log.Warnf("handleAADeposit err: %s", err)
-				if errors.Is(err, ErrServerStop) {
-					return
-				}
-				continue
+				return err
 			}
 		}
+		if event == nil {
+			return nil
+		}
+		return tx.DepositEvents().Append(event)
+	})
+}
+
+// mintAllowed run circuit breaker checks, return false when minting is paused
+func (bis *BridgeDepositService) mintAllowed() bool {
+	if bis.breaker == nil {
+		return true
+	}
+	if err := bis.breaker.Check(); err != nil {
+		bis.log.Errorw("circuit breaker check failed", "error", err)
+	}
+	if err := bis.breaker.Allow(); err != nil {
+		bis.log.Warnw("skip deposit", "error", err)
+		return false
+	}
+	return true
+}
+
+// ApplyDepositPolicy evaluate the deposit policy once per deposit
+// deposits violating the policy are held for operator review
+func (bis *BridgeDepositService) ApplyDepositPolicy(deposit *model.Deposit) (bool, error) {
+	if bis.policy == nil || !bis.policy.Enabled() || deposit.PolicyStatus == model.DepositPolicyStatusApproved {
+		return true, nil
+	}
+	if deposit.PolicyStatus == model.DepositPolicyStatusHeld {
+		return false, nil
+	}
+
+	since := bis.clock.Now().Add(-DepositPolicyWindow)
+	addressVolume, err := bis.store.Deposits().ApprovedVolume(since, deposit.BtcFrom)
+	if err != nil {
+		return false, err
+	}
+	globalVolume, err := bis.store.Deposits().ApprovedVolume(since, "")
+	if err != nil {
+		return false, err
 	}
+
+	result := bis.policy.Evaluate(deposit.BtcValue.Big(), addressVolume, globalVolume)
+	updateFields := map[string]interface{}{}
+	var event *model.DepositEvent
+	if result.Allowed {
+		approvedAt := bis.clock.Now()
+		deposit.PolicyStatus = model.DepositPolicyStatusApproved
+		deposit.BtcFee = model.NewBigInt(result.Fee)
+		deposit.ApprovedAt = &approvedAt
+		updateFields[model.Deposit{}.Column().PolicyStatus] = deposit.PolicyStatus
+		updateFields[model.Deposit{}.Column().BtcFee] = deposit.BtcFee
+		updateFields[model.Deposit{}.Column().ApprovedAt] = approvedAt
+	} else {
+		fromStatus := deposit.B2TxStatus
+		deposit.PolicyStatus = model.DepositPolicyStatusHeld
+		deposit.B2TxStatus = model.DepositB2TxStatusHeld
+		deposit.HoldReason = result.Reason
+		updateFields[model.Deposit{}.Column().PolicyStatus] = deposit.PolicyStatus
+		updateFields[model.Deposit{}.Column().B2TxStatus] = deposit.B2TxStatus
+		updateFields[model.Deposit{}.Column().HoldReason] = deposit.HoldReason
+		event = model.NewDepositEvent(deposit, model.DepositEventStatus, fromStatus)
+		event.Error = result.Reason
+		bis.log.Warnw("deposit held by policy",
+			"reason", result.Reason,
+			"btcTxHash", deposit.BtcTxHash,
+			"addressVolume", addressVolume,
+			"globalVolume", globalVolume)
+	}
+	err = bis.updateDeposit(deposit, updateFields, event)
+	if err != nil {
+		return false, err
+	}
+	return result.Allowed, nil
+}
+
+// HandleFeeDeposit mint the deducted bridge fee to the treasury
+// 1. fee tx pending, sign the mint tx, record it, then send it
+// 2. fee tx wait mined, check receipt, send the recorded tx again while it is not mined
+// 3. fee tx reverted or dropped, sign a new one up to DepositRetry times, then fail and alert
+func (bis *BridgeDepositService) HandleFeeDeposit() error {
+	if bis.policy == nil || bis.policy.TreasuryAddress() == "" {
+		return nil
+	}
+	deposits, err := bis.store.Deposits().List(storage.DepositQuery{
+		Statuses: []int{model.DepositB2TxStatusSuccess},
+		FeeStatuses: []int{
+			model.DepositB2TxStatusPending,
+			model.DepositB2TxStatusWaitMined,
+		},
+		Limit: BatchDepositLimit,
+	})
+	if err != nil {
+		bis.log.Errorw("failed find fee deposit from db", "error", err)
+		return err
+	}
+
+	for _, deposit := range deposits {
+		var err error
+		if deposit.FeeTxStatus == model.DepositB2TxStatusPending {
+			err = bis.sendFeeTx(deposit)
+		} else {
+			err = bis.checkFeeTx(deposit)
+		}
+		if err != nil {
+			bis.log.Errorw("handle fee deposit error", "error", err, "btcTxHash", deposit.BtcTxHash)
+			continue
+		}
+		select {
+		case <-bis.stopChan:
+			bis.log.Warnf("handle fee deposit stopping...")
+			return ErrServerStop
+		case <-bis.clock.After(HandleDepositTimeout):
+		}
+	}
+	return nil
+}
+
+// sendFeeTx sign the fee mint tx and record it before it is sent, a tx failing to be recorded is
+// never sent, so a db error can not mint the fee twice
+func (bis *BridgeDepositService) sendFeeTx(deposit *model.Deposit) error {
+	bridge := bis.bridgeFor(deposit.BtcTo)
+	tx, err := bridge.SignMintTo(bis.policy.TreasuryAddress(), deposit.BtcFee.Big())
+	if err != nil {
+		return fmt.Errorf("sign fee mint tx: %w", err)
+	}
+	raw, err := tx.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	updateFields := map[string]interface{}{
+		model.Deposit{}.Column().FeeTxHash:   tx.Hash().String(),
+		model.Deposit{}.Column().FeeTxNonce:  tx.Nonce(),
+		model.Deposit{}.Column().FeeTxRaw:    hexutil.Encode(raw),
+		model.Deposit{}.Column().FeeTxStatus: model.DepositB2TxStatusWaitMined,
+	}
+	event := model.NewDepositEvent(deposit, model.DepositEventFeeBroadcast, deposit.B2TxStatus)
+	event.B2TxHash = tx.Hash().String()
+	event.B2TxNonce = tx.Nonce()
+	event.GasPrice = model.NewBigInt(tx.GasPrice())
+	err = bis.updateDeposit(deposit, updateFields, event)
+	if err != nil {
+		return err
+	}
+	// the recorded tx is sent again while it is not mined, a failed send is settled next round
+	err = bridge.SendTransaction(tx)
+	if err != nil {
+		bis.log.Warnw("send fee tx failed, sending again next round",
+			"error", err, "feeTxHash", tx.Hash().String(), "btcTxHash", deposit.BtcTxHash)
+	}
+	return nil
+}
+
+// checkFeeTx settle the recorded fee tx by its receipt. A tx without receipt whose nonce was taken
+// by another tx is dropped and signed again, else it is sent again in case the node lost it.
+func (bis *BridgeDepositService) checkFeeTx(deposit *model.Deposit) error {
+	bridge := bis.bridgeFor(deposit.BtcTo)
+	var tx *ethTypes.Transaction
+	var confirmedNonce uint64
+	if deposit.FeeTxRaw != "" {
+		raw, err := hexutil.Decode(deposit.FeeTxRaw)
+		if err != nil {
+			return fmt.Errorf("decode fee tx: %w", err)
+		}
+		tx = new(ethTypes.Transaction)
+		err = tx.UnmarshalBinary(raw)
+		if err != nil {
+			return fmt.Errorf("decode fee tx: %w", err)
+		}
+		from, err := ethTypes.Sender(ethTypes.LatestSignerForChainID(tx.ChainId()), tx)
+		if err != nil {
+			return fmt.Errorf("fee tx sender: %w", err)
+		}
+		// the nonce is read before the receipt, a tx mined in between is not taken for dropped
+		confirmedNonce, err = bridge.NonceAt(from.String())
+		if err != nil {
+			return err
+		}
+	}
+	receipt, err := bridge.TransactionReceipt(deposit.FeeTxHash)
+	if err == nil {
+		if receipt.Status == 1 {
+			event := model.NewDepositEvent(deposit, model.DepositEventFeeReceipt, deposit.B2TxStatus)
+			event.B2TxHash = deposit.FeeTxHash
+			event.B2TxNonce = deposit.FeeTxNonce
+			event.ReceiptStatus = receipt.Status
+			event.ReceiptBlock = receipt.BlockNumber.Uint64()
+			return bis.updateDeposit(deposit, map[string]interface{}{
+				model.Deposit{}.Column().FeeTxStatus: model.DepositB2TxStatusSuccess,
+			}, event)
+		}
+		return bis.retryFeeTx(deposit, receipt, "fee tx reverted")
+	}
+	if !errors.Is(err, ethereum.NotFound) {
+		return fmt.Errorf("fee tx receipt: %w", err)
+	}
+	if tx == nil {
+		// recorded before the signed tx was kept, left to the receipt
+		return nil
+	}
+	if confirmedNonce > tx.Nonce() {
+		return bis.retryFeeTx(deposit, nil, fmt.Sprintf("fee tx dropped, nonce %d taken", tx.Nonce()))
+	}
+	err = bridge.SendTransaction(tx)
+	if err != nil {
+		bis.log.Debugw("send fee tx again", "error", err, "feeTxHash", deposit.FeeTxHash)
+	}
+	return nil
+}
+
+// retryFeeTx a fee tx that did not mint is signed again, after DepositRetry times the deposit
+// is marked failed and left to the operator
+func (bis *BridgeDepositService) retryFeeTx(deposit *model.Deposit, receipt *ethTypes.Receipt, reason string) error {
+	updateFields := map[string]interface{}{}
+	if deposit.FeeTxRetry >= DepositRetry {
+		updateFields[model.Deposit{}.Column().FeeTxStatus] = model.DepositB2TxStatusWaitMinedStatusFailed
+		bis.log.Errorw("fee tx failed, retries exhausted, treasury fee not minted",
+			"reason", reason,
+			"feeTxHash", deposit.FeeTxHash,
+			"retry", deposit.FeeTxRetry,
+			"btcTxHash", deposit.BtcTxHash)
+	} else {
+		updateFields[model.Deposit{}.Column().FeeTxStatus] = model.DepositB2TxStatusPending
+		updateFields[model.Deposit{}.Column().FeeTxRetry] = deposit.FeeTxRetry + 1
+		bis.log.Warnw("fee tx failed, signing again",
+			"reason", reason,
+			"feeTxHash", deposit.FeeTxHash,
+			"retry", deposit.FeeTxRetry,
+			"btcTxHash", deposit.BtcTxHash)
+	}
+	event := model.NewDepositEvent(deposit, model.DepositEventFeeReceipt, deposit.B2TxStatus)
+	event.B2TxHash = deposit.FeeTxHash
+	event.B2TxNonce = deposit.FeeTxNonce
+	event.Error = reason
+	if receipt != nil {
+		event.ReceiptStatus = receipt.Status
+		event.ReceiptBlock = receipt.BlockNumber.Uint64()
+	}
+	return bis.updateDeposit(deposit, updateFields, event)
 }
 
 func (bis *BridgeDepositService) handleAADeposit() error {
 	// handle aa not found err
 	// If there is no binding between the registered address and pubkey
 	// an error will occur, which can be handled again next time
-	var aaNotFoundDeposits []*model.Deposit
-	err := bis.db.Where(fmt.Sprintf("%s.%s = ?", model.Deposit{}.TableName(), model.Deposit{}.Column().B2TxStatus), model.DepositB2TxStatusAAAddressNotFound).
-		Limit(BatchDepositLimit).
-		Order(fmt.Sprintf("%s.%s ASC", model.Deposit{}.TableName(), model.Deposit{}.Column().BtcBlockNumber)).
-		Order(fmt.Sprintf("%s.%s ASC", model.Deposit{}.TableName(), "id")).
-		Find(&aaNotFoundDeposits).Error
+	aaNotFoundDeposits, err := bis.store.Deposits().ListByStatus([]int{model.DepositB2TxStatusAAAddressNotFound}, BatchDepositLimit)
 	if err != nil {
 		bis.log.Errorw("failed find tx from db", "error", err)
 		return err
@@ -171,7 +492,7 @@ func (bis *BridgeDepositService) handleAADeposit() error {
 		case <-bis.stopChan:
 			bis.log.Warnf("handle aa not found deposit stopping...")
 			return ErrServerStop
-		case <-time.After(HandleDepositTimeout):
+		case <-bis.clock.After(HandleDepositTimeout):
 		}
 	}
 	return nil
@@ -185,32 +506,21 @@ func (bis *BridgeDepositService) GetTxToDeposit() ([]*model.Deposit, error) {
 	// 3. invoke contract from account insufficient balance
 	// 4. callback status is success
 	// 5. listener status is success
-	var deposits []*model.Deposit
-	err := bis.db.
-		Where(
-			fmt.Sprintf("%s.%s IN (?)", model.Deposit{}.TableName(), model.Deposit{}.Column().B2TxStatus),
-			[]int{
-				model.DepositB2TxStatusPending,
-				model.DepositB2TxStatusInsufficientBalance,
-				model.DepositB2TxStatusFromAccountGasInsufficient,
-			},
-		).
-		Where(
-			fmt.Sprintf("%s.%s = ?", model.Deposit{}.TableName(), model.Deposit{}.Column().CallbackStatus),
-			model.CallbackStatusSuccess,
-		).
-		Where(
-			fmt.Sprintf("%s.%s = ?", model.Deposit{}.TableName(), model.Deposit{}.Column().ListenerStatus),
-			model.ListenerStatusSuccess,
-		).
-		Where(
-			fmt.Sprintf("%s.%s <= ?", model.Deposit{}.TableName(), model.Deposit{}.Column().B2TxRetry),
-			300,
-		).
-		Limit(BatchDepositLimit).
-		Order(fmt.Sprintf("%s.%s ASC", model.Deposit{}.TableName(), model.Deposit{}.Column().BtcBlockNumber)).
-		Order(fmt.Sprintf("%s.%s ASC", model.Deposit{}.TableName(), "id")).
-		Find(&deposits).Error
+	// 6. reached the target confirmations at the cached tip
+	// 7. not flagged by the attempt watcher
+	tipHeight := bis.tip.Height()
+	deposits, err := bis.store.Deposits().List(storage.DepositQuery{
+		Statuses: []int{
+			model.DepositB2TxStatusPending,
+			model.DepositB2TxStatusInsufficientBalance,
+			model.DepositB2TxStatusFromAccountGasInsufficient,
+		},
+		Notified:      true,
+		MaxRetry:      300,
+		EligibleAt:    &tipHeight,
+		ExcludeChecks: mintFlaggedChecks,
+		Limit:         BatchDepositLimit,
+	})
 
 	if err != nil || len(deposits) == 0 {
 		return nil, err
@@ -219,20 +529,18 @@ func (bis *BridgeDepositService) GetTxToDeposit() ([]*model.Deposit, error) {
 }
 
 func (bis *BridgeDepositService) UnconfirmedDeposit() error {
-	var deposits []*model.Deposit
-	err := bis.db.
-		Where(
-			fmt.Sprintf("%s.%s IN (?)", model.Deposit{}.TableName(), model.Deposit{}.Column().B2TxStatus),
-			[]int{
-				model.DepositB2TxStatusContextDeadlineExceeded,
-				model.DepositB2TxStatusWaitMined,
-				model.DepositB2TxStatusWaitMinedFailed,
-				model.DepositB2TxStatusIsPending,
-				model.DepositB2TxStatusNonceToLow,
-			},
-		).
-		Order(fmt.Sprintf("%s.%s ASC", model.Deposit{}.TableName(), model.Deposit{}.Column().B2TxNonce)).
-		Find(&deposits).Error
+	deposits, err := bis.store.Deposits().List(storage.DepositQuery{
+		Statuses: []int{
+			model.DepositB2TxStatusContextDeadlineExceeded,
+			model.DepositB2TxStatusWaitMined,
+			model.DepositB2TxStatusWaitMinedFailed,
+			model.DepositB2TxStatusIsPending,
+			model.DepositB2TxStatusNonceToLow,
+		},
+		// a flagged deposit is left to the operator, resending could mint again
+		ExcludeChecks: mintFlaggedChecks,
+		ByNonce:       true,
+	})
 	if err != nil {
 		bis.log.Errorw("failed find tx from db", "error", err)
 		return err
@@ -249,7 +557,7 @@ func (bis *BridgeDepositService) UnconfirmedDeposit() error {
 		case <-bis.stopChan:
 			bis.log.Warnf("unconfirmed deposit stopping...")
 			return ErrServerStop
-		case <-time.After(HandleDepositTimeout):
+		case <-bis.clock.After(HandleDepositTimeout):
 		}
 	}
 	return nil
@@ -265,19 +573,33 @@ func (bis *BridgeDepositService) HandleDeposit(deposit *model.Deposit, oldTx *et
 	if oldTx != nil {
 		bis.log.Warnw("handle old deposit", "old tx:", oldTx)
 	}
+	fromStatus := deposit.B2TxStatus
+	replacedTxHash := deposit.B2TxHash
+	if oldTx != nil {
+		replacedTxHash = oldTx.Hash().String()
+	}
+
+	if bis.breaker != nil {
+		if err := bis.breaker.Allow(); err != nil {
+			return err
+		}
+	}
 
 	// check Confirmations
-	err := bis.btcIndexer.CheckConfirmations(deposit.BtcTxHash)
+	err := bis.tip.CheckConfirmations(deposit)
 	if err != nil {
 		bis.log.Errorw("check btc tx confirmations err", "tx hash:", deposit.B2TxHash, "err:", err)
 		return err
 	}
 
 	// send deposit tx
-	b2Tx, _, aaAddress, fromAddress, err := bis.bridge.Deposit(deposit.BtcTxHash, model.BitcoinFrom{
+	bridge := bis.bridgeFor(deposit.BtcTo)
+	b2Tx, _, aaAddress, fromAddress, err := bridge.Deposit(deposit.BtcTxHash, model.BitcoinFrom{
 		Address: deposit.BtcFrom,
-	}, deposit.BtcTos, deposit.BtcValue, oldTx, nonce, resetNonce)
+	}, string(deposit.BtcTos), deposit.MintValue(), oldTx, nonce, resetNonce)
 	if err != nil {
+		updateFields := map[string]interface{}{}
+		retry := false
 		switch {
 		case errors.Is(err, ErrBridgeDepositTxHashExist):
 			deposit.B2TxStatus = model.DepositB2TxStatusTxHashExist
@@ -286,7 +608,7 @@ func (bis *BridgeDepositService) HandleDeposit(deposit *model.Deposit, oldTx *et
 				"btcTxHash", deposit.BtcTxHash,
 				"data", deposit)
 			if deposit.B2TxHash != "" {
-				receipt, err := bis.bridge.TransactionReceipt(deposit.B2TxHash)
+				receipt, err := bridge.TransactionReceipt(deposit.B2TxHash)
 				if err == nil {
 					if receipt.Status == 1 {
 						deposit.B2TxStatus = model.DepositB2TxStatusSuccess
@@ -324,6 +646,13 @@ func (bis *BridgeDepositService) HandleDeposit(deposit *model.Deposit, oldTx *et
 				deposit.B2TxStatus = model.DepositB2TxStatusIsPending
 				bis.log.Infof("b2 tx hash not empty, set status to ispending")
 			}
+		case errors.Is(err, ErrOldNonceToHeight):
+			// nonce gap, the stored nonce is ahead of the account, resent with the account nonce
+			deposit.B2TxStatus = model.DepositB2TxStatusNonceToLow
+			bis.log.Errorw("invoke deposit send tx nonce gap",
+				"error", err.Error(),
+				"btcTxHash", deposit.BtcTxHash,
+				"data", deposit)
 		case strings.Contains(err.Error(), "nonce too low"):
 			deposit.B2TxStatus = model.DepositB2TxStatusNonceToLow
 			bis.log.Errorw("invoke deposit send tx nonce to low",
@@ -337,14 +666,9 @@ func (bis *BridgeDepositService) HandleDeposit(deposit *model.Deposit, oldTx *et
 				"error", err.Error(),
 				"btcTxHash", deposit.BtcTxHash,
 				"data", deposit)
-			// The call may not succeed due to network reasons. sleep wait for a while
-			err = bis.db.Model(&model.Deposit{}).Where("id = ?", deposit.ID).Updates(map[string]interface{}{
-				model.Deposit{}.Column().B2TxStatus: deposit.B2TxStatus,
-				model.Deposit{}.Column().B2TxRetry:  deposit.B2TxRetry,
-			}).Error
-			if err != nil {
-				return err
-			}
+			// The call may not succeed due to network reasons, retried in the next round
+			updateFields[model.Deposit{}.Column().B2TxRetry] = deposit.B2TxRetry
+			retry = true
 			//tryTicker := time.NewTicker(DepositErrTimeout)
 			//select {
 			//case <-bis.stopChan:
@@ -353,12 +677,16 @@ func (bis *BridgeDepositService) HandleDeposit(deposit *model.Deposit, oldTx *et
 			//	return fmt.Errorf("retry handle deposit")
 			//}
 		}
-		dbErr := bis.db.Model(&model.Deposit{}).Where("id = ?", deposit.ID).Updates(map[string]interface{}{
-			model.Deposit{}.Column().B2TxStatus: deposit.B2TxStatus,
-		}).Error
+		updateFields[model.Deposit{}.Column().B2TxStatus] = deposit.B2TxStatus
+		event := model.NewDepositEvent(deposit, model.DepositEventStatus, fromStatus)
+		event.Error = err.Error()
+		dbErr := bis.updateDeposit(deposit, updateFields, event)
 		if dbErr != nil {
 			return dbErr
 		}
+		if retry {
+			return nil
+		}
 		return err
 	}
 
@@ -373,7 +701,21 @@ func (bis *BridgeDepositService) HandleDeposit(deposit *model.Deposit, oldTx *et
 		model.Deposit{}.Column().B2TxNonce:        deposit.B2TxNonce,
 		model.Deposit{}.Column().B2TxFrom:         fromAddress,
 	}
-	err = bis.db.Model(&model.Deposit{}).Where("id = ?", deposit.ID).Updates(updateFields).Error
+	event := model.NewDepositEvent(deposit, model.DepositEventBroadcast, fromStatus)
+	event.GasPrice = model.NewBigInt(b2Tx.GasPrice())
+	if replacedTxHash != deposit.B2TxHash {
+		event.ReplacedTxHash = replacedTxHash
+	}
+	attempt := &model.B2TxAttempt{
+		DepositID: deposit.ID,
+		BtcTxHash: deposit.BtcTxHash,
+		B2TxHash:  deposit.B2TxHash,
+		B2TxNonce: deposit.B2TxNonce,
+		B2TxFrom:  fromAddress,
+		GasPrice:  model.NewBigInt(b2Tx.GasPrice()),
+		Status:    model.B2TxAttemptStatusPending,
+	}
+	err = bis.updateDeposit(deposit, updateFields, event, attempt)
 	if err != nil {
 		return err
 	}
@@ -382,18 +724,14 @@ func (bis *BridgeDepositService) HandleDeposit(deposit *model.Deposit, oldTx *et
 		"data", deposit)
 
 	// wait tx mined, may be wait long time so set timeout ctx
-	ctx1, cancel1 := context.WithTimeout(context.Background(), WaitMinedTimeout)
+	ctx1, cancel1 := context.WithTimeout(context.Background(), bis.waitMinedTimeout)
 	defer cancel1()
-	errCh := make(chan error)
+	// buffered, the result is dropped when the wait is abandoned
+	errCh := make(chan error, 1)
 	go func(ctx1 context.Context, b2Tx *ethTypes.Transaction, deposit *model.Deposit) {
-		err := bis.WaitMined(ctx1, b2Tx, deposit)
-		if err != nil {
-			errCh <- err
-			return
-		}
-		//errCh <- nil
+		errCh <- bis.WaitMined(ctx1, b2Tx, deposit)
 	}(ctx1, b2Tx, deposit)
-	waitMinedTicker := time.NewTimer(WaitMinedTimeout + (10 * time.Second))
+	waitMinedTicker := time.NewTimer(bis.waitMinedTimeout + (10 * time.Second))
 	bis.log.Warn("wait mined")
 	select {
 	case err = <-errCh:
@@ -420,23 +758,30 @@ func (bis *BridgeDepositService) HandleDeposit(deposit *model.Deposit, oldTx *et
 //nolint:dupl
 func (bis *BridgeDepositService) HandleUnconfirmedDeposit(deposit *model.Deposit) error {
 	resetNonce := false
+	bridge := bis.bridgeFor(deposit.BtcTo)
 	// 1. nonce to low, need reset nonce
 	// 2. change from priv
 	if deposit.B2TxStatus == model.DepositB2TxStatusNonceToLow ||
-		!strings.EqualFold(deposit.B2TxFrom, bis.bridge.FromAddress()) {
+		!strings.EqualFold(deposit.B2TxFrom, bridge.FromAddress()) {
 		resetNonce = true
 	}
-	txReceipt, err := bis.bridge.TransactionReceipt(deposit.B2TxHash)
+	txReceipt, err := bridge.TransactionReceipt(deposit.B2TxHash)
 	if err == nil {
 		// case 1
-		updateFields := map[string]interface{}{}
+		fromStatus := deposit.B2TxStatus
 		if txReceipt.Status == 1 {
-			updateFields[model.Deposit{}.Column().B2TxStatus] = model.DepositB2TxStatusSuccess
+			deposit.B2TxStatus = model.DepositB2TxStatusSuccess
 		} else {
-			updateFields[model.Deposit{}.Column().B2TxStatus] = model.DepositB2TxStatusWaitMinedStatusFailed
+			deposit.B2TxStatus = model.DepositB2TxStatusWaitMinedStatusFailed
 		}
+		updateFields := map[string]interface{}{
+			model.Deposit{}.Column().B2TxStatus: deposit.B2TxStatus,
+		}
+		event := model.NewDepositEvent(deposit, model.DepositEventReceipt, fromStatus)
+		event.ReceiptStatus = txReceipt.Status
+		event.ReceiptBlock = txReceipt.BlockNumber.Uint64()
 
-		dbErr := bis.db.Model(&model.Deposit{}).Where("id = ?", deposit.ID).Updates(updateFields).Error
+		dbErr := bis.updateDeposit(deposit, updateFields, event)
 		if dbErr != nil {
 			return dbErr
 		}
@@ -446,7 +791,7 @@ func (bis *BridgeDepositService) HandleUnconfirmedDeposit(deposit *model.Deposit
 	if errors.Is(err, ethereum.NotFound) {
 		bis.log.Errorf("TransactionReceipt not found")
 		// tx in mempool, isPending
-		tx, isPending, err := bis.bridge.TransactionByHash(deposit.B2TxHash)
+		tx, isPending, err := bridge.TransactionByHash(deposit.B2TxHash)
 		if err != nil {
 			if errors.Is(err, ethereum.NotFound) || strings.Contains(err.Error(), "not found") {
 				// case 3
@@ -465,7 +810,9 @@ func (bis *BridgeDepositService) HandleUnconfirmedDeposit(deposit *model.Deposit
 }
 
 func (bis *BridgeDepositService) WaitMined(ctx1 context.Context, b2Tx *ethTypes.Transaction, deposit *model.Deposit) error {
-	b2txReceipt, err := bis.bridge.WaitMined(ctx1, b2Tx, nil)
+	fromStatus := deposit.B2TxStatus
+	b2txReceipt, err := bis.bridgeFor(deposit.BtcTo).WaitMined(ctx1, b2Tx, nil)
+	waitErr := err
 	if err != nil {
 		switch {
 		case errors.Is(err, ErrBridgeWaitMinedStatus):
@@ -493,9 +840,18 @@ func (bis *BridgeDepositService) WaitMined(ctx1 context.Context, b2Tx *ethTypes.
 	} else {
 		deposit.B2TxStatus = model.DepositB2TxStatusSuccess
 	}
-	err = bis.db.Model(&model.Deposit{}).Where("id = ?", deposit.ID).Updates(map[string]interface{}{
+	event := model.NewDepositEvent(deposit, model.DepositEventStatus, fromStatus)
+	if b2txReceipt != nil {
+		event.Event = model.DepositEventReceipt
+		event.ReceiptStatus = b2txReceipt.Status
+		event.ReceiptBlock = b2txReceipt.BlockNumber.Uint64()
+	}
+	if waitErr != nil {
+		event.Error = waitErr.Error()
+	}
+	err = bis.updateDeposit(deposit, map[string]interface{}{
 		model.Deposit{}.Column().B2TxStatus: deposit.B2TxStatus,
-	}).Error
+	}, event)
 	if err != nil {
 		return err
 	}
@@ -514,78 +870,79 @@ func (bis *BridgeDepositService) CheckDeposit() {
 		case <-bis.stopChan:
 			bis.log.Warnf("check deposit stopping...")
 			return
-		case <-time.After(BatchDepositWaitTimeout):
-			var deposits []model.Deposit
-			err := bis.db.
-				Where(
-					fmt.Sprintf("%s.%s IN (?)", model.Deposit{}.TableName(), model.Deposit{}.Column().B2TxStatus),
-					[]int{
-						model.DepositB2TxStatusSuccess,
-						model.DepositB2TxStatusTxHashExist,
-					},
-				).
-				Where(
-					fmt.Sprintf("%s.%s = ?", model.Deposit{}.TableName(), model.Deposit{}.Column().B2TxCheck),
-					model.B2CheckStatusPending,
-				).
-				Limit(BatchDepositLimit).
-				Order(fmt.Sprintf("%s.%s ASC", model.Deposit{}.TableName(), model.Deposit{}.Column().B2TxNonce)).
-				Find(&deposits).
-				Error
+		case <-bis.clock.After(BatchDepositWaitTimeout):
+			bis.checkDeposits()
+		}
+	}
+}
+
+// checkDeposits verify minted deposits against the deposit events seen by the rollup listener
+func (bis *BridgeDepositService) checkDeposits() {
+	deposits, err := bis.store.Deposits().List(storage.DepositQuery{
+		Statuses: []int{
+			model.DepositB2TxStatusSuccess,
+			model.DepositB2TxStatusTxHashExist,
+		},
+		Checks:  []int{model.B2CheckStatusPending},
+		ByNonce: true,
+		Limit:   BatchDepositLimit,
+	})
+	if err != nil {
+		bis.log.Errorw("failed find tx from db", "error", err)
+		return
+	}
+
+	for _, deposit := range deposits {
+
+		rollupDeposits, err := bis.store.RollupDeposits().ListByBtcTxHash(deposit.BtcTxHash)
+		if err != nil || len(rollupDeposits) == 0 {
+			bis.log.Errorw("find rollup deposit error", "err", err, "deposit", deposit)
+			continue
+		}
+		rollupDeposit := rollupDeposits[0]
+		if deposit.B2TxStatus == model.DepositB2TxStatusSuccess {
+			if strings.EqualFold(deposit.BtcFromAAAddress, rollupDeposit.BtcFromAAAddress) &&
+				deposit.MintValue().Cmp(rollupDeposit.BtcValue.Big()) == 0 {
+				deposit.B2TxCheck = model.B2CheckStatusSuccess
+			} else {
+				deposit.B2TxCheck = model.B2CheckStatusFailed
+			}
+			fields := map[string]interface{}{
+				model.Deposit{}.Column().B2TxCheck: deposit.B2TxCheck,
+			}
+			if deposit.B2TxCheck == model.B2CheckStatusFailed {
+				fields[model.Deposit{}.Column().FlaggedAt] = bis.clock.Now()
+			}
+			err = bis.updateDeposit(deposit, fields, model.NewDepositEvent(deposit, model.DepositEventCheck, deposit.B2TxStatus))
+			if err != nil {
+				bis.log.Errorw("update deposit error", "err", err)
+			}
+		} else if (deposit.B2TxStatus == model.DepositB2TxStatusTxHashExist) &&
+			((deposit.B2TxHash == "") || (deposit.B2TxHash != rollupDeposit.B2TxHash)) {
+			tx, _, err := bis.bridgeFor(deposit.BtcTo).TransactionByHash(rollupDeposit.B2TxHash)
 			if err != nil {
-				bis.log.Errorw("failed find tx from db", "error", err)
+				bis.log.Errorw("get tx receipt error", "err", err)
 				continue
 			}
-
-			for _, deposit := range deposits {
-
-				var rollupDeposit model.RollupDeposit
-				err = bis.db.
-					Where(
-						fmt.Sprintf("%s.%s = ?", model.RollupDeposit{}.TableName(), model.Deposit{}.Column().BtcTxHash),
-						deposit.BtcTxHash,
-					).
-					First(&rollupDeposit).Error
-				if err != nil {
-					bis.log.Errorw("find rollup deposit error", "err", err, "deposit", deposit)
-					continue
-				}
-				if deposit.B2TxStatus == model.DepositB2TxStatusSuccess {
-					if strings.EqualFold(deposit.BtcFromAAAddress, rollupDeposit.BtcFromAAAddress) &&
-						deposit.BtcValue == rollupDeposit.BtcValue {
-						deposit.B2TxCheck = model.B2CheckStatusSuccess
-					} else {
-						deposit.B2TxCheck = model.B2CheckStatusFailed
-					}
-					err = bis.db.Model(&model.Deposit{}).Where("id = ?", deposit.ID).Updates(map[string]interface{}{
-						model.Deposit{}.Column().B2TxCheck: deposit.B2TxCheck,
-					}).Error
-					if err != nil {
-						bis.log.Errorw("update deposit error", "err", err)
-					}
-				} else if (deposit.B2TxStatus == model.DepositB2TxStatusTxHashExist) &&
-					((deposit.B2TxHash == "") || (deposit.B2TxHash != rollupDeposit.B2TxHash)) {
-					tx, _, err := bis.bridge.TransactionByHash(rollupDeposit.B2TxHash)
-					if err != nil {
-						bis.log.Errorw("get tx receipt error", "err", err)
-						continue
-					}
-					// update tx info from rollup event
-					err = bis.db.Model(&model.Deposit{}).Where("id = ?", deposit.ID).Updates(map[string]interface{}{
-						model.Deposit{}.Column().B2TxCheck:        model.B2CheckStatusSuccess,
-						model.Deposit{}.Column().B2TxHash:         rollupDeposit.B2TxHash,
-						model.Deposit{}.Column().BtcFromAAAddress: rollupDeposit.BtcFromAAAddress,
-						model.Deposit{}.Column().B2TxNonce:        tx.Nonce(),
-						model.Deposit{}.Column().B2TxStatus:       model.DepositB2TxStatusSuccess,
-						model.Deposit{}.Column().B2TxFrom:         rollupDeposit.B2TxFrom,
-					}).Error
-					if err != nil {
-						bis.log.Errorw("update deposit error", "err", err)
-					}
-				}
-
-				<-time.After(2 * time.Second)
+			// update tx info from rollup event
+			fromStatus := deposit.B2TxStatus
+			deposit.B2TxCheck = model.B2CheckStatusSuccess
+			deposit.B2TxHash = rollupDeposit.B2TxHash
+			deposit.B2TxNonce = tx.Nonce()
+			deposit.B2TxStatus = model.DepositB2TxStatusSuccess
+			err = bis.updateDeposit(deposit, map[string]interface{}{
+				model.Deposit{}.Column().B2TxCheck:        deposit.B2TxCheck,
+				model.Deposit{}.Column().B2TxHash:         deposit.B2TxHash,
+				model.Deposit{}.Column().BtcFromAAAddress: rollupDeposit.BtcFromAAAddress,
+				model.Deposit{}.Column().B2TxNonce:        deposit.B2TxNonce,
+				model.Deposit{}.Column().B2TxStatus:       deposit.B2TxStatus,
+				model.Deposit{}.Column().B2TxFrom:         rollupDeposit.B2TxFrom,
+			}, model.NewDepositEvent(deposit, model.DepositEventCheck, fromStatus))
+			if err != nil {
+				bis.log.Errorw("update deposit error", "err", err)
 			}
 		}
+
+		<-bis.clock.After(2 * time.Second)
 	}
 }
@@ -0,0 +1,40 @@
+package indexer
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	_interface "github.com/qday-io/qday-abel-bridge-indexer/internal/interface"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the b2 tx of a deposit is looked up with the bridge of its route, not the default bridge
+func TestBridgeDepositService_RouteBridge(t *testing.T) {
+	defaultChain := &fakeBridge{receipts: map[string]*types.Receipt{}, from: "0x00000000000000000000000000000000000000d0"}
+	routeChain := &fakeBridge{receipts: map[string]*types.Receipt{}, txs: map[string]*types.Transaction{},
+		from: "0x00000000000000000000000000000000000000e0"}
+	bis, _, db := newTestDepositService(t, defaultChain)
+	bis.routeBridges = map[string]_interface.BitcoinBridge{"abe1route": routeChain}
+
+	// mined on the route, unknown to the default bridge
+	minted := createDeposit(t, db, &model.Deposit{BtcTxHash: "a", BtcTo: "abe1route", BtcValue: model.NewBigIntFromInt64(100),
+		B2TxHash: "0xa1", B2TxFrom: routeChain.from, B2TxStatus: model.DepositB2TxStatusWaitMined})
+	routeChain.receipts["0xa1"] = &types.Receipt{Status: 1, BlockNumber: big.NewInt(1)}
+	require.NoError(t, bis.HandleUnconfirmedDeposit(minted))
+	assert.Equal(t, model.DepositB2TxStatusSuccess, loadDeposit(t, db, "a").B2TxStatus)
+
+	// minted by the route before the deposit was sent, the mint is taken from the rollup event
+	exists := createDeposit(t, db, &model.Deposit{BtcTxHash: "b", BtcTo: "abe1route", BtcValue: model.NewBigIntFromInt64(100),
+		BtcFromAAAddress: reconcileAAAddress, B2TxStatus: model.DepositB2TxStatusTxHashExist})
+	require.NoError(t, bis.store.RollupDeposits().Create(&model.RollupDeposit{BtcTxHash: exists.BtcTxHash, B2TxHash: "0xb1",
+		B2TxFrom: routeChain.from, BtcFromAAAddress: reconcileAAAddress, BtcValue: model.NewBigIntFromInt64(100)}))
+	routeChain.txs["0xb1"] = types.NewTx(&types.LegacyTx{Nonce: 7})
+	bis.checkDeposits()
+	checked := loadDeposit(t, db, "b")
+	assert.Equal(t, model.DepositB2TxStatusSuccess, checked.B2TxStatus)
+	assert.Equal(t, "0xb1", checked.B2TxHash)
+	assert.Equal(t, uint64(7), checked.B2TxNonce)
+}
@@ -88,7 +88,7 @@ func TestLocalTransfer(t *testing.T) {
 
 	for _, tc := range testCase {
 		t.Run(tc.name, func(t *testing.T) {
-			hex, _, err := bridge.Transfer(tc.args[0].(b2types.BitcoinFrom), tc.args[1].(int64), nil, 0, false)
+			hex, _, err := bridge.Transfer(tc.args[0].(b2types.BitcoinFrom), big.NewInt(tc.args[1].(int64)), nil, 0, false)
 			if err != nil {
 				assert.Equal(t, tc.err, err)
 			}
@@ -319,7 +319,7 @@ func TestBridge_Deposit(t *testing.T) {
 ]
 `
 
-	b2Tx, _, aaAddress, fromAddr, err := b.Deposit(hash, from, tos, 11000000, nil, nonce, false)
+	b2Tx, _, aaAddress, fromAddr, err := b.Deposit(hash, from, tos, big.NewInt(11000000), nil, nonce, false)
 
 	if err != nil {
 		t.Fatal(err)
@@ -6,12 +6,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"net/http"
 	"strings"
 	"time"
 
-	"github.com/go-resty/resty/v2"
 	"github.com/qday-io/qday-abel-bridge-indexer/config"
 
 	"github.com/btcsuite/btcd/chaincfg"
@@ -20,8 +17,10 @@ import (
 	"github.com/btcsuite/btcd/wire"
 	"github.com/cometbft/cometbft/libs/service"
 	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/clock"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/coinselect"
 	"github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
-	"gorm.io/gorm"
 
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/btcutil/psbt"
@@ -32,7 +31,6 @@ import (
 const (
 	BridgeWithdrawServiceName = "BitcoinBridgeWithdrawService"
 	WithdrawHandleTime        = 10
-	WithdrawTXConfirmTime     = 60 * 5
 
 	// P2SHSize 23 bytes.
 	P2SHSize = 23
@@ -41,477 +39,494 @@ const (
 	//      - var_int: 1 byte (pkscript_length)
 	//      - pkscript (p2sh): 23 bytes
 	P2SHOutputSize = 8 + 1 + P2SHSize
-	// InputSize 41 bytes
-	//	- PreviousOutPoint:
-	//		- Hash: 32 bytes
-	//		- Index: 4 bytes
-	//	- OP_DATA: 1 byte (ScriptSigLength)
-	//	- ScriptSig: 0 bytes
-	//	- Witness <----	we use "Witness" instead of "ScriptSig" for
-	// 			transaction validation, but "Witness" is stored
-	// 			separately and weight for it size is smaller. So
-	// 			we separate the calculation of ordinary data
-	// 			from witness data.
-	//	- Sequence: 4 bytes
-	InputSize = 32 + 4 + 1 + 4
-	// MultiSigSize 71 bytes
-	//	- OP_2: 1 byte
-	//	- OP_DATA: 1 byte (pubKeyAlice length)
-	//	- pubKeyAlice: 33 bytes
-	//	- OP_DATA: 1 byte (pubKeyBob length)
-	//	- pubKeyBob: 33 bytes
-	//	- OP_2: 1 byte
-	//	- OP_CHECKMULTISIG: 1 byte
-	MultiSigSize = 1 + 1 + 33 + 1 + 33 + 1 + 1
 )
 
+// WithdrawTxInSequence input sequence of the withdraw txs, signals bip125 replaceability
+const WithdrawTxInSequence = wire.MaxTxInSequenceNum - 2
+
+var ErrNoUnspentTx = errors.New("no unspent tx")
+
 // BridgeWithdrawService indexes transactions for json-rpc service.
 type BridgeWithdrawService struct {
 	service.BaseService
 
-	btcCli *rpcclient.Client
-	ethCli *ethclient.Client
-	config *config.BitcoinConfig
-	db     *gorm.DB
-	log    log.Logger
+	btcCli   *rpcclient.Client
+	ethCli   *ethclient.Client
+	backends WithdrawBackends
+	config   *config.BitcoinConfig
+	store    storage.Store
+	log      log.Logger
+	clock    clock.Clock
 }
 
 // NewBridgeWithdrawService returns a new service instance.
 func NewBridgeWithdrawService(
 	btcCli *rpcclient.Client,
 	ethCli *ethclient.Client,
+	backends WithdrawBackends,
 	config *config.BitcoinConfig,
-	db *gorm.DB,
+	store storage.Store,
 	log log.Logger,
 ) *BridgeWithdrawService {
-	is := &BridgeWithdrawService{btcCli: btcCli, ethCli: ethCli, config: config, db: db, log: log}
+	is := &BridgeWithdrawService{btcCli: btcCli, ethCli: ethCli, backends: backends, config: config, store: store, log: log, clock: clock.Real}
 	is.BaseService = *service.NewBaseService(nil, BridgeWithdrawServiceName, is)
 	return is
 }
 
+// SetClock the clock the broadcast and stuck times are taken from
+func (bis *BridgeWithdrawService) SetClock(c clock.Clock) {
+	bis.clock = c
+}
+
 // OnStart implements service.Service by subscribing for new blocks
 // and indexing them by events.
 func (bis *BridgeWithdrawService) OnStart() error {
-	if !bis.db.Migrator().HasTable(&model.Withdraw{}) {
-		err := bis.db.AutoMigrate(&model.Withdraw{})
-		if err != nil {
-			bis.log.Errorw("BridgeWithdrawService create withdraw table", "error", err.Error())
-			return err
+	// the existing tables get the columns added since they were created, e.g. the withdraw chain
+	err := bis.store.Migrate(&model.Withdraw{})
+	if err != nil {
+		bis.log.Errorw("BridgeWithdrawService create withdraw table", "error", err.Error())
+		return err
+	}
+	err = bis.store.Migrate(&model.WithdrawTx{})
+	if err != nil {
+		bis.log.Errorw("BridgeWithdrawService create withdrawTx table", "error", err.Error())
+		return err
+	}
+
+	go bis.loop(time.Duration(bis.config.Bridge.TimeInterval)*time.Second, bis.ConstructWithdraws)
+	go bis.loop(time.Duration(WithdrawHandleTime)*time.Second, bis.BroadcastSigned)
+	go bis.loop(time.Duration(bis.config.Bridge.Broadcast.ConfirmInterval)*time.Second, bis.ConfirmBroadcast)
+	if bis.config.Bridge.Broadcast.StuckTimeout > 0 {
+		go bis.loop(time.Duration(bis.config.Bridge.Broadcast.ConfirmInterval)*time.Second, bis.ReplaceStuck)
+	}
+	go bis.loop(time.Duration(WithdrawHandleTime)*time.Second, bis.CompleteWithdraws)
+	return nil
+}
+
+// loop run the round every interval until the service stops
+func (bis *BridgeWithdrawService) loop(interval time.Duration, round func() error) {
+	defer func() {
+		if r := recover(); r != nil {
+			bis.log.Errorw("BridgeWithdrawService panic", "error", r)
+		}
+	}()
+	for {
+		select {
+		case <-bis.Quit():
+			return
+		case <-time.After(interval):
+			// errors are logged, the round is run again
+			_ = round()
 		}
 	}
-	if !bis.db.Migrator().HasTable(&model.WithdrawTx{}) {
-		err := bis.db.AutoMigrate(&model.WithdrawTx{})
-		if err != nil {
-			bis.log.Errorw("BridgeWithdrawService create withdrawTx table", "error", err.Error())
+}
+
+// ConstructWithdraws build one psbt paying the next batch of pending withdraws, left to the co-signers to sign.
+// A withdraw no tx can pay fails and a withdraw over the batch max value is held, with the reason,
+// so they never block the withdraws after them.
+func (bis *BridgeWithdrawService) ConstructWithdraws() error {
+	withdrawList, err := bis.store.Withdraws().ListByChain(model.WithdrawChainBitcoin, model.BtcTxWithdrawPending, 0)
+	if err != nil {
+		bis.log.Errorw("BridgeWithdrawService get blockNumber failed", "error", err)
+		return err
+	}
+	payable := make([]*model.Withdraw, 0, len(withdrawList))
+	for _, v := range withdrawList {
+		reason := bis.unpayableReason(v)
+		if reason == "" {
+			payable = append(payable, v)
+			continue
+		}
+		bis.log.Errorw("BridgeWithdrawService withdraw can not be paid, failed", "b2TxHash", v.B2TxHash,
+			"btcTo", v.BtcTo, "value", v.BtcValue, "reason", reason)
+		if err := bis.setAsideWithdraw(v, model.BtcTxWithdrawFailed, reason); err != nil {
 			return err
 		}
 	}
-
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				bis.log.Errorw("BridgeWithdrawService panic", "error", r)
+	if len(payable) == 0 {
+		return nil
+	}
+	batch := NewWithdrawBatch(payable, bis.config.Bridge.Batch)
+	for _, group := range groupByB2Tx(batch.Oversized) {
+		reason := WithdrawLimitReason(group, bis.config.Bridge.Batch)
+		for _, v := range group {
+			bis.log.Warnw("BridgeWithdrawService withdraw over the batch limits, held", "b2TxHash", v.B2TxHash,
+				"value", v.BtcValue, "reason", reason)
+			if err := bis.setAsideWithdraw(v, model.BtcTxWithdrawHeld, reason); err != nil {
+				return err
 			}
-		}()
-		for {
-			// broadcast transaction
-			time.Sleep(time.Duration(WithdrawHandleTime) * time.Second)
-			var withdrawTxList []model.WithdrawTx
-			err := bis.db.Model(&model.WithdrawTx{}).Where(fmt.Sprintf("%s = ?", model.Withdraw{}.Column().Status), model.BtcTxWithdrawSignatureCompleted).Find(&withdrawTxList).Error
-			if err != nil {
-				bis.log.Errorw("BridgeWithdrawService get broadcast tx failed", "error", err)
-				continue
+		}
+	}
+	if !batch.Ready(bis.config.Bridge.Batch, bis.clock.Now()) {
+		return nil
+	}
+	var destAddressList []string
+	var amounts []int64
+	var ids []int64
+	var b2TxHashes []string
+	for _, v := range batch.Withdraws {
+		ids = append(ids, v.ID)
+		destAddressList = append(destAddressList, v.BtcTo)
+		amounts = append(amounts, v.BtcValue)
+		// the withdraws of a b2 tx are batched next to each other
+		if len(b2TxHashes) == 0 || b2TxHashes[len(b2TxHashes)-1] != v.B2TxHash {
+			b2TxHashes = append(b2TxHashes, v.B2TxHash)
+		}
+	}
+	b2TxHashesByte, err := json.Marshal(b2TxHashes)
+	if err != nil {
+		bis.log.Errorw("BridgeWithdrawService Marshal b2TxHashes err", "error", err, "id", ids)
+		return err
+	}
+	txID, btcTx, err := bis.ConstructTx(destAddressList, amounts, b2TxHashesByte)
+	if err != nil {
+		if errors.Is(err, ErrNoUnspentTx) {
+			return nil
+		}
+		bis.log.Errorw("BridgeWithdrawService transferToBtc failed: ", "error", err)
+		return err
+	}
+	err = bis.store.Transaction(func(store storage.Store) error {
+		err = store.Withdraws().UpdateStatusByB2TxHashes(b2TxHashes, model.BtcTxWithdrawSubmitTxMsg)
+		if err != nil {
+			bis.log.Errorw("BridgeWithdrawService submit withdraw tx update db err", "error", err, "id", ids)
+			return err
+		}
+		withdrawTxData := model.WithdrawTx{
+			BtcTxID:    txID,
+			BtcTx:      btcTx,
+			B2TxHashes: string(b2TxHashesByte),
+			Status:     model.BtcTxWithdrawPending,
+		}
+		withdrawTx, err := store.WithdrawTxs().GetByBtcTxID(txID)
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			if err = store.WithdrawTxs().Create(&withdrawTxData); err != nil {
+				bis.log.Errorw("BridgeWithdrawService create withdrawTx err", "b2TxHashes", b2TxHashes, "error", err)
+				return err
 			}
-			if len(withdrawTxList) == 0 {
-				continue
+		case err != nil:
+			return err
+		default:
+			// the same tx is built again, it goes back to the co-signers
+			updateFields := map[string]interface{}{
+				model.WithdrawTx{}.Column().Status: model.BtcTxWithdrawPending,
+				model.WithdrawTx{}.Column().Reason: "",
 			}
-			for _, v := range withdrawTxList {
-				pack, err := psbt.NewFromRawBytes(strings.NewReader(v.BtcTx), true)
-				if err != nil {
-					bis.log.Errorw("BridgeWithdrawService get psbt tx err", "error", err)
-					continue
-				}
-				tx := pack.UnsignedTx
-				preTx := pack.Inputs
-
-				var signes [][]model.Sign
-				var signatures []string
-				for _, signHex := range signatures {
-					signByte, err := hex.DecodeString(signHex)
-					if err != nil {
-						bis.log.Errorw("BridgeWithdrawService DecodeString sign err", "error", err)
-						continue
-					}
-					var sign []model.Sign
-					err = json.Unmarshal(signByte, &sign)
-					if err != nil {
-						bis.log.Errorw("BridgeWithdrawService Unmarshal sign err", "error", err)
-						continue
-					}
-					signes = append(signes, sign)
-				}
-				for index, in := range tx.TxIn {
-					witness := wire.TxWitness{nil}
-					for i := 0; i < bis.config.Bridge.MultisigNum; i++ {
-						sign := signes[i][index].Sign
-						witness = append(witness, sign)
-					}
-					witness = append(witness, preTx[index].WitnessUtxo.PkScript)
-					in.Witness = witness
-				}
-				var status int
-				var reason string
-				txHash, err := bis.btcCli.SendRawTransaction(tx, true)
-				if err != nil {
-					bis.log.Errorw("BridgeWithdrawService broadcast tx err", "id", v.ID, "txID", v.BtcTxID, "error", err)
-					status = model.BtcTxWithdrawBroadcastFailed
-					reason = err.Error()
-				} else {
-					status = model.BtcTxWithdrawBroadcastSuccess
-				}
-				updateFields := map[string]interface{}{
-					model.WithdrawTx{}.Column().BtcTxHash: txHash,
-					model.WithdrawTx{}.Column().Status:    status,
-					model.WithdrawTx{}.Column().Reason:    reason,
-				}
-				err = bis.db.Model(&model.WithdrawTx{}).Where("id = ?", v.ID).Updates(updateFields).Error
-				if err != nil {
-					bis.log.Errorw("BridgeWithdrawService broadcast tx update db err", "error", err, "id", v.ID)
-					continue
-				}
-				bis.log.Infow("BridgeWithdrawService broadcast tx success", "id", v.ID, "btcTxID", v.BtcTxID)
+			err = store.WithdrawTxs().Update(withdrawTx.ID, updateFields)
+			if err != nil {
+				bis.log.Errorw("BridgeWithdrawService Update WithdrawTx status err", "error", err, "txID", withdrawTx.BtcTxID)
+				return err
 			}
 		}
-	}()
 
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				bis.log.Errorw("BridgeWithdrawService panic", "error", r)
-			}
-		}()
-		for {
-			time.Sleep(time.Duration(WithdrawTXConfirmTime) * time.Second)
-			// confirm tx
-			var withdrawTxList []model.WithdrawTx
-			err := bis.db.Model(&model.WithdrawTx{}).Where(fmt.Sprintf("%s = ?", model.WithdrawTx{}.Column().Status), model.BtcTxWithdrawBroadcastSuccess).Find(&withdrawTxList).Error
-			if err != nil {
-				bis.log.Errorw("BridgeWithdrawService get broadcast tx failed", "error", err)
-				continue
-			}
-			if len(withdrawTxList) == 0 {
-				continue
-			}
-			for _, v := range withdrawTxList {
-				txHash, err := chainhash.NewHashFromStr(v.BtcTxHash)
-				if err != nil {
-					bis.log.Errorw("BridgeWithdrawService NewHashFromStr err", "error", err, "txhash", v.BtcTxHash)
-					continue
-				}
-				txRawResult, err := bis.btcCli.GetRawTransactionVerbose(txHash)
-				if err != nil {
-					bis.log.Errorw("BridgeWithdrawService GetRawTransactionVerbose err", "error", err, "txID", v.BtcTxID)
-					continue
-				}
-				if txRawResult.Confirmations >= 6 {
-					err = bis.db.Model(&model.WithdrawTx{}).Where("id = ?", v.ID).Update(model.WithdrawTx{}.Column().Status, model.BtcTxWithdrawConfirmed).Error
-					if err != nil {
-						bis.log.Errorw("BridgeWithdrawService Update WithdrawTx status err", "error", err, "txID", v.BtcTxID)
-						continue
-					}
-				}
-			}
+		if err = bis.reserveInputs(store, btcTx, txID); err != nil {
+			bis.log.Errorw("BridgeWithdrawService reserve withdraw tx inputs err", "error", err, "txID", txID)
+			return err
 		}
-	}()
 
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				bis.log.Errorw("BridgeWithdrawService panic", "error", r)
-			}
-		}()
-		for {
-			time.Sleep(time.Duration(WithdrawHandleTime) * time.Second)
-			// complete tx
-			var withdrawTxList []model.WithdrawTx
-			err := bis.db.Model(&model.WithdrawTx{}).
-				Where(fmt.Sprintf("%s = ? OR %s = ?", model.WithdrawTx{}.Column().Status, model.WithdrawTx{}.Column().Status), model.BtcTxWithdrawConfirmed, model.BtcTxWithdrawBroadcastFailed).
-				Find(&withdrawTxList).Error
+		bis.log.Infow("BridgeWithdrawService submit withdraw tx success", "id", ids, "b2TxHashes", b2TxHashes, "txID", txID)
+		return nil
+	})
+	if err != nil {
+		bis.log.Errorw("BridgeWithdrawService submit withdrawTx err", "error", err, "txID", txID)
+	}
+	return err
+}
+
+// unpayableReason why no withdraw tx can pay the withdraw, empty if it can be paid
+func (bis *BridgeWithdrawService) unpayableReason(v *model.Withdraw) string {
+	address, err := btcutil.DecodeAddress(v.BtcTo, config.ChainParams(bis.config.NetworkName))
+	if err != nil {
+		return fmt.Sprintf("invalid address %q: %v", v.BtcTo, err)
+	}
+	script, err := txscript.PayToAddrScript(address)
+	if err != nil {
+		return fmt.Sprintf("invalid address %q: %v", v.BtcTo, err)
+	}
+	if dust := coinselect.DustThreshold(script, coinselect.DustRelayFeeRate); v.BtcValue < dust {
+		return fmt.Sprintf("value %d below the dust threshold %d", v.BtcValue, dust)
+	}
+	return ""
+}
+
+// setAsideWithdraw move a pending withdraw out of the batches with the reason
+func (bis *BridgeWithdrawService) setAsideWithdraw(v *model.Withdraw, status int, reason string) error {
+	_, err := bis.store.Withdraws().UpdateIfStatus(v.ID, model.BtcTxWithdrawPending, map[string]interface{}{
+		model.Withdraw{}.Column().Status: status,
+		model.Withdraw{}.Column().Reason: reason,
+	})
+	if err != nil {
+		bis.log.Errorw("BridgeWithdrawService set aside withdraw err", "error", err, "b2TxHash", v.B2TxHash)
+	}
+	return err
+}
+
+// ReleaseHeldWithdraw return the held withdraws of the b2 tx to the pending withdraws after operator review,
+// withdraws still over the batch max value are held again
+func ReleaseHeldWithdraw(store storage.Store, b2TxHash string) error {
+	return store.Transaction(func(tx storage.Store) error {
+		withdraws, err := tx.Withdraws().ListByB2TxHash(b2TxHash)
+		if err != nil {
+			return err
+		}
+		var released bool
+		for _, withdraw := range withdraws {
+			ok, err := tx.Withdraws().UpdateIfStatus(withdraw.ID, model.BtcTxWithdrawHeld, map[string]interface{}{
+				model.Withdraw{}.Column().Status: model.BtcTxWithdrawPending,
+				model.Withdraw{}.Column().Reason: "",
+			})
 			if err != nil {
-				bis.log.Errorw("BridgeWithdrawService get broadcast tx failed", "error", err)
-				continue
-			}
-			if len(withdrawTxList) == 0 {
-				continue
-			}
-			for _, v := range withdrawTxList {
-				var withdrawTxStatus int
-				var withdrawHistoryStatus int
-				if v.Status == model.BtcTxWithdrawConfirmed {
-					withdrawTxStatus = model.BtcTxWithdrawSuccess
-					withdrawHistoryStatus = model.BtcTxWithdrawSuccess
-				} else {
-					withdrawTxStatus = model.BtcTxWithdrawFailed
-					withdrawHistoryStatus = model.BtcTxWithdrawPending
-				}
-				err = bis.db.Transaction(func(tx *gorm.DB) error {
-					err = tx.Model(&model.WithdrawTx{}).Where("id = ?", v.ID).Update(model.WithdrawTx{}.Column().Status, withdrawTxStatus).Error
-					if err != nil {
-						bis.log.Errorw("BridgeWithdrawService Update WithdrawTx status err", "error", err, "txID", v.BtcTxID)
-						return err
-					}
-					var b2TxHashList []string
-					err = json.Unmarshal([]byte(v.B2TxHashes), &b2TxHashList)
-					if err != nil {
-						return err
-					}
-					err = tx.Model(&model.Withdraw{}).Where(fmt.Sprintf("%s in (?)", model.Withdraw{}.Column().B2TxHash), b2TxHashList).Update(model.Withdraw{}.Column().Status, withdrawHistoryStatus).Error
-					if err != nil {
-						bis.log.Errorw("BridgeWithdrawService Update WithdrawTx status err", "error", err, "txID", v.BtcTxID)
-						return err
-					}
-					return nil
-				})
-				if err != nil {
-					bis.log.Errorw("BridgeWithdrawService complete WithdrawTx err", "error", err, "txID", v.BtcTxID)
-				}
+				return err
 			}
+			released = released || ok
 		}
-	}()
+		if !released {
+			return fmt.Errorf("no held withdraw of b2 tx %s", b2TxHash)
+		}
+		return nil
+	})
+}
 
-	for {
-		timeInterval := bis.config.Bridge.TimeInterval
-		time.Sleep(time.Duration(timeInterval) * time.Second)
-		var withdrawList []model.Withdraw
-		err := bis.db.Model(&model.Withdraw{}).Where(fmt.Sprintf("%s = ?", model.Withdraw{}.Column().Status), model.BtcTxWithdrawPending).Find(&withdrawList).Error
+// BroadcastSigned finalize and broadcast the withdraw txs signed by enough co-signers.
+// A failed broadcast of a tx the node has is a success, the tx fails only when the node rejects it,
+// otherwise it is broadcast again on the next pass and its inputs stay reserved.
+func (bis *BridgeWithdrawService) BroadcastSigned() error {
+	withdrawTxList, err := bis.store.WithdrawTxs().ListByStatus([]int{model.BtcTxWithdrawSignatureCompleted}, 0)
+	if err != nil {
+		bis.log.Errorw("BridgeWithdrawService get broadcast tx failed", "error", err)
+		return err
+	}
+	for _, v := range withdrawTxList {
+		pack, err := psbt.NewFromRawBytes(strings.NewReader(v.BtcTx), true)
 		if err != nil {
-			bis.log.Errorw("BridgeWithdrawService get blockNumber failed", "error", err)
+			bis.log.Errorw("BridgeWithdrawService get psbt tx err", "error", err)
 			continue
 		}
-		if len(withdrawList) == 0 {
+		tx, err := FinalizeWithdrawTx(pack, bis.config.Bridge.PublicKeys, bis.config.Bridge.MultisigNum)
+		if err != nil {
+			bis.log.Errorw("BridgeWithdrawService finalize tx err", "error", err, "txID", v.BtcTxID)
 			continue
 		}
-		var destAddressList []string
-		var amounts []int64
-		var ids []int64
-		var b2TxHashes []string
-		for _, v := range withdrawList {
-			ids = append(ids, v.ID)
-			destAddressList = append(destAddressList, v.BtcTo)
-			amounts = append(amounts, v.BtcValue)
-			b2TxHashes = append(b2TxHashes, v.B2TxHash)
+		var status int
+		var reason string
+		var btcTxHash string
+		var broadcastTime int64
+		txHash, err := bis.backends.Broadcaster.Broadcast(tx)
+		if err != nil {
+			bis.log.Errorw("BridgeWithdrawService broadcast tx err", "id", v.ID, "txID", v.BtcTxID, "error", err)
+			outcome, rejectReason := bis.checkBroadcastErr(tx, err)
+			switch outcome {
+			case broadcastKnown:
+				hash := tx.TxHash()
+				txHash = &hash
+			case broadcastRejected:
+				status = model.BtcTxWithdrawBroadcastFailed
+				reason = fmt.Sprintf("%s, rejected: %s", err, rejectReason)
+			default:
+				bis.log.Warnw("BridgeWithdrawService broadcast tx not rejected, broadcast again", "id", v.ID, "txID", v.BtcTxID)
+				continue
+			}
+		}
+		if status != model.BtcTxWithdrawBroadcastFailed {
+			status = model.BtcTxWithdrawBroadcastSuccess
+			btcTxHash = txHash.String()
+			broadcastTime = bis.clock.Now().Unix()
+		}
+		updateFields := map[string]interface{}{
+			model.WithdrawTx{}.Column().BtcTxHash:     btcTxHash,
+			model.WithdrawTx{}.Column().Status:        status,
+			model.WithdrawTx{}.Column().Reason:        reason,
+			model.WithdrawTx{}.Column().BroadcastTime: broadcastTime,
 		}
-		if len(ids) == 0 {
+		err = bis.store.WithdrawTxs().Update(v.ID, updateFields)
+		if err != nil {
+			bis.log.Errorw("BridgeWithdrawService broadcast tx update db err", "error", err, "id", v.ID)
 			continue
 		}
-		b2TxHashesByte, err := json.Marshal(b2TxHashes)
+		bis.log.Infow("BridgeWithdrawService broadcast tx", "id", v.ID, "btcTxID", v.BtcTxID, "status", status)
+	}
+	return nil
+}
+
+// ConfirmBroadcast mark the broadcast withdraw txs confirmed, the other txs paying the same withdraws are replaced
+func (bis *BridgeWithdrawService) ConfirmBroadcast() error {
+	withdrawTxList, err := bis.store.WithdrawTxs().ListByStatus([]int{model.BtcTxWithdrawBroadcastSuccess}, 0)
+	if err != nil {
+		bis.log.Errorw("BridgeWithdrawService get broadcast tx failed", "error", err)
+		return err
+	}
+	for _, v := range withdrawTxList {
+		txHash, err := chainhash.NewHashFromStr(v.BtcTxHash)
 		if err != nil {
-			bis.log.Errorw("BridgeWithdrawService Marshal b2TxHashes err", "error", err, "id", ids)
+			bis.log.Errorw("BridgeWithdrawService NewHashFromStr err", "error", err, "txhash", v.BtcTxHash)
 			continue
 		}
-		txID, btcTx, err := bis.ConstructTx(destAddressList, amounts, b2TxHashesByte)
+		txRawResult, err := bis.btcCli.GetRawTransactionVerbose(txHash)
 		if err != nil {
-			if errors.Is(err, errors.New("no unspent tx")) {
+			bis.log.Errorw("BridgeWithdrawService GetRawTransactionVerbose err", "error", err, "txID", v.BtcTxID)
+			continue
+		}
+		if int64(txRawResult.Confirmations) >= bis.config.Bridge.Broadcast.Confirmations {
+			err = bis.store.Transaction(func(tx storage.Store) error {
+				confirmed, err := tx.WithdrawTxs().UpdateIfStatus(v.ID, model.BtcTxWithdrawBroadcastSuccess, map[string]interface{}{
+					model.WithdrawTx{}.Column().Status: model.BtcTxWithdrawConfirmed,
+				})
+				if err != nil || !confirmed {
+					return err
+				}
+				return bis.markReplaced(tx, v)
+			})
+			if err != nil {
+				bis.log.Errorw("BridgeWithdrawService Update WithdrawTx status err", "error", err, "txID", v.BtcTxID)
 				continue
 			}
-			bis.log.Errorw("BridgeWithdrawService transferToBtc failed: ", "error", err)
+			bis.log.Infow("BridgeWithdrawService withdraw tx confirmed", "txID", v.BtcTxID,
+				"confirmations", txRawResult.Confirmations, "replaces", v.ReplacesTxID)
+		}
+	}
+	return nil
+}
+
+// CompleteWithdraws settle the withdraws of the confirmed txs, the withdraws of a failed broadcast are built again
+func (bis *BridgeWithdrawService) CompleteWithdraws() error {
+	withdrawTxList, err := bis.store.WithdrawTxs().ListByStatus([]int{model.BtcTxWithdrawConfirmed, model.BtcTxWithdrawBroadcastFailed}, 0)
+	if err != nil {
+		bis.log.Errorw("BridgeWithdrawService get broadcast tx failed", "error", err)
+		return err
+	}
+	for _, v := range withdrawTxList {
+		var withdrawTxStatus int
+		var withdrawHistoryStatus int
+		if v.Status == model.BtcTxWithdrawConfirmed {
+			withdrawTxStatus = model.BtcTxWithdrawSuccess
+			withdrawHistoryStatus = model.BtcTxWithdrawSuccess
+		} else {
+			withdrawTxStatus = model.BtcTxWithdrawFailed
+			withdrawHistoryStatus = model.BtcTxWithdrawPending
+		}
+		if v.Status == model.BtcTxWithdrawBroadcastFailed && v.ReplacesTxID != "" {
+			// the replaced tx may still be mined, its withdraws are not built again
+			if err = bis.failReplacement(v); err != nil {
+				bis.log.Errorw("BridgeWithdrawService fail replacement err", "error", err, "txID", v.BtcTxID)
+			}
 			continue
 		}
-		err = bis.db.Transaction(func(tx *gorm.DB) error {
-			err = tx.Model(&model.Withdraw{}).Where("id in (?)", ids).Update(model.Withdraw{}.Column().Status, model.BtcTxWithdrawSubmitTxMsg).Error
+		if v.Status == model.BtcTxWithdrawBroadcastFailed {
+			// the inputs are released only when the node does not have the tx
+			known, err := bis.txKnown(v.BtcTxID)
 			if err != nil {
-				bis.log.Errorw("BridgeWithdrawService submit withdraw tx update db err", "error", err, "id", ids)
-				return err
-			}
-			withdrawTxData := model.WithdrawTx{
-				BtcTxID:    txID,
-				BtcTx:      btcTx,
-				B2TxHashes: string(b2TxHashesByte),
+				bis.log.Errorw("BridgeWithdrawService check failed tx err", "error", err, "txID", v.BtcTxID)
+				continue
 			}
-			var withdrawTx model.WithdrawTx
-			result := tx.Model(&model.WithdrawTx{}).Where(fmt.Sprintf("%s = ?", model.WithdrawTx{}.Column().BtcTxID), txID).First(&withdrawTx)
-			if result.RowsAffected == 0 {
-				if err = tx.Create(&withdrawTxData).Error; err != nil {
-					bis.log.Errorw("BridgeWithdrawService create withdrawTx err", "b2TxHashes", b2TxHashes, "error", err)
-					return err
-				}
-			} else {
-				updateFields := map[string]interface{}{
-					model.WithdrawTx{}.Column().Status: model.BtcTxWithdrawPending,
-					model.WithdrawTx{}.Column().Reason: "",
-				}
-				err = tx.Model(&model.WithdrawTx{}).Where("id = ?", withdrawTx.ID).Updates(updateFields).Error
+			if known {
+				bis.log.Warnw("BridgeWithdrawService failed tx known by the node", "txID", v.BtcTxID)
+				err = bis.store.WithdrawTxs().Update(v.ID, map[string]interface{}{
+					model.WithdrawTx{}.Column().BtcTxHash:     v.BtcTxID,
+					model.WithdrawTx{}.Column().Status:        model.BtcTxWithdrawBroadcastSuccess,
+					model.WithdrawTx{}.Column().BroadcastTime: bis.clock.Now().Unix(),
+				})
 				if err != nil {
-					bis.log.Errorw("BridgeWithdrawService Update WithdrawTx status err", "error", err, "txID", withdrawTx.BtcTxID)
-					return err
+					bis.log.Errorw("BridgeWithdrawService Update WithdrawTx status err", "error", err, "txID", v.BtcTxID)
 				}
+				continue
+			}
+		}
+		err = bis.store.Transaction(func(store storage.Store) error {
+			err = store.WithdrawTxs().Update(v.ID, map[string]interface{}{model.WithdrawTx{}.Column().Status: withdrawTxStatus})
+			if err != nil {
+				bis.log.Errorw("BridgeWithdrawService Update WithdrawTx status err", "error", err, "txID", v.BtcTxID)
+				return err
+			}
+			var b2TxHashList []string
+			err = json.Unmarshal([]byte(v.B2TxHashes), &b2TxHashList)
+			if err != nil {
+				return err
+			}
+			err = store.Withdraws().UpdateStatusByB2TxHashes(b2TxHashList, withdrawHistoryStatus)
+			if err != nil {
+				bis.log.Errorw("BridgeWithdrawService Update WithdrawTx status err", "error", err, "txID", v.BtcTxID)
+				return err
+			}
+			if withdrawTxStatus == model.BtcTxWithdrawFailed {
+				return bis.releaseInputs(store, v.BtcTxID)
 			}
-
-			bis.log.Infow("BridgeWithdrawService broadcast tx success", "id", ids, "b2TxHashes", b2TxHashes)
 			return nil
 		})
 		if err != nil {
-			bis.log.Errorw("BridgeWithdrawService submit withdrawTx err", "error", err, "txID", txID)
+			bis.log.Errorw("BridgeWithdrawService complete WithdrawTx err", "error", err, "txID", v.BtcTxID)
 		}
 	}
+	return nil
 }
 
-func (bis *BridgeWithdrawService) BroadcastTx(tx *wire.MsgTx) (*chainhash.Hash, error) {
-	mempoolURL := bis.GetMempoolURL()
-	var buf bytes.Buffer
-	if err := tx.Serialize(&buf); err != nil {
-		return nil, err
-	}
-	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/tx", mempoolURL), strings.NewReader(hex.EncodeToString(buf.Bytes())))
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Accept", "application/json")
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	txHash, err := chainhash.NewHashFromStr(string(body))
-	if err != nil {
-		return nil, err
-	}
-	return txHash, nil
-}
-
-func (bis *BridgeWithdrawService) GetUnspentList(address string, cursor int64) (int64, int64, []*model.UnspentOutput, error) {
-	var total int64
-	var satoshiTotal int64
-	url := bis.GetUisatURL()
-	client := resty.New()
-	resp, err := client.R().
-		SetHeader("Content-Type", "application/json").
-		SetHeader("Authorization", fmt.Sprintf("Bearer %s", bis.config.Bridge.UnisatAPIKey)).
-		Get(fmt.Sprintf("%s/v1/indexer/address/%s/utxo-data?cursor=%d", url, address, cursor))
-	if err != nil {
-		bis.log.Errorw("BridgeWithdrawService client url err", "error", err)
-		return total, satoshiTotal, nil, err
-	}
-	if resp.StatusCode() != 200 {
-		bis.log.Errorw("BridgeWithdrawService client res err", "res", resp)
-		return total, satoshiTotal, nil, errors.New(resp.Status())
+// reserveInputs keep the inputs of the withdraw tx out of the next batches, when the utxo source tracks them
+func (bis *BridgeWithdrawService) reserveInputs(store storage.Store, btcTx string, txID string) error {
+	reserver, ok := bis.backends.UTXOSource.(UTXOReserver)
+	if !ok {
+		return nil
 	}
-	var respData model.UnisatResponse
-	err = json.Unmarshal(resp.Body(), &respData)
+	pack, err := psbt.NewFromRawBytes(strings.NewReader(btcTx), true)
 	if err != nil {
-		bis.log.Errorw("BridgeWithdrawService unmarshal err", "error", err)
-		return total, satoshiTotal, nil, err
-	}
-	if respData.Code != 0 {
-		bis.log.Errorw("BridgeWithdrawService get utxo data err", "resp", respData)
-		return total, satoshiTotal, nil, errors.New(respData.Msg)
-	}
-	utxoDataByte, err := json.Marshal(respData.Data)
-	if err != nil {
-		bis.log.Errorw("BridgeWithdrawService marshal utxo data err", "error", err)
-		return total, satoshiTotal, nil, err
-	}
-	var utxoData model.UtxoData
-	err = json.Unmarshal(utxoDataByte, &utxoData)
-	if err != nil {
-		bis.log.Errorw("BridgeWithdrawService unmarshal utxo data err", "error", err)
-		return total, satoshiTotal, nil, err
-	}
-	unspentOutputs := make([]*model.UnspentOutput, 0)
-	total = utxoData.Total
-	for _, v := range utxoData.Utxo {
-		txHash, err := chainhash.NewHashFromStr(v.Txid)
-		if err != nil {
-			return total, satoshiTotal, nil, err
-		}
-		unspentOutputs = append(unspentOutputs, &model.UnspentOutput{
-			Outpoint: wire.NewOutPoint(txHash, uint32(v.Vout)),
-			Output:   wire.NewTxOut(v.Satoshi, []byte(v.ScriptPk)),
-		})
-		satoshiTotal += v.Satoshi
+		return err
 	}
-	return total, satoshiTotal, unspentOutputs, nil
-}
-
-func (bis *BridgeWithdrawService) GetUisatURL() string {
-	networkName := bis.config.NetworkName
-	switch networkName {
-	case chaincfg.MainNetParams.Name:
-		return "https://open-api.unisat.io"
-	case chaincfg.TestNet3Params.Name, "testnet":
-		return "https://open-api-testnet.unisat.io"
+	outpoints := make([]wire.OutPoint, 0, len(pack.UnsignedTx.TxIn))
+	for _, in := range pack.UnsignedTx.TxIn {
+		outpoints = append(outpoints, in.PreviousOutPoint)
 	}
-	return ""
+	return reserver.Reserve(store, outpoints, txID)
 }
 
-func (bis *BridgeWithdrawService) GetMempoolURL() string {
-	networkName := bis.config.NetworkName
-	switch networkName {
-	case chaincfg.MainNetParams.Name:
-		return "https://mempool.space/api"
-	case chaincfg.TestNet3Params.Name, "testnet":
-		return "https://mempool.space/testnet/api"
-	case chaincfg.SigNetParams.Name:
-		return "https://mempool.space/signet/api"
+// releaseInputs return the inputs of a failed withdraw tx to the utxo source
+func (bis *BridgeWithdrawService) releaseInputs(store storage.Store, txID string) error {
+	reserver, ok := bis.backends.UTXOSource.(UTXOReserver)
+	if !ok {
+		return nil
 	}
-	return ""
+	return reserver.Release(store, txID)
 }
 
 func (bis *BridgeWithdrawService) ConstructTx(destAddressList []string, amounts []int64, b2TxHashes []byte) (string, string, error) {
-	sourceAddrStr := bis.config.IndexerListenAddress
 	destAddressList, amounts = mergeDuplicateAddresses(destAddressList, amounts)
 
 	var defaultNet *chaincfg.Params
 	networkName := bis.config.NetworkName
 	defaultNet = config.ChainParams(networkName)
 
-	// get sourceAddress UTXO
-	sourceAddr, err := btcutil.DecodeAddress(sourceAddrStr, defaultNet)
+	// the vault is the p2wsh multisig address of the co-signers, its UTXO pays the withdraws and takes the change
+	multiSigScript, sourceAddr, err := bis.config.Bridge.MultisigVault(defaultNet)
 	if err != nil {
-		bis.log.Errorw("BridgeWithdrawService ConstructTx DecodeAddress err: ", "error", err)
+		bis.log.Errorw("BridgeWithdrawService ConstructTx multisig vault err: ", "error", err)
 		return "", "", err
 	}
 
-	total, satoshiTotal, unspentTxs, err := bis.GetUnspentList(sourceAddrStr, 0)
+	unspentTxs, err := bis.backends.UTXOSource.ListUnspent(sourceAddr)
 	if err != nil {
-		bis.log.Errorw("BridgeWithdrawService GetUnspentList err: ", "error", err)
+		bis.log.Errorw("BridgeWithdrawService ListUnspent err: ", "error", err, "address", sourceAddr.EncodeAddress())
 		return "", "", err
 	}
-	if len(unspentTxs) == 0 {
-		return "", "", errors.New("no unspent tx")
-	}
-	var totalTransferAmount int64
-	for _, v := range amounts {
-		totalTransferAmount += v
-	}
-	if satoshiTotal <= totalTransferAmount {
-		for i := 0; int64(i) < total/16; i++ {
-			_, satoshiTotalTemp, unspentTxsTemp, err := bis.GetUnspentList(sourceAddrStr, int64(i))
-			if err != nil {
-				bis.log.Errorw("BridgeWithdrawService GetUnspentList err: ", "error", err)
-				return "", "", err
-			}
-			if (satoshiTotal + satoshiTotalTemp) > totalTransferAmount {
-				break
-			}
-			unspentTxs = append(unspentTxs, unspentTxsTemp...)
-		}
-	}
-
-	tx := wire.NewMsgTx(wire.TxVersion)
 	changeScript, err := txscript.PayToAddrScript(sourceAddr)
 	if err != nil {
 		bis.log.Errorw("BridgeWithdrawService transferToBtc PayToAddrScript sourceAddr failed: ", "error", err)
 		return "", "", err
 	}
-	var txSize int
-	var outputSize int
-	var fee int
+	coins := make([]coinselect.Coin, 0, len(unspentTxs))
+	unspentOutputs := make(map[wire.OutPoint]*wire.TxOut, len(unspentTxs))
+	for _, unspentTx := range unspentTxs {
+		// the witness script only unlocks the vault output, anything else sent to the address is left alone
+		if !bytes.Equal(unspentTx.Output.PkScript, changeScript) {
+			bis.log.Warnw("BridgeWithdrawService ConstructTx skip utxo not paying the vault script",
+				"outpoint", unspentTx.Outpoint.String(), "pkScript", hex.EncodeToString(unspentTx.Output.PkScript))
+			continue
+		}
+		coins = append(coins, coinselect.Coin{OutPoint: *unspentTx.Outpoint, Value: unspentTx.Output.Value})
+		unspentOutputs[*unspentTx.Outpoint] = unspentTx.Output
+	}
+	if len(coins) == 0 {
+		return "", "", ErrNoUnspentTx
+	}
+
+	tx := wire.NewMsgTx(wire.TxVersion)
 	for index, destAddress := range destAddressList {
 		destAddr, err := btcutil.DecodeAddress(destAddress, defaultNet)
 		if err != nil {
@@ -524,69 +539,61 @@ func (bis *BridgeWithdrawService) ConstructTx(destAddressList []string, amounts
 			return "", "", err
 		}
 		tx.AddTxOut(wire.NewTxOut(amounts[index], destinationScript))
-		outputSize += wire.NewTxOut(amounts[index], destinationScript).SerializeSize()
 	}
-	outputSize += wire.NewTxOut(0, changeScript).SerializeSize()
-	var pInput psbt.PInput
-	feeRate, err := bis.GetFeeRate()
+	feeRate, err := bis.backends.FeeEstimator.FeeRate()
 	if err != nil {
-		bis.log.Errorw("BridgeWithdrawService GetFeeRate err: ", "error", err)
+		bis.log.Errorw("BridgeWithdrawService FeeRate err: ", "error", err)
 		return "", "", err
 	}
-	txSize += outputSize
-	pInputArry := make([]psbt.PInput, 0)
-	totalInputAmount := btcutil.Amount(0)
-	for _, unspentTx := range unspentTxs {
-		var inputSize int
-		outpoint := wire.NewOutPoint(&unspentTx.Outpoint.Hash, unspentTx.Outpoint.Index)
-		txIn := wire.NewTxIn(outpoint, nil, nil)
-		tx.AddTxIn(txIn)
-		multiSigScript, err := bis.GetMultiSigScript(bis.config.Bridge.PublicKeys, bis.config.Bridge.MultisigNum)
-		if err != nil {
-			bis.log.Errorw("BridgeWithdrawService ConstructTx GenerateMultiSigScript err", "error", err)
-			return "", "", err
-		}
-		unspentTx.Output.PkScript = multiSigScript
-		pInput.WitnessUtxo = unspentTx.Output
-		pInput.WitnessScript = multiSigScript
-		pInputArry = append(pInputArry, pInput)
-		totalInputAmount += btcutil.Amount(unspentTx.Output.Value)
-		inputSize = InputSize + bis.GetMultiSigWitnessSize()
-		txSize += inputSize
-		fee = txSize * feeRate.FastestFee
-		if int64(totalInputAmount) > (totalTransferAmount + int64(fee)) {
-			break
-		}
-	}
-	changeAmount := int64(totalInputAmount) - int64(fee) - totalTransferAmount
-	if changeAmount < 0 {
-		bis.log.Errorw("BridgeWithdrawService ConstructTx insufficient balance err",
-			"totalInputAmount", totalInputAmount, "fee", fee, "totalTransferAmount", totalTransferAmount)
-		return "", "", errors.New("insufficient balance")
-	}
-	tx.AddTxOut(wire.NewTxOut(changeAmount, changeScript))
-	bis.log.Infow("BridgeWithdrawService ConstructTx fee", "tx_id", tx.TxHash().String(), "fee", fee, "feeRate", feeRate)
-
-	txCopy := tx.Copy()
-	unsignedPsbt, err := psbt.NewFromUnsignedTx(txCopy)
+	selection, err := coinselect.Select(coinselect.Strategy(bis.config.Bridge.CoinSelection), coins, coinselect.Params{
+		Outputs:      tx.TxOut,
+		ChangeScript: changeScript,
+		InputWeight:  coinselect.MultisigInputWeight(bis.config.Bridge.MultisigNum, len(bis.config.Bridge.PublicKeys)),
+		FeeRate:      feeRate,
+		MaxInputs:    bis.config.Bridge.MaxInputs,
+	})
 	if err != nil {
-		bis.log.Errorw("BridgeWithdrawService NewFromUnsignedTx err: ", "error", err)
+		bis.log.Errorw("BridgeWithdrawService ConstructTx select coins err", "error", err,
+			"strategy", bis.config.Bridge.CoinSelection, "utxos", len(coins), "feeRate", feeRate)
 		return "", "", err
 	}
-	unsignedPsbt.Inputs = pInputArry
-	var unknown psbt.Unknown
-	var unknowns []*psbt.Unknown
-	unknown.Key = []byte("b2TxHashes")
-	unknown.Value = b2TxHashes
-	unknowns = append(unknowns, &unknown)
-	unsignedPsbt.Unknowns = unknowns
-	psbtData, err := unsignedPsbt.B64Encode()
+	pInputArry := make([]psbt.PInput, 0, len(selection.Coins))
+	for _, coin := range selection.Coins {
+		txIn := wire.NewTxIn(&coin.OutPoint, nil, nil)
+		txIn.Sequence = WithdrawTxInSequence
+		tx.AddTxIn(txIn)
+		pInputArry = append(pInputArry, psbt.PInput{
+			WitnessUtxo:   unspentOutputs[coin.OutPoint],
+			WitnessScript: multiSigScript,
+		})
+	}
+	// dust change is left to the fee
+	if selection.Change > 0 {
+		tx.AddTxOut(wire.NewTxOut(selection.Change, changeScript))
+	}
+	pInputArry = sortWithdrawTx(tx, pInputArry)
+	bis.log.Infow("BridgeWithdrawService ConstructTx fee", "tx_id", tx.TxHash().String(), "fee", selection.Fee,
+		"feeRate", feeRate, "vsize", selection.VSize, "inputs", len(selection.Coins))
+
+	psbtData, err := newWithdrawPsbt(tx, pInputArry, b2TxHashes)
 	if err != nil {
+		bis.log.Errorw("BridgeWithdrawService NewFromUnsignedTx err: ", "error", err)
 		return "", "", err
 	}
 	return tx.TxHash().String(), psbtData, nil
 }
 
+// newWithdrawPsbt unsigned psbt of the withdraw tx, the b2 tx hashes it pays are kept in the unknowns
+func newWithdrawPsbt(tx *wire.MsgTx, inputs []psbt.PInput, b2TxHashes []byte) (string, error) {
+	unsignedPsbt, err := psbt.NewFromUnsignedTx(tx.Copy())
+	if err != nil {
+		return "", err
+	}
+	unsignedPsbt.Inputs = inputs
+	unsignedPsbt.Unknowns = []*psbt.Unknown{{Key: []byte(B2TxHashesKey), Value: b2TxHashes}}
+	return unsignedPsbt.B64Encode()
+}
+
 func (bis *BridgeWithdrawService) GetMultiSigScript(pubs []string, minSignNum int) ([]byte, error) {
 	var defaultNet *chaincfg.Params
 	networkName := bis.config.NetworkName
@@ -615,57 +622,21 @@ func (bis *BridgeWithdrawService) GetMultiSigScript(pubs []string, minSignNum in
 	return multiSigScript, nil
 }
 
-func (bis *BridgeWithdrawService) GetFeeRate() (*model.FeeRates, error) {
-	mempoolURL := bis.GetMempoolURL()
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/v1/fees/recommended", mempoolURL), strings.NewReader(""))
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Accept", "application/json")
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	var feeRates model.FeeRates
-	err = json.Unmarshal(body, &feeRates)
-	if err != nil {
-		return nil, err
-	}
-	return &feeRates, nil
-}
-
-func (bis *BridgeWithdrawService) GetMultiSigWitnessSize() int {
-	//	- NumberOfWitnessElements: 1 byte
-	//	- NilLength: 1 byte
-	//	- sigAliceLength: 1 byte
-	//	- sigAlice: 73 bytes
-	//	- sigBobLength: 1 byte
-	//	- sigBob: 73 bytes
-	//	- WitnessScriptLength: 1 byte
-	//	- WitnessScript (MultiSig)
-	// MultiSigWitnessSize = 1 + 1 + 1 + 73 + 1 + 73 + 1 + MultiSigSize
-	return 1 + 1 + 1 + MultiSigSize + bis.config.Bridge.MultisigNum*74
-}
-
+// mergeDuplicateAddresses one amount per address, in the order the addresses first appear
 func mergeDuplicateAddresses(destAddressList []string, amounts []int64) ([]string, []int64) {
-	mergedAddresses := make(map[string]int64)
-
-	for i, address := range destAddressList {
-		mergedAddresses[address] += amounts[i]
-	}
-
+	indexes := make(map[string]int)
 	uniqueAddresses := make([]string, 0)
 	mergedAmounts := make([]int64, 0)
 
-	for address, amount := range mergedAddresses {
-		uniqueAddresses = append(uniqueAddresses, address)
-		mergedAmounts = append(mergedAmounts, amount)
+	for i, address := range destAddressList {
+		index, ok := indexes[address]
+		if !ok {
+			index = len(uniqueAddresses)
+			indexes[address] = index
+			uniqueAddresses = append(uniqueAddresses, address)
+			mergedAmounts = append(mergedAmounts, 0)
+		}
+		mergedAmounts[index] += amounts[i]
 	}
 
 	return uniqueAddresses, mergedAmounts
@@ -0,0 +1,1095 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/btcutil/txsort"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/qday-io/qday-abel-bridge-indexer/config"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/clock"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/coinselect"
+	logger "github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	gormlog "gorm.io/gorm/logger"
+)
+
+// fakeBitcoind regtest node stand-in, accepts a tx only if every input script verifies against its utxo set.
+// A tx spending the inputs of mempool txs replaces them if it pays a higher fee.
+type fakeBitcoind struct {
+	*httptest.Server
+	mu            sync.Mutex
+	utxos         map[wire.OutPoint]*wire.TxOut
+	txs           map[string]*wire.MsgTx
+	blocks        []*wire.MsgBlock
+	confirmations uint64
+	// mempool spends and fees, block height of the mined txs
+	spends  map[wire.OutPoint]fakeSpend
+	fees    map[string]int64
+	heights map[string]int
+	// sendErr fails sendrawtransaction before the tx reaches the mempool, lostReply after,
+	// rejectReason makes the node refuse every tx
+	sendErr      bool
+	lostReply    bool
+	rejectReason string
+}
+
+type fakeSpend struct {
+	txID    string
+	prevOut *wire.TxOut
+}
+
+func newFakeBitcoind(t *testing.T) *fakeBitcoind {
+	t.Helper()
+	f := &fakeBitcoind{
+		utxos:   make(map[wire.OutPoint]*wire.TxOut),
+		txs:     make(map[string]*wire.MsgTx),
+		spends:  make(map[wire.OutPoint]fakeSpend),
+		fees:    make(map[string]int64),
+		heights: make(map[string]int),
+	}
+	f.mine()
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeBitcoind) client(t *testing.T) *rpcclient.Client {
+	t.Helper()
+	client, err := rpcclient.New(&rpcclient.ConnConfig{
+		Host:         strings.TrimPrefix(f.URL, "http://"),
+		User:         "regtest",
+		Pass:         "regtest",
+		HTTPPostMode: true,
+		DisableTLS:   true,
+	}, nil)
+	require.NoError(t, err)
+	t.Cleanup(client.Shutdown)
+	return client
+}
+
+// mine a block of the txs on top of the chain, their outputs join the utxo set
+func (f *fakeBitcoind) mine(txs ...*wire.MsgTx) *wire.MsgBlock {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var prevBlock chainhash.Hash
+	if len(f.blocks) > 0 {
+		prevBlock = f.blocks[len(f.blocks)-1].BlockHash()
+	}
+	block := wire.NewMsgBlock(wire.NewBlockHeader(1, &prevBlock, &chainhash.Hash{}, 0, uint32(len(f.blocks))))
+	for _, tx := range txs {
+		_ = block.AddTransaction(tx)
+		f.heights[tx.TxHash().String()] = len(f.blocks)
+		for _, in := range tx.TxIn {
+			delete(f.utxos, in.PreviousOutPoint)
+			delete(f.spends, in.PreviousOutPoint)
+		}
+		for vout, out := range tx.TxOut {
+			f.utxos[wire.OutPoint{Hash: tx.TxHash(), Index: uint32(vout)}] = out
+		}
+	}
+	f.blocks = append(f.blocks, block)
+	return block
+}
+
+func (f *fakeBitcoind) serve(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     json.RawMessage   `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	result, rpcErr := f.handle(req.Method, req.Params)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": req.ID, "result": result, "error": rpcErr})
+}
+
+func (f *fakeBitcoind) handle(method string, params []json.RawMessage) (interface{}, interface{}) {
+	switch method {
+	case "getinfo":
+		return map[string]interface{}{"version": 1}, nil
+	case "testmempoolaccept":
+		var rawHexes []string
+		_ = json.Unmarshal(params[0], &rawHexes)
+		tx, rpcErr := decodeFakeTx(rawHexes[0])
+		if rpcErr == nil {
+			_, _, _, rpcErr = f.accept(tx)
+		}
+		if rpcErr != nil {
+			return []map[string]interface{}{{"txid": tx.TxHash().String(), "allowed": false, "reject-reason": rpcErr["message"]}}, nil
+		}
+		return []map[string]interface{}{{"txid": tx.TxHash().String(), "allowed": true}}, nil
+	case "sendrawtransaction":
+		var rawHex string
+		_ = json.Unmarshal(params[0], &rawHex)
+		tx, rpcErr := decodeFakeTx(rawHex)
+		if rpcErr != nil {
+			return nil, rpcErr
+		}
+		if f.sendErr {
+			return nil, fakeRPCError(-1, "connection reset")
+		}
+		if _, ok := f.txs[tx.TxHash().String()]; ok {
+			return nil, fakeRPCError(-27, "txn-already-in-mempool")
+		}
+		prevOuts, conflicts, fee, rpcErr := f.accept(tx)
+		if rpcErr != nil {
+			return nil, rpcErr
+		}
+		// the replaced txs leave the mempool, their inputs are unspent again
+		for outpoint, spend := range f.spends {
+			if conflicts[spend.txID] {
+				f.utxos[outpoint] = spend.prevOut
+				delete(f.spends, outpoint)
+			}
+		}
+		for txID := range conflicts {
+			delete(f.txs, txID)
+		}
+		for _, in := range tx.TxIn {
+			f.spends[in.PreviousOutPoint] = fakeSpend{txID: tx.TxHash().String(), prevOut: prevOuts[in.PreviousOutPoint]}
+			delete(f.utxos, in.PreviousOutPoint)
+		}
+		f.txs[tx.TxHash().String()] = tx
+		f.fees[tx.TxHash().String()] = fee
+		if f.lostReply {
+			return nil, fakeRPCError(-1, "connection reset")
+		}
+		return tx.TxHash().String(), nil
+	case "listunspent":
+		var addresses []string
+		_ = json.Unmarshal(params[2], &addresses)
+		var results []btcjson.ListUnspentResult
+		for outpoint, out := range f.utxos {
+			_, outAddresses, _, _ := txscript.ExtractPkScriptAddrs(out.PkScript, &chaincfg.RegressionNetParams)
+			for _, address := range addresses {
+				if len(outAddresses) == 1 && outAddresses[0].EncodeAddress() == address {
+					results = append(results, btcjson.ListUnspentResult{
+						TxID:          outpoint.Hash.String(),
+						Vout:          outpoint.Index,
+						Address:       address,
+						ScriptPubKey:  hex.EncodeToString(out.PkScript),
+						Amount:        btcutil.Amount(out.Value).ToBTC(),
+						Confirmations: 1,
+						Spendable:     true,
+					})
+				}
+			}
+		}
+		return results, nil
+	case "estimatesmartfee":
+		return map[string]interface{}{"feerate": 0.00002, "blocks": 2}, nil
+	case "getblockcount":
+		return len(f.blocks) - 1, nil
+	case "getblockhash":
+		var height int
+		_ = json.Unmarshal(params[0], &height)
+		if height < 0 || height >= len(f.blocks) {
+			return nil, fakeRPCError(-8, "Block height out of range")
+		}
+		return f.blocks[height].BlockHash().String(), nil
+	case "getblock":
+		var blockHash string
+		_ = json.Unmarshal(params[0], &blockHash)
+		for _, block := range f.blocks {
+			if block.BlockHash().String() == blockHash {
+				var buf bytes.Buffer
+				_ = block.Serialize(&buf)
+				return hex.EncodeToString(buf.Bytes()), nil
+			}
+		}
+		return nil, fakeRPCError(-5, "Block not found")
+	case "getrawtransaction":
+		var txID string
+		_ = json.Unmarshal(params[0], &txID)
+		tx, ok := f.txs[txID]
+		if !ok {
+			return nil, fakeRPCError(-5, "No such mempool or blockchain transaction")
+		}
+		confirmations := f.confirmations
+		if height, mined := f.heights[txID]; mined {
+			confirmations = uint64(len(f.blocks) - height)
+		}
+		var buf bytes.Buffer
+		_ = tx.Serialize(&buf)
+		return map[string]interface{}{"txid": txID, "hex": hex.EncodeToString(buf.Bytes()), "confirmations": confirmations}, nil
+	default:
+		return nil, fakeRPCError(-32601, "Method not found")
+	}
+}
+
+// fakeRPCError json-rpc error of the fake node
+func fakeRPCError(code int, message string) map[string]interface{} {
+	return map[string]interface{}{"code": code, "message": message}
+}
+
+func decodeFakeTx(rawHex string) (*wire.MsgTx, map[string]interface{}) {
+	var tx wire.MsgTx
+	raw, err := hex.DecodeString(rawHex)
+	if err == nil {
+		err = tx.Deserialize(bytes.NewReader(raw))
+	}
+	if err != nil {
+		return &tx, fakeRPCError(-22, err.Error())
+	}
+	return &tx, nil
+}
+
+// accept the mempool checks of the tx, its prevouts, the mempool txs it replaces and its fee
+func (f *fakeBitcoind) accept(tx *wire.MsgTx) (map[wire.OutPoint]*wire.TxOut, map[string]bool, int64, map[string]interface{}) {
+	if f.rejectReason != "" {
+		return nil, nil, 0, fakeRPCError(-26, f.rejectReason)
+	}
+	prevOuts := make(map[wire.OutPoint]*wire.TxOut)
+	conflicts := make(map[string]bool)
+	var fee int64
+	for _, in := range tx.TxIn {
+		prevOut, ok := f.utxos[in.PreviousOutPoint]
+		if spend, spent := f.spends[in.PreviousOutPoint]; !ok && spent {
+			prevOut, ok = spend.prevOut, true
+			conflicts[spend.txID] = true
+		}
+		if !ok {
+			return nil, nil, 0, fakeRPCError(-25, "bad-txns-inputs-missingorspent")
+		}
+		prevOuts[in.PreviousOutPoint] = prevOut
+		fee += prevOut.Value
+	}
+	for _, out := range tx.TxOut {
+		fee -= out.Value
+	}
+	for txID := range conflicts {
+		if fee <= f.fees[txID] {
+			return nil, nil, 0, fakeRPCError(-26, "insufficient fee")
+		}
+	}
+	fetcher := txscript.NewMultiPrevOutFetcher(prevOuts)
+	sigHashes := txscript.NewTxSigHashes(tx, fetcher)
+	for index, in := range tx.TxIn {
+		prevOut := prevOuts[in.PreviousOutPoint]
+		engine, err := txscript.NewEngine(prevOut.PkScript, tx, index, txscript.StandardVerifyFlags, nil, sigHashes, prevOut.Value, fetcher)
+		if err == nil {
+			err = engine.Execute()
+		}
+		if err != nil {
+			return nil, nil, 0, fakeRPCError(-26, fmt.Sprintf("mandatory-script-verify-flag-failed: %v", err))
+		}
+	}
+	return prevOuts, conflicts, fee, nil
+}
+
+// newFakeUnisat utxo source stand-in listing the utxos of the address page by page
+func newFakeUnisat(t *testing.T, address string, utxos []*model.UnspentOutput) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, fmt.Sprintf("/v1/indexer/address/%s/utxo-data", address), r.URL.Path)
+		cursor, _ := strconv.Atoi(r.URL.Query().Get("cursor"))
+		size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+		list := make([]model.Utxo, 0)
+		for index := cursor; index < len(utxos) && index < cursor+size; index++ {
+			list = append(list, model.Utxo{
+				Txid:     utxos[index].Outpoint.Hash.String(),
+				Vout:     int64(utxos[index].Outpoint.Index),
+				Satoshi:  utxos[index].Output.Value,
+				ScriptPk: hex.EncodeToString(utxos[index].Output.PkScript),
+				Address:  address,
+			})
+		}
+		_ = json.NewEncoder(w).Encode(model.UnisatResponse{Data: model.UtxoData{Cursor: int64(cursor), Total: int64(len(utxos)), Utxo: list}})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newFakeMempool fee source and broadcaster stand-in
+func newFakeMempool(t *testing.T, feeRate int, broadcast func(rawHex string)) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/fees/recommended":
+			_ = json.NewEncoder(w).Encode(model.FeeRates{FastestFee: feeRate, HalfHourFee: feeRate, HourFee: feeRate, EconomyFee: 1, MinimumFee: 1})
+		case "/tx":
+			body, _ := io.ReadAll(r.Body)
+			broadcast(string(body))
+			raw, _ := hex.DecodeString(string(body))
+			var tx wire.MsgTx
+			if err := tx.Deserialize(bytes.NewReader(raw)); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			_, _ = io.WriteString(w, tx.TxHash().String())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// signWithdrawPsbt partial signatures of the co-signer key for every input
+func signWithdrawPsbt(t *testing.T, b64 string, key *btcec.PrivateKey) string {
+	t.Helper()
+	pack, err := psbt.NewFromRawBytes(strings.NewReader(b64), true)
+	require.NoError(t, err)
+	fetcher := txscript.NewMultiPrevOutFetcher(nil)
+	for index, in := range pack.UnsignedTx.TxIn {
+		fetcher.AddPrevOut(in.PreviousOutPoint, pack.Inputs[index].WitnessUtxo)
+	}
+	sigHashes := txscript.NewTxSigHashes(pack.UnsignedTx, fetcher)
+	for index := range pack.Inputs {
+		input := &pack.Inputs[index]
+		sig, err := txscript.RawTxInWitnessSignature(pack.UnsignedTx, sigHashes, index,
+			input.WitnessUtxo.Value, input.WitnessScript, txscript.SigHashAll, key)
+		require.NoError(t, err)
+		input.PartialSigs = append(input.PartialSigs, &psbt.PartialSig{PubKey: key.PubKey().SerializeCompressed(), Signature: sig})
+	}
+	signed, err := pack.B64Encode()
+	require.NoError(t, err)
+	return signed
+}
+
+func newRegtestAddress(t *testing.T) btcutil.Address {
+	t.Helper()
+	key, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	address, err := btcutil.NewAddressWitnessPubKeyHash(btcutil.Hash160(key.PubKey().SerializeCompressed()), &chaincfg.RegressionNetParams)
+	require.NoError(t, err)
+	return address
+}
+
+// regtestWithdraw 2-of-3 vault funded with one utxo, alice and bob waiting for their withdraws
+type regtestWithdraw struct {
+	keys       []*btcec.PrivateKey
+	cfg        *config.BitcoinConfig
+	vault      btcutil.Address
+	node       *fakeBitcoind
+	client     *rpcclient.Client
+	db         *gorm.DB
+	store      storage.Store
+	bis        *BridgeWithdrawService
+	alice, bob btcutil.Address
+}
+
+func newRegtestWithdraw(t *testing.T) *regtestWithdraw {
+	t.Helper()
+	var keys []*btcec.PrivateKey
+	var pubKeys []string
+	for i := 0; i < 3; i++ {
+		key, err := btcec.NewPrivateKey()
+		require.NoError(t, err)
+		keys = append(keys, key)
+		pubKeys = append(pubKeys, hex.EncodeToString(key.PubKey().SerializeCompressed()))
+	}
+	cfg := &config.BitcoinConfig{
+		NetworkName: chaincfg.RegressionNetParams.Name,
+		Bridge: config.BridgeConfig{
+			PublicKeys:    pubKeys,
+			MultisigNum:   2,
+			UTXOSource:    WithdrawBackendRPC,
+			FeeSource:     WithdrawBackendRPC,
+			Broadcaster:   WithdrawBackendRPC,
+			CoinSelection: string(coinselect.StrategyBnB),
+			MaxInputs:     100,
+			Broadcast: config.WithdrawBroadcastConfig{
+				Confirmations:  6,
+				StuckTimeout:   3600,
+				FeeBumpPercent: 25,
+				MaxFeeRate:     20,
+			},
+		},
+	}
+	_, vault, err := cfg.Bridge.MultisigVault(&chaincfg.RegressionNetParams)
+	require.NoError(t, err)
+	vaultScript, err := txscript.PayToAddrScript(vault)
+	require.NoError(t, err)
+
+	node := newFakeBitcoind(t)
+	funding := wire.OutPoint{Hash: chainhash.DoubleHashH([]byte("funding")), Index: 1}
+	node.utxos[funding] = wire.NewTxOut(100000, vaultScript)
+	client := node.client(t)
+	db, err := storage.Open(storage.Options{Source: storage.SQLiteScheme + ":memory:", LogLevel: gormlog.Silent})
+	require.NoError(t, err)
+	store, err := storage.New(db)
+	require.NoError(t, err)
+	backends, err := NewWithdrawBackends(cfg, client, store)
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&model.Withdraw{}, &model.WithdrawTx{}))
+	alice, bob := newRegtestAddress(t), newRegtestAddress(t)
+	require.NoError(t, db.Create(&[]model.Withdraw{
+		{BtcFrom: vault.EncodeAddress(), BtcTo: alice.EncodeAddress(), BtcValue: 30000, B2TxHash: "0xb1", Status: model.BtcTxWithdrawPending},
+		{BtcFrom: vault.EncodeAddress(), BtcTo: bob.EncodeAddress(), BtcValue: 20000, B2TxHash: "0xb2", Status: model.BtcTxWithdrawPending},
+	}).Error)
+
+	return &regtestWithdraw{
+		keys:   keys,
+		cfg:    cfg,
+		vault:  vault,
+		node:   node,
+		client: client,
+		db:     db,
+		store:  store,
+		bis:    NewBridgeWithdrawService(client, nil, backends, cfg, store, logger.NewNopLogger()),
+		alice:  alice,
+		bob:    bob,
+	}
+}
+
+// two withdraws burned on the rollup are paid from the 2-of-3 vault on regtest
+func TestBridgeWithdrawService_Regtest(t *testing.T) {
+	w := newRegtestWithdraw(t)
+	keys, cfg, vault, node, db, bis, alice, bob := w.keys, w.cfg, w.vault, w.node, w.db, w.bis, w.alice, w.bob
+	require.NoError(t, bis.ConstructWithdraws())
+	var withdrawTx model.WithdrawTx
+	require.NoError(t, db.First(&withdrawTx).Error)
+	assert.Equal(t, model.BtcTxWithdrawPending, withdrawTx.Status)
+	assertWithdrawStatus(t, w.db, model.BtcTxWithdrawSubmitTxMsg)
+
+	// nothing is broadcast before the co-signers sign
+	require.NoError(t, bis.BroadcastSigned())
+	assert.Empty(t, node.txs)
+
+	outsider, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	_, err = MergeWithdrawSignatures(w.store, cfg, signWithdrawPsbt(t, withdrawTx.BtcTx, outsider), "")
+	require.ErrorIs(t, err, ErrWithdrawUnknownSigner)
+
+	// the signatures are ordered by the multisig keys, not by submission
+	submitted, err := MergeWithdrawSignatures(w.store, cfg, signWithdrawPsbt(t, withdrawTx.BtcTx, keys[2]), "")
+	require.NoError(t, err)
+	assert.Equal(t, model.BtcTxWithdrawPending, submitted.Status)
+	pack, err := psbt.NewFromRawBytes(strings.NewReader(submitted.BtcTx), true)
+	require.NoError(t, err)
+	_, err = FinalizeWithdrawTx(pack, cfg.Bridge.PublicKeys, 2)
+	require.ErrorIs(t, err, ErrWithdrawSignatureMissing)
+
+	submitted, err = MergeWithdrawSignatures(w.store, cfg, signWithdrawPsbt(t, withdrawTx.BtcTx, keys[0]), "")
+	require.NoError(t, err)
+	assert.Equal(t, model.BtcTxWithdrawSignatureCompleted, submitted.Status)
+	_, err = MergeWithdrawSignatures(w.store, cfg, signWithdrawPsbt(t, withdrawTx.BtcTx, keys[1]), "")
+	require.ErrorIs(t, err, ErrWithdrawTxNotSigning)
+
+	require.NoError(t, bis.BroadcastSigned())
+	require.NoError(t, db.First(&withdrawTx).Error)
+	require.Equal(t, model.BtcTxWithdrawBroadcastSuccess, withdrawTx.Status, withdrawTx.Reason)
+	assert.Equal(t, withdrawTx.BtcTxID, withdrawTx.BtcTxHash)
+	tx := node.txs[withdrawTx.BtcTxHash]
+	require.NotNil(t, tx)
+	paid := make(map[string]int64)
+	for _, out := range tx.TxOut {
+		_, addresses, _, err := txscript.ExtractPkScriptAddrs(out.PkScript, &chaincfg.RegressionNetParams)
+		require.NoError(t, err)
+		paid[addresses[0].EncodeAddress()] += out.Value
+	}
+	assert.Equal(t, int64(30000), paid[alice.EncodeAddress()])
+	assert.Equal(t, int64(20000), paid[bob.EncodeAddress()])
+	assert.Greater(t, paid[vault.EncodeAddress()], int64(0), "change back to the vault")
+	assert.Less(t, paid[vault.EncodeAddress()], int64(50000), "fee paid from the change")
+	// the estimate with max size signatures pays the 2 sat/vbyte of estimatesmartfee
+	fee := 100000 - paid[alice.EncodeAddress()] - paid[bob.EncodeAddress()] - paid[vault.EncodeAddress()]
+	assert.GreaterOrEqual(t, fee, coinselect.VSize(blockchain.GetTransactionWeight(btcutil.NewTx(tx)))*2)
+
+	require.NoError(t, bis.ConfirmBroadcast())
+	require.NoError(t, db.First(&withdrawTx).Error)
+	assert.Equal(t, model.BtcTxWithdrawBroadcastSuccess, withdrawTx.Status)
+	node.confirmations = 6
+	require.NoError(t, bis.ConfirmBroadcast())
+	require.NoError(t, bis.CompleteWithdraws())
+	require.NoError(t, db.First(&withdrawTx).Error)
+	assert.Equal(t, model.BtcTxWithdrawSuccess, withdrawTx.Status)
+	assertWithdrawStatus(t, w.db, model.BtcTxWithdrawSuccess)
+}
+
+// a failed broadcast fails the withdraw tx only when the node rejects it
+func TestBridgeWithdrawService_BroadcastErr(t *testing.T) {
+	signed := func(w *regtestWithdraw) *model.WithdrawTx {
+		t.Helper()
+		require.NoError(t, w.bis.ConstructWithdraws())
+		var withdrawTx model.WithdrawTx
+		require.NoError(t, w.db.First(&withdrawTx).Error)
+		for _, key := range w.keys[:2] {
+			_, err := MergeWithdrawSignatures(w.store, w.cfg, signWithdrawPsbt(t, withdrawTx.BtcTx, key), "")
+			require.NoError(t, err)
+		}
+		return &withdrawTx
+	}
+	reload := func(w *regtestWithdraw, withdrawTx *model.WithdrawTx) {
+		t.Helper()
+		require.NoError(t, w.db.First(withdrawTx, withdrawTx.ID).Error)
+	}
+
+	w := newRegtestWithdraw(t)
+	withdrawTx := signed(w)
+
+	// the tx never reached the node but it would accept it, broadcast again
+	w.node.sendErr = true
+	require.NoError(t, w.bis.BroadcastSigned())
+	reload(w, withdrawTx)
+	assert.Equal(t, model.BtcTxWithdrawSignatureCompleted, withdrawTx.Status)
+	assert.Empty(t, w.node.txs)
+
+	// the reply is lost, the node has the tx
+	w.node.sendErr, w.node.lostReply = false, true
+	require.NoError(t, w.bis.BroadcastSigned())
+	reload(w, withdrawTx)
+	require.Equal(t, model.BtcTxWithdrawBroadcastSuccess, withdrawTx.Status, withdrawTx.Reason)
+	assert.Equal(t, withdrawTx.BtcTxID, withdrawTx.BtcTxHash)
+	require.NotNil(t, w.node.txs[withdrawTx.BtcTxID])
+
+	// broadcast again, already in the mempool
+	w.node.lostReply = false
+	require.NoError(t, w.db.Model(withdrawTx).Update("status", model.BtcTxWithdrawSignatureCompleted).Error)
+	require.NoError(t, w.bis.BroadcastSigned())
+	reload(w, withdrawTx)
+	assert.Equal(t, model.BtcTxWithdrawBroadcastSuccess, withdrawTx.Status)
+
+	// a failed tx the node has keeps its withdraws and inputs
+	require.NoError(t, w.db.Model(withdrawTx).Update("status", model.BtcTxWithdrawBroadcastFailed).Error)
+	require.NoError(t, w.bis.CompleteWithdraws())
+	reload(w, withdrawTx)
+	assert.Equal(t, model.BtcTxWithdrawBroadcastSuccess, withdrawTx.Status)
+	assertWithdrawStatus(t, w.db, model.BtcTxWithdrawSubmitTxMsg)
+
+	// rejected by the node, the withdraws are built again
+	w = newRegtestWithdraw(t)
+	withdrawTx = signed(w)
+	w.node.rejectReason = "min relay fee not met"
+	require.NoError(t, w.bis.BroadcastSigned())
+	reload(w, withdrawTx)
+	assert.Equal(t, model.BtcTxWithdrawBroadcastFailed, withdrawTx.Status)
+	assert.Contains(t, withdrawTx.Reason, "min relay fee not met")
+	require.NoError(t, w.bis.CompleteWithdraws())
+	reload(w, withdrawTx)
+	assert.Equal(t, model.BtcTxWithdrawFailed, withdrawTx.Status)
+	assertWithdrawStatus(t, w.db, model.BtcTxWithdrawPending)
+}
+
+// withdraws no tx can pay fail and withdraws over the batch max value are held, the others are batched
+func TestBridgeWithdrawService_SetAsideWithdraws(t *testing.T) {
+	w := newRegtestWithdraw(t)
+	w.cfg.Bridge.Batch.MaxValue = 40000
+	require.NoError(t, w.db.Create(&[]model.Withdraw{
+		{BtcFrom: w.vault.EncodeAddress(), BtcTo: w.alice.EncodeAddress(), BtcValue: 100, B2TxHash: "0xdust", Status: model.BtcTxWithdrawPending},
+		{BtcFrom: w.vault.EncodeAddress(), BtcTo: "not-an-address", BtcValue: 10000, B2TxHash: "0xinvalid", Status: model.BtcTxWithdrawPending},
+		{BtcFrom: w.vault.EncodeAddress(), BtcTo: w.bob.EncodeAddress(), BtcValue: 45000, B2TxHash: "0xlarge", Status: model.BtcTxWithdrawPending},
+	}).Error)
+	store, err := storage.New(w.db)
+	require.NoError(t, err)
+
+	require.NoError(t, w.bis.ConstructWithdraws())
+	withdraw := func(b2TxHash string) *model.Withdraw {
+		t.Helper()
+		return withdrawOf(t, store, b2TxHash)
+	}
+	assert.Equal(t, model.BtcTxWithdrawFailed, withdraw("0xdust").Status)
+	assert.Contains(t, withdraw("0xdust").Reason, "below the dust threshold")
+	assert.Equal(t, model.BtcTxWithdrawFailed, withdraw("0xinvalid").Status)
+	assert.Contains(t, withdraw("0xinvalid").Reason, "invalid address")
+	assert.Equal(t, model.BtcTxWithdrawHeld, withdraw("0xlarge").Status)
+	assert.Contains(t, withdraw("0xlarge").Reason, "over the batch max value 40000")
+	// the held withdraw does not block the older ones
+	assert.Equal(t, model.BtcTxWithdrawSubmitTxMsg, withdraw("0xb1").Status)
+
+	require.NoError(t, ReleaseHeldWithdraw(store, "0xlarge"))
+	assert.Equal(t, model.BtcTxWithdrawPending, withdraw("0xlarge").Status)
+	assert.Empty(t, withdraw("0xlarge").Reason)
+	assert.Error(t, ReleaseHeldWithdraw(store, "0xlarge"))
+}
+
+func assertWithdrawStatus(t *testing.T, db *gorm.DB, status int) {
+	t.Helper()
+	var withdraws []model.Withdraw
+	require.NoError(t, db.Find(&withdraws).Error)
+	require.NotEmpty(t, withdraws)
+	for _, withdraw := range withdraws {
+		assert.Equal(t, status, withdraw.Status, withdraw.B2TxHash)
+	}
+}
+
+// signatures are checked against their keys and the psbt against the indexed withdraws before merging
+func TestMergeWithdrawSignatures_Verify(t *testing.T) {
+	w := newRegtestWithdraw(t)
+	require.NoError(t, w.bis.ConstructWithdraws())
+	signer := hex.EncodeToString(w.keys[0].PubKey().SerializeCompressed())
+
+	requests, err := ListWithdrawSigningRequests(w.store, w.cfg, signer)
+	require.NoError(t, err)
+	require.Len(t, requests, 1)
+	require.Empty(t, requests[0].Error)
+	assert.Len(t, requests[0].Withdraws, 2)
+	assert.False(t, requests[0].Signed)
+	unsigned := requests[0].Psbt
+
+	_, err = MergeWithdrawSignatures(w.store, w.cfg, signWithdrawPsbt(t, unsigned, w.keys[1]), signer)
+	require.ErrorIs(t, err, ErrWithdrawSignerMismatch)
+
+	// a signature of key 0 claimed for key 1
+	pack, err := psbt.NewFromRawBytes(strings.NewReader(signWithdrawPsbt(t, unsigned, w.keys[0])), true)
+	require.NoError(t, err)
+	for _, input := range pack.Inputs {
+		input.PartialSigs[0].PubKey = w.keys[1].PubKey().SerializeCompressed()
+	}
+	forged, err := pack.B64Encode()
+	require.NoError(t, err)
+	_, err = MergeWithdrawSignatures(w.store, w.cfg, forged, "")
+	require.ErrorIs(t, err, ErrWithdrawInvalidSignature)
+
+	submitted, err := MergeWithdrawSignatures(w.store, w.cfg, signWithdrawPsbt(t, unsigned, w.keys[0]), signer)
+	require.NoError(t, err)
+	assert.Equal(t, model.BtcTxWithdrawPending, submitted.Status)
+	requests, err = ListWithdrawSigningRequests(w.store, w.cfg, signer)
+	require.NoError(t, err)
+	assert.True(t, requests[0].Signed)
+	signedByFirst := requests[0].Psbt
+
+	// the indexed withdraw no longer matches the output paying alice
+	require.NoError(t, w.db.Model(&model.Withdraw{}).
+		Where("b2_tx_hash = ?", "0xb1").
+		Update("btc_value", 31000).Error)
+	_, err = MergeWithdrawSignatures(w.store, w.cfg, signWithdrawPsbt(t, unsigned, w.keys[1]), "")
+	require.ErrorIs(t, err, ErrWithdrawUnverified)
+	requests, err = ListWithdrawSigningRequests(w.store, w.cfg, signer)
+	require.NoError(t, err)
+	assert.Empty(t, requests[0].Psbt)
+	assert.Contains(t, requests[0].Error, ErrWithdrawUnverified.Error())
+
+	// the next co-signer signs on top of the merged signatures
+	require.NoError(t, w.db.Model(&model.Withdraw{}).
+		Where("b2_tx_hash = ?", "0xb1").
+		Update("btc_value", 30000).Error)
+	submitted, err = MergeWithdrawSignatures(w.store, w.cfg, signWithdrawPsbt(t, signedByFirst, w.keys[2]),
+		hex.EncodeToString(w.keys[2].PubKey().SerializeCompressed()))
+	require.NoError(t, err)
+	assert.Equal(t, model.BtcTxWithdrawSignatureCompleted, submitted.Status)
+}
+
+// a b2 tx emitting two withdraw logs is listed once in the psbt and verified against both withdraws
+func TestMergeWithdrawSignatures_TwoLogsInOneB2Tx(t *testing.T) {
+	w := newRegtestWithdraw(t)
+	require.NoError(t, w.db.Create(&model.Withdraw{BtcFrom: w.vault.EncodeAddress(), BtcTo: w.bob.EncodeAddress(),
+		BtcValue: 5000, B2TxHash: "0xb1", B2LogIndex: 1, Status: model.BtcTxWithdrawPending}).Error)
+	require.NoError(t, w.bis.ConstructWithdraws())
+
+	requests, err := ListWithdrawSigningRequests(w.store, w.cfg, "")
+	require.NoError(t, err)
+	require.Len(t, requests, 1)
+	require.Empty(t, requests[0].Error)
+	assert.Len(t, requests[0].Withdraws, 3)
+	pack, err := psbt.NewFromRawBytes(strings.NewReader(requests[0].Psbt), true)
+	require.NoError(t, err)
+	b2TxHashes, err := WithdrawB2TxHashes(pack)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"0xb1", "0xb2"}, b2TxHashes)
+
+	unsigned := requests[0].Psbt
+	_, err = MergeWithdrawSignatures(w.store, w.cfg, signWithdrawPsbt(t, unsigned, w.keys[0]), "")
+	require.NoError(t, err)
+	submitted, err := MergeWithdrawSignatures(w.store, w.cfg, signWithdrawPsbt(t, unsigned, w.keys[1]), "")
+	require.NoError(t, err)
+	assert.Equal(t, model.BtcTxWithdrawSignatureCompleted, submitted.Status)
+}
+
+func TestWithdrawBackends(t *testing.T) {
+	vault := newRegtestAddress(t)
+	vaultScript, err := txscript.PayToAddrScript(vault)
+	require.NoError(t, err)
+	var utxos []*model.UnspentOutput
+	for i := 0; i < unisatPageSize+4; i++ {
+		utxos = append(utxos, &model.UnspentOutput{
+			Outpoint: wire.NewOutPoint(&chainhash.Hash{byte(i)}, uint32(i)),
+			Output:   wire.NewTxOut(int64(1000+i), vaultScript),
+		})
+	}
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(wire.NewTxIn(utxos[0].Outpoint, nil, nil))
+	tx.AddTxOut(wire.NewTxOut(900, vaultScript))
+
+	t.Run("unisat and mempool", func(t *testing.T) {
+		var broadcast []string
+		cfg := &config.BitcoinConfig{
+			NetworkName: chaincfg.RegressionNetParams.Name,
+			Bridge: config.BridgeConfig{
+				UnisatURL:   newFakeUnisat(t, vault.EncodeAddress(), utxos).URL,
+				MempoolURL:  newFakeMempool(t, 5, func(rawHex string) { broadcast = append(broadcast, rawHex) }).URL,
+				UTXOSource:  WithdrawBackendUnisat,
+				FeeSource:   WithdrawBackendMempool,
+				Broadcaster: WithdrawBackendMempool,
+			},
+		}
+		backends, err := NewWithdrawBackends(cfg, nil, nil)
+		require.NoError(t, err)
+
+		// every page is listed, the script is decoded from hex
+		unspent, err := backends.UTXOSource.ListUnspent(vault)
+		require.NoError(t, err)
+		assert.Equal(t, utxos, unspent)
+		feeRate, err := backends.FeeEstimator.FeeRate()
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), feeRate)
+		txHash, err := backends.Broadcaster.Broadcast(tx)
+		require.NoError(t, err)
+		assert.Equal(t, tx.TxHash(), *txHash)
+		assert.Len(t, broadcast, 1)
+	})
+
+	t.Run("static", func(t *testing.T) {
+		static := NewStaticWithdrawBackend(append(utxos[:2:2], &model.UnspentOutput{
+			Outpoint: wire.NewOutPoint(&chainhash.Hash{0xff}, 0),
+			Output:   wire.NewTxOut(5000, []byte{txscript.OP_TRUE}),
+		}), 3)
+		unspent, err := static.ListUnspent(vault)
+		require.NoError(t, err)
+		assert.Equal(t, utxos[:2], unspent)
+		_, err = static.Broadcast(tx)
+		require.NoError(t, err)
+		unspent, err = static.ListUnspent(vault)
+		require.NoError(t, err)
+		assert.Equal(t, utxos[1:2], unspent)
+		assert.Equal(t, []*wire.MsgTx{tx}, static.Txs())
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		_, err := NewWithdrawBackends(&config.BitcoinConfig{Bridge: config.BridgeConfig{UTXOSource: "electrum"}}, nil, nil)
+		require.Error(t, err)
+	})
+}
+
+// the vault utxos are scanned from the blocks, reserved by the withdraw tx spending them and released if it fails
+func TestVaultScanner_LocalUTXOSource(t *testing.T) {
+	w := newRegtestWithdraw(t)
+	cfg := *w.cfg
+	cfg.Bridge.UTXOSource = WithdrawBackendLocal
+	cfg.Bridge.Vault.Confirmations = 1
+	store, err := storage.New(w.db)
+	require.NoError(t, err)
+	require.NoError(t, store.Migrate(&model.VaultUtxo{}, &model.VaultIndex{}))
+	backends, err := NewWithdrawBackends(&cfg, w.client, store)
+	require.NoError(t, err)
+	bis := NewBridgeWithdrawService(w.client, nil, backends, &cfg, store, logger.NewNopLogger())
+	scanner := NewVaultScanner(w.client, &cfg, store, logger.NewNopLogger())
+
+	// nothing is scanned yet, the utxo known to the node only is not used
+	require.NoError(t, bis.ConstructWithdraws())
+	assertWithdrawStatus(t, w.db, model.BtcTxWithdrawPending)
+
+	vaultScript, err := txscript.PayToAddrScript(w.vault)
+	require.NoError(t, err)
+	funding := wire.NewMsgTx(wire.TxVersion)
+	funding.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{0x01}, 0), nil, nil))
+	funding.AddTxOut(wire.NewTxOut(100000, vaultScript))
+	funding.AddTxOut(wire.NewTxOut(5000, []byte{txscript.OP_TRUE}))
+	w.node.mine(funding)
+	require.NoError(t, scanner.Scan())
+	unspent, err := store.VaultUTXOs().ListUnspent(w.vault.EncodeAddress())
+	require.NoError(t, err)
+	require.Len(t, unspent, 1)
+	assert.Equal(t, funding.TxHash().String(), unspent[0].TxID)
+	assert.Equal(t, int64(100000), unspent[0].Value)
+	index, err := store.Cursors().GetVaultIndex()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), index.BtcIndexBlock)
+
+	require.NoError(t, bis.ConstructWithdraws())
+	var withdrawTx model.WithdrawTx
+	require.NoError(t, w.db.First(&withdrawTx).Error)
+	var reserved model.VaultUtxo
+	require.NoError(t, w.db.First(&reserved).Error)
+	assert.Equal(t, model.VaultUtxoReserved, reserved.Status)
+	assert.Equal(t, withdrawTx.BtcTxID, reserved.ReservedBy)
+
+	// the reserved utxo is not spent twice by the next batch
+	require.NoError(t, w.db.Create(&model.Withdraw{BtcFrom: w.vault.EncodeAddress(), BtcTo: w.alice.EncodeAddress(),
+		BtcValue: 10000, B2TxHash: "0xb3", Status: model.BtcTxWithdrawPending}).Error)
+	require.NoError(t, bis.ConstructWithdraws())
+	var count int64
+	require.NoError(t, w.db.Model(&model.WithdrawTx{}).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+
+	// a failed tx gives its inputs back
+	require.NoError(t, w.db.Model(&withdrawTx).Update("status", model.BtcTxWithdrawBroadcastFailed).Error)
+	require.NoError(t, bis.CompleteWithdraws())
+	unspent, err = store.VaultUTXOs().ListUnspent(w.vault.EncodeAddress())
+	require.NoError(t, err)
+	require.Len(t, unspent, 1)
+	assertWithdrawStatus(t, w.db, model.BtcTxWithdrawPending)
+
+	// the three withdraws are paid from the released utxo and the mined spend is scanned
+	require.NoError(t, bis.ConstructWithdraws())
+	withdrawTx = model.WithdrawTx{}
+	require.NoError(t, w.db.Where("status = ?", model.BtcTxWithdrawPending).First(&withdrawTx).Error)
+	_, err = MergeWithdrawSignatures(w.store, &cfg, signWithdrawPsbt(t, withdrawTx.BtcTx, w.keys[0]), "")
+	require.NoError(t, err)
+	_, err = MergeWithdrawSignatures(w.store, &cfg, signWithdrawPsbt(t, withdrawTx.BtcTx, w.keys[1]), "")
+	require.NoError(t, err)
+	require.NoError(t, bis.BroadcastSigned())
+	spend := w.node.txs[withdrawTx.BtcTxID]
+	require.NotNil(t, spend)
+	w.node.mine(spend)
+	require.NoError(t, scanner.Scan())
+
+	var spent model.VaultUtxo
+	require.NoError(t, w.db.Where("tx_id = ?", funding.TxHash().String()).First(&spent).Error)
+	assert.Equal(t, model.VaultUtxoSpent, spent.Status)
+	assert.Equal(t, withdrawTx.BtcTxID, spent.SpentBy)
+	unspent, err = store.VaultUTXOs().ListUnspent(w.vault.EncodeAddress())
+	require.NoError(t, err)
+	require.Len(t, unspent, 1, "change back to the vault")
+	assert.Equal(t, withdrawTx.BtcTxID, unspent[0].TxID)
+}
+
+func TestBumpFeeRate(t *testing.T) {
+	cfg := config.WithdrawBroadcastConfig{FeeBumpPercent: 25, MaxFeeRate: 100}
+	testCases := []struct {
+		oldRate, estimate int64
+		rate              int64
+		err               error
+	}{
+		{oldRate: 2, estimate: 2, rate: 3},
+		{oldRate: 20, estimate: 10, rate: 25},
+		{oldRate: 20, estimate: 40, rate: 40},
+		{oldRate: 90, estimate: 10, rate: 100},
+		{oldRate: 100, estimate: 150, err: ErrWithdrawFeeCap},
+	}
+	for _, tc := range testCases {
+		rate, err := BumpFeeRate(tc.oldRate, tc.estimate, cfg)
+		if tc.err != nil {
+			require.ErrorIs(t, err, tc.err)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, tc.rate, rate, "old rate %d, estimate %d", tc.oldRate, tc.estimate)
+	}
+}
+
+// a withdraw tx not mined in time is replaced by fee, the co-signers sign the replacement and it confirms instead
+func TestBridgeWithdrawService_ReplaceStuck(t *testing.T) {
+	w := newRegtestWithdraw(t)
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	w.bis.SetClock(clk)
+	signAndBroadcast := func(btcTx string, keys ...*btcec.PrivateKey) {
+		t.Helper()
+		for _, key := range keys {
+			_, err := MergeWithdrawSignatures(w.store, w.cfg, signWithdrawPsbt(t, btcTx, key), "")
+			require.NoError(t, err)
+		}
+		require.NoError(t, w.bis.BroadcastSigned())
+	}
+	countWithdrawTxs := func() int64 {
+		t.Helper()
+		var count int64
+		require.NoError(t, w.db.Model(&model.WithdrawTx{}).Count(&count).Error)
+		return count
+	}
+
+	require.NoError(t, w.bis.ConstructWithdraws())
+	var original model.WithdrawTx
+	require.NoError(t, w.db.First(&original).Error)
+	signAndBroadcast(original.BtcTx, w.keys[0], w.keys[1])
+	require.NoError(t, w.db.First(&original, original.ID).Error)
+	require.Equal(t, model.BtcTxWithdrawBroadcastSuccess, original.Status, original.Reason)
+	assert.Equal(t, clk.Now().Unix(), original.BroadcastTime)
+	originalTx := w.node.txs[original.BtcTxID]
+	require.NotNil(t, originalTx)
+
+	clk.Advance(30 * time.Minute)
+	require.NoError(t, w.bis.ReplaceStuck())
+	assert.Equal(t, int64(1), countWithdrawTxs(), "not stuck before the timeout")
+
+	clk.Advance(time.Hour)
+	require.NoError(t, w.bis.ReplaceStuck())
+	var replacement model.WithdrawTx
+	require.NoError(t, w.db.Where("replaces_tx_id = ?", original.BtcTxID).First(&replacement).Error)
+	assert.Equal(t, model.BtcTxWithdrawPending, replacement.Status)
+	assert.Equal(t, original.B2TxHashes, replacement.B2TxHashes)
+	require.NoError(t, w.db.First(&original, original.ID).Error)
+	assert.Equal(t, replacement.BtcTxID, original.ReplacedByTxID)
+	require.NoError(t, w.bis.ReplaceStuck())
+	assert.Equal(t, int64(2), countWithdrawTxs(), "replaced once")
+
+	// the same inputs and payments at a higher fee
+	pack, err := psbt.NewFromRawBytes(strings.NewReader(replacement.BtcTx), true)
+	require.NoError(t, err)
+	require.Len(t, pack.UnsignedTx.TxIn, len(originalTx.TxIn))
+	var replacementFee int64
+	for index, in := range pack.UnsignedTx.TxIn {
+		assert.Equal(t, originalTx.TxIn[index].PreviousOutPoint, in.PreviousOutPoint)
+		assert.Equal(t, uint32(WithdrawTxInSequence), in.Sequence)
+		replacementFee += pack.Inputs[index].WitnessUtxo.Value
+	}
+	vaultScript, err := txscript.PayToAddrScript(w.vault)
+	require.NoError(t, err)
+	payments := func(outs []*wire.TxOut) []*wire.TxOut {
+		var result []*wire.TxOut
+		for _, out := range outs {
+			if !bytes.Equal(out.PkScript, vaultScript) {
+				result = append(result, out)
+			}
+		}
+		return result
+	}
+	for _, out := range pack.UnsignedTx.TxOut {
+		replacementFee -= out.Value
+	}
+	assert.Equal(t, payments(originalTx.TxOut), payments(pack.UnsignedTx.TxOut))
+	assert.Greater(t, replacementFee, w.node.fees[original.BtcTxID])
+
+	// a replacement not broadcast keeps the withdraws in flight, the stuck tx is replaced again
+	require.NoError(t, w.db.Model(&replacement).Update("status", model.BtcTxWithdrawBroadcastFailed).Error)
+	require.NoError(t, w.bis.CompleteWithdraws())
+	require.NoError(t, w.db.First(&replacement, replacement.ID).Error)
+	assert.Equal(t, model.BtcTxWithdrawFailed, replacement.Status)
+	require.NoError(t, w.db.First(&original, original.ID).Error)
+	assert.Empty(t, original.ReplacedByTxID)
+	assertWithdrawStatus(t, w.db, model.BtcTxWithdrawSubmitTxMsg)
+	require.NoError(t, w.bis.ReplaceStuck())
+	require.NoError(t, w.db.First(&replacement, replacement.ID).Error)
+	assert.Equal(t, model.BtcTxWithdrawPending, replacement.Status)
+	assert.Equal(t, int64(2), countWithdrawTxs(), "the same replacement is built again")
+
+	requests, err := ListWithdrawSigningRequests(w.store, w.cfg, hex.EncodeToString(w.keys[2].PubKey().SerializeCompressed()))
+	require.NoError(t, err)
+	require.Len(t, requests, 1)
+	assert.Equal(t, replacement.BtcTxID, requests[0].BtcTxID)
+	signAndBroadcast(replacement.BtcTx, w.keys[1], w.keys[2])
+	require.NoError(t, w.db.First(&replacement, replacement.ID).Error)
+	require.Equal(t, model.BtcTxWithdrawBroadcastSuccess, replacement.Status, replacement.Reason)
+	assert.Nil(t, w.node.txs[original.BtcTxID], "the original left the mempool")
+
+	w.node.mine(w.node.txs[replacement.BtcTxID])
+	for i := 0; i < 5; i++ {
+		w.node.mine()
+	}
+	require.NoError(t, w.bis.ConfirmBroadcast())
+	require.NoError(t, w.bis.CompleteWithdraws())
+	require.NoError(t, w.db.First(&replacement, replacement.ID).Error)
+	assert.Equal(t, model.BtcTxWithdrawSuccess, replacement.Status)
+	require.NoError(t, w.db.First(&original, original.ID).Error)
+	assert.Equal(t, model.BtcTxWithdrawReplaced, original.Status)
+	assert.Contains(t, original.Reason, replacement.BtcTxID)
+	assertWithdrawStatus(t, w.db, model.BtcTxWithdrawSuccess)
+}
+
+func TestNewWithdrawBatch(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	withdraw := func(id int64, to string, value int64, age time.Duration) *model.Withdraw {
+		return &model.Withdraw{Base: model.Base{ID: id, CreatedAt: now.Add(-age)}, B2TxHash: fmt.Sprintf("0x%d", id), BtcTo: to, BtcValue: value}
+	}
+	withdraws := []*model.Withdraw{
+		withdraw(1, "alice", 100, time.Hour),
+		withdraw(2, "bob", 200, 30*time.Minute),
+		withdraw(3, "alice", 300, 20*time.Minute),
+		withdraw(4, "carol", 5000, 10*time.Minute),
+		withdraw(5, "dave", 400, time.Minute),
+	}
+	ids := func(withdraws []*model.Withdraw) []int64 {
+		var result []int64
+		for _, withdraw := range withdraws {
+			result = append(result, withdraw.ID)
+		}
+		return result
+	}
+
+	testCases := []struct {
+		name      string
+		cfg       config.WithdrawBatchConfig
+		ids       []int64
+		oversized []int64
+		full      bool
+		ready     bool
+	}{
+		{name: "no limits", cfg: config.WithdrawBatchConfig{}, ids: []int64{1, 2, 3, 4, 5}, ready: true},
+		// alice is paid by one output
+		{name: "max outputs", cfg: config.WithdrawBatchConfig{MaxOutputs: 2}, ids: []int64{1, 2, 3}, full: true, ready: true},
+		{name: "max value", cfg: config.WithdrawBatchConfig{MaxValue: 650}, ids: []int64{1, 2, 3}, oversized: []int64{4}, full: true, ready: true},
+		// the oversized withdraw is set aside, the younger ones are batched
+		{name: "oversized", cfg: config.WithdrawBatchConfig{MaxValue: 1000}, ids: []int64{1, 2, 3, 5}, oversized: []int64{4}, ready: true},
+		// set aside after the batch is full too
+		{name: "oversized after full", cfg: config.WithdrawBatchConfig{MaxValue: 500}, ids: []int64{1, 2}, oversized: []int64{4}, full: true, ready: true},
+		{name: "min age", cfg: config.WithdrawBatchConfig{MinAge: 3600}, ids: []int64{1, 2, 3, 4, 5}, ready: true},
+		{name: "too young", cfg: config.WithdrawBatchConfig{MinAge: 7200}, ids: []int64{1, 2, 3, 4, 5}, ready: false},
+		{name: "full before min age", cfg: config.WithdrawBatchConfig{MinAge: 7200, MaxOutputs: 4}, ids: []int64{1, 2, 3, 4, 5}, full: true, ready: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			batch := NewWithdrawBatch(withdraws, tc.cfg)
+			assert.Equal(t, tc.ids, ids(batch.Withdraws))
+			assert.Equal(t, tc.oversized, ids(batch.Oversized))
+			assert.Equal(t, tc.full, batch.Full)
+			assert.Equal(t, tc.ready, batch.Ready(tc.cfg, now))
+		})
+	}
+	assert.False(t, NewWithdrawBatch(nil, config.WithdrawBatchConfig{}).Ready(config.WithdrawBatchConfig{}, now))
+
+	// the withdraws of a b2 tx are batched or held together
+	withdraws[2].B2TxHash = withdraws[0].B2TxHash
+	batch := NewWithdrawBatch(withdraws, config.WithdrawBatchConfig{MaxValue: 399})
+	assert.Equal(t, []int64{2}, ids(batch.Withdraws))
+	assert.Equal(t, []int64{1, 3, 4, 5}, ids(batch.Oversized))
+	assert.Equal(t, "value 400 over the batch max value 399", WithdrawLimitReason(batch.Oversized[:2], config.WithdrawBatchConfig{MaxValue: 399}))
+	batch = NewWithdrawBatch(withdraws, config.WithdrawBatchConfig{MaxValue: 1000})
+	assert.Equal(t, []int64{1, 3, 2, 5}, ids(batch.Withdraws))
+	batch = NewWithdrawBatch(withdraws, config.WithdrawBatchConfig{MaxOutputs: 1})
+	assert.Equal(t, []int64{1, 3}, ids(batch.Withdraws))
+	assert.True(t, batch.Full)
+}
+
+// the same withdraws and utxos build the same psbt whatever order they are listed in
+func TestConstructTx_Deterministic(t *testing.T) {
+	w := newRegtestWithdraw(t)
+	w.cfg.Bridge.CoinSelection = string(coinselect.StrategyLargestFirst)
+	vaultScript, err := txscript.PayToAddrScript(w.vault)
+	require.NoError(t, err)
+	for i := 0; i < 8; i++ {
+		w.node.utxos[wire.OutPoint{Hash: chainhash.DoubleHashH([]byte{byte(i)}), Index: uint32(i)}] = wire.NewTxOut(20000, vaultScript)
+	}
+	delete(w.node.utxos, wire.OutPoint{Hash: chainhash.DoubleHashH([]byte("funding")), Index: 1})
+	carol := newRegtestAddress(t)
+	b2TxHashes := []byte(`["0xb1","0xb2","0xb3"]`)
+
+	txID, btcTx, err := w.bis.ConstructTx(
+		[]string{w.alice.EncodeAddress(), w.bob.EncodeAddress(), carol.EncodeAddress(), w.alice.EncodeAddress()},
+		[]int64{30000, 20000, 25000, 5000}, b2TxHashes)
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		again, againTx, err := w.bis.ConstructTx(
+			[]string{carol.EncodeAddress(), w.alice.EncodeAddress(), w.bob.EncodeAddress(), w.alice.EncodeAddress()},
+			[]int64{25000, 5000, 20000, 30000}, b2TxHashes)
+		require.NoError(t, err)
+		assert.Equal(t, txID, again)
+		assert.Equal(t, btcTx, againTx)
+	}
+
+	pack, err := psbt.NewFromRawBytes(strings.NewReader(btcTx), true)
+	require.NoError(t, err)
+	require.Len(t, pack.UnsignedTx.TxOut, 4, "alice is paid by one output")
+	assert.True(t, txsort.IsSorted(pack.UnsignedTx))
+	for index, in := range pack.UnsignedTx.TxIn {
+		assert.Equal(t, w.node.utxos[in.PreviousOutPoint], pack.Inputs[index].WitnessUtxo)
+	}
+}
@@ -0,0 +1,240 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/qday-io/qday-abel-bridge-indexer/config"
+	_interface "github.com/qday-io/qday-abel-bridge-indexer/internal/interface"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/amount"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/clock"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
+)
+
+const (
+	// circuit breaker trigger
+	BreakerTriggerMintVolume  = "mint_volume"
+	BreakerTriggerCheckFailed = "check_failed"
+	BreakerTriggerSupply      = "supply_mismatch"
+	BreakerTriggerDoubleMint  = "double_mint"
+)
+
+var ErrCircuitBreakerOpen = errors.New("circuit breaker open, minting paused")
+
+// CircuitBreaker pause minting on anomaly detection
+// The paused state is persisted and only cleared by an explicit Resume.
+type CircuitBreaker struct {
+	cfg       config.CircuitBreakerConfig
+	store     storage.Store
+	bridge    _interface.BitcoinBridge
+	converter *amount.Converter
+	log       log.Logger
+	clock     clock.Clock
+
+	supplyBaseline  *big.Int
+	supplyTolerance *big.Int
+}
+
+// NewCircuitBreaker new circuit breaker, bridge is only required by the supply check
+func NewCircuitBreaker(
+	cfg config.CircuitBreakerConfig,
+	store storage.Store,
+	bridge _interface.BitcoinBridge,
+	converter *amount.Converter,
+	logger log.Logger,
+) (*CircuitBreaker, error) {
+	baseline, ok := new(big.Int).SetString(cfg.SupplyBaseline, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid supply baseline: %s", cfg.SupplyBaseline)
+	}
+	tolerance, ok := new(big.Int).SetString(cfg.SupplyTolerance, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid supply tolerance: %s", cfg.SupplyTolerance)
+	}
+	return &CircuitBreaker{
+		cfg:             cfg,
+		store:           store,
+		bridge:          bridge,
+		converter:       converter,
+		log:             logger,
+		clock:           clock.Real,
+		supplyBaseline:  baseline,
+		supplyTolerance: tolerance,
+	}, nil
+}
+
+// SetClock stamp the pause and resume times and the mint window with the clock
+func (cb *CircuitBreaker) SetClock(c clock.Clock) {
+	cb.clock = c
+}
+
+// State current persisted state
+func (cb *CircuitBreaker) State() (*model.CircuitBreaker, error) {
+	state, err := cb.store.CircuitBreaker().Get()
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return &model.CircuitBreaker{}, nil
+		}
+		return nil, err
+	}
+	return state, nil
+}
+
+// Allow return ErrCircuitBreakerOpen when minting is paused
+func (cb *CircuitBreaker) Allow() error {
+	state, err := cb.State()
+	if err != nil {
+		return err
+	}
+	if state.Paused {
+		return fmt.Errorf("%w: %s", ErrCircuitBreakerOpen, state.Reason)
+	}
+	return nil
+}
+
+// Pause persist paused state, an already paused breaker keeps its first reason
+func (cb *CircuitBreaker) Pause(reason string, triggeredBy string) error {
+	state, err := cb.State()
+	if err != nil {
+		return err
+	}
+	if state.Paused {
+		return nil
+	}
+	state.Paused = true
+	state.Reason = reason
+	state.TriggeredBy = triggeredBy
+	state.PausedAt = cb.clock.Now()
+	cb.log.Errorw("circuit breaker tripped, minting paused", "reason", reason, "triggeredBy", triggeredBy)
+	return cb.store.CircuitBreaker().Save(state)
+}
+
+// Resume clear paused state
+func (cb *CircuitBreaker) Resume(resumedBy string) error {
+	state, err := cb.State()
+	if err != nil {
+		return err
+	}
+	state.Paused = false
+	state.ResumedBy = resumedBy
+	state.ResumedAt = cb.clock.Now()
+	cb.log.Warnw("circuit breaker resumed", "resumedBy", resumedBy, "lastReason", state.Reason)
+	return cb.store.CircuitBreaker().Save(state)
+}
+
+// Check run anomaly checks and pause on the first one triggered
+func (cb *CircuitBreaker) Check() error {
+	if !cb.cfg.Enable {
+		return nil
+	}
+	state, err := cb.State()
+	if err != nil {
+		return err
+	}
+	if state.Paused {
+		return nil
+	}
+
+	checks := []struct {
+		name  string
+		check func(state *model.CircuitBreaker) (string, error)
+	}{
+		{BreakerTriggerMintVolume, cb.checkMintVolume},
+		{BreakerTriggerCheckFailed, cb.checkFailedDeposit},
+		{BreakerTriggerSupply, cb.checkSupply},
+		{BreakerTriggerDoubleMint, cb.checkDoubleMint},
+	}
+	for _, c := range checks {
+		reason, err := c.check(state)
+		if err != nil {
+			cb.log.Errorw("circuit breaker check err", "check", c.name, "error", err)
+			continue
+		}
+		if reason != "" {
+			return cb.Pause(reason, c.name)
+		}
+	}
+	return nil
+}
+
+// checkMintVolume volume of the deposits minted in the window exceeds threshold
+func (cb *CircuitBreaker) checkMintVolume(_ *model.CircuitBreaker) (string, error) {
+	if cb.cfg.MintThreshold <= 0 {
+		return "", nil
+	}
+	since := cb.clock.Now().Add(-time.Duration(cb.cfg.MintWindow) * time.Second)
+	volume, err := cb.store.Deposits().MintedVolume(since)
+	if err != nil {
+		return "", err
+	}
+	if volume.Cmp(big.NewInt(cb.cfg.MintThreshold)) > 0 {
+		return fmt.Sprintf("mint volume %s in %ds exceeds threshold %d", volume.String(), cb.cfg.MintWindow, cb.cfg.MintThreshold), nil
+	}
+	return "", nil
+}
+
+// checkFailedDeposit any deposit check failed since last resume, a flagged deposit updated later does not count again
+func (cb *CircuitBreaker) checkFailedDeposit(state *model.CircuitBreaker) (string, error) {
+	deposit, err := cb.store.Deposits().FirstFlagged([]int{model.B2CheckStatusFailed}, state.ResumedAt)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+	return fmt.Sprintf("deposit check failed, btc tx hash: %s", deposit.BtcTxHash), nil
+}
+
+// checkDoubleMint any deposit flagged by the attempt watcher since last resume
+func (cb *CircuitBreaker) checkDoubleMint(state *model.CircuitBreaker) (string, error) {
+	deposit, err := cb.store.Deposits().FirstFlagged(mintFlaggedChecks, state.ResumedAt)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+	return fmt.Sprintf("deposit minted by an unexpected b2 tx, btc tx hash: %s", deposit.BtcTxHash), nil
+}
+
+// checkSupply on-chain token supply disagrees with the minted ledger
+func (cb *CircuitBreaker) checkSupply(_ *model.CircuitBreaker) (string, error) {
+	if cb.cfg.TokenAddress == "" || cb.bridge == nil || cb.converter == nil {
+		return "", nil
+	}
+	ledger, err := MintedLedger(cb.store)
+	if err != nil {
+		return "", err
+	}
+	supply, err := cb.bridge.TotalSupply(cb.cfg.TokenAddress)
+	if err != nil {
+		return "", err
+	}
+	minted, err := cb.converter.ToTarget(ledger)
+	if err != nil {
+		return "", err
+	}
+	expected := new(big.Int).Add(cb.supplyBaseline, minted)
+	diff := new(big.Int).Sub(supply, expected)
+	if diff.CmpAbs(cb.supplyTolerance) > 0 {
+		return fmt.Sprintf("token supply %s disagrees with ledger %s, diff %s", supply, expected, diff), nil
+	}
+	return "", nil
+}
+
+// MintedLedger sum of successfully minted amount, including treasury fee, source chain unit
+func MintedLedger(store storage.Store) (*big.Int, error) {
+	minted, err := store.Deposits().MintedVolume(time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	fees, err := store.Deposits().MintedFees()
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).Add(minted, fees), nil
+}
@@ -0,0 +1,174 @@
+package indexer
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/qday-io/qday-abel-bridge-indexer/config"
+	_interface "github.com/qday-io/qday-abel-bridge-indexer/internal/interface"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/amount"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/clock"
+	logger "github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	gormlog "gorm.io/gorm/logger"
+)
+
+func TestNewCircuitBreaker_InvalidConfig(t *testing.T) {
+	_, err := NewCircuitBreaker(config.CircuitBreakerConfig{SupplyBaseline: "0", SupplyTolerance: "0"}, nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	_, err = NewCircuitBreaker(config.CircuitBreakerConfig{SupplyBaseline: "1e18", SupplyTolerance: "0"}, nil, nil, nil, nil)
+	require.Error(t, err)
+
+	_, err = NewCircuitBreaker(config.CircuitBreakerConfig{SupplyBaseline: "0", SupplyTolerance: ""}, nil, nil, nil, nil)
+	require.Error(t, err)
+}
+
+func newTestCircuitBreaker(t *testing.T, cfg config.CircuitBreakerConfig, chain *fakeBridge) (*CircuitBreaker, *gorm.DB) {
+	t.Helper()
+	db, err := storage.Open(storage.Options{Source: storage.SQLiteScheme + ":memory:", LogLevel: gormlog.Silent})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&model.Deposit{}, &model.DepositEvent{}, &model.B2TxAttempt{}, &model.CircuitBreaker{}))
+	cfg.SupplyBaseline, cfg.SupplyTolerance = "0", "0"
+	var bridge _interface.BitcoinBridge
+	if chain != nil {
+		bridge = chain
+	}
+	store, err := storage.New(db)
+	require.NoError(t, err)
+	cb, err := NewCircuitBreaker(cfg, store, bridge, amount.NewConverter(7, 18), logger.NewNopLogger())
+	require.NoError(t, err)
+	return cb, db
+}
+
+func TestCircuitBreaker_PauseResume(t *testing.T) {
+	cb, _ := newTestCircuitBreaker(t, config.CircuitBreakerConfig{}, nil)
+	require.NoError(t, cb.Allow())
+
+	require.NoError(t, cb.Pause("manual", "cli"))
+	require.ErrorIs(t, cb.Allow(), ErrCircuitBreakerOpen)
+	// a paused breaker keeps its first reason
+	require.NoError(t, cb.Pause("again", "http:127.0.0.1"))
+
+	// the paused state survives a restart
+	restarted, err := NewCircuitBreaker(config.CircuitBreakerConfig{SupplyBaseline: "0", SupplyTolerance: "0"}, cb.store, nil, nil, logger.NewNopLogger())
+	require.NoError(t, err)
+	require.ErrorIs(t, restarted.Allow(), ErrCircuitBreakerOpen)
+	state, err := restarted.State()
+	require.NoError(t, err)
+	assert.Equal(t, "manual", state.Reason)
+	assert.Equal(t, "cli", state.TriggeredBy)
+
+	require.NoError(t, restarted.Resume("http:127.0.0.1"))
+	require.NoError(t, cb.Allow())
+	state, err = cb.State()
+	require.NoError(t, err)
+	assert.False(t, state.Paused)
+	assert.Equal(t, "http:127.0.0.1", state.ResumedBy)
+}
+
+func TestCircuitBreaker_Check(t *testing.T) {
+	minted := func(hash string, value int64) *model.Deposit {
+		return &model.Deposit{BtcTxHash: hash, B2TxHash: "0x" + hash, BtcValue: model.NewBigIntFromInt64(value), B2TxStatus: model.DepositB2TxStatusWaitMined}
+	}
+
+	t.Run("mint volume", func(t *testing.T) {
+		cb, db := newTestCircuitBreaker(t, config.CircuitBreakerConfig{Enable: true, MintWindow: 3600, MintThreshold: 100}, nil)
+		clk := clock.NewFake(time.Unix(1700000000, 0))
+		cb.SetClock(clk)
+		deposits := NewBridgeDepositService(nil, nil, nil, nil, nil, cb.store, logger.NewNopLogger(), config.BridgeConfig{})
+		deposits.SetClock(clk)
+
+		// minted before the window, touched again in it
+		old := minted("a", 500)
+		require.NoError(t, db.Create(old).Error)
+		require.NoError(t, db.Model(old).Updates(map[string]interface{}{
+			model.Deposit{}.Column().B2TxStatus: model.DepositB2TxStatusSuccess,
+			model.Deposit{}.Column().MintedAt:   clk.Now().Add(-2 * time.Hour),
+		}).Error)
+		require.NoError(t, deposits.updateDeposit(old, map[string]interface{}{model.Deposit{}.Column().B2TxCheck: model.B2CheckStatusSuccess}, nil))
+		require.NoError(t, cb.Check())
+		require.NoError(t, cb.Allow())
+
+		fresh := minted("b", 150)
+		require.NoError(t, db.Create(fresh).Error)
+		require.NoError(t, deposits.updateDeposit(fresh, map[string]interface{}{model.Deposit{}.Column().B2TxStatus: model.DepositB2TxStatusSuccess}, nil))
+		require.NoError(t, cb.Check())
+		require.ErrorIs(t, cb.Allow(), ErrCircuitBreakerOpen)
+		state, err := cb.State()
+		require.NoError(t, err)
+		assert.Equal(t, BreakerTriggerMintVolume, state.TriggeredBy)
+	})
+
+	for name, check := range map[string]int{
+		BreakerTriggerCheckFailed: model.B2CheckStatusFailed,
+		BreakerTriggerDoubleMint:  model.B2CheckStatusDoubleMint,
+	} {
+		t.Run(name, func(t *testing.T) {
+			cb, db := newTestCircuitBreaker(t, config.CircuitBreakerConfig{Enable: true}, nil)
+			clk := clock.NewFake(time.Unix(1700000000, 0))
+			cb.SetClock(clk)
+			flagged := func(hash string) *model.Deposit {
+				deposit := minted(hash, 100)
+				deposit.B2TxCheck = check
+				flaggedAt := clk.Advance(time.Minute)
+				deposit.FlaggedAt = &flaggedAt
+				require.NoError(t, db.Create(deposit).Error)
+				return deposit
+			}
+			deposit := flagged("a")
+			require.NoError(t, cb.Check())
+			state, err := cb.State()
+			require.NoError(t, err)
+			assert.True(t, state.Paused)
+			assert.Equal(t, name, state.TriggeredBy)
+			assert.Contains(t, state.Reason, "a")
+
+			// the flagged deposit does not trip the breaker again once resumed, even when updated later
+			clk.Advance(time.Minute)
+			require.NoError(t, cb.Resume("cli"))
+			require.NoError(t, db.Model(deposit).Update(model.Deposit{}.Column().BtcFrom, "abel1").Error)
+			require.NoError(t, cb.Check())
+			require.NoError(t, cb.Allow())
+
+			flagged("b")
+			require.NoError(t, cb.Check())
+			state, err = cb.State()
+			require.NoError(t, err)
+			assert.True(t, state.Paused)
+			assert.Contains(t, state.Reason, "b")
+		})
+	}
+
+	t.Run(BreakerTriggerSupply, func(t *testing.T) {
+		chain := &fakeBridge{supply: mintAmount(100)}
+		cb, db := newTestCircuitBreaker(t, config.CircuitBreakerConfig{Enable: true, TokenAddress: "0x01"}, chain)
+		deposit := minted("a", 100)
+		require.NoError(t, db.Create(deposit).Error)
+		// success is the zero status, the column default applies on create
+		require.NoError(t, db.Model(deposit).Update(model.Deposit{}.Column().B2TxStatus, model.DepositB2TxStatusSuccess).Error)
+		require.NoError(t, cb.Check())
+		require.NoError(t, cb.Allow())
+
+		chain.supply = new(big.Int).Add(mintAmount(100), big.NewInt(1))
+		require.NoError(t, cb.Check())
+		state, err := cb.State()
+		require.NoError(t, err)
+		assert.True(t, state.Paused)
+		assert.Equal(t, BreakerTriggerSupply, state.TriggeredBy)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		cb, db := newTestCircuitBreaker(t, config.CircuitBreakerConfig{MintThreshold: 1}, nil)
+		deposit := minted("a", 100)
+		deposit.B2TxCheck = model.B2CheckStatusFailed
+		require.NoError(t, db.Create(deposit).Error)
+		require.NoError(t, cb.Check())
+		require.NoError(t, cb.Allow())
+	})
+}
@@ -0,0 +1,116 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/qday-io/qday-abel-bridge-indexer/config"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
+)
+
+const (
+	// DepositPolicyWindow rolling window of the daily caps
+	DepositPolicyWindow = 24 * time.Hour
+	// FeeBasisPointsDenominator 1 bps = 0.01%
+	FeeBasisPointsDenominator = 10000
+)
+
+var ErrDepositPolicyConfig = errors.New("deposit policy config err")
+
+// DepositPolicy evaluates deposit amount limits, rolling daily caps and bridge fee
+type DepositPolicy struct {
+	cfg config.DepositPolicyConfig
+}
+
+// DepositPolicyResult policy evaluation result
+type DepositPolicyResult struct {
+	Allowed bool
+	// Reason why the deposit is held, empty when allowed
+	Reason string
+	// Fee deducted from the deposit and credited to the treasury
+	Fee *big.Int
+}
+
+// NewDepositPolicy new deposit policy
+func NewDepositPolicy(cfg config.DepositPolicyConfig) (*DepositPolicy, error) {
+	if cfg.FeeBasisPoints < 0 || cfg.FeeBasisPoints >= FeeBasisPointsDenominator {
+		return nil, fmt.Errorf("%w: fee bps %d out of range", ErrDepositPolicyConfig, cfg.FeeBasisPoints)
+	}
+	if cfg.FeeBasisPoints > 0 && cfg.TreasuryAddress == "" {
+		return nil, fmt.Errorf("%w: fee bps set without treasury address", ErrDepositPolicyConfig)
+	}
+	if cfg.MaxAmount > 0 && cfg.MinAmount > cfg.MaxAmount {
+		return nil, fmt.Errorf("%w: min amount %d greater than max amount %d", ErrDepositPolicyConfig, cfg.MinAmount, cfg.MaxAmount)
+	}
+	return &DepositPolicy{cfg: cfg}, nil
+}
+
+func (p *DepositPolicy) Enabled() bool {
+	return p.cfg.Enable
+}
+
+func (p *DepositPolicy) TreasuryAddress() string {
+	return p.cfg.TreasuryAddress
+}
+
+// Fee bridge fee of amount, rounds down
+func (p *DepositPolicy) Fee(amount *big.Int) *big.Int {
+	if p.cfg.FeeBasisPoints == 0 || amount.Sign() <= 0 {
+		return new(big.Int)
+	}
+	fee := new(big.Int).Mul(amount, big.NewInt(p.cfg.FeeBasisPoints))
+	return fee.Quo(fee, big.NewInt(FeeBasisPointsDenominator))
+}
+
+// Evaluate check amount against the limits
+// addressVolume and globalVolume are the approved amounts in the current window,
+// excluding the deposit being evaluated
+func (p *DepositPolicy) Evaluate(amount *big.Int, addressVolume *big.Int, globalVolume *big.Int) DepositPolicyResult {
+	exceeds := func(v *big.Int, limit int64) bool {
+		return limit > 0 && v.Cmp(big.NewInt(limit)) > 0
+	}
+	switch {
+	case amount.Sign() <= 0:
+		return DepositPolicyResult{Reason: fmt.Sprintf("invalid amount %s", amount)}
+	case p.cfg.MinAmount > 0 && amount.Cmp(big.NewInt(p.cfg.MinAmount)) < 0:
+		return DepositPolicyResult{Reason: fmt.Sprintf("amount %s below minimum %d", amount, p.cfg.MinAmount)}
+	case exceeds(amount, p.cfg.MaxAmount):
+		return DepositPolicyResult{Reason: fmt.Sprintf("amount %s above maximum %d", amount, p.cfg.MaxAmount)}
+	case exceeds(new(big.Int).Add(addressVolume, amount), p.cfg.AddressDailyCap):
+		return DepositPolicyResult{Reason: fmt.Sprintf("address daily cap %d exceeded, volume %s amount %s",
+			p.cfg.AddressDailyCap, addressVolume, amount)}
+	case exceeds(new(big.Int).Add(globalVolume, amount), p.cfg.GlobalDailyCap):
+		return DepositPolicyResult{Reason: fmt.Sprintf("global daily cap %d exceeded, volume %s amount %s",
+			p.cfg.GlobalDailyCap, globalVolume, amount)}
+	}
+	return DepositPolicyResult{Allowed: true, Fee: p.Fee(amount)}
+}
+
+// ReleaseHeldDeposit operator approve a held deposit, the deposit will be minted in the next round
+// and counts in the policy volume from the release time
+func ReleaseHeldDeposit(store storage.Store, policy *DepositPolicy, btcTxHash string, releasedAt time.Time) error {
+	return store.Transaction(func(tx storage.Store) error {
+		deposit, err := tx.Deposits().GetByBtcTxHash(btcTxHash)
+		if err != nil {
+			return err
+		}
+		if deposit.PolicyStatus != model.DepositPolicyStatusHeld {
+			return fmt.Errorf("deposit %s is not held, policy status: %d", btcTxHash, deposit.PolicyStatus)
+		}
+		err = tx.Deposits().Update(deposit.ID, map[string]interface{}{
+			model.Deposit{}.Column().PolicyStatus: model.DepositPolicyStatusApproved,
+			model.Deposit{}.Column().B2TxStatus:   model.DepositB2TxStatusPending,
+			model.Deposit{}.Column().BtcFee:       model.NewBigInt(policy.Fee(deposit.BtcValue.Big())),
+			model.Deposit{}.Column().ApprovedAt:   releasedAt,
+		})
+		if err != nil {
+			return err
+		}
+		fromStatus := deposit.B2TxStatus
+		deposit.B2TxStatus = model.DepositB2TxStatusPending
+		return tx.DepositEvents().Append(model.NewDepositEvent(deposit, model.DepositEventStatus, fromStatus))
+	})
+}
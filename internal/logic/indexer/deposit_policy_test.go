@@ -0,0 +1,246 @@
+package indexer
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/qday-io/qday-abel-bridge-indexer/config"
+	_interface "github.com/qday-io/qday-abel-bridge-indexer/internal/interface"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/clock"
+	logger "github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	gormlog "gorm.io/gorm/logger"
+)
+
+var testPolicyConfig = config.DepositPolicyConfig{
+	Enable:          true,
+	MinAmount:       100,
+	MaxAmount:       10000,
+	AddressDailyCap: 15000,
+	GlobalDailyCap:  50000,
+	FeeBasisPoints:  30,
+	TreasuryAddress: "0x176F283DcD00b75334f643a8a8C72E42EBF96755",
+}
+
+func newTestDepositService(t *testing.T, chain *fakeBridge) (*BridgeDepositService, *DepositPolicy, *gorm.DB) {
+	t.Helper()
+	db, err := storage.Open(storage.Options{Source: storage.SQLiteScheme + ":memory:", LogLevel: gormlog.Silent})
+	require.NoError(t, err)
+	store, err := storage.New(db)
+	require.NoError(t, err)
+	require.NoError(t, store.Migrate(&model.Deposit{}, &model.DepositEvent{}, &model.RollupDeposit{}))
+	policy, err := NewDepositPolicy(testPolicyConfig)
+	require.NoError(t, err)
+	var bridge _interface.BitcoinBridge
+	if chain != nil {
+		bridge = chain
+	}
+	bis := NewBridgeDepositService(bridge, nil, nil, policy, nil, store, logger.NewNopLogger(), config.BridgeConfig{})
+	bis.SetClock(clock.NewFake(time.Now()))
+	return bis, policy, db
+}
+
+// createDeposit insert the deposit, the zero statuses replaced by the column defaults are set after
+func createDeposit(t *testing.T, db *gorm.DB, deposit *model.Deposit) *model.Deposit {
+	t.Helper()
+	statuses := map[string]interface{}{
+		model.Deposit{}.Column().B2TxStatus:   deposit.B2TxStatus,
+		model.Deposit{}.Column().PolicyStatus: deposit.PolicyStatus,
+	}
+	require.NoError(t, db.Create(deposit).Error)
+	require.NoError(t, db.Model(deposit).Updates(statuses).Error)
+	return deposit
+}
+
+func loadDeposit(t *testing.T, db *gorm.DB, btcTxHash string) *model.Deposit {
+	t.Helper()
+	var deposit model.Deposit
+	require.NoError(t, db.Where("btc_tx_hash = ?", btcTxHash).First(&deposit).Error)
+	return &deposit
+}
+
+func TestDepositPolicy_Evaluate(t *testing.T) {
+	policy, err := NewDepositPolicy(testPolicyConfig)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name          string
+		amount        int64
+		addressVolume int64
+		globalVolume  int64
+		allowed       bool
+		fee           int64
+	}{
+		{name: "allowed", amount: 10000, allowed: true, fee: 30},
+		{name: "fee rounds down", amount: 500, allowed: true, fee: 1},
+		{name: "below minimum", amount: 99},
+		{name: "above maximum", amount: 10001},
+		{name: "address cap", amount: 6000, addressVolume: 9001},
+		{name: "address cap reached exactly", amount: 6000, addressVolume: 9000, allowed: true, fee: 18},
+		{name: "global cap", amount: 1000, globalVolume: 49001},
+		{name: "zero amount", amount: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := policy.Evaluate(big.NewInt(tc.amount), big.NewInt(tc.addressVolume), big.NewInt(tc.globalVolume))
+			assert.Equal(t, tc.allowed, result.Allowed, result.Reason)
+			if !tc.allowed {
+				assert.NotEmpty(t, result.Reason)
+				return
+			}
+			assert.Equal(t, tc.fee, result.Fee.Int64())
+		})
+	}
+}
+
+func TestNewDepositPolicy_InvalidConfig(t *testing.T) {
+	_, err := NewDepositPolicy(config.DepositPolicyConfig{FeeBasisPoints: 10})
+	require.ErrorIs(t, err, ErrDepositPolicyConfig)
+
+	_, err = NewDepositPolicy(config.DepositPolicyConfig{MinAmount: 10, MaxAmount: 1})
+	require.ErrorIs(t, err, ErrDepositPolicyConfig)
+
+	_, err = NewDepositPolicy(config.DepositPolicyConfig{FeeBasisPoints: FeeBasisPointsDenominator, TreasuryAddress: "0x1"})
+	require.ErrorIs(t, err, ErrDepositPolicyConfig)
+}
+
+func TestBridgeDepositService_ApplyDepositPolicy(t *testing.T) {
+	bis, _, db := newTestDepositService(t, nil)
+	now := bis.clock.Now()
+	approvedAt, expiredAt := now.Add(-time.Hour), now.Add(-DepositPolicyWindow-time.Hour)
+	// seen before the window, approved in it
+	approved := createDeposit(t, db, &model.Deposit{BtcTxHash: "approved", BtcFrom: "abe1", BtcValue: model.NewBigIntFromInt64(9000),
+		PolicyStatus: model.DepositPolicyStatusApproved, ApprovedAt: &approvedAt})
+	require.NoError(t, db.Model(approved).Update("created_at", expiredAt).Error)
+	// approved before the window
+	createDeposit(t, db, &model.Deposit{BtcTxHash: "expired", BtcFrom: "abe2", BtcValue: model.NewBigIntFromInt64(9001),
+		PolicyStatus: model.DepositPolicyStatusApproved, ApprovedAt: &expiredAt})
+
+	// over the address cap with the approved volume of the address
+	held := createDeposit(t, db, &model.Deposit{BtcTxHash: "held", BtcFrom: "abe1", BtcValue: model.NewBigIntFromInt64(6001), B2TxStatus: model.DepositB2TxStatusPending})
+	allowed, err := bis.ApplyDepositPolicy(held)
+	require.NoError(t, err)
+	assert.False(t, allowed)
+	stored := loadDeposit(t, db, "held")
+	assert.Equal(t, model.DepositPolicyStatusHeld, stored.PolicyStatus)
+	assert.Equal(t, model.DepositB2TxStatusHeld, stored.B2TxStatus)
+	assert.NotEmpty(t, stored.HoldReason)
+	var events []model.DepositEvent
+	require.NoError(t, db.Where("btc_tx_hash = ?", "held").Find(&events).Error)
+	require.Len(t, events, 1)
+	assert.Equal(t, stored.HoldReason, events[0].Error)
+
+	// a held deposit is not evaluated again
+	allowed, err = bis.ApplyDepositPolicy(stored)
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	// another address is under its cap, the fee is recorded
+	other := createDeposit(t, db, &model.Deposit{BtcTxHash: "other", BtcFrom: "abe2", BtcValue: model.NewBigIntFromInt64(6000), B2TxStatus: model.DepositB2TxStatusPending})
+	allowed, err = bis.ApplyDepositPolicy(other)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	stored = loadDeposit(t, db, "other")
+	assert.Equal(t, model.DepositPolicyStatusApproved, stored.PolicyStatus)
+	assert.Equal(t, model.DepositB2TxStatusPending, stored.B2TxStatus)
+	assert.Equal(t, int64(18), stored.BtcFee.Int64())
+	require.NotNil(t, stored.ApprovedAt)
+	assert.True(t, now.Equal(*stored.ApprovedAt))
+}
+
+func TestReleaseHeldDeposit(t *testing.T) {
+	bis, policy, db := newTestDepositService(t, nil)
+	createDeposit(t, db, &model.Deposit{BtcTxHash: "held", BtcValue: model.NewBigIntFromInt64(20000), B2TxStatus: model.DepositB2TxStatusHeld, PolicyStatus: model.DepositPolicyStatusHeld})
+
+	releasedAt := bis.clock.Now()
+	require.NoError(t, ReleaseHeldDeposit(bis.store, policy, "held", releasedAt))
+	stored := loadDeposit(t, db, "held")
+	assert.Equal(t, model.DepositPolicyStatusApproved, stored.PolicyStatus)
+	assert.Equal(t, model.DepositB2TxStatusPending, stored.B2TxStatus)
+	require.NotNil(t, stored.ApprovedAt)
+	assert.True(t, releasedAt.Equal(*stored.ApprovedAt))
+	assert.Equal(t, int64(60), stored.BtcFee.Int64())
+	var events []model.DepositEvent
+	require.NoError(t, db.Where("btc_tx_hash = ?", "held").Find(&events).Error)
+	require.Len(t, events, 1)
+	assert.Equal(t, model.DepositB2TxStatusHeld, events[0].FromStatus)
+
+	// released once only
+	require.Error(t, ReleaseHeldDeposit(bis.store, policy, "held", releasedAt))
+	require.ErrorIs(t, ReleaseHeldDeposit(bis.store, policy, "missing", releasedAt), storage.ErrNotFound)
+}
+
+func TestBridgeDepositService_HandleFeeDeposit(t *testing.T) {
+	chain := &fakeBridge{receipts: map[string]*types.Receipt{}}
+	bis, _, db := newTestDepositService(t, chain)
+	createDeposit(t, db, &model.Deposit{BtcTxHash: "a", BtcValue: model.NewBigIntFromInt64(10000), BtcFee: model.NewBigIntFromInt64(30),
+		B2TxStatus: model.DepositB2TxStatusSuccess, PolicyStatus: model.DepositPolicyStatusApproved, FeeTxStatus: model.DepositB2TxStatusPending})
+	receipt := func(status uint64) *types.Receipt {
+		return &types.Receipt{Status: status, BlockNumber: big.NewInt(1)}
+	}
+
+	// a fee tx that can not be recorded is not sent
+	require.NoError(t, db.Migrator().DropTable(&model.DepositEvent{}))
+	require.NoError(t, bis.HandleFeeDeposit())
+	assert.Empty(t, chain.sent)
+	assert.Equal(t, model.DepositB2TxStatusPending, loadDeposit(t, db, "a").FeeTxStatus)
+	require.NoError(t, db.AutoMigrate(&model.DepositEvent{}))
+
+	// recorded before it is sent, a failed send is sent again while the nonce is free
+	chain.sendErr = errors.New("connection refused")
+	require.NoError(t, bis.HandleFeeDeposit())
+	first := loadDeposit(t, db, "a")
+	assert.Equal(t, model.DepositB2TxStatusWaitMined, first.FeeTxStatus)
+	assert.NotEmpty(t, first.FeeTxHash)
+	assert.NotEmpty(t, first.FeeTxRaw)
+	chain.sendErr = nil
+	require.NoError(t, bis.HandleFeeDeposit())
+	require.Len(t, chain.sent, 1)
+	assert.Equal(t, first.FeeTxHash, chain.sent[0].Hash().String())
+
+	// the nonce taken by another tx, the fee tx is dropped and signed again
+	chain.confirmed = first.FeeTxNonce + 1
+	require.NoError(t, bis.HandleFeeDeposit())
+	stored := loadDeposit(t, db, "a")
+	assert.Equal(t, model.DepositB2TxStatusPending, stored.FeeTxStatus)
+	assert.Equal(t, 1, stored.FeeTxRetry)
+	require.NoError(t, bis.HandleFeeDeposit())
+	second := loadDeposit(t, db, "a")
+	assert.NotEqual(t, first.FeeTxHash, second.FeeTxHash)
+	assert.Equal(t, chain.confirmed, second.FeeTxNonce)
+
+	// reverted, signed again
+	chain.receipts[second.FeeTxHash] = receipt(0)
+	require.NoError(t, bis.HandleFeeDeposit())
+	stored = loadDeposit(t, db, "a")
+	assert.Equal(t, model.DepositB2TxStatusPending, stored.FeeTxStatus)
+	assert.Equal(t, 2, stored.FeeTxRetry)
+
+	// mined
+	require.NoError(t, bis.HandleFeeDeposit())
+	third := loadDeposit(t, db, "a")
+	chain.receipts[third.FeeTxHash] = receipt(1)
+	require.NoError(t, bis.HandleFeeDeposit())
+	assert.Equal(t, model.DepositB2TxStatusSuccess, loadDeposit(t, db, "a").FeeTxStatus)
+
+	// the retries exhausted, the fee tx is failed
+	createDeposit(t, db, &model.Deposit{BtcTxHash: "b", BtcValue: model.NewBigIntFromInt64(10000), BtcFee: model.NewBigIntFromInt64(30),
+		B2TxStatus: model.DepositB2TxStatusSuccess, PolicyStatus: model.DepositPolicyStatusApproved, FeeTxStatus: model.DepositB2TxStatusPending, FeeTxRetry: DepositRetry})
+	require.NoError(t, bis.HandleFeeDeposit())
+	chain.receipts[loadDeposit(t, db, "b").FeeTxHash] = receipt(0)
+	require.NoError(t, bis.HandleFeeDeposit())
+	stored = loadDeposit(t, db, "b")
+	assert.Equal(t, model.DepositB2TxStatusWaitMinedStatusFailed, stored.FeeTxStatus)
+	var events []model.DepositEvent
+	require.NoError(t, db.Where("btc_tx_hash = ? AND event = ?", "b", model.DepositEventFeeReceipt).Find(&events).Error)
+	require.Len(t, events, 1)
+	assert.Equal(t, "fee tx reverted", events[0].Error)
+}
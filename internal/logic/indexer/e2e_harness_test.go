@@ -0,0 +1,511 @@
+//go:build e2e
+
+package indexer
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/eth/ethconfig"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/qday-io/qday-abel-bridge-indexer/config"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/logic/rollup"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
+	logger "github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+	gormlog "gorm.io/gorm/logger"
+)
+
+// end-to-end harness, runs IndexerService -> BridgeDepositService -> rollup listener -> checkDeposits
+// against a fake abec node, a fake aa api and a simulated evm chain, each step is driven by the test
+
+const (
+	e2eNetwork       = "abe-test"
+	e2eVault         = "abe1vault"
+	e2eConfirmations = 2
+)
+
+var (
+	mintWAbelTopic = crypto.Keccak256Hash([]byte("MintWAbel(address,uint256,uint256)"))
+	// bridgeDepositTopic deposit event decoded by the rollup listener,
+	// caller and to indexed, amount and the btc tx hash in data
+	bridgeDepositTopic = crypto.Keccak256Hash([]byte("Deposit(address,address,uint256,bytes32)"))
+)
+
+// fakeAbec abec json-rpc serving scripted blocks, the tip is the last block
+type fakeAbec struct {
+	*httptest.Server
+	mu     sync.Mutex
+	blocks []*AbecBlock
+}
+
+func newFakeAbec(t *testing.T) *fakeAbec {
+	t.Helper()
+	abec := &fakeAbec{}
+	abec.blocks = []*AbecBlock{abec.newBlock(0, nil)}
+	abec.Server = httptest.NewServer(http.HandlerFunc(abec.serve))
+	t.Cleanup(abec.Close)
+	return abec
+}
+
+func (f *fakeAbec) newBlock(height int64, txs []*AbecTx) *AbecBlock {
+	block := &AbecBlock{
+		Height:    height,
+		Time:      1700000000 + height*256,
+		BlockHash: fmt.Sprintf("%064x", height),
+		RawTxs:    txs,
+	}
+	for _, tx := range txs {
+		tx.BlockHash = block.BlockHash
+		block.TxHashes = append(block.TxHashes, tx.TxID)
+	}
+	return block
+}
+
+// mine append a block with the txs, returns its height
+func (f *fakeAbec) mine(txs ...*AbecTx) int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	block := f.newBlock(int64(len(f.blocks)), txs)
+	f.blocks = append(f.blocks, block)
+	return block.Height
+}
+
+func (f *fakeAbec) tip() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return int64(len(f.blocks) - 1)
+}
+
+func (f *fakeAbec) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	params := gjson.GetBytes(body, "params").Array()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var result interface{}
+	switch method := gjson.GetBytes(body, "method").String(); method {
+	case "getblockcount":
+		result = len(f.blocks) - 1
+	case "getblockhash":
+		height := params[0].Int()
+		if height < 0 || height >= int64(len(f.blocks)) {
+			writeAbecError(w, "block number out of range")
+			return
+		}
+		result = f.blocks[height].BlockHash
+	case "getblockabe":
+		for _, block := range f.blocks {
+			if block.BlockHash == params[0].String() {
+				result = block
+			}
+		}
+	case "getrawtransaction":
+		for _, block := range f.blocks {
+			for _, tx := range block.RawTxs {
+				if tx.TxID == params[0].String() {
+					verbose := *tx
+					verbose.Confirmations = uint64(int64(len(f.blocks)) - block.Height)
+					result = verbose
+				}
+			}
+		}
+	case "getrawmempool":
+		result = []string{}
+	default:
+		writeAbecError(w, "unknown method "+method)
+		return
+	}
+	if result == nil {
+		writeAbecError(w, "not found")
+		return
+	}
+	resp, _ := json.Marshal(map[string]interface{}{"result": result, "error": nil})
+	_, _ = w.Write(resp)
+}
+
+func writeAbecError(w http.ResponseWriter, message string) {
+	resp, _ := json.Marshal(map[string]interface{}{"result": nil, "error": map[string]string{"message": message}})
+	_, _ = w.Write(resp)
+}
+
+// depositTx abec tx with a deposit memo to the vault
+func depositTx(txID string, from string, value int64) *AbecTx {
+	memo := fmt.Sprintf(`{"action":"deposit","protocol":"%s","from":"%s","to":"%s","value":"%d"}`,
+		config.DefaultListenProtocol, from, e2eVault, value)
+	return &AbecTx{
+		TxID:   txID,
+		TxHash: txID,
+		Memo:   hex.EncodeToString(append([]byte("00000000"), []byte(memo)...)),
+	}
+}
+
+// fakeAA aa api binding deposit tx hashes to the l2 aa address
+type fakeAA struct {
+	*httptest.Server
+	mu       sync.Mutex
+	bindings map[string][2]string // tx hash -> from address, aa address
+}
+
+func newFakeAA(t *testing.T) *fakeAA {
+	t.Helper()
+	api := &fakeAA{bindings: make(map[string][2]string)}
+	api.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.mu.Lock()
+		binding, ok := api.bindings[r.URL.Query().Get("hash")]
+		api.mu.Unlock()
+		if !ok {
+			_, _ = w.Write([]byte(`{"code":1001,"message":"address not found","data":{}}`))
+			return
+		}
+		resp, _ := json.Marshal(map[string]interface{}{
+			"code":    0,
+			"message": "success",
+			"data": map[string]string{
+				"from_network": e2eNetwork,
+				"from_address": binding[0],
+				"to_address":   binding[1],
+			},
+		})
+		_, _ = w.Write(resp)
+	}))
+	t.Cleanup(api.Close)
+	return api
+}
+
+func (a *fakeAA) bind(txID string, from string, aaAddress common.Address) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.bindings[txID] = [2]string{from, aaAddress.Hex()}
+}
+
+// simChain simulated evm chain served over http, blocks are sealed while mining and txs are pending
+type simChain struct {
+	backend *simulated.Backend
+	client  *ethclient.Client
+	url     string
+	mu      sync.Mutex
+	mining  bool
+}
+
+func newSimChain(t *testing.T, alloc types.GenesisAlloc) *simChain {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := listener.Addr().(*net.TCPAddr).Port
+	require.NoError(t, listener.Close())
+
+	backend := simulated.NewBackend(alloc, func(nodeConf *node.Config, _ *ethconfig.Config) {
+		nodeConf.HTTPHost = "127.0.0.1"
+		nodeConf.HTTPPort = port
+		nodeConf.HTTPModules = []string{"eth", "net", "web3"}
+		nodeConf.HTTPVirtualHosts = []string{"*"}
+	})
+	// the tx index of a chain at genesis is reported as in progress, receipts lookups fail until a block is sealed
+	backend.Commit()
+	chain := &simChain{backend: backend, url: fmt.Sprintf("http://127.0.0.1:%d", port), mining: true}
+	chain.client, err = ethclient.Dial(chain.url)
+	require.NoError(t, err)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(50 * time.Millisecond):
+				chain.sealPending()
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		close(stop)
+		<-done
+		chain.client.Close()
+		_ = backend.Close()
+	})
+	return chain
+}
+
+func (c *simChain) sealPending() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.mining {
+		return
+	}
+	pending, err := c.backend.Client().PendingTransactionCount(context.Background())
+	if err == nil && pending > 0 {
+		c.backend.Commit()
+	}
+}
+
+func (c *simChain) setMining(mining bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mining = mining
+}
+
+// reorg rewind depth blocks and seal depth+1 empty replacing blocks,
+// the txs of the dropped blocks are broadcast again as the network would
+func (c *simChain) reorg(t *testing.T, depth uint64) {
+	t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ctx := context.Background()
+	head, err := c.client.BlockNumber(ctx)
+	require.NoError(t, err)
+	var dropped []*types.Transaction
+	for number := head - depth + 1; number <= head; number++ {
+		block, err := c.client.BlockByNumber(ctx, new(big.Int).SetUint64(number))
+		require.NoError(t, err)
+		dropped = append(dropped, block.Transactions()...)
+	}
+	parent, err := c.client.HeaderByNumber(ctx, new(big.Int).SetUint64(head-depth))
+	require.NoError(t, err)
+	require.NoError(t, c.backend.Fork(parent.Hash()))
+	for i := uint64(0); i <= depth; i++ {
+		c.backend.Commit()
+	}
+	for _, tx := range dropped {
+		require.NoError(t, c.client.SendTransaction(ctx, tx))
+	}
+}
+
+// send a legacy tx and wait until it is mined
+func (c *simChain) send(t *testing.T, key *ecdsaKey, to common.Address, data []byte) *types.Receipt {
+	t.Helper()
+	ctx := context.Background()
+	nonce, err := c.client.PendingNonceAt(ctx, key.address)
+	require.NoError(t, err)
+	gasPrice, err := c.client.SuggestGasPrice(ctx)
+	require.NoError(t, err)
+	tx, err := types.SignTx(types.NewTx(&types.LegacyTx{
+		Nonce:    nonce,
+		To:       &to,
+		Gas:      100000,
+		GasPrice: gasPrice,
+		Data:     data,
+	}), types.NewEIP155Signer(big.NewInt(1337)), key.priv)
+	require.NoError(t, err)
+	require.NoError(t, c.client.SendTransaction(ctx, tx))
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	receipt, err := bind.WaitMined(waitCtx, c.client, tx)
+	require.NoError(t, err)
+	return receipt
+}
+
+// mintLogs MintWAbel logs of the contract on the canonical chain
+func (c *simChain) mintLogs(t *testing.T, contract common.Address) []types.Log {
+	t.Helper()
+	logs, err := c.client.FilterLogs(context.Background(), ethereum.FilterQuery{
+		FromBlock: big.NewInt(0),
+		Addresses: []common.Address{contract},
+		Topics:    [][]common.Hash{{mintWAbelTopic}},
+	})
+	require.NoError(t, err)
+	return logs
+}
+
+type ecdsaKey struct {
+	priv    *ecdsa.PrivateKey
+	address common.Address
+}
+
+func newKey(t *testing.T) *ecdsaKey {
+	t.Helper()
+	priv, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	return &ecdsaKey{priv: priv, address: crypto.PubkeyToAddress(priv.PublicKey)}
+}
+
+// wabelCode runtime of the WAbel stand-in.
+// mintWAbel(to, amount, lockDay) emits MintWAbel(to, amount, lockDay), any other call data
+// is emitted as log3 of the first three words with the rest as data, used to relay bridge deposit events.
+func wabelCode() []byte {
+	code := []byte{
+		// mintWAbel call data is the selector and three words
+		byte(vm.CALLDATASIZE), byte(vm.PUSH1), 0x64, byte(vm.EQ), byte(vm.PUSH1), 0x1e, byte(vm.JUMPI),
+		// log3(calldata[0x60:], calldata[0x00], calldata[0x20], calldata[0x40])
+		byte(vm.PUSH1), 0x40, byte(vm.CALLDATALOAD),
+		byte(vm.PUSH1), 0x20, byte(vm.CALLDATALOAD),
+		byte(vm.PUSH1), 0x00, byte(vm.CALLDATALOAD),
+		byte(vm.PUSH1), 0x60, byte(vm.CALLDATASIZE), byte(vm.SUB),
+		byte(vm.DUP1), byte(vm.PUSH1), 0x60, byte(vm.PUSH1), 0x00, byte(vm.CALLDATACOPY),
+		byte(vm.PUSH1), 0x00, byte(vm.LOG3), byte(vm.STOP),
+		// log2(amount ++ lockDay, MintWAbel, to)
+		byte(vm.JUMPDEST),
+		byte(vm.PUSH1), 0x40, byte(vm.PUSH1), 0x24, byte(vm.PUSH1), 0x00, byte(vm.CALLDATACOPY),
+		byte(vm.PUSH1), 0x04, byte(vm.CALLDATALOAD),
+		byte(vm.PUSH32),
+	}
+	code = append(code, mintWAbelTopic.Bytes()...)
+	return append(code, byte(vm.PUSH1), 0x40, byte(vm.PUSH1), 0x00, byte(vm.LOG2), byte(vm.STOP))
+}
+
+type e2eHarness struct {
+	abec     *fakeAbec
+	aa       *fakeAA
+	chain    *simChain
+	wabel    common.Address
+	bridge   *ecdsaKey
+	relayer  *ecdsaKey
+	cfg      *config.BitcoinConfig
+	txIdxr   *AbelianIndexer
+	minter   *Bridge
+	store    storage.Store
+	indexer  *IndexerService
+	deposits *BridgeDepositService
+	attempts *AttemptWatcher
+	rollup   *rollup.IndexerService
+	btcIndex *model.BtcIndex
+	relayed  map[string]struct{}
+}
+
+func newE2EHarness(t *testing.T) *e2eHarness {
+	t.Helper()
+	h := &e2eHarness{
+		abec:    newFakeAbec(t),
+		aa:      newFakeAA(t),
+		wabel:   common.HexToAddress("0x000000000000000000000000000000000000a0e1"),
+		bridge:  newKey(t),
+		relayer: newKey(t),
+		relayed: make(map[string]struct{}),
+	}
+	funds := new(big.Int).Mul(big.NewInt(1000), big.NewInt(1e18))
+	h.chain = newSimChain(t, types.GenesisAlloc{
+		h.wabel:           {Code: wabelCode(), Balance: new(big.Int)},
+		h.bridge.address:  {Balance: funds},
+		h.relayer.address: {Balance: funds},
+	})
+
+	cfg := &config.BitcoinConfig{
+		NetworkName:                      e2eNetwork,
+		RPCHost:                          h.abec.URL,
+		IndexerListenAddress:             e2eVault,
+		IndexerListenTargetConfirmations: e2eConfirmations,
+		Bridge: config.BridgeConfig{
+			EthRPCURL:       h.chain.url,
+			EthPrivKey:      hex.EncodeToString(crypto.FromECDSA(h.bridge.priv)),
+			ContractAddress: h.wabel.Hex(),
+			ABI:             config.DefaultDepositAbi,
+			AAB2PI:          h.aa.URL,
+			Deposit:         bridgeDepositTopic.Hex(),
+			SourceDecimals:  7,
+			TargetDecimals:  18,
+		},
+	}
+	routes, err := cfg.ListenRoutes()
+	require.NoError(t, err)
+	txIdxr, err := NewAbelianIndexer(logger.NewNopLogger(), cfg, routes, cfg.IndexerListenTargetConfirmations)
+	require.NoError(t, err)
+	bridge, err := NewBridge(cfg.Bridge, "", logger.NewNopLogger(), cfg.NetworkName)
+	require.NoError(t, err)
+	h.cfg, h.txIdxr, h.minter = cfg, txIdxr.(*AbelianIndexer), bridge
+
+	db, err := storage.Open(storage.Options{Source: storage.SQLiteScheme + ":memory:", LogLevel: gormlog.Silent})
+	require.NoError(t, err)
+	h.store, err = storage.New(db)
+	require.NoError(t, err)
+	h.indexer = NewIndexerService(txIdxr, nil, h.store, logger.NewNopLogger())
+	require.NoError(t, h.indexer.CheckDb())
+	require.NoError(t, h.store.Migrate(&model.RollupIndex{}))
+	h.btcIndex = &model.BtcIndex{Base: model.Base{ID: 1}}
+	require.NoError(t, h.store.Cursors().SaveBtcIndex(h.btcIndex))
+
+	h.deposits = NewBridgeDepositService(bridge, nil, txIdxr, nil, nil, h.store, logger.NewNopLogger(), cfg.Bridge)
+	h.deposits.waitMinedTimeout = 5 * time.Second
+	h.attempts = NewAttemptWatcher(time.Second, bridge, h.store, logger.NewNopLogger())
+
+	h.rollup = rollup.NewRollupService(h.chain.client, cfg, h.store, logger.NewNopLogger())
+	events, err := rollup.NewEventIndexer(cfg.Bridge, h.store)
+	require.NoError(t, err)
+	require.NoError(t, events.Migrate())
+	require.NoError(t, h.rollup.SetEventIndexer(events))
+	// the first scan starts the rollup index at the current block
+	require.NoError(t, h.rollup.ScanLogs())
+	return h
+}
+
+// deposit mine an abec deposit to the vault, bound to a new aa address, returns the btc tx id
+func (h *e2eHarness) deposit(t *testing.T, label string, value int64) (string, common.Address) {
+	t.Helper()
+	txID := hex.EncodeToString(crypto.Keccak256([]byte(label)))
+	from := "abe1" + label
+	aaAddress := newKey(t).address
+	h.aa.bind(txID, from, aaAddress)
+	h.abec.mine(depositTx(txID, from, value))
+	return txID, aaAddress
+}
+
+// indexBlocks index the abec blocks up to the tip
+func (h *e2eHarness) indexBlocks(t *testing.T) {
+	t.Helper()
+	for height := h.btcIndex.BtcIndexBlock + 1; height <= h.abec.tip(); height++ {
+		require.NoError(t, h.indexer.IndexBlock(h.btcIndex, height))
+	}
+}
+
+// relayMints emit the bridge deposit event of every minted deposit from its MintWAbel log
+func (h *e2eHarness) relayMints(t *testing.T) {
+	t.Helper()
+	minted, err := h.store.Deposits().ListByStatus([]int{model.DepositB2TxStatusSuccess}, 0)
+	require.NoError(t, err)
+	for _, deposit := range minted {
+		if _, ok := h.relayed[deposit.BtcTxHash]; ok {
+			continue
+		}
+		receipt, err := h.chain.client.TransactionReceipt(context.Background(), common.HexToHash(deposit.B2TxHash))
+		require.NoError(t, err)
+		for _, vlog := range receipt.Logs {
+			if vlog.Topics[0] != mintWAbelTopic {
+				continue
+			}
+			data := append([]byte{}, bridgeDepositTopic.Bytes()...)
+			data = append(data, common.LeftPadBytes(common.HexToAddress(deposit.B2TxFrom).Bytes(), 32)...)
+			data = append(data, vlog.Topics[1].Bytes()...)
+			data = append(data, vlog.Data[:32]...)
+			data = append(data, common.HexToHash(deposit.BtcTxHash).Bytes()...)
+			h.chain.send(t, h.relayer, h.wabel, data)
+		}
+		h.relayed[deposit.BtcTxHash] = struct{}{}
+	}
+}
+
+// settle relay the mints, scan them with the rollup listener and check the deposits
+func (h *e2eHarness) settle(t *testing.T) {
+	t.Helper()
+	h.relayMints(t)
+	require.NoError(t, h.rollup.ScanLogs())
+	h.deposits.checkDeposits()
+}
+
+func (h *e2eHarness) get(t *testing.T, txID string) *model.Deposit {
+	t.Helper()
+	deposit, err := h.store.Deposits().GetByBtcTxHash(txID)
+	require.NoError(t, err)
+	return deposit
+}
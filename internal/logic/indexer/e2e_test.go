@@ -0,0 +1,296 @@
+//go:build e2e
+
+package indexer
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/qday-io/qday-abel-bridge-indexer/config"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/clock"
+	logger "github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/rpcrecord"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gormlog "gorm.io/gorm/logger"
+)
+
+func TestE2E_Deposit(t *testing.T) {
+	h := newE2EHarness(t)
+
+	txID, aaAddress := h.deposit(t, "happy", 1500)
+	h.indexBlocks(t)
+	deposit := h.get(t, txID)
+	assert.Equal(t, model.DepositB2TxStatusPending, deposit.B2TxStatus)
+	assert.Equal(t, int64(2), deposit.EligibleAtHeight)
+
+	// one confirmation, not minted yet
+	require.NoError(t, h.deposits.depositRound())
+	assert.Equal(t, model.DepositB2TxStatusPending, h.get(t, txID).B2TxStatus)
+	assert.Empty(t, h.chain.mintLogs(t, h.wabel))
+
+	h.abec.mine()
+	h.indexBlocks(t)
+	require.NoError(t, h.deposits.depositRound())
+	deposit = h.get(t, txID)
+	require.Equal(t, model.DepositB2TxStatusSuccess, deposit.B2TxStatus)
+	assert.Equal(t, aaAddress.Hex(), deposit.BtcFromAAAddress)
+
+	logs := h.chain.mintLogs(t, h.wabel)
+	require.Len(t, logs, 1)
+	assert.Equal(t, aaAddress, common.BytesToAddress(logs[0].Topics[1].Bytes()))
+	// 7 source decimals to 18 token decimals
+	assert.Equal(t, "150000000000000", new(big.Int).SetBytes(logs[0].Data[:32]).String())
+
+	h.settle(t)
+	assert.Equal(t, model.B2CheckStatusSuccess, h.get(t, txID).B2TxCheck)
+	rollups, err := h.store.RollupDeposits().ListByBtcTxHash(txID)
+	require.NoError(t, err)
+	require.Len(t, rollups, 1)
+	assert.Equal(t, "1500", rollups[0].BtcValue.String())
+}
+
+// a mint reorged out of the rollup is mined again in another block, the deposit is minted once
+func TestE2E_RollupReorg(t *testing.T) {
+	h := newE2EHarness(t)
+
+	txID, _ := h.deposit(t, "reorg", 700)
+	h.abec.mine()
+	h.indexBlocks(t)
+	require.NoError(t, h.deposits.depositRound())
+	deposit := h.get(t, txID)
+	require.Equal(t, model.DepositB2TxStatusSuccess, deposit.B2TxStatus)
+
+	ctx := context.Background()
+	before, err := h.chain.client.TransactionReceipt(ctx, common.HexToHash(deposit.B2TxHash))
+	require.NoError(t, err)
+
+	h.chain.reorg(t, 1)
+	require.Eventually(t, func() bool {
+		receipt, err := h.chain.client.TransactionReceipt(ctx, common.HexToHash(deposit.B2TxHash))
+		return err == nil && receipt.BlockHash != before.BlockHash
+	}, 10*time.Second, 100*time.Millisecond)
+
+	require.NoError(t, h.deposits.depositRound())
+	h.settle(t)
+	after := h.get(t, txID)
+	assert.Equal(t, model.DepositB2TxStatusSuccess, after.B2TxStatus)
+	assert.Equal(t, deposit.B2TxHash, after.B2TxHash)
+	assert.Equal(t, model.B2CheckStatusSuccess, after.B2TxCheck)
+	assert.Len(t, h.chain.mintLogs(t, h.wabel), 1)
+}
+
+// a mint stuck in the rollup mempool is replaced with a higher gas price
+func TestE2E_StuckTx(t *testing.T) {
+	h := newE2EHarness(t)
+	h.deposits.waitMinedTimeout = 2 * time.Second
+
+	txID, _ := h.deposit(t, "stuck", 900)
+	h.abec.mine()
+	h.indexBlocks(t)
+
+	h.chain.setMining(false)
+	require.NoError(t, h.deposits.depositRound())
+	stuck := h.get(t, txID)
+	require.Equal(t, model.DepositB2TxStatusContextDeadlineExceeded, stuck.B2TxStatus)
+
+	// mining resumes while the replacement is waited for
+	time.AfterFunc(500*time.Millisecond, func() { h.chain.setMining(true) })
+	require.NoError(t, h.deposits.depositRound())
+	deposit := h.get(t, txID)
+	require.Equal(t, model.DepositB2TxStatusSuccess, deposit.B2TxStatus)
+	assert.NotEqual(t, stuck.B2TxHash, deposit.B2TxHash)
+	assert.Equal(t, stuck.B2TxNonce, deposit.B2TxNonce)
+
+	// both broadcast txs are kept in the audit trail
+	events, err := h.store.DepositEvents().ListByBtcTxHash(txID)
+	require.NoError(t, err)
+	var broadcasts []*model.DepositEvent
+	for _, event := range events {
+		if event.Event == model.DepositEventBroadcast {
+			broadcasts = append(broadcasts, event)
+		}
+	}
+	require.Len(t, broadcasts, 2)
+	assert.Equal(t, stuck.B2TxHash, broadcasts[0].B2TxHash)
+	assert.Equal(t, deposit.B2TxHash, broadcasts[1].B2TxHash)
+	assert.Equal(t, stuck.B2TxHash, broadcasts[1].ReplacedTxHash)
+	assert.Equal(t, 1, broadcasts[1].GasPrice.Cmp(&broadcasts[0].GasPrice.Int))
+	last := events[len(events)-1]
+	assert.Equal(t, model.DepositEventReceipt, last.Event)
+	assert.Equal(t, model.DepositB2TxStatusSuccess, last.ToStatus)
+	assert.Equal(t, uint64(1), last.ReceiptStatus)
+
+	replaced, _, err := h.deposits.bridge.TransactionByHash(deposit.B2TxHash)
+	require.NoError(t, err)
+	assert.Equal(t, stuck.B2TxNonce, replaced.Nonce())
+	_, _, err = h.chain.client.TransactionByHash(context.Background(), common.HexToHash(stuck.B2TxHash))
+	require.ErrorIs(t, err, ethereum.NotFound, "the stuck tx is replaced")
+	assert.Len(t, h.chain.mintLogs(t, h.wabel), 1)
+
+	h.settle(t)
+	assert.Equal(t, model.B2CheckStatusSuccess, h.get(t, txID).B2TxCheck)
+
+	// the replaced tx can no longer be mined, the deposit is not flagged
+	require.NoError(t, h.attempts.Watch())
+	attempts, err := h.store.B2TxAttempts().ListByBtcTxHash(txID)
+	require.NoError(t, err)
+	require.Len(t, attempts, 2)
+	assert.Equal(t, model.B2TxAttemptStatusDropped, attempts[0].Status)
+	assert.Equal(t, model.B2TxAttemptStatusSuccess, attempts[1].Status)
+	assert.Equal(t, model.B2CheckStatusSuccess, h.get(t, txID).B2TxCheck)
+}
+
+// a deposit whose b2 tx is lost is sent again and minted twice, the attempt watcher flags it
+func TestE2E_DoubleMint(t *testing.T) {
+	h := newE2EHarness(t)
+	breaker, err := NewCircuitBreaker(config.CircuitBreakerConfig{Enable: true, SupplyBaseline: "0", SupplyTolerance: "0"},
+		h.store, nil, nil, logger.NewNopLogger())
+	require.NoError(t, err)
+
+	txID, _ := h.deposit(t, "double", 400)
+	h.abec.mine()
+	h.indexBlocks(t)
+	require.NoError(t, h.deposits.depositRound())
+	first := h.get(t, txID)
+	require.Equal(t, model.DepositB2TxStatusSuccess, first.B2TxStatus)
+
+	// the first b2 tx is overwritten, the deposit looks dropped
+	lost := map[string]interface{}{
+		model.Deposit{}.Column().B2TxStatus: model.DepositB2TxStatusWaitMinedFailed,
+		model.Deposit{}.Column().B2TxHash:   common.HexToHash("0xdead").Hex(),
+		model.Deposit{}.Column().B2TxNonce:  0,
+	}
+	require.NoError(t, h.store.Deposits().Update(first.ID, lost))
+	require.NoError(t, h.deposits.depositRound())
+	second := h.get(t, txID)
+	require.Equal(t, model.DepositB2TxStatusSuccess, second.B2TxStatus)
+	require.Len(t, h.chain.mintLogs(t, h.wabel), 2)
+
+	require.NoError(t, h.attempts.Watch())
+	deposit := h.get(t, txID)
+	assert.Equal(t, model.B2CheckStatusDoubleMint, deposit.B2TxCheck)
+	assert.NotNil(t, deposit.FlaggedAt)
+	attempts, err := h.store.B2TxAttempts().ListByBtcTxHash(txID)
+	require.NoError(t, err)
+	require.Len(t, attempts, 2)
+	assert.Equal(t, first.B2TxHash, attempts[0].B2TxHash)
+	assert.Equal(t, second.B2TxHash, attempts[1].B2TxHash)
+	for _, attempt := range attempts {
+		assert.Equal(t, model.B2TxAttemptStatusSuccess, attempt.Status)
+	}
+	events, err := h.store.DepositEvents().ListByBtcTxHash(txID)
+	require.NoError(t, err)
+	last := events[len(events)-1]
+	assert.Equal(t, model.DepositEventCheck, last.Event)
+	assert.Equal(t, model.B2CheckStatusDoubleMint, last.B2TxCheck)
+	assert.Contains(t, last.Error, first.B2TxHash)
+
+	// a flagged deposit is never sent again and pauses minting
+	require.NoError(t, h.store.Deposits().Update(deposit.ID, lost))
+	require.NoError(t, h.deposits.depositRound())
+	assert.Len(t, h.chain.mintLogs(t, h.wabel), 2)
+	require.NoError(t, breaker.Check())
+	require.ErrorIs(t, breaker.Allow(), ErrCircuitBreakerOpen)
+	state, err := breaker.State()
+	require.NoError(t, err)
+	assert.Equal(t, BreakerTriggerDoubleMint, state.TriggeredBy)
+}
+
+// the nonce stored for a dropped mint is ahead of the account, the mint is resent with the account nonce
+func TestE2E_NonceGap(t *testing.T) {
+	h := newE2EHarness(t)
+
+	first, _ := h.deposit(t, "first", 100)
+	h.abec.mine()
+	h.indexBlocks(t)
+	require.NoError(t, h.deposits.depositRound())
+	require.Equal(t, model.DepositB2TxStatusSuccess, h.get(t, first).B2TxStatus)
+
+	gap, _ := h.deposit(t, "gap", 200)
+	h.abec.mine()
+	h.indexBlocks(t)
+	deposit := h.get(t, gap)
+	require.NoError(t, h.store.Deposits().Update(deposit.ID, map[string]interface{}{
+		model.Deposit{}.Column().B2TxStatus: model.DepositB2TxStatusWaitMinedFailed,
+		model.Deposit{}.Column().B2TxHash:   common.HexToHash("0xdead").Hex(),
+		model.Deposit{}.Column().B2TxNonce:  5,
+		model.Deposit{}.Column().B2TxFrom:   h.bridge.address.Hex(),
+	}))
+
+	require.ErrorIs(t, h.deposits.depositRound(), ErrOldNonceToHeight)
+	assert.Equal(t, model.DepositB2TxStatusNonceToLow, h.get(t, gap).B2TxStatus)
+
+	require.NoError(t, h.deposits.depositRound())
+	deposit = h.get(t, gap)
+	require.Equal(t, model.DepositB2TxStatusSuccess, deposit.B2TxStatus)
+	assert.Equal(t, uint64(1), deposit.B2TxNonce)
+	assert.Len(t, h.chain.mintLogs(t, h.wabel), 2)
+
+	h.settle(t)
+	assert.Equal(t, model.B2CheckStatusSuccess, h.get(t, first).B2TxCheck)
+	assert.Equal(t, model.B2CheckStatusSuccess, h.get(t, gap).B2TxCheck)
+}
+
+// the traffic of a deposit is recorded, replaying it without the nodes mints the same tx
+func TestE2E_Replay(t *testing.T) {
+	h := newE2EHarness(t)
+	var recording bytes.Buffer
+	recorder := rpcrecord.NewRecorder(&recording)
+	h.txIdxr.SetHTTPClient(recorder.Client(rpcrecord.SourceAbec, nil), nil)
+	h.minter.SetHTTPClient(recorder.Client(rpcrecord.SourceEVM, nil), recorder.Client(rpcrecord.SourceAA, nil))
+
+	txID, _ := h.deposit(t, "replay", 1200)
+	h.abec.mine()
+	h.indexBlocks(t)
+	require.NoError(t, h.deposits.depositRound())
+	recorded := h.get(t, txID)
+	require.Equal(t, model.DepositB2TxStatusSuccess, recorded.B2TxStatus)
+
+	replayer, err := rpcrecord.Load(&recording)
+	require.NoError(t, err)
+	cfg := *h.cfg
+	cfg.RPCHost = "http://abec.invalid"
+	cfg.Bridge.EthRPCURL = "http://rollup.invalid"
+	cfg.Bridge.AAB2PI = "http://aa.invalid"
+	routes, err := cfg.ListenRoutes()
+	require.NoError(t, err)
+	txIdxr, err := NewAbelianIndexer(logger.NewNopLogger(), &cfg, routes, cfg.IndexerListenTargetConfirmations)
+	require.NoError(t, err)
+	txIdxr.(*AbelianIndexer).SetHTTPClient(replayer.Client(rpcrecord.SourceAbec), nil)
+	bridge, err := NewBridge(cfg.Bridge, "", logger.NewNopLogger(), cfg.NetworkName)
+	require.NoError(t, err)
+	bridge.SetHTTPClient(replayer.Client(rpcrecord.SourceEVM), replayer.Client(rpcrecord.SourceAA))
+
+	db, err := storage.Open(storage.Options{Source: storage.SQLiteScheme + ":memory:", LogLevel: gormlog.Silent})
+	require.NoError(t, err)
+	store, err := storage.New(db)
+	require.NoError(t, err)
+	fake := clock.NewFake(time.Now())
+	indexerService := NewIndexerService(txIdxr, nil, store, logger.NewNopLogger())
+	indexerService.SetClock(fake)
+	require.NoError(t, indexerService.CheckDb())
+	btcIndex := &model.BtcIndex{Base: model.Base{ID: 1}}
+	require.NoError(t, store.Cursors().SaveBtcIndex(btcIndex))
+	for height := int64(1); height <= h.abec.tip(); height++ {
+		require.NoError(t, indexerService.IndexBlock(btcIndex, height))
+	}
+	deposits := NewBridgeDepositService(bridge, nil, txIdxr, nil, nil, store, logger.NewNopLogger(), cfg.Bridge)
+	deposits.SetClock(fake)
+	require.NoError(t, deposits.depositRound())
+
+	replayed, err := store.Deposits().GetByBtcTxHash(txID)
+	require.NoError(t, err)
+	assert.Equal(t, model.DepositB2TxStatusSuccess, replayed.B2TxStatus)
+	assert.Equal(t, recorded.B2TxHash, replayed.B2TxHash)
+	assert.Equal(t, recorded.B2TxNonce, replayed.B2TxNonce)
+	assert.Equal(t, recorded.BtcFromAAAddress, replayed.BtcFromAAAddress)
+	assert.Zero(t, replayer.Remaining())
+}
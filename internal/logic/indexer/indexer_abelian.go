@@ -16,35 +16,50 @@ import (
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/qday-io/qday-abel-bridge-indexer/config"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/amount"
 	"github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
-	"github.com/qday-io/qday-abel-bridge-indexer/pkg/utils"
 	"github.com/tidwall/gjson"
 )
 
 // AbelianIndexer bitcoin indexer, parse and forward data
 type AbelianIndexer struct {
 	//chainParams         *chaincfg.Params  // bitcoin network params, e.g. mainnet, testnet, etc.
-	listenAddress       string // need listened bitcoin address
+	routes              map[string]config.ListenRoute // listened address -> route
 	targetConfirmations uint64
 	bitcoinCfg          *config.BitcoinConfig
+	vault               *VaultWallet // nil when the received value is not verified
+	client              *http.Client // nil sends with http.DefaultClient
 	logger              log.Logger
 }
 
 // NewAbelianIndexer new bitcoin indexer
-func NewAbelianIndexer(log log.Logger, bitcoinCfg *config.BitcoinConfig, listenAddress string, targetConfirmations uint64) (_interface.TxIndexer, error) {
+func NewAbelianIndexer(log log.Logger, bitcoinCfg *config.BitcoinConfig, routes []config.ListenRoute, targetConfirmations uint64) (_interface.TxIndexer, error) {
 	// check listenAddress
 	//address, err := btcutil.DecodeAddress(listenAddress, chainParams)
 	//if err != nil {
 	//	return nil, fmt.Errorf("%w:%s", ErrDecodeListenAddress, err.Error())
 	//}
+	routeMap := make(map[string]config.ListenRoute, len(routes))
+	for _, route := range routes {
+		routeMap[config.NormalizeListenAddress(route.Address)] = route
+	}
 	return &AbelianIndexer{
 		logger:              log,
-		listenAddress:       listenAddress,
+		routes:              routeMap,
 		bitcoinCfg:          bitcoinCfg,
+		vault:               NewVaultWallet(bitcoinCfg.VaultWallet),
 		targetConfirmations: targetConfirmations,
 	}, nil
 }
 
+// SetHTTPClient send the abec and the vault wallet rpc with the clients, e.g. to record the traffic
+func (b *AbelianIndexer) SetHTTPClient(abec *http.Client, vault *http.Client) {
+	b.client = abec
+	if b.vault != nil && vault != nil {
+		b.vault.client = vault
+	}
+}
+
 func (b *AbelianIndexer) newRequest(id string, method string, params []interface{}) (*http.Request, error) {
 	jsonReq := &AbecJSONRPCRequest{
 		JSONRPC: "1.0",
@@ -91,7 +106,10 @@ func (b *AbelianIndexer) getResponseFromChan(method string, params []interface{}
 		return nil, err
 	}
 
-	client := http.DefaultClient
+	client := b.client
+	if client == nil {
+		client = http.DefaultClient
+	}
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
@@ -123,7 +141,7 @@ func (b *AbelianIndexer) Stop() {
 
 // ParseBlock parse block data by block height
 // NOTE: Currently, only transfer transactions are supported.
-func (b *AbelianIndexer) ParseBlock(height int64, txIndex int64) ([]*model.BitcoinTxParseResult, *model.BlockInfo, error) {
+func (b *AbelianIndexer) ParseBlock(height int64) ([]*model.BitcoinTxParseResult, *model.BlockInfo, error) {
 	blockResult, err := b.GetBlockByHeight(height)
 	if err != nil {
 		return nil, nil, err
@@ -136,16 +154,17 @@ func (b *AbelianIndexer) ParseBlock(height int64, txIndex int64) ([]*model.Bitco
 
 	blockParsedResult := make([]*model.BitcoinTxParseResult, 0)
 	for k, v := range MsgBlock.RawTxs {
-		if int64(k) < txIndex {
-			continue
-		}
-
-		b.logger.Debugw("parse block", "k", k, "height", height, "txIndex", txIndex, "tx", v.TxHash)
+		b.logger.Debugw("parse block", "k", k, "height", height, "tx", v.TxHash)
 
 		parseTxs, err := b.parseTx(v, k)
 		if err != nil {
 			return nil, nil, err
 		}
+		if parseTxs != nil {
+			if err := b.verifyReceivedValue(parseTxs, height); err != nil {
+				return nil, nil, err
+			}
+		}
 		b.logger.Infof("parse block:height=%v,txIndex=%v", height, k)
 
 		if parseTxs != nil {
@@ -156,6 +175,54 @@ func (b *AbelianIndexer) ParseBlock(height int64, txIndex int64) ([]*model.Bitco
 	return blockParsedResult, blockResult, nil
 }
 
+// TargetConfirmations confirmations a tx needs before it is minted
+func (b *AbelianIndexer) TargetConfirmations() uint64 {
+	return b.targetConfirmations
+}
+
+// verifyReceivedValue the memo value is written by the user, verify it against the value the vault received.
+// Only mined txs are verified, the vault wallet does not see the mempool. The block is parsed again
+// while the wallet has not scanned it.
+func (b *AbelianIndexer) verifyReceivedValue(result *model.BitcoinTxParseResult, height int64) error {
+	if b.vault == nil {
+		return nil
+	}
+	receivedValue, err := b.vault.ReceivedValue(result.TxID, height)
+	if err != nil {
+		b.logger.Errorw("vault wallet received value", "error", err, "txId", result.TxID)
+		return err
+	}
+	result.ReceivedValue = receivedValue
+	return nil
+}
+
+// MempoolTxIDs unconfirmed tx ids in the node mempool
+func (b *AbelianIndexer) MempoolTxIDs() ([]string, error) {
+	resp, err := b.getResponseFromChan("getrawmempool", nil)
+	if err != nil {
+		return nil, err
+	}
+	var txIDs []string
+	err = json.Unmarshal(resp, &txIDs)
+	if err != nil {
+		return nil, err
+	}
+	return txIDs, nil
+}
+
+// ParseMempoolTx parse an unconfirmed tx with the same memo parser as mined txs
+func (b *AbelianIndexer) ParseMempoolTx(txID string) (*model.BitcoinTxParseResult, error) {
+	txInfo, err := b.GetRawTransactionVerbose(txID)
+	if err != nil {
+		return nil, err
+	}
+	abeTx, ok := txInfo.Data.(AbecTx)
+	if !ok {
+		return nil, fmt.Errorf("abec tx convert error")
+	}
+	return b.parseTx(&abeTx, 0)
+}
+
 func (b *AbelianIndexer) CheckConfirmations(hash string) error {
 	txVerbose, err := b.GetRawTransactionVerbose(hash)
 	if err != nil {
@@ -217,7 +284,7 @@ func (b *AbelianIndexer) parseTx(txResult *AbecTx, index int) (*model.BitcoinTxP
 	action := gjson.ParseBytes(memo).Get("action").String()
 	protocol := gjson.ParseBytes(memo).Get("protocol").String()
 
-	if action != "deposit" || protocol != "Mable" {
+	if action != "deposit" {
 		return nil, nil
 	}
 
@@ -228,32 +295,15 @@ func (b *AbelianIndexer) parseTx(txResult *AbecTx, index int) (*model.BitcoinTxP
 		return nil, err
 	}
 
-	listenAddress := b.listenAddress
-	if has0xPrefix(listenAddress) {
-		listenAddress = strings.Replace(listenAddress, "0x", "", 1)
-	}
-	toAddress := m.To
-	if has0xPrefix(toAddress) {
-		toAddress = strings.Replace(toAddress, "0x", "", 1)
-	}
-
-	fromAddress := m.From
-	if has0xPrefix(fromAddress) {
-		fromAddress = strings.Replace(fromAddress, "0x", "", 1)
-	}
-
-	hasListenAddress := false
-	if listenAddress == toAddress {
-		hasListenAddress = true
-	}
-
-	v, err := utils.ConvertScientificToBigIntString(m.Value)
-	if err != nil {
-		b.logger.Errorf("ParseInt value error:%v,txId:%v,memo:%v", err, txResult.TxID, string(memo))
-		return nil, err
+	// memo to must be a listened address, with the protocol of its route
+	route, hasListenAddress := b.routes[config.NormalizeListenAddress(m.To)]
+	if !hasListenAddress || route.Protocol != protocol {
+		return nil, nil
 	}
-	totalValue, err := strconv.ParseInt(v, 0, 64)
+	listenAddress := config.NormalizeListenAddress(route.Address)
+	fromAddress := config.NormalizeListenAddress(m.From)
 
+	totalValue, err := amount.Parse(m.Value)
 	if err != nil {
 		b.logger.Errorf("ParseInt value error:%v,txId:%v,memo:%v", err, txResult.TxID, string(memo))
 		return nil, err
@@ -279,7 +329,7 @@ func (b *AbelianIndexer) parseTx(txResult *AbecTx, index int) (*model.BitcoinTxP
 		if len(m.From) == 0 {
 			b.logger.Warnw("parse from address empty or nonsupport tx type",
 				"txId", txResult.TxID,
-				"listenAddress", b.listenAddress)
+				"listenAddress", route.Address)
 			return nil, nil
 		}
 
@@ -291,8 +341,9 @@ func (b *AbelianIndexer) parseTx(txResult *AbecTx, index int) (*model.BitcoinTxP
 			From: []model.BitcoinFrom{model.BitcoinFrom{
 				Address: m.From,
 			}},
-			To:  b.listenAddress,
-			Tos: tos,
+			To:    route.Address,
+			Tos:   tos,
+			Route: &route,
 		}, nil
 	}
 	return nil, nil
@@ -449,7 +500,7 @@ type AbecTx struct {
 	Time          int64         `json:"time"`
 	BlockHash     string        `json:"blockhash"`
 	BlockTime     int64         `json:"blocktime"`
-	Confirmations uint64        `bson:"confirmations"`
+	Confirmations uint64        `json:"confirmations"`
 	Version       int64         `json:"version"`
 	Size          int64         `json:"size"`
 	FullSize      int64         `json:"fullsize"`
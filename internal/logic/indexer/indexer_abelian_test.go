@@ -1,33 +1,212 @@
 package indexer
 
 import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/qday-io/qday-abel-bridge-indexer/config"
 	logger "github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
 )
 
-func init2() *AbelianIndexer {
+func init2(t *testing.T) *AbelianIndexer {
+	t.Helper()
 	bitcoinCfg, err := config.LoadBitcoinConfig()
+	require.NoError(t, err)
+
+	routes, err := bitcoinCfg.ListenRoutes()
 	if err != nil {
-		panic(err)
+		t.Skipf("no listen routes configured: %s", err)
 	}
+	idx, err := NewAbelianIndexer(logger.NewNopLogger(), bitcoinCfg, routes, bitcoinCfg.IndexerListenTargetConfirmations)
+	require.NoError(t, err)
+	return idx.(*AbelianIndexer)
+}
 
+func initRoutes() *AbelianIndexer {
 	return &AbelianIndexer{
-		listenAddress:       bitcoinCfg.IndexerListenAddress,
-		targetConfirmations: bitcoinCfg.IndexerListenTargetConfirmations,
-		logger:              logger.NewNopLogger(),
-		bitcoinCfg:          bitcoinCfg,
+		routes: map[string]config.ListenRoute{
+			"e37e799d5077682fa0a244d46e5649f71457bd09": {
+				Address:         "0xE37e799D5077682FA0a244D46E5649F71457BD09",
+				Protocol:        "Mable",
+				ContractAddress: "0x0000000000000000000000000000000000000001",
+				MintMethod:      config.DefaultMintMethod,
+			},
+			"aa00": {
+				Address:         "aa00",
+				Protocol:        "Vault2",
+				ContractAddress: "0x0000000000000000000000000000000000000002",
+				MintMethod:      config.DefaultMintMethod,
+			},
+		},
+		logger: logger.NewNopLogger(),
+	}
+}
+
+func memoTx(t *testing.T, memo string) *AbecTx {
+	t.Helper()
+	return &AbecTx{
+		TxID: "c0ffee",
+		Memo: hex.EncodeToString(append([]byte("00000000"), []byte(memo)...)),
+	}
+}
+
+func TestAbelianIndexer_ParseTxRoutes(t *testing.T) {
+	b := initRoutes()
+
+	testCases := []struct {
+		name     string
+		memo     string
+		contract string
+		value    string
+	}{
+		{
+			name:     "default vault",
+			memo:     `{"action":"deposit","protocol":"Mable","from":"abe1","to":"0xe37e799d5077682fa0a244d46e5649f71457bd09","value":"1.5e8"}`,
+			contract: "0x0000000000000000000000000000000000000001",
+			value:    "150000000",
+		},
+		{
+			name:     "second vault",
+			memo:     `{"action":"deposit","protocol":"Vault2","from":"abe1","to":"AA00","value":"0x10"}`,
+			contract: "0x0000000000000000000000000000000000000002",
+			value:    "16",
+		},
+		{
+			name: "protocol of another route",
+			memo: `{"action":"deposit","protocol":"Mable","from":"abe1","to":"aa00","value":"1"}`,
+		},
+		{
+			name: "not listened",
+			memo: `{"action":"deposit","protocol":"Mable","from":"abe1","to":"bb00","value":"1"}`,
+		},
+		{
+			name: "not deposit",
+			memo: `{"action":"inscribe","protocol":"Mable","from":"abe1","to":"aa00"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := b.parseTx(memoTx(t, tc.memo), 3)
+			require.NoError(t, err)
+			if tc.contract == "" {
+				assert.Nil(t, result)
+				return
+			}
+			require.NotNil(t, result)
+			require.NotNil(t, result.Route)
+			assert.Equal(t, tc.contract, result.Route.ContractAddress)
+			assert.Equal(t, result.Route.Address, result.To)
+			assert.Equal(t, tc.value, result.Value.String())
+			assert.Equal(t, int64(3), result.Index)
+		})
 	}
 }
 
+func TestAbelianIndexer_ParseTxVaultValue(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch gjson.GetBytes(body, "method").String() {
+		case "listallutxoabe":
+			_, _ = w.Write([]byte(`{"result":[
+				{"TxHash":"C0FFEE","Index":0,"Amount":100000000},
+				{"TxHash":"beef","Index":0,"Amount":7}
+			],"error":null,"id":"1"}`))
+		case "listspentbutunminedabe":
+			_, _ = w.Write([]byte(`{"result":[],"error":null,"id":"1"}`))
+		case "listspentandminedabe":
+			// spent by a payout since it was received, listed once only
+			_, _ = w.Write([]byte(`{"result":[
+				{"TxHash":"c0ffee","Index":1,"Amount":50000000},
+				{"TxHash":"c0ffee","Index":1,"Amount":50000000}
+			],"error":null,"id":"1"}`))
+		case "getblockcount":
+			_, _ = w.Write([]byte(`{"result":100,"error":null,"id":"1"}`))
+		}
+	}))
+	defer srv.Close()
+
+	b := initRoutes()
+	b.vault = NewVaultWallet(config.VaultWalletConfig{RPCHost: srv.URL})
+
+	memo := `{"action":"deposit","protocol":"Mable","from":"abe1","to":"0xe37e799d5077682fa0a244d46e5649f71457bd09","value":"%s"}`
+	result, err := b.parseTx(memoTx(t, fmt.Sprintf(memo, "1.5e8")), 0)
+	require.NoError(t, err)
+	require.NotNil(t, result)
+	assert.Nil(t, result.ReceivedValue)
+	require.NoError(t, b.verifyReceivedValue(result, 100))
+	assert.Equal(t, "150000000", result.ReceivedValue.String())
+	assert.Empty(t, result.ValueMismatch())
+
+	result, err = b.parseTx(memoTx(t, fmt.Sprintf(memo, "2e8")), 0)
+	require.NoError(t, err)
+	require.NoError(t, b.verifyReceivedValue(result, 100))
+	assert.Equal(t, "declared value 200000000, received value 150000000", result.ValueMismatch())
+
+	// the wallet has not scanned the block yet, the block is parsed again later
+	result.TxID = "abba"
+	result.ReceivedValue = nil
+	require.ErrorIs(t, b.verifyReceivedValue(result, 101), ErrVaultTxNotFound)
+	assert.Nil(t, result.ReceivedValue)
+
+	// scanned and nothing received
+	require.NoError(t, b.verifyReceivedValue(result, 100))
+	assert.Equal(t, "0", result.ReceivedValue.String())
+
+	// wallet unavailable, the block is parsed again later
+	srv.Close()
+	require.Error(t, b.verifyReceivedValue(result, 100))
+}
+
+func TestAbelianIndexer_ParseMempool(t *testing.T) {
+	deposit := memoTx(t, `{"action":"deposit","protocol":"Vault2","from":"abe1","to":"aa00","value":"0x10"}`)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch gjson.GetBytes(body, "method").String() {
+		case "getrawmempool":
+			_, _ = w.Write([]byte(`{"result":["c0ffee","beef"],"error":null}`))
+		case "getrawtransaction":
+			tx := AbecTx{TxID: gjson.GetBytes(body, "params.0").String()}
+			if tx.TxID == deposit.TxID {
+				tx.Memo = deposit.Memo
+			}
+			result, _ := json.Marshal(tx)
+			_, _ = w.Write([]byte(`{"result":` + string(result) + `,"error":null}`))
+		}
+	}))
+	defer srv.Close()
+
+	b := initRoutes()
+	b.bitcoinCfg = &config.BitcoinConfig{RPCHost: srv.URL}
+
+	txIDs, err := b.MempoolTxIDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c0ffee", "beef"}, txIDs)
+
+	result, err := b.ParseMempoolTx("c0ffee")
+	require.NoError(t, err)
+	require.NotNil(t, result)
+	assert.Equal(t, "16", result.Value.String())
+	assert.Nil(t, result.ReceivedValue)
+
+	result, err = b.ParseMempoolTx("beef")
+	require.NoError(t, err)
+	assert.Nil(t, result)
+}
+
 func TestAbelianIndexer_Init(t *testing.T) {
 	// 测试索引器初始化，不执行实际的网络调用
-	b := init2()
+	b := init2(t)
 	require.NotNil(t, b)
-	assert.Equal(t, ":9090", b.listenAddress)
+	assert.Len(t, b.routes, 1)
 	assert.Equal(t, uint64(1), b.targetConfirmations)
 }
 
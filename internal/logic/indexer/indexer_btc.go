@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	_interface "github.com/qday-io/qday-abel-bridge-indexer/internal/interface"
+	"math/big"
 
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg"
@@ -68,7 +69,7 @@ func (b *BtcIndexer) Stop() {
 
 // ParseBlock parse block data by block height
 // NOTE: Currently, only transfer transactions are supported.
-func (b *BtcIndexer) ParseBlock(height int64, txIndex int64) ([]*model.BitcoinTxParseResult, *model.BlockInfo, error) {
+func (b *BtcIndexer) ParseBlock(height int64) ([]*model.BitcoinTxParseResult, *model.BlockInfo, error) {
 	blockResult, err := b.GetBlockByHeight(height)
 	if err != nil {
 		return nil, nil, err
@@ -81,11 +82,7 @@ func (b *BtcIndexer) ParseBlock(height int64, txIndex int64) ([]*model.BitcoinTx
 
 	blockParsedResult := make([]*model.BitcoinTxParseResult, 0)
 	for k, v := range MsgBlock.Transactions {
-		if int64(k) < txIndex {
-			continue
-		}
-
-		b.logger.Debugw("parse block", "k", k, "height", height, "txIndex", txIndex, "tx", v.TxHash().String())
+		b.logger.Debugw("parse block", "k", k, "height", height, "tx", v.TxHash().String())
 
 		parseTxs, err := b.parseTx(v, k)
 		if err != nil {
@@ -102,6 +99,11 @@ func (b *BtcIndexer) ParseBlock(height int64, txIndex int64) ([]*model.BitcoinTx
 	return blockParsedResult, &block, nil
 }
 
+// TargetConfirmations confirmations a tx needs before it is minted
+func (b *BtcIndexer) TargetConfirmations() uint64 {
+	return b.targetConfirmations
+}
+
 func (b *BtcIndexer) CheckConfirmations(hash string) error {
 	txVerbose, err := b.GetRawTransactionVerbose(hash)
 	if err != nil {
@@ -139,7 +141,7 @@ func (b *BtcIndexer) parseTx(txResult *wire.MsgTx, index int) (*model.BitcoinTxP
 			TxID:   txResult.TxHash().String(),
 			TxType: TxTypeTransfer,
 			Index:  int64(index),
-			Value:  totalValue,
+			Value:  big.NewInt(totalValue),
 			From:   fromAddress,
 			To:     b.listenAddress.EncodeAddress(),
 			Tos:    tos,
@@ -166,7 +168,7 @@ func (b *BtcIndexer) parseToAddress(TxOut []*wire.TxOut) (toAddress []model.Bitc
 		}
 		parseTo := model.BitcoinTo{
 			Address: pkAddress,
-			Value:   v.Value,
+			Value:   big.NewInt(v.Value),
 		}
 		tos = append(tos, parseTo)
 		// if pk address eq dest listened address, after parse from address by vin prev tx
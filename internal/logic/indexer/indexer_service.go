@@ -8,10 +8,10 @@ import (
 	"time"
 
 	"github.com/cometbft/cometbft/libs/service"
-	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/clock"
 	"github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
-	"gorm.io/gorm"
 )
 
 const (
@@ -19,7 +19,6 @@ const (
 
 	NewBlockWaitTimeout = 60 * time.Second
 
-	IndexTxTimeout    = 100 * time.Millisecond
 	IndexBlockTimeout = 2 * time.Second
 )
 
@@ -41,40 +40,39 @@ const (
 type IndexerService struct {
 	service.BaseService
 	txIdxr _interface.BitcoinTxIndexer
-	db     *gorm.DB
-	log    log.Logger
+	// notifier pushes new blocks, nil polls every NewBlockWaitTimeout
+	notifier *BlockNotifier
+	store    storage.Store
+	log      log.Logger
+	clock    clock.Clock
 }
 
 // NewIndexerService returns a new service instance.
-func NewIndexerService(txIdxr _interface.BitcoinTxIndexer, db *gorm.DB, logger log.Logger) *IndexerService {
-	is := &IndexerService{txIdxr: txIdxr, db: db, log: logger}
+func NewIndexerService(txIdxr _interface.BitcoinTxIndexer, notifier *BlockNotifier, store storage.Store, logger log.Logger) *IndexerService {
+	is := &IndexerService{txIdxr: txIdxr, notifier: notifier, store: store, log: logger, clock: clock.Real}
 	is.BaseService = *service.NewBaseService(nil, ServiceName, is)
 	return is
 }
 
-func (bis *IndexerService) CheckDb() error {
-	if !bis.db.Migrator().HasTable(&model.Deposit{}) {
-		err := bis.db.AutoMigrate(&model.Deposit{})
-		if err != nil {
-			bis.log.Errorw("bitcoin indexer create table", "error", err.Error())
-			return err
-		}
-	}
+// SetClock wait with the clock, a fake clock replays recorded traffic without waiting
+func (bis *IndexerService) SetClock(c clock.Clock) {
+	bis.clock = c
+}
 
-	if !bis.db.Migrator().HasTable(&model.BtcIndex{}) {
-		err := bis.db.AutoMigrate(&model.BtcIndex{})
-		if err != nil {
-			bis.log.Errorw("bitcoin indexer create table", "error", err.Error())
-			return err
-		}
+func (bis *IndexerService) CheckDb() error {
+	// AutoMigrate creates missing tables and adds missing columns
+	tables := []interface{}{
+		&model.Deposit{},
+		&model.DepositEvent{},
+		&model.B2TxAttempt{},
+		&model.BtcIndex{},
+		&model.RollupDeposit{},
+		&model.CircuitBreaker{},
 	}
-
-	if !bis.db.Migrator().HasTable(&model.RollupDeposit{}) {
-		err := bis.db.AutoMigrate(&model.RollupDeposit{})
-		if err != nil {
-			bis.log.Errorw("bitcoin indexer create table", "error", err.Error())
-			return err
-		}
+	err := bis.store.Migrate(tables...)
+	if err != nil {
+		bis.log.Errorw("bitcoin indexer migrate table", "error", err.Error())
+		return err
 	}
 
 	return nil
@@ -88,42 +86,44 @@ func (bis *IndexerService) OnStart() error {
 		return err
 	}
 
-	var (
-		currentBlock   int64 // index current block number
-		currentTxIndex int64 // index current block tx index
-	)
-	var btcIndex model.BtcIndex
-	if err := bis.db.First(&btcIndex, 1).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			btcIndex = model.BtcIndex{
-				Base: model.Base{
-					ID: 1,
-				},
-				BtcIndexBlock: latestBlock,
-				BtcIndexTx:    0,
-			}
-			if err := bis.db.Create(&btcIndex).Error; err != nil {
-				return err
-			}
-		} else {
+	btcIndex, err := bis.store.Cursors().GetBtcIndex()
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+		btcIndex = &model.BtcIndex{
+			Base: model.Base{
+				ID: 1,
+			},
+			BtcIndexBlock: latestBlock,
+		}
+		if err := bis.store.Cursors().SaveBtcIndex(btcIndex); err != nil {
 			return err
 		}
 	}
 
 	bis.log.Infow("bitcoin indexer load db", "data", btcIndex)
 
-	// set default value
-	currentBlock = btcIndex.BtcIndexBlock
-	currentTxIndex = btcIndex.BtcIndexTx
+	if bis.notifier != nil {
+		go bis.notifier.Run(bis.Quit())
+	}
+
+	// index current block number, the last block fully committed
+	currentBlock := resumeBlock(btcIndex)
 
 	//ticker := time.NewTicker(NewBlockWaitTimeout)
 	for {
-		bis.log.Infow("bitcoin indexer", "latestBlock",
-			latestBlock, "currentBlock", currentBlock, "currentTxIndex", currentTxIndex)
+		select {
+		case <-bis.Quit():
+			bis.log.Warnf("bitcoin indexer stopping...")
+			return nil
+		default:
+		}
+		bis.log.Infow("bitcoin indexer", "latestBlock", latestBlock, "currentBlock", currentBlock)
 
 		if latestBlock <= currentBlock {
 			//<-ticker.C
-			<-time.After(NewBlockWaitTimeout)
+			bis.waitNewBlock()
 
 			// update latest block
 			latestBlock, err = bis.txIdxr.LatestBlock()
@@ -132,191 +132,207 @@ func (bis *IndexerService) OnStart() error {
 			}
 			continue
 		}
-		// index > 0, start index from currentBlock currentTxIndex + 1
-		// index == 0, start index from currentBlock + 1
-		if currentTxIndex == 0 {
-			currentBlock++
-		} else {
-			currentTxIndex++
-		}
 
-		for i := currentBlock; i <= latestBlock; i++ {
-			bis.log.Infow("start parse block", "currentBlock", i, "currentTxIndex", currentTxIndex)
-			txResults, blockHeader, err := bis.txIdxr.ParseBlock(i, currentTxIndex)
+		for i := currentBlock + 1; i <= latestBlock; i++ {
+			bis.log.Infow("start parse block", "currentBlock", i)
+			err := bis.IndexBlock(btcIndex, i)
 			if err != nil {
 				if errors.Is(err, ErrTargetConfirmations) {
-					bis.log.Warnw("parse block confirmations", "error", err.Error(), "currentBlock", i, "currentTxIndex", currentTxIndex)
-					time.Sleep(NewBlockWaitTimeout)
+					bis.log.Warnw("parse block confirmations", "error", err.Error(), "currentBlock", i)
+					bis.clock.Sleep(NewBlockWaitTimeout)
 				} else {
-					bis.log.Errorw("parse block unknown err", "error", err.Error(), "currentBlock", i, "currentTxIndex", currentTxIndex)
+					bis.log.Errorw("index block err", "error", err.Error(),
+						"currentBlock", i, "latestBlock", latestBlock)
 				}
-				if currentTxIndex == 0 {
-					currentBlock = i - 1
-				} else {
-					currentBlock = i
-					currentTxIndex--
-				}
-
 				break
 			}
-			if len(txResults) > 0 {
-				currentBlock, currentTxIndex, err = bis.HandleResults(txResults, btcIndex, time.Unix(blockHeader.Time, 0), i)
-				if err != nil {
-					bis.log.Errorw("failed to handle results", "error", err,
-						"currentBlock", currentBlock, "currentTxIndex", currentTxIndex, "latestBlock", latestBlock)
-					rollback := true
-					// not duplicated key, rollback index
-					if pgErr, ok := err.(*pgconn.PgError); ok {
-						// 23505 duplicate key value violates unique constraint , continue
-						if pgErr.Code == "23505" {
-							rollback = false
-						}
-					}
-
-					if rollback {
-						if currentTxIndex == 0 {
-							currentBlock = i - 1
-						} else {
-							currentBlock = i
-							currentTxIndex--
-						}
-						break
-					}
-				}
-			}
 			currentBlock = i
-			currentTxIndex = 0
-			btcIndex.BtcIndexBlock = currentBlock
-			btcIndex.BtcIndexTx = currentTxIndex
-			if err := bis.db.Save(&btcIndex).Error; err != nil {
-				bis.log.Errorw("failed to save bitcoin index block", "error", err, "currentBlock", i,
-					"currentTxIndex", currentTxIndex, "latestBlock", latestBlock)
-				// rollback
-				currentBlock = i - 1
-				break
-			}
-			bis.log.Infow("bitcoin indexer parsed", "currentBlock", i,
-				"currentTxIndex", currentTxIndex, "latestBlock", latestBlock)
-			time.Sleep(IndexBlockTimeout)
+			bis.log.Infow("bitcoin indexer parsed", "currentBlock", i, "latestBlock", latestBlock)
+			bis.clock.Sleep(IndexBlockTimeout)
 		}
 	}
 }
 
-// save index tx to db
+// IndexBlock parse the block and commit its deposits together with the cursor,
+// the block is committed as a whole, a failed block is parsed again from the start
+func (bis *IndexerService) IndexBlock(btcIndex *model.BtcIndex, height int64) error {
+	txResults, blockHeader, err := bis.txIdxr.ParseBlock(height)
+	if err != nil {
+		return err
+	}
+	index := *btcIndex
+	index.BtcIndexBlock = height
+	index.BtcIndexTx = 0
+	err = bis.HandleResults(txResults, index, time.Unix(blockHeader.Time, 0), height)
+	if err != nil {
+		return err
+	}
+	btcIndex.BtcIndexBlock = height
+	btcIndex.BtcIndexTx = 0
+	return nil
+}
+
+// resumeBlock last block fully committed. A cursor left by the tx by tx indexing with a tx index
+// points into a block indexed in part, that block is indexed again, its indexed txs are left untouched.
+func resumeBlock(btcIndex *model.BtcIndex) int64 {
+	if btcIndex.BtcIndexTx != 0 {
+		return btcIndex.BtcIndexBlock - 1
+	}
+	return btcIndex.BtcIndexBlock
+}
+
+// waitNewBlock wait for a block notification, polls every NewBlockWaitTimeout
+// without a notifier or while it is disconnected
+func (bis *IndexerService) waitNewBlock() {
+	if bis.notifier == nil {
+		<-bis.clock.After(NewBlockWaitTimeout)
+		return
+	}
+	select {
+	case height := <-bis.notifier.Notify():
+		bis.log.Debugw("bitcoin indexer new block notified", "height", height)
+	case <-bis.clock.After(NewBlockWaitTimeout):
+	}
+}
+
+// SaveParsedResult save index tx to db, within the block transaction.
+// A tx already indexed is left untouched, so replaying a block is a no-op.
 func (bis *IndexerService) SaveParsedResult(
+	tx storage.Store,
 	parseResult *model.BitcoinTxParseResult,
 	btcBlockNumber int64,
 	b2TxStatus int,
 	btcBlockTime time.Time,
-	btcIndex model.BtcIndex,
 ) error {
-	// write db
-	err := bis.db.Transaction(func(tx *gorm.DB) error {
-		if len(parseResult.From) == 0 {
-			return fmt.Errorf("parse result from empty")
-		}
+	if len(parseResult.From) == 0 {
+		return fmt.Errorf("parse result from empty")
+	}
 
-		if len(parseResult.To) == 0 {
-			return fmt.Errorf("parse result to empty")
-		}
+	if len(parseResult.To) == 0 {
+		return fmt.Errorf("parse result to empty")
+	}
 
-		froms, err := json.Marshal(parseResult.From)
-		if err != nil {
-			return err
-		}
-		tos, err := json.Marshal(parseResult.Tos)
-		if err != nil {
-			return err
-		}
+	froms, err := json.Marshal(parseResult.From)
+	if err != nil {
+		return err
+	}
+	tos, err := json.Marshal(parseResult.Tos)
+	if err != nil {
+		return err
+	}
 
-		// if existed, update deposit record
-		var deposit model.Deposit
-		err = tx.First(&deposit,
-			fmt.Sprintf("%s = ?", model.Deposit{}.Column().BtcTxHash),
-			parseResult.TxID).Error
-		if err != nil {
-			if !errors.Is(err, gorm.ErrRecordNotFound) {
-				return err
-			}
-			deposit := model.Deposit{
-				BtcBlockNumber: btcBlockNumber,
-				BtcTxIndex:     parseResult.Index,
-				BtcTxHash:      parseResult.TxID,
-				BtcFrom:        parseResult.From[0].Address,
-				BtcTos:         string(tos),
-				BtcTo:          parseResult.To,
-				BtcValue:       parseResult.Value,
-				BtcFroms:       string(froms),
-				B2TxStatus:     b2TxStatus,
-				BtcBlockTime:   btcBlockTime,
-				B2TxRetry:      0,
-				ListenerStatus: model.ListenerStatusSuccess,
-				CallbackStatus: model.CallbackStatusSuccess,
-			}
-			err = tx.Create(&deposit).Error
-			if err != nil {
-				bis.log.Errorw("failed to save tx parsed result", "error", err)
-				return err
-			}
-		} else if deposit.CallbackStatus == model.CallbackStatusSuccess &&
-			deposit.ListenerStatus == model.ListenerStatusPending {
-			// if existed, update deposit record
-			updateFields := map[string]interface{}{
-				model.Deposit{}.Column().BtcBlockNumber: btcBlockNumber,
-				model.Deposit{}.Column().BtcTxIndex:     parseResult.Index,
-				model.Deposit{}.Column().BtcFroms:       string(froms),
-				model.Deposit{}.Column().BtcTos:         string(tos),
-				model.Deposit{}.Column().BtcBlockTime:   btcBlockTime,
-				model.Deposit{}.Column().ListenerStatus: model.ListenerStatusSuccess,
-			}
-			err = tx.Model(&model.Deposit{}).Where("id = ?", deposit.ID).Updates(updateFields).Error
-			if err != nil {
-				bis.log.Errorw("failed to update tx parsed result", "error", err)
-				return err
-			}
-		}
+	deposit := model.Deposit{
+		BtcBlockNumber:   btcBlockNumber,
+		BtcTxIndex:       parseResult.Index,
+		EligibleAtHeight: model.EligibleAt(btcBlockNumber, bis.txIdxr.TargetConfirmations()),
+		BtcTxHash:        parseResult.TxID,
+		BtcFrom:          parseResult.From[0].Address,
+		BtcTos:           model.JSONText(tos),
+		BtcTo:            parseResult.To,
+		BtcValue:         model.NewBigInt(parseResult.Value),
+		BtcFroms:         model.JSONText(froms),
+		B2TxStatus:       b2TxStatus,
+		BtcBlockTime:     btcBlockTime,
+		B2TxRetry:        0,
+		HoldReason:       parseResult.ValueMismatch(),
+		ListenerStatus:   model.ListenerStatusSuccess,
+		CallbackStatus:   model.CallbackStatusSuccess,
+	}
+	created, err := tx.Deposits().CreateIfAbsent(&deposit)
+	if err != nil {
+		bis.log.Errorw("failed to save tx parsed result", "error", err)
+		return err
+	}
+	if created {
+		return tx.DepositEvents().Append(model.NewDepositEvent(&deposit, model.DepositEventIndexed, b2TxStatus))
+	}
 
-		if err := tx.Save(&btcIndex).Error; err != nil {
-			bis.log.Errorw("failed to save bitcoin tx index", "error", err)
-			return err
-		}
+	// existed, recorded by the callback or the mempool watcher before the tx was mined
+	existed, err := tx.Deposits().GetByBtcTxHash(parseResult.TxID)
+	if err != nil {
+		return err
+	}
+	if existed.CallbackStatus != model.CallbackStatusSuccess ||
+		existed.ListenerStatus != model.ListenerStatusPending {
 		return nil
-	})
-	return err
+	}
+
+	// update deposit record
+	updateFields := map[string]interface{}{
+		model.Deposit{}.Column().BtcBlockNumber:   btcBlockNumber,
+		model.Deposit{}.Column().BtcTxIndex:       parseResult.Index,
+		model.Deposit{}.Column().BtcFroms:         model.JSONText(froms),
+		model.Deposit{}.Column().BtcTos:           model.JSONText(tos),
+		model.Deposit{}.Column().BtcBlockTime:     btcBlockTime,
+		model.Deposit{}.Column().ListenerStatus:   model.ListenerStatusSuccess,
+		model.Deposit{}.Column().EligibleAtHeight: deposit.EligibleAtHeight,
+	}
+	if reason := parseResult.ValueMismatch(); reason != "" && existed.B2TxStatus == model.DepositB2TxStatusPending {
+		updateFields[model.Deposit{}.Column().B2TxStatus] = b2TxStatus
+		updateFields[model.Deposit{}.Column().HoldReason] = reason
+	}
+	// seen in mempool, promote to pending now that it is mined
+	if existed.B2TxStatus == model.DepositB2TxStatusSeenInMempool ||
+		existed.B2TxStatus == model.DepositB2TxStatusMempoolExpired {
+		updateFields[model.Deposit{}.Column().B2TxStatus] = b2TxStatus
+		updateFields[model.Deposit{}.Column().BtcValue] = deposit.BtcValue
+		updateFields[model.Deposit{}.Column().HoldReason] = deposit.HoldReason
+	}
+	err = tx.Deposits().Update(existed.ID, updateFields)
+	if err != nil {
+		bis.log.Errorw("failed to update tx parsed result", "error", err)
+		return err
+	}
+	if status, ok := updateFields[model.Deposit{}.Column().B2TxStatus]; ok {
+		fromStatus := existed.B2TxStatus
+		existed.B2TxStatus = status.(int)
+		event := model.NewDepositEvent(existed, model.DepositEventStatus, fromStatus)
+		if reason, ok := updateFields[model.Deposit{}.Column().HoldReason].(string); ok {
+			event.Error = reason
+		}
+		return tx.DepositEvents().Append(event)
+	}
+	return nil
 }
 
+// HandleResults commit the deposits of a block and the block cursor in one transaction
 func (bis *IndexerService) HandleResults(
 	txResults []*model.BitcoinTxParseResult,
 	btcIndex model.BtcIndex,
 	btcBlockTime time.Time,
 	currentBlock int64,
-) (int64, int64, error) {
-	for _, v := range txResults {
-		// if from is listen address, skip
-		if bis.ToInFroms(v.From, v.To) {
-			bis.log.Infow("current transaction from is listen address", "currentBlock", currentBlock, "currentTxIndex", v.Index, "data", v)
-			continue
+) error {
+	return bis.store.Transaction(func(tx storage.Store) error {
+		for _, v := range txResults {
+			// if from is listen address, skip
+			if bis.ToInFroms(v.From, v.To) {
+				bis.log.Infow("current transaction from is listen address", "currentBlock", currentBlock, "currentTxIndex", v.Index, "data", v)
+				continue
+			}
+
+			b2TxStatus := model.DepositB2TxStatusPending
+			if reason := v.ValueMismatch(); reason != "" {
+				// flag the deposit, it is not minted
+				bis.log.Warnw("deposit value mismatch", "txId", v.TxID, "reason", reason)
+				b2TxStatus = model.DepositB2TxStatusValueMismatch
+			}
+
+			// write db
+			err := bis.SaveParsedResult(tx, v, currentBlock, b2TxStatus, btcBlockTime)
+			if err != nil {
+				bis.log.Errorw("failed to save bitcoin index tx", "error", err,
+					"data", v)
+				return err
+			}
+			bis.log.Infow("save bitcoin index tx success", "currentBlock", currentBlock, "currentTxIndex", v.Index, "data", v)
 		}
 
-		btcIndex.BtcIndexBlock = currentBlock
-		btcIndex.BtcIndexTx = v.Index
-		// write db
-		err := bis.SaveParsedResult(
-			v,
-			currentBlock,
-			model.DepositB2TxStatusPending,
-			btcBlockTime,
-			btcIndex,
-		)
-		if err != nil {
-			bis.log.Errorw("failed to save bitcoin index tx", "error", err,
-				"data", v)
-			return currentBlock, v.Index, err
+		if err := tx.Cursors().SaveBtcIndex(&btcIndex); err != nil {
+			bis.log.Errorw("failed to save bitcoin index block", "error", err, "currentBlock", currentBlock)
+			return err
 		}
-		bis.log.Infow("save bitcoin index tx success", "currentBlock", currentBlock, "currentTxIndex", v.Index, "data", v)
-		time.Sleep(IndexTxTimeout)
-	}
-	return currentBlock, 0, nil
+		return nil
+	})
 }
 
 func (bis *IndexerService) ToInFroms(a []model.BitcoinFrom, s string) bool {
@@ -0,0 +1,135 @@
+package indexer
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
+	logger "github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gormlog "gorm.io/gorm/logger"
+)
+
+func newSQLiteIndexerService(t *testing.T) *IndexerService {
+	t.Helper()
+	db, err := storage.Open(storage.Options{Source: storage.SQLiteScheme + ":memory:", LogLevel: gormlog.Silent})
+	require.NoError(t, err)
+	store, err := storage.New(db)
+	require.NoError(t, err)
+	bis := NewIndexerService(&tipIndexer{}, nil, store, logger.NewNopLogger())
+	require.NoError(t, bis.CheckDb())
+	return bis
+}
+
+func parsedDeposit(txID string, value int64) *model.BitcoinTxParseResult {
+	return &model.BitcoinTxParseResult{
+		TxID:  txID,
+		From:  []model.BitcoinFrom{{Address: "abe1"}},
+		To:    "aa00",
+		Value: big.NewInt(value),
+		Tos:   []model.BitcoinTo{{Address: "0x01", Value: big.NewInt(value)}},
+	}
+}
+
+func TestIndexerService_HandleResults(t *testing.T) {
+	bis := newSQLiteIndexerService(t)
+	blockTime := time.Unix(1700000000, 0)
+	results := []*model.BitcoinTxParseResult{parsedDeposit("a", 100), parsedDeposit("b", 200)}
+
+	// seen in mempool before it is mined
+	_, err := bis.store.Deposits().CreateIfAbsent(&model.Deposit{
+		BtcTxHash:      "b",
+		BtcValue:       model.NewBigIntFromInt64(200),
+		B2TxStatus:     model.DepositB2TxStatusSeenInMempool,
+		CallbackStatus: model.CallbackStatusSuccess,
+		ListenerStatus: model.ListenerStatusPending,
+	})
+	require.NoError(t, err)
+
+	index := model.BtcIndex{Base: model.Base{ID: 1}, BtcIndexBlock: 100}
+	require.NoError(t, bis.HandleResults(results, index, blockTime, 100))
+	// replaying the block is a no-op
+	require.NoError(t, bis.HandleResults(results, index, blockTime, 100))
+
+	pending, err := bis.store.Deposits().ListByStatus([]int{model.DepositB2TxStatusPending}, 0)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	for _, deposit := range pending {
+		assert.Equal(t, int64(100), deposit.BtcBlockNumber)
+		assert.Equal(t, int64(105), deposit.EligibleAtHeight)
+		assert.Equal(t, model.ListenerStatusSuccess, deposit.ListenerStatus)
+	}
+
+	cursor, err := bis.store.Cursors().GetBtcIndex()
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), cursor.BtcIndexBlock)
+
+	// one event per transition, the replay appends none
+	events, err := bis.store.DepositEvents().ListByBtcTxHash("a")
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, model.DepositEventIndexed, events[0].Event)
+	assert.Equal(t, model.DepositB2TxStatusPending, events[0].ToStatus)
+	events, err = bis.store.DepositEvents().ListByBtcTxHash("b")
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, model.DepositEventStatus, events[0].Event)
+	assert.Equal(t, model.DepositB2TxStatusSeenInMempool, events[0].FromStatus)
+	assert.Equal(t, model.DepositB2TxStatusPending, events[0].ToStatus)
+
+	// a failing tx rolls back the whole block, cursor included
+	broken := parsedDeposit("c", 1)
+	broken.From = nil
+	index.BtcIndexBlock = 101
+	require.Error(t, bis.HandleResults([]*model.BitcoinTxParseResult{parsedDeposit("d", 1), broken}, index, blockTime, 101))
+	_, err = bis.store.Deposits().GetByBtcTxHash("d")
+	require.ErrorIs(t, err, storage.ErrNotFound)
+	events, err = bis.store.DepositEvents().ListByBtcTxHash("d")
+	require.NoError(t, err)
+	assert.Empty(t, events)
+	cursor, err = bis.store.Cursors().GetBtcIndex()
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), cursor.BtcIndexBlock)
+}
+
+// blockIndexer parses every block into the same txs
+type blockIndexer struct {
+	tipIndexer
+	results []*model.BitcoinTxParseResult
+}
+
+func (i *blockIndexer) ParseBlock(int64) ([]*model.BitcoinTxParseResult, *model.BlockInfo, error) {
+	return i.results, &model.BlockInfo{Time: 1700000000}, nil
+}
+
+func TestIndexerService_ResumeLegacyCursor(t *testing.T) {
+	bis := newSQLiteIndexerService(t)
+	// block 100 indexed in part by the tx by tx indexing, up to its first tx
+	_, err := bis.store.Deposits().CreateIfAbsent(&model.Deposit{
+		BtcTxHash: "a", BtcValue: model.NewBigIntFromInt64(100), BtcBlockNumber: 100, B2TxStatus: model.DepositB2TxStatusWaitMined,
+	})
+	require.NoError(t, err)
+	legacy := &model.BtcIndex{Base: model.Base{ID: 1}, BtcIndexBlock: 100, BtcIndexTx: 1}
+	require.NoError(t, bis.store.Cursors().SaveBtcIndex(legacy))
+	assert.Equal(t, int64(99), resumeBlock(legacy))
+	assert.Equal(t, int64(100), resumeBlock(&model.BtcIndex{BtcIndexBlock: 100}))
+
+	bis.txIdxr = &blockIndexer{results: []*model.BitcoinTxParseResult{parsedDeposit("a", 100), parsedDeposit("b", 200)}}
+	require.NoError(t, bis.IndexBlock(legacy, 100))
+
+	// the rest of the block is indexed, the indexed tx is left untouched
+	a, err := bis.store.Deposits().GetByBtcTxHash("a")
+	require.NoError(t, err)
+	assert.Equal(t, model.DepositB2TxStatusWaitMined, a.B2TxStatus)
+	b, err := bis.store.Deposits().GetByBtcTxHash("b")
+	require.NoError(t, err)
+	assert.Equal(t, model.DepositB2TxStatusPending, b.B2TxStatus)
+	cursor, err := bis.store.Cursors().GetBtcIndex()
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), cursor.BtcIndexBlock)
+	assert.Equal(t, int64(0), cursor.BtcIndexTx)
+	assert.Equal(t, int64(100), resumeBlock(cursor))
+}
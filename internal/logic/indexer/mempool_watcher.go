@@ -0,0 +1,190 @@
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/cometbft/cometbft/libs/service"
+	"github.com/qday-io/qday-abel-bridge-indexer/config"
+	_interface "github.com/qday-io/qday-abel-bridge-indexer/internal/interface"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
+)
+
+const MempoolWatcherServiceName = "MempoolWatcherService"
+
+// MempoolWatcher scans the mempool for deposits so users get a status before the tx is mined.
+// Deposits are recorded as seen_in_mempool, the indexer promotes them to pending once mined,
+// minting still waits for the target confirmations.
+type MempoolWatcher struct {
+	service.BaseService
+	cfg    config.MempoolConfig
+	txIdxr _interface.MempoolTxIndexer
+	store  storage.Store
+	log    log.Logger
+	// seen tx ids already parsed, pruned when they leave the mempool
+	seen     map[string]struct{}
+	stopChan chan struct{}
+}
+
+// NewMempoolWatcher returns a new service instance.
+func NewMempoolWatcher(cfg config.MempoolConfig, txIdxr _interface.MempoolTxIndexer, store storage.Store, logger log.Logger) *MempoolWatcher {
+	mw := &MempoolWatcher{
+		cfg:    cfg,
+		txIdxr: txIdxr,
+		store:  store,
+		log:    logger,
+		seen:   make(map[string]struct{}),
+	}
+	mw.BaseService = *service.NewBaseService(nil, MempoolWatcherServiceName, mw)
+	return mw
+}
+
+// OnStart
+func (mw *MempoolWatcher) OnStart() error {
+	mw.stopChan = make(chan struct{})
+	go mw.loop()
+	return nil
+}
+
+func (mw *MempoolWatcher) OnStop() {
+	mw.log.Warnf("mempool watcher stoping...")
+	close(mw.stopChan)
+}
+
+func (mw *MempoolWatcher) loop() {
+	for {
+		select {
+		case <-mw.stopChan:
+			return
+		case <-time.After(time.Duration(mw.cfg.Interval) * time.Second):
+			if err := mw.Scan(time.Now()); err != nil {
+				mw.log.Errorw("mempool scan failed", "error", err)
+			}
+		}
+	}
+}
+
+// Scan record new mempool deposits and expire the ones dropped from the mempool
+func (mw *MempoolWatcher) Scan(now time.Time) error {
+	txIDs, err := mw.txIdxr.MempoolTxIDs()
+	if err != nil {
+		return err
+	}
+
+	inPool := make(map[string]struct{}, len(txIDs))
+	for _, txID := range txIDs {
+		inPool[txID] = struct{}{}
+		if _, ok := mw.seen[txID]; ok {
+			continue
+		}
+		result, err := mw.txIdxr.ParseMempoolTx(txID)
+		if err != nil {
+			// the tx may have been mined or dropped in between, retry next scan
+			mw.log.Warnw("parse mempool tx failed", "error", err, "txId", txID)
+			continue
+		}
+		mw.seen[txID] = struct{}{}
+		if result == nil {
+			continue
+		}
+		if err := mw.saveSeen(result); err != nil {
+			mw.log.Errorw("failed to save mempool deposit", "error", err, "txId", txID)
+			delete(mw.seen, txID)
+		}
+	}
+	for txID := range mw.seen {
+		if _, ok := inPool[txID]; !ok {
+			delete(mw.seen, txID)
+		}
+	}
+
+	return mw.expire(inPool, now)
+}
+
+// saveSeen record the deposit, a tx already indexed is left untouched
+func (mw *MempoolWatcher) saveSeen(result *model.BitcoinTxParseResult) error {
+	if len(result.From) == 0 || len(result.To) == 0 {
+		return fmt.Errorf("parse result from or to empty")
+	}
+	// from is listen address, skip
+	for _, from := range result.From {
+		if from.Address == result.To {
+			return nil
+		}
+	}
+	froms, err := json.Marshal(result.From)
+	if err != nil {
+		return err
+	}
+	tos, err := json.Marshal(result.Tos)
+	if err != nil {
+		return err
+	}
+
+	deposit := model.Deposit{
+		BtcTxHash:      result.TxID,
+		BtcFrom:        result.From[0].Address,
+		BtcFroms:       model.JSONText(froms),
+		BtcTos:         model.JSONText(tos),
+		BtcTo:          result.To,
+		BtcValue:       model.NewBigInt(result.Value),
+		B2TxStatus:     model.DepositB2TxStatusSeenInMempool,
+		CallbackStatus: model.CallbackStatusSuccess,
+		ListenerStatus: model.ListenerStatusPending,
+	}
+	created := false
+	err = mw.store.Transaction(func(tx storage.Store) error {
+		created, err = tx.Deposits().CreateIfAbsent(&deposit)
+		if err != nil || !created {
+			return err
+		}
+		return tx.DepositEvents().Append(model.NewDepositEvent(&deposit, model.DepositEventIndexed, deposit.B2TxStatus))
+	})
+	if err != nil || !created {
+		return err
+	}
+	mw.log.Infow("deposit seen in mempool", "txId", result.TxID, "value", result.Value)
+	return nil
+}
+
+// expire deposits which left the mempool without being mined for longer than the expiry,
+// deposits still in the mempool are touched so the expiry counts from the last time seen
+func (mw *MempoolWatcher) expire(inPool map[string]struct{}, now time.Time) error {
+	deposits, err := mw.store.Deposits().ListByStatus([]int{model.DepositB2TxStatusSeenInMempool}, 0)
+	if err != nil {
+		return err
+	}
+
+	expiry := time.Duration(mw.cfg.Expiry) * time.Second
+	for _, deposit := range deposits {
+		updates := map[string]interface{}{}
+		if _, ok := inPool[deposit.BtcTxHash]; ok {
+			updates["updated_at"] = now
+		} else if now.Sub(deposit.UpdatedAt) > expiry {
+			updates[model.Deposit{}.Column().B2TxStatus] = model.DepositB2TxStatusMempoolExpired
+			mw.log.Warnw("mempool deposit expired", "txId", deposit.BtcTxHash, "lastSeen", deposit.UpdatedAt)
+		} else {
+			continue
+		}
+		// guard the status, the indexer may have promoted the deposit in between
+		err = mw.store.Transaction(func(tx storage.Store) error {
+			updated, err := tx.Deposits().UpdateIfStatus(deposit.ID, model.DepositB2TxStatusSeenInMempool, updates)
+			if err != nil || !updated {
+				return err
+			}
+			status, ok := updates[model.Deposit{}.Column().B2TxStatus].(int)
+			if !ok {
+				return nil
+			}
+			deposit.B2TxStatus = status
+			return tx.DepositEvents().Append(model.NewDepositEvent(deposit, model.DepositEventStatus, model.DepositB2TxStatusSeenInMempool))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
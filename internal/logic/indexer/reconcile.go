@@ -0,0 +1,482 @@
+package indexer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cometbft/cometbft/libs/service"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/qday-io/qday-abel-bridge-indexer/config"
+	_interface "github.com/qday-io/qday-abel-bridge-indexer/internal/interface"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/amount"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/event"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/event/bridge"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
+)
+
+const (
+	ReconcileServiceName = "ReconcileService"
+
+	// reconcile issue kind
+	ReconcileMissingMint    = "missing_mint"
+	ReconcileDoubleMint     = "double_mint"
+	ReconcileAmountMismatch = "amount_mismatch"
+	ReconcileOrphanRollup   = "orphan_rollup"
+	ReconcileMissingReceipt = "missing_receipt"
+
+	ReconcileFormatJSON = "json"
+	ReconcileFormatCSV  = "csv"
+)
+
+var ErrReconcileFindings = errors.New("reconcile found issues")
+
+// receiptStatuses b2 tx statuses of a deposit whose b2 tx is known to be mined, a missing receipt of a
+// deposit in another status is a tx still in flight
+var receiptStatuses = map[int]struct{}{
+	model.DepositB2TxStatusSuccess:               {},
+	model.DepositB2TxStatusTxHashExist:           {},
+	model.DepositB2TxStatusWaitMinedStatusFailed: {},
+}
+
+// MintEvent on-chain MintWAbel event
+type MintEvent struct {
+	TxHash    string   `json:"tx_hash"`
+	LogIndex  uint     `json:"log_index"`
+	ToAddress string   `json:"to_address"`
+	Amount    *big.Int `json:"amount"`
+}
+
+// ReconcileInput the three sources joined by the reconciliation
+type ReconcileInput struct {
+	Deposits []model.Deposit
+	Rollups  []model.RollupDeposit
+	// MintEvents mint events keyed by b2 tx hash, lower case
+	MintEvents map[string][]MintEvent
+	// MissingReceipts b2 txs without a receipt, lower case: not mined yet, replaced, dropped or reorged out
+	MissingReceipts map[string]struct{}
+}
+
+// ReconcileIssue one finding
+type ReconcileIssue struct {
+	Kind      string `json:"kind"`
+	BtcTxHash string `json:"btc_tx_hash"`
+	B2TxHash  string `json:"b2_tx_hash"`
+	Expected  string `json:"expected"`
+	Actual    string `json:"actual"`
+	Detail    string `json:"detail"`
+}
+
+// ReconcileReport reconciliation result
+type ReconcileReport struct {
+	From       time.Time        `json:"from"`
+	To         time.Time        `json:"to"`
+	Deposits   int              `json:"deposits"`
+	Rollups    int              `json:"rollups"`
+	MintEvents int              `json:"mint_events"`
+	Issues     []ReconcileIssue `json:"issues"`
+}
+
+// Reconcile join deposits, rollup deposit events and on-chain mint events
+// A deposit recorded as minted must have exactly one mint event on chain, across its
+// own b2 tx and every rollup event of the same btc tx, with the expected amount.
+// A missing receipt is reported for the deposits whose b2 tx is recorded as mined and for the
+// rollup events, the b2 tx of a deposit in flight may not be mined yet.
+func Reconcile(in ReconcileInput, converter *amount.Converter) []ReconcileIssue {
+	issues := make([]ReconcileIssue, 0)
+
+	rollupsByBtcHash := make(map[string][]model.RollupDeposit)
+	for _, rollup := range in.Rollups {
+		rollupsByBtcHash[rollup.BtcTxHash] = append(rollupsByBtcHash[rollup.BtcTxHash], rollup)
+	}
+
+	depositHashes := make(map[string]struct{}, len(in.Deposits))
+	for _, deposit := range in.Deposits {
+		depositHashes[deposit.BtcTxHash] = struct{}{}
+		_, mined := receiptStatuses[deposit.B2TxStatus]
+		if _, ok := in.MissingReceipts[strings.ToLower(deposit.B2TxHash)]; ok && mined && deposit.B2TxHash != "" {
+			issues = append(issues, ReconcileIssue{
+				Kind:      ReconcileMissingReceipt,
+				BtcTxHash: deposit.BtcTxHash,
+				B2TxHash:  deposit.B2TxHash,
+				Expected:  "receipt",
+				Actual:    "not found",
+				Detail:    fmt.Sprintf("b2 tx status %d, receipt not found", deposit.B2TxStatus),
+			})
+		}
+		rollups := rollupsByBtcHash[deposit.BtcTxHash]
+		minted := deposit.B2TxStatus == model.DepositB2TxStatusSuccess ||
+			deposit.B2TxStatus == model.DepositB2TxStatusTxHashExist
+		if !minted && len(rollups) == 0 {
+			continue
+		}
+		expected := deposit.MintValue()
+
+		// b2 tx related to this deposit
+		txHashes := make([]string, 0, len(rollups)+1)
+		if deposit.B2TxHash != "" {
+			txHashes = append(txHashes, deposit.B2TxHash)
+		}
+		for _, rollup := range rollups {
+			txHashes = append(txHashes, rollup.B2TxHash)
+		}
+		events := uniqueMintEvents(in.MintEvents, txHashes)
+
+		switch {
+		case len(events) == 0:
+			issues = append(issues, ReconcileIssue{
+				Kind:      ReconcileMissingMint,
+				BtcTxHash: deposit.BtcTxHash,
+				B2TxHash:  deposit.B2TxHash,
+				Expected:  expected.String(),
+				Actual:    "0",
+				Detail:    fmt.Sprintf("b2 tx status %d, no mint event on chain", deposit.B2TxStatus),
+			})
+		case len(events) > 1 || len(rollups) > 1:
+			hashes := make([]string, 0, len(events))
+			for _, e := range events {
+				hashes = append(hashes, e.TxHash)
+			}
+			issues = append(issues, ReconcileIssue{
+				Kind:      ReconcileDoubleMint,
+				BtcTxHash: deposit.BtcTxHash,
+				B2TxHash:  strings.Join(hashes, ";"),
+				Expected:  "1",
+				Actual:    strconv.Itoa(max(len(events), len(rollups))),
+				Detail:    fmt.Sprintf("%d mint events, %d rollup events", len(events), len(rollups)),
+			})
+		default:
+			mint := events[0]
+			expectedAmount, err := converter.ToTarget(expected)
+			if err != nil || mint.Amount.Cmp(expectedAmount) != 0 {
+				issues = append(issues, ReconcileIssue{
+					Kind:      ReconcileAmountMismatch,
+					BtcTxHash: deposit.BtcTxHash,
+					B2TxHash:  mint.TxHash,
+					Expected:  fmt.Sprint(expectedAmount),
+					Actual:    mint.Amount.String(),
+					Detail:    "mint event amount",
+				})
+			}
+			if deposit.BtcFromAAAddress != "" && !strings.EqualFold(deposit.BtcFromAAAddress, mint.ToAddress) {
+				issues = append(issues, ReconcileIssue{
+					Kind:      ReconcileAmountMismatch,
+					BtcTxHash: deposit.BtcTxHash,
+					B2TxHash:  mint.TxHash,
+					Expected:  deposit.BtcFromAAAddress,
+					Actual:    mint.ToAddress,
+					Detail:    "mint event to address",
+				})
+			}
+			for _, rollup := range rollups {
+				if rollup.BtcValue.Big().Cmp(expected) != 0 {
+					issues = append(issues, ReconcileIssue{
+						Kind:      ReconcileAmountMismatch,
+						BtcTxHash: deposit.BtcTxHash,
+						B2TxHash:  rollup.B2TxHash,
+						Expected:  expected.String(),
+						Actual:    rollup.BtcValue.String(),
+						Detail:    "rollup deposit value",
+					})
+				}
+			}
+		}
+	}
+
+	for _, rollup := range in.Rollups {
+		if _, ok := in.MissingReceipts[strings.ToLower(rollup.B2TxHash)]; ok {
+			issues = append(issues, ReconcileIssue{
+				Kind:      ReconcileMissingReceipt,
+				BtcTxHash: rollup.BtcTxHash,
+				B2TxHash:  rollup.B2TxHash,
+				Expected:  "receipt",
+				Actual:    "not found",
+				Detail:    "rollup deposit event tx receipt not found",
+			})
+		}
+		if _, ok := depositHashes[rollup.BtcTxHash]; ok {
+			continue
+		}
+		issues = append(issues, ReconcileIssue{
+			Kind:      ReconcileOrphanRollup,
+			BtcTxHash: rollup.BtcTxHash,
+			B2TxHash:  rollup.B2TxHash,
+			Expected:  "0",
+			Actual:    rollup.BtcValue.String(),
+			Detail:    "rollup deposit event without abelian deposit",
+		})
+	}
+	return issues
+}
+
+func uniqueMintEvents(mintEvents map[string][]MintEvent, txHashes []string) []MintEvent {
+	seen := make(map[string]struct{})
+	events := make([]MintEvent, 0)
+	for _, txHash := range txHashes {
+		txHash = strings.ToLower(txHash)
+		if _, ok := seen[txHash]; ok {
+			continue
+		}
+		seen[txHash] = struct{}{}
+		events = append(events, mintEvents[txHash]...)
+	}
+	return events
+}
+
+// Reconciler load the reconcile sources from the store and chain
+type Reconciler struct {
+	store     storage.Store
+	bridge    _interface.BitcoinBridge
+	contracts map[common.Address]struct{}
+	converter *amount.Converter
+}
+
+// NewReconciler mint events are accepted from the bridge contract and every route contract
+func NewReconciler(store storage.Store, bridge _interface.BitcoinBridge, bridgeCfg config.BridgeConfig, routes []config.ListenRoute) *Reconciler {
+	contracts := map[common.Address]struct{}{
+		common.HexToAddress(bridgeCfg.ContractAddress): {},
+	}
+	for _, route := range routes {
+		contracts[common.HexToAddress(route.ContractAddress)] = struct{}{}
+	}
+	return &Reconciler{
+		store:     store,
+		bridge:    bridge,
+		contracts: contracts,
+		converter: amount.NewConverter(bridgeCfg.SourceDecimals, bridgeCfg.TargetDecimals),
+	}
+}
+
+// Run reconcile deposits created in [from, to)
+func (r *Reconciler) Run(from, to time.Time) (*ReconcileReport, error) {
+	in, err := r.Load(from, to)
+	if err != nil {
+		return nil, err
+	}
+	mintEvents := 0
+	for _, events := range in.MintEvents {
+		mintEvents += len(events)
+	}
+	return &ReconcileReport{
+		From:       from,
+		To:         to,
+		Deposits:   len(in.Deposits),
+		Rollups:    len(in.Rollups),
+		MintEvents: mintEvents,
+		Issues:     Reconcile(*in, r.converter),
+	}, nil
+}
+
+// Load deposits and rollup events created in the window, with the counterpart of each
+// outside the window, and the mint events of every related b2 tx
+func (r *Reconciler) Load(from, to time.Time) (*ReconcileInput, error) {
+	created, err := r.store.Deposits().List(storage.DepositQuery{CreatedFrom: from, CreatedBefore: to})
+	if err != nil {
+		return nil, err
+	}
+	deposits := make([]model.Deposit, 0, len(created))
+	for _, deposit := range created {
+		deposits = append(deposits, *deposit)
+	}
+
+	rollups := make([]model.RollupDeposit, 0)
+	createdRollups, err := r.store.RollupDeposits().ListCreated(from, to)
+	if err != nil {
+		return nil, err
+	}
+	rollups = mergeRollups(rollups, createdRollups)
+
+	// rollup events of deposits in the window, created after the window
+	depositHashes := make([]string, 0, len(deposits))
+	for _, deposit := range deposits {
+		depositHashes = append(depositHashes, deposit.BtcTxHash)
+	}
+	related, err := r.store.RollupDeposits().ListByBtcTxHashes(depositHashes)
+	if err != nil {
+		return nil, err
+	}
+	rollups = mergeRollups(rollups, related)
+
+	// deposits of rollup events in the window, created before the window
+	rollupHashes := make([]string, 0, len(rollups))
+	for _, rollup := range rollups {
+		rollupHashes = append(rollupHashes, rollup.BtcTxHash)
+	}
+	if len(rollupHashes) > 0 {
+		earlier, err := r.store.Deposits().List(storage.DepositQuery{BtcTxHashes: rollupHashes, CreatedBefore: from})
+		if err != nil {
+			return nil, err
+		}
+		for _, deposit := range earlier {
+			deposits = append(deposits, *deposit)
+		}
+	}
+
+	mintEvents := make(map[string][]MintEvent)
+	missingReceipts := make(map[string]struct{})
+	txHashes := make([]string, 0, len(deposits)+len(rollups))
+	for _, deposit := range deposits {
+		txHashes = append(txHashes, deposit.B2TxHash)
+	}
+	for _, rollup := range rollups {
+		txHashes = append(txHashes, rollup.B2TxHash)
+	}
+	for _, txHash := range txHashes {
+		key := strings.ToLower(txHash)
+		if txHash == "" {
+			continue
+		}
+		if _, ok := mintEvents[key]; ok {
+			continue
+		}
+		events, err := r.mintEvents(txHash)
+		if errors.Is(err, ethereum.NotFound) {
+			// reported on the deposit, the other txs are still reconciled
+			missingReceipts[key] = struct{}{}
+			mintEvents[key] = nil
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("load mint events of %s err:%w", txHash, err)
+		}
+		mintEvents[key] = events
+	}
+
+	return &ReconcileInput{
+		Deposits:        deposits,
+		Rollups:         rollups,
+		MintEvents:      mintEvents,
+		MissingReceipts: missingReceipts,
+	}, nil
+}
+
+// mintEvents MintWAbel events emitted by the bridge contracts in the tx
+func (r *Reconciler) mintEvents(txHash string) ([]MintEvent, error) {
+	receipt, err := r.bridge.TransactionReceipt(txHash)
+	if err != nil {
+		return nil, err
+	}
+	mint := &bridge.MintWAbel{}
+	events := make([]MintEvent, 0)
+	for _, vlog := range receipt.Logs {
+		if _, ok := r.contracts[vlog.Address]; !ok || len(vlog.Topics) < 2 || vlog.Topics[0] != mint.EventHash() {
+			continue
+		}
+		events = append(events, MintEvent{
+			TxHash:    vlog.TxHash.String(),
+			LogIndex:  vlog.Index,
+			ToAddress: event.TopicToAddress(*vlog, 1).Hex(),
+			Amount:    event.DataToDecimal(*vlog, 0, 0).BigInt(),
+		})
+	}
+	return events, nil
+}
+
+func mergeRollups(rollups []model.RollupDeposit, related []*model.RollupDeposit) []model.RollupDeposit {
+	seen := make(map[int64]struct{}, len(rollups))
+	for _, rollup := range rollups {
+		seen[rollup.ID] = struct{}{}
+	}
+	for _, rollup := range related {
+		if _, ok := seen[rollup.ID]; ok {
+			continue
+		}
+		seen[rollup.ID] = struct{}{}
+		rollups = append(rollups, *rollup)
+	}
+	sort.Slice(rollups, func(i, j int) bool { return rollups[i].ID < rollups[j].ID })
+	return rollups
+}
+
+// WriteReconcileReport write report as json or csv
+func WriteReconcileReport(w io.Writer, report *ReconcileReport, format string) error {
+	switch format {
+	case ReconcileFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	case ReconcileFormatCSV:
+		writer := csv.NewWriter(w)
+		err := writer.Write([]string{"kind", "btc_tx_hash", "b2_tx_hash", "expected", "actual", "detail"})
+		if err != nil {
+			return err
+		}
+		for _, issue := range report.Issues {
+			err = writer.Write([]string{issue.Kind, issue.BtcTxHash, issue.B2TxHash, issue.Expected, issue.Actual, issue.Detail})
+			if err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	default:
+		return fmt.Errorf("unsupported report format: %s", format)
+	}
+}
+
+// ReconcileService periodic reconciliation
+type ReconcileService struct {
+	service.BaseService
+	cfg        config.ReconcileConfig
+	reconciler *Reconciler
+	log        log.Logger
+	stopChan   chan struct{}
+}
+
+// NewReconcileService returns a new service instance.
+func NewReconcileService(cfg config.ReconcileConfig, reconciler *Reconciler, logger log.Logger) *ReconcileService {
+	rs := &ReconcileService{
+		cfg:        cfg,
+		reconciler: reconciler,
+		log:        logger,
+	}
+	rs.BaseService = *service.NewBaseService(nil, ReconcileServiceName, rs)
+	return rs
+}
+
+// OnStart
+func (rs *ReconcileService) OnStart() error {
+	rs.stopChan = make(chan struct{})
+	go rs.loop()
+	return nil
+}
+
+func (rs *ReconcileService) OnStop() {
+	rs.log.Warnf("reconcile service stoping...")
+	close(rs.stopChan)
+}
+
+func (rs *ReconcileService) loop() {
+	for {
+		select {
+		case <-rs.stopChan:
+			return
+		case <-time.After(time.Duration(rs.cfg.Interval) * time.Second):
+			to := time.Now()
+			from := to.Add(-time.Duration(rs.cfg.Window) * time.Second)
+			report, err := rs.reconciler.Run(from, to)
+			if err != nil {
+				rs.log.Errorw("reconcile failed", "error", err)
+				continue
+			}
+			for _, issue := range report.Issues {
+				rs.log.Errorw("reconcile issue", "issue", issue)
+			}
+			rs.log.Infow("reconcile finished",
+				"deposits", report.Deposits,
+				"rollups", report.Rollups,
+				"mintEvents", report.MintEvents,
+				"issues", len(report.Issues))
+		}
+	}
+}
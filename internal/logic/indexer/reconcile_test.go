@@ -0,0 +1,291 @@
+package indexer
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/qday-io/qday-abel-bridge-indexer/config"
+	_interface "github.com/qday-io/qday-abel-bridge-indexer/internal/interface"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/amount"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/event/bridge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gormlog "gorm.io/gorm/logger"
+)
+
+const reconcileAAAddress = "0x176F283DcD00b75334f643a8a8C72E42EBF96755"
+
+func mintAmount(v int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(v), big.NewInt(1e11))
+}
+
+func TestReconcile(t *testing.T) {
+	deposit := func(hash string, b2Hash string, value int64) model.Deposit {
+		return model.Deposit{
+			BtcTxHash:        hash,
+			B2TxHash:         b2Hash,
+			BtcValue:         model.NewBigIntFromInt64(value),
+			BtcFromAAAddress: reconcileAAAddress,
+			B2TxStatus:       model.DepositB2TxStatusSuccess,
+		}
+	}
+	rollup := func(id int64, hash string, b2Hash string, value int64) model.RollupDeposit {
+		return model.RollupDeposit{Base: model.Base{ID: id}, BtcTxHash: hash, B2TxHash: b2Hash, BtcValue: model.NewBigIntFromInt64(value)}
+	}
+	mint := func(b2Hash string, value int64) MintEvent {
+		return MintEvent{TxHash: b2Hash, ToAddress: reconcileAAAddress, Amount: mintAmount(value)}
+	}
+
+	testCases := []struct {
+		name  string
+		input ReconcileInput
+		kinds []string
+	}{
+		{
+			name: "consistent",
+			input: ReconcileInput{
+				Deposits:   []model.Deposit{deposit("a", "0xA1", 100)},
+				Rollups:    []model.RollupDeposit{rollup(1, "a", "0xa1", 100)},
+				MintEvents: map[string][]MintEvent{"0xa1": {mint("0xa1", 100)}},
+			},
+		},
+		{
+			name: "pending deposit is skipped",
+			input: ReconcileInput{
+				Deposits: []model.Deposit{{BtcTxHash: "a", BtcValue: model.NewBigIntFromInt64(100), B2TxStatus: model.DepositB2TxStatusPending}},
+			},
+		},
+		{
+			name: "missing mint",
+			input: ReconcileInput{
+				Deposits:   []model.Deposit{deposit("a", "0xa1", 100)},
+				MintEvents: map[string][]MintEvent{"0xa1": {}},
+			},
+			kinds: []string{ReconcileMissingMint},
+		},
+		{
+			name: "double mint by retried tx",
+			input: ReconcileInput{
+				Deposits: []model.Deposit{deposit("a", "0xa2", 100)},
+				Rollups:  []model.RollupDeposit{rollup(1, "a", "0xa1", 100), rollup(2, "a", "0xa2", 100)},
+				MintEvents: map[string][]MintEvent{
+					"0xa1": {mint("0xa1", 100)},
+					"0xa2": {mint("0xa2", 100)},
+				},
+			},
+			kinds: []string{ReconcileDoubleMint},
+		},
+		{
+			name: "amount mismatch",
+			input: ReconcileInput{
+				Deposits:   []model.Deposit{deposit("a", "0xa1", 100)},
+				Rollups:    []model.RollupDeposit{rollup(1, "a", "0xa1", 90)},
+				MintEvents: map[string][]MintEvent{"0xa1": {mint("0xa1", 90)}},
+			},
+			kinds: []string{ReconcileAmountMismatch, ReconcileAmountMismatch},
+		},
+		{
+			name: "fee deducted",
+			input: ReconcileInput{
+				Deposits: []model.Deposit{func() model.Deposit {
+					d := deposit("a", "0xa1", 1000)
+					d.BtcFee = model.NewBigIntFromInt64(3)
+					return d
+				}()},
+				MintEvents: map[string][]MintEvent{"0xa1": {mint("0xa1", 997)}},
+			},
+		},
+		{
+			name: "missing receipt of a tx in flight is skipped",
+			input: ReconcileInput{
+				Deposits:        []model.Deposit{{BtcTxHash: "a", B2TxHash: "0xA1", BtcValue: model.NewBigIntFromInt64(100), B2TxStatus: model.DepositB2TxStatusWaitMined}},
+				MintEvents:      map[string][]MintEvent{"0xa1": nil},
+				MissingReceipts: map[string]struct{}{"0xa1": {}},
+			},
+		},
+		{
+			name: "missing receipt of a minted deposit",
+			input: ReconcileInput{
+				Deposits:        []model.Deposit{deposit("a", "0xA1", 100)},
+				MintEvents:      map[string][]MintEvent{"0xa1": nil},
+				MissingReceipts: map[string]struct{}{"0xa1": {}},
+			},
+			kinds: []string{ReconcileMissingReceipt, ReconcileMissingMint},
+		},
+		{
+			name: "orphan rollup",
+			input: ReconcileInput{
+				Rollups:    []model.RollupDeposit{rollup(1, "b", "0xb1", 100)},
+				MintEvents: map[string][]MintEvent{"0xb1": {mint("0xb1", 100)}},
+			},
+			kinds: []string{ReconcileOrphanRollup},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			issues := Reconcile(tc.input, amount.NewConverter(7, 18))
+			kinds := make([]string, 0, len(issues))
+			for _, issue := range issues {
+				kinds = append(kinds, issue.Kind)
+			}
+			if len(tc.kinds) == 0 {
+				assert.Empty(t, kinds)
+				return
+			}
+			assert.Equal(t, tc.kinds, kinds)
+		})
+	}
+}
+
+func TestWriteReconcileReport(t *testing.T) {
+	report := &ReconcileReport{
+		Issues: []ReconcileIssue{{Kind: ReconcileOrphanRollup, BtcTxHash: "b", B2TxHash: "0xb1", Expected: "0", Actual: "100"}},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteReconcileReport(&buf, report, ReconcileFormatCSV))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Equal(t, "orphan_rollup,b,0xb1,0,100,", lines[1])
+
+	buf.Reset()
+	require.NoError(t, WriteReconcileReport(&buf, report, ReconcileFormatJSON))
+	assert.Contains(t, buf.String(), `"kind": "orphan_rollup"`)
+
+	require.Error(t, WriteReconcileReport(&buf, report, "xml"))
+}
+
+// fakeBridge b2 chain of the receipts and the token supply, the other methods are not called
+type fakeBridge struct {
+	_interface.BitcoinBridge
+	receipts map[string]*types.Receipt
+	txs      map[string]*types.Transaction
+	supply   *big.Int
+	from     string
+
+	key       *ecdsa.PrivateKey
+	nonce     uint64 // next nonce signed
+	confirmed uint64 // nonce of the sender confirmed on chain
+	sendErr   error
+	sent      []*types.Transaction
+}
+
+func (b *fakeBridge) SignMintTo(to string, value *big.Int) (*types.Transaction, error) {
+	if b.key == nil {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+		b.key = key
+	}
+	b.nonce = max(b.nonce, b.confirmed)
+	toAddress := common.HexToAddress(to)
+	tx, err := types.SignTx(types.NewTx(&types.LegacyTx{
+		Nonce:    b.nonce,
+		To:       &toAddress,
+		Gas:      100000,
+		GasPrice: big.NewInt(1),
+		Data:     value.Bytes(),
+	}), types.NewEIP155Signer(big.NewInt(1)), b.key)
+	if err != nil {
+		return nil, err
+	}
+	b.nonce++
+	return tx, nil
+}
+
+func (b *fakeBridge) SendTransaction(tx *types.Transaction) error {
+	if b.sendErr != nil {
+		return b.sendErr
+	}
+	b.sent = append(b.sent, tx)
+	return nil
+}
+
+func (b *fakeBridge) NonceAt(string) (uint64, error) {
+	return b.confirmed, nil
+}
+
+func (b *fakeBridge) TotalSupply(string) (*big.Int, error) {
+	return b.supply, nil
+}
+
+func (b *fakeBridge) FromAddress() string {
+	return b.from
+}
+
+func (b *fakeBridge) TransactionByHash(hash string) (*types.Transaction, bool, error) {
+	tx, ok := b.txs[hash]
+	if !ok {
+		return nil, false, ethereum.NotFound
+	}
+	return tx, false, nil
+}
+
+func (b *fakeBridge) TransactionReceipt(hash string) (*types.Receipt, error) {
+	receipt, ok := b.receipts[hash]
+	if !ok {
+		return nil, ethereum.NotFound
+	}
+	return receipt, nil
+}
+
+// mintReceipt receipt of a b2 tx minting the value to the reconcile aa address
+func mintReceipt(contract string, txHash string, value int64) *types.Receipt {
+	mint := &bridge.MintWAbel{}
+	return &types.Receipt{Status: 1, Logs: []*types.Log{{
+		Address: common.HexToAddress(contract),
+		Topics:  []common.Hash{mint.EventHash(), common.BytesToHash(common.HexToAddress(reconcileAAAddress).Bytes())},
+		Data:    common.LeftPadBytes(mintAmount(value).Bytes(), 32),
+		TxHash:  common.HexToHash(txHash),
+	}}}
+}
+
+func TestReconciler_LoadMissingReceipt(t *testing.T) {
+	db, err := storage.Open(storage.Options{Source: storage.SQLiteScheme + ":memory:", LogLevel: gormlog.Silent})
+	require.NoError(t, err)
+	store, err := storage.New(db)
+	require.NoError(t, err)
+	require.NoError(t, store.Migrate(&model.Deposit{}, &model.RollupDeposit{}))
+	minted := common.HexToHash("0xa1").Hex()
+	inFlight := common.HexToHash("0xb1").Hex()
+	reorged := common.HexToHash("0xc1").Hex()
+	for _, deposit := range []*model.Deposit{
+		{BtcTxHash: "a", B2TxHash: minted, BtcValue: model.NewBigIntFromInt64(100), BtcFromAAAddress: reconcileAAAddress, B2TxStatus: model.DepositB2TxStatusSuccess},
+		{BtcTxHash: "b", B2TxHash: inFlight, BtcValue: model.NewBigIntFromInt64(100), B2TxStatus: model.DepositB2TxStatusWaitMined},
+		{BtcTxHash: "c", B2TxHash: reorged, BtcValue: model.NewBigIntFromInt64(100), B2TxStatus: model.DepositB2TxStatusSuccess},
+	} {
+		createDeposit(t, db, deposit)
+	}
+	// rollup event of the minted deposit, created in the window
+	require.NoError(t, store.RollupDeposits().Create(&model.RollupDeposit{BtcTxHash: "a", B2TxHash: minted,
+		BtcFromAAAddress: reconcileAAAddress, BtcValue: model.NewBigIntFromInt64(100)}))
+
+	contract := "0x0000000000000000000000000000000000000001"
+	chain := &fakeBridge{receipts: map[string]*types.Receipt{minted: mintReceipt(contract, minted, 100)}}
+	r := NewReconciler(store, chain, config.BridgeConfig{ContractAddress: contract, SourceDecimals: 7, TargetDecimals: 18}, nil)
+
+	// the tx of a minted deposit without a receipt is reported, the tx in flight is not,
+	// the minted one is still reconciled
+	report, err := r.Run(time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Deposits)
+	assert.Equal(t, 1, report.Rollups)
+	assert.Equal(t, 1, report.MintEvents)
+	require.Len(t, report.Issues, 2)
+	assert.Equal(t, ReconcileMissingReceipt, report.Issues[0].Kind)
+	assert.Equal(t, "c", report.Issues[0].BtcTxHash)
+	assert.Equal(t, ReconcileMissingMint, report.Issues[1].Kind)
+	assert.Equal(t, "c", report.Issues[1].BtcTxHash)
+}
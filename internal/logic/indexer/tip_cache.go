@@ -0,0 +1,63 @@
+package indexer
+
+import (
+	"fmt"
+	"sync"
+
+	_interface "github.com/qday-io/qday-abel-bridge-indexer/internal/interface"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+)
+
+// TipCache latest source chain block height, refreshed once per deposit loop
+// so confirmations are computed from the stored block height instead of a query per tx
+type TipCache struct {
+	btcIndexer _interface.BitcoinTxIndexer
+	mu         sync.RWMutex
+	height     int64
+}
+
+func NewTipCache(btcIndexer _interface.BitcoinTxIndexer) *TipCache {
+	return &TipCache{btcIndexer: btcIndexer}
+}
+
+// Refresh fetch the latest block height, the cached height is kept on error
+func (c *TipCache) Refresh() (int64, error) {
+	height, err := c.btcIndexer.LatestBlock()
+	if err != nil {
+		return c.Height(), err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.height = height
+	return height, nil
+}
+
+// Height cached tip height, 0 before the first refresh
+func (c *TipCache) Height() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.height
+}
+
+// CheckConfirmations check the deposit reached the target confirmations at the cached tip
+func (c *TipCache) CheckConfirmations(deposit *model.Deposit) error {
+	eligibleAt := deposit.EligibleAtHeight
+	if eligibleAt == 0 {
+		// indexed before eligible_at_height existed
+		eligibleAt = model.EligibleAt(deposit.BtcBlockNumber, c.btcIndexer.TargetConfirmations())
+	}
+	tip := c.Height()
+	if tip < eligibleAt {
+		return fmt.Errorf("%w, current confirmations:%d target confirmations: %d",
+			ErrTargetConfirmations, confirmations(tip, deposit.BtcBlockNumber), c.btcIndexer.TargetConfirmations())
+	}
+	return nil
+}
+
+// confirmations of a tx in the block at the tip, the block itself counts as one
+func confirmations(tip int64, blockNumber int64) int64 {
+	if tip < blockNumber {
+		return 0
+	}
+	return tip - blockNumber + 1
+}
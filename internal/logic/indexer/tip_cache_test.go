@@ -0,0 +1,76 @@
+package indexer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type tipIndexer struct {
+	latest int64
+	err    error
+	calls  int
+}
+
+func (i *tipIndexer) ParseBlock(int64) ([]*model.BitcoinTxParseResult, *model.BlockInfo, error) {
+	return nil, nil, nil
+}
+
+func (i *tipIndexer) LatestBlock() (int64, error) {
+	i.calls++
+	return i.latest, i.err
+}
+
+func (i *tipIndexer) CheckConfirmations(string) error { return errors.New("unexpected tx query") }
+
+func (i *tipIndexer) TargetConfirmations() uint64 { return 6 }
+
+func (i *tipIndexer) GetRawTransactionVerbose(string) (*model.TxInfo, error) { return nil, nil }
+
+func (i *tipIndexer) BlockChainInfo() (*model.BlockChainInfo, error) { return nil, nil }
+
+func (i *tipIndexer) GetRawTransaction(*chainhash.Hash) (*model.TxInfo, error) { return nil, nil }
+
+func (i *tipIndexer) GetBlockByHeight(int64) (*model.BlockInfo, error) { return nil, nil }
+
+func TestEligibleAt(t *testing.T) {
+	assert.Equal(t, int64(105), model.EligibleAt(100, 6))
+	assert.Equal(t, int64(100), model.EligibleAt(100, 1))
+	assert.Equal(t, int64(100), model.EligibleAt(100, 0))
+}
+
+func TestTipCache(t *testing.T) {
+	idx := &tipIndexer{latest: 104}
+	tip := NewTipCache(idx)
+
+	deposit := &model.Deposit{BtcBlockNumber: 100, EligibleAtHeight: model.EligibleAt(100, 6)}
+	require.ErrorIs(t, tip.CheckConfirmations(deposit), ErrTargetConfirmations)
+
+	height, err := tip.Refresh()
+	require.NoError(t, err)
+	assert.Equal(t, int64(104), height)
+	require.ErrorIs(t, tip.CheckConfirmations(deposit), ErrTargetConfirmations)
+
+	idx.latest = 105
+	_, err = tip.Refresh()
+	require.NoError(t, err)
+	require.NoError(t, tip.CheckConfirmations(deposit))
+	// many deposits, a single tip query
+	require.NoError(t, tip.CheckConfirmations(deposit))
+	assert.Equal(t, 2, idx.calls)
+
+	// indexed before eligible_at_height existed
+	require.NoError(t, tip.CheckConfirmations(&model.Deposit{BtcBlockNumber: 100}))
+	require.ErrorIs(t, tip.CheckConfirmations(&model.Deposit{BtcBlockNumber: 101}), ErrTargetConfirmations)
+
+	// refresh failure keeps the cached tip
+	idx.err = errors.New("rpc down")
+	height, err = tip.Refresh()
+	require.Error(t, err)
+	assert.Equal(t, int64(105), height)
+	assert.Equal(t, int64(105), tip.Height())
+}
@@ -0,0 +1,150 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/cometbft/cometbft/libs/service"
+	"github.com/qday-io/qday-abel-bridge-indexer/config"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
+)
+
+const VaultScannerName = "VaultScanner"
+
+// VaultScanner keep the utxo set of the withdraw vault from the confirmed bitcoin blocks,
+// the outputs paying the vault are added and the outputs spent by a mined tx are marked spent
+type VaultScanner struct {
+	service.BaseService
+	btcCli   *rpcclient.Client
+	cfg      *config.BitcoinConfig
+	store    storage.Store
+	log      log.Logger
+	stopChan chan struct{}
+}
+
+// NewVaultScanner returns a new service instance.
+func NewVaultScanner(btcCli *rpcclient.Client, cfg *config.BitcoinConfig, store storage.Store, logger log.Logger) *VaultScanner {
+	s := &VaultScanner{
+		btcCli: btcCli,
+		cfg:    cfg,
+		store:  store,
+		log:    logger,
+	}
+	s.BaseService = *service.NewBaseService(nil, VaultScannerName, s)
+	return s
+}
+
+// OnStart
+func (s *VaultScanner) OnStart() error {
+	if err := s.store.Migrate(&model.VaultUtxo{}, &model.VaultIndex{}); err != nil {
+		s.log.Errorw("vault scanner migrate err", "error", err)
+		return err
+	}
+	s.stopChan = make(chan struct{})
+	go s.loop()
+	return nil
+}
+
+func (s *VaultScanner) OnStop() {
+	s.log.Warnf("vault scanner stoping...")
+	close(s.stopChan)
+}
+
+func (s *VaultScanner) loop() {
+	for {
+		if err := s.Scan(); err != nil {
+			s.log.Errorw("scan vault utxos failed", "error", err)
+		}
+		select {
+		case <-s.stopChan:
+			return
+		case <-time.After(time.Duration(s.cfg.Bridge.Vault.ScanInterval) * time.Second):
+		}
+	}
+}
+
+// Scan the blocks with enough confirmations since the last scanned block
+func (s *VaultScanner) Scan() error {
+	_, vault, err := s.cfg.Bridge.MultisigVault(config.ChainParams(s.cfg.NetworkName))
+	if err != nil {
+		return err
+	}
+	vaultScript, err := txscript.PayToAddrScript(vault)
+	if err != nil {
+		return err
+	}
+
+	next := s.cfg.Bridge.Vault.StartHeight
+	index, err := s.store.Cursors().GetVaultIndex()
+	switch {
+	case err == nil:
+		next = index.BtcIndexBlock + 1
+	case !errors.Is(err, storage.ErrNotFound):
+		return err
+	}
+	tip, err := s.btcCli.GetBlockCount()
+	if err != nil {
+		return err
+	}
+	confirmations := max(s.cfg.Bridge.Vault.Confirmations, 1)
+	for height := next; height <= tip-confirmations+1; height++ {
+		if err := s.scanBlock(height, vault.EncodeAddress(), vaultScript); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *VaultScanner) scanBlock(height int64, address string, vaultScript []byte) error {
+	hash, err := s.btcCli.GetBlockHash(height)
+	if err != nil {
+		return err
+	}
+	block, err := s.btcCli.GetBlock(hash)
+	if err != nil {
+		return err
+	}
+	spends := make(map[wire.OutPoint]string)
+	var received []*model.VaultUtxo
+	for _, tx := range block.Transactions {
+		txID := tx.TxHash().String()
+		for _, in := range tx.TxIn {
+			spends[in.PreviousOutPoint] = txID
+		}
+		for vout, out := range tx.TxOut {
+			if !bytes.Equal(out.PkScript, vaultScript) {
+				continue
+			}
+			received = append(received, &model.VaultUtxo{
+				TxID:        txID,
+				Vout:        uint32(vout),
+				Value:       out.Value,
+				PkScript:    hex.EncodeToString(out.PkScript),
+				Address:     address,
+				BlockHeight: height,
+				Status:      model.VaultUtxoUnspent,
+			})
+		}
+	}
+
+	return s.store.Transaction(func(tx storage.Store) error {
+		for _, utxo := range received {
+			if _, err := tx.VaultUTXOs().CreateIfAbsent(utxo); err != nil {
+				return err
+			}
+			s.log.Infow("vault utxo received", "txID", utxo.TxID, "vout", utxo.Vout, "value", utxo.Value, "height", height)
+		}
+		// an output received and spent in the same block is added first
+		if err := tx.VaultUTXOs().MarkSpent(spends); err != nil {
+			return err
+		}
+		return tx.Cursors().SaveVaultIndex(&model.VaultIndex{Base: model.Base{ID: 1}, BtcIndexBlock: height})
+	})
+}
@@ -0,0 +1,176 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/qday-io/qday-abel-bridge-indexer/config"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/amount"
+	"github.com/tidwall/gjson"
+)
+
+var (
+	ErrVaultWalletResponse = errors.New("vault wallet response err")
+	// ErrVaultWalletRejected the wallet answered the request with an rpc error, nothing was done
+	ErrVaultWalletRejected = errors.New("vault wallet rejected the request")
+	// ErrVaultTxNotFound the wallet has not scanned the block of the tx yet, asked again later
+	ErrVaultTxNotFound = errors.New("vault wallet tx not found")
+)
+
+// vaultOutputMethods wallet rpcs listing the outputs of the vault, spent ones included, so an
+// output spent by a payout since it was received is still counted
+var vaultOutputMethods = []string{"listallutxoabe", "listspentbutunminedabe", "listspentandminedabe"}
+
+// VaultWallet abewallet rpc client of the vault, the wallet holds the view key of the
+// listened addresses, so it can decrypt the hidden amounts of the received outputs
+type VaultWallet struct {
+	cfg    config.VaultWalletConfig
+	client *http.Client
+}
+
+// NewVaultWallet new vault wallet, nil if the wallet rpc is not configured
+func NewVaultWallet(cfg config.VaultWalletConfig) *VaultWallet {
+	if cfg.RPCHost == "" {
+		return nil
+	}
+	return &VaultWallet{
+		cfg:    cfg,
+		client: http.DefaultClient,
+	}
+}
+
+func (w *VaultWallet) call(method string, params []interface{}) ([]byte, error) {
+	jsonBody, err := json.Marshal(&AbecJSONRPCRequest{
+		JSONRPC: "1.0",
+		Method:  method,
+		Params:  params,
+		ID:      fmt.Sprintf("%d", time.Now().UnixNano()),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	url := w.cfg.RPCHost
+	if !strings.HasPrefix(url, "http") {
+		url = fmt.Sprintf("http://%s", url)
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(w.cfg.RPCUser, w.cfg.RPCPass)
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	root := gjson.ParseBytes(body)
+	if rpcErr := root.Get("error"); rpcErr.Exists() && rpcErr.Type != gjson.Null {
+		return nil, fmt.Errorf("%w: %w: abewallet.%s: %s", ErrVaultWalletResponse, ErrVaultWalletRejected, method, rpcErr.Raw)
+	}
+	// the wallet checks the credentials before running the request
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, fmt.Errorf("%w: %w: abewallet.%s: http status %d", ErrVaultWalletResponse, ErrVaultWalletRejected, method, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: abewallet.%s: http status %d", ErrVaultWalletResponse, method, resp.StatusCode)
+	}
+	return []byte(root.Get("result").Raw), nil
+}
+
+// ReceivedValue value received by the vault in the tx mined at the height, source chain unit.
+// Sums the wallet outputs created by the tx, spent or not. Zero if the vault received nothing,
+// ErrVaultTxNotFound while the wallet has not scanned the height yet.
+func (w *VaultWallet) ReceivedValue(txID string, height int64) (*big.Int, error) {
+	txID = strings.ToLower(strings.TrimPrefix(txID, "0x"))
+	received := new(big.Int)
+	found := false
+	seen := make(map[int64]struct{})
+	for _, method := range vaultOutputMethods {
+		resp, err := w.call(method, nil)
+		if err != nil {
+			return nil, err
+		}
+		outputs := gjson.ParseBytes(resp)
+		if !outputs.IsArray() {
+			return nil, fmt.Errorf("%w: %s result is not an array", ErrVaultWalletResponse, method)
+		}
+		for _, output := range outputs.Array() {
+			if strings.ToLower(output.Get("TxHash").String()) != txID {
+				continue
+			}
+			index := output.Get("Index").Int()
+			if _, ok := seen[index]; ok {
+				continue
+			}
+			seen[index] = struct{}{}
+			v, err := amount.Parse(output.Get("Amount").Raw)
+			if err != nil {
+				return nil, fmt.Errorf("%w: utxo %s:%d amount: %s", ErrVaultWalletResponse,
+					txID, index, err.Error())
+			}
+			received.Add(received, v)
+			found = true
+		}
+	}
+	if found {
+		return received, nil
+	}
+	synced, err := w.SyncedHeight()
+	if err != nil {
+		return nil, err
+	}
+	if synced < height {
+		return nil, fmt.Errorf("%w: %s at height %d, wallet synced to %d", ErrVaultTxNotFound, txID, height, synced)
+	}
+	return received, nil
+}
+
+// SyncedHeight height of the last block scanned by the wallet
+func (w *VaultWallet) SyncedHeight() (int64, error) {
+	resp, err := w.call("getblockcount", nil)
+	if err != nil {
+		return 0, err
+	}
+	height := gjson.ParseBytes(resp)
+	if height.Type != gjson.Number {
+		return 0, fmt.Errorf("%w: getblockcount result %s is not a height", ErrVaultWalletResponse, resp)
+	}
+	return height.Int(), nil
+}
+
+// Unlock unlock the wallet for timeout seconds, the transfers are signed with its spend key
+func (w *VaultWallet) Unlock(passphrase string, timeout int64) error {
+	_, err := w.call("walletunlock", []interface{}{passphrase, timeout})
+	return err
+}
+
+// SendToAddress transfer value neutrino from the wallet to the abelian address, the hash of the transfer tx.
+// The transfer was not sent when the error is ErrVaultWalletRejected, otherwise it may have been.
+func (w *VaultWallet) SendToAddress(address string, value int64) (string, error) {
+	resp, err := w.call("sendtoaddressesabe", []interface{}{
+		[]map[string]interface{}{{"address": address, "amount": value}},
+	})
+	if err != nil {
+		return "", err
+	}
+	var txHash string
+	if err := json.Unmarshal(resp, &txHash); err != nil || txHash == "" {
+		return "", fmt.Errorf("%w: sendtoaddressesabe result %s is not a tx hash", ErrVaultWalletResponse, resp)
+	}
+	return txHash, nil
+}
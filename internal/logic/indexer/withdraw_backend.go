@@ -0,0 +1,410 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/go-resty/resty/v2"
+	"github.com/qday-io/qday-abel-bridge-indexer/config"
+	_interface "github.com/qday-io/qday-abel-bridge-indexer/internal/interface"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
+)
+
+// withdraw backend names of the config
+const (
+	WithdrawBackendUnisat  = "unisat"
+	WithdrawBackendMempool = "mempool"
+	WithdrawBackendRPC     = "rpc"
+	WithdrawBackendStatic  = "static"
+	WithdrawBackendLocal   = "local"
+)
+
+const (
+	// unisatPageSize utxos per unisat page
+	unisatPageSize = 16
+	// feeConfTarget blocks the withdraw tx should be mined in, bitcoind estimates at least 2
+	feeConfTarget = 2
+)
+
+var errNoFeeEstimate = errors.New("no fee estimate")
+
+// WithdrawBackends bitcoin sources of the withdraw service
+type WithdrawBackends struct {
+	UTXOSource   _interface.UTXOSource
+	FeeEstimator _interface.FeeEstimator
+	Broadcaster  _interface.Broadcaster
+}
+
+// UTXOReserver utxo source tracking the outputs spent by the withdraw txs not mined yet,
+// so a batch never selects the inputs of another
+type UTXOReserver interface {
+	// Reserve mark the inputs of the withdraw tx reserved, in the transaction of the store
+	Reserve(store storage.Store, outpoints []wire.OutPoint, btcTxID string) error
+	// Release return the inputs of a failed withdraw tx to the unspent set
+	Release(store storage.Store, btcTxID string) error
+}
+
+// NewWithdrawBackends backends chosen by the bridge config, the rpc backends use the bitcoind client
+// and the local utxo source the vault utxos scanned into the store
+func NewWithdrawBackends(cfg *config.BitcoinConfig, btcCli *rpcclient.Client, store storage.Store) (WithdrawBackends, error) {
+	var backends WithdrawBackends
+	rpc := NewRPCWithdrawBackend(btcCli)
+	mempool := NewMempoolSpace(mempoolURL(cfg))
+
+	switch cfg.Bridge.UTXOSource {
+	case WithdrawBackendUnisat:
+		backends.UTXOSource = NewUnisatUTXOSource(unisatURL(cfg), cfg.Bridge.UnisatAPIKey)
+	case WithdrawBackendRPC:
+		backends.UTXOSource = rpc
+	case WithdrawBackendLocal:
+		backends.UTXOSource = NewLocalUTXOSource(store)
+	default:
+		return backends, fmt.Errorf("unknown utxo source %q", cfg.Bridge.UTXOSource)
+	}
+	switch cfg.Bridge.FeeSource {
+	case WithdrawBackendMempool:
+		backends.FeeEstimator = mempool
+	case WithdrawBackendRPC:
+		backends.FeeEstimator = rpc
+	case WithdrawBackendStatic:
+		backends.FeeEstimator = NewStaticWithdrawBackend(nil, cfg.Bridge.StaticFeeRate)
+	default:
+		return backends, fmt.Errorf("unknown fee source %q", cfg.Bridge.FeeSource)
+	}
+	switch cfg.Bridge.Broadcaster {
+	case WithdrawBackendRPC:
+		backends.Broadcaster = rpc
+	case WithdrawBackendMempool:
+		backends.Broadcaster = mempool
+	default:
+		return backends, fmt.Errorf("unknown broadcaster %q", cfg.Bridge.Broadcaster)
+	}
+	return backends, nil
+}
+
+// UnisatUTXOSource utxos indexed by the unisat open api
+type UnisatUTXOSource struct {
+	url    string
+	apiKey string
+	client *resty.Client
+}
+
+func NewUnisatUTXOSource(url, apiKey string) *UnisatUTXOSource {
+	return &UnisatUTXOSource{url: url, apiKey: apiKey, client: resty.New()}
+}
+
+// ListUnspent every page of the address utxos
+func (u *UnisatUTXOSource) ListUnspent(address btcutil.Address) ([]*model.UnspentOutput, error) {
+	unspentOutputs := make([]*model.UnspentOutput, 0)
+	for cursor := int64(0); ; {
+		utxoData, err := u.page(address.EncodeAddress(), cursor)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range utxoData.Utxo {
+			txHash, err := chainhash.NewHashFromStr(v.Txid)
+			if err != nil {
+				return nil, err
+			}
+			pkScript, err := hex.DecodeString(v.ScriptPk)
+			if err != nil {
+				return nil, err
+			}
+			unspentOutputs = append(unspentOutputs, &model.UnspentOutput{
+				Outpoint: wire.NewOutPoint(txHash, uint32(v.Vout)),
+				Output:   wire.NewTxOut(v.Satoshi, pkScript),
+			})
+		}
+		cursor += int64(len(utxoData.Utxo))
+		if len(utxoData.Utxo) == 0 || cursor >= utxoData.Total {
+			return unspentOutputs, nil
+		}
+	}
+}
+
+func (u *UnisatUTXOSource) page(address string, cursor int64) (*model.UtxoData, error) {
+	resp, err := u.client.R().
+		SetHeader("Content-Type", "application/json").
+		SetHeader("Authorization", fmt.Sprintf("Bearer %s", u.apiKey)).
+		Get(fmt.Sprintf("%s/v1/indexer/address/%s/utxo-data?cursor=%d&size=%d", u.url, address, cursor, unisatPageSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("unisat utxo data: %s", resp.Status())
+	}
+	var respData model.UnisatResponse
+	if err := json.Unmarshal(resp.Body(), &respData); err != nil {
+		return nil, err
+	}
+	if respData.Code != 0 {
+		return nil, fmt.Errorf("unisat utxo data: %s", respData.Msg)
+	}
+	utxoDataByte, err := json.Marshal(respData.Data)
+	if err != nil {
+		return nil, err
+	}
+	var utxoData model.UtxoData
+	if err := json.Unmarshal(utxoDataByte, &utxoData); err != nil {
+		return nil, err
+	}
+	return &utxoData, nil
+}
+
+// LocalUTXOSource vault utxos kept by the VaultScanner, the inputs of pending withdraw txs are reserved
+type LocalUTXOSource struct {
+	store storage.Store
+}
+
+func NewLocalUTXOSource(store storage.Store) *LocalUTXOSource {
+	return &LocalUTXOSource{store: store}
+}
+
+func (l *LocalUTXOSource) ListUnspent(address btcutil.Address) ([]*model.UnspentOutput, error) {
+	utxos, err := l.store.VaultUTXOs().ListUnspent(address.EncodeAddress())
+	if err != nil {
+		return nil, err
+	}
+	unspentOutputs := make([]*model.UnspentOutput, 0, len(utxos))
+	for _, utxo := range utxos {
+		txHash, err := chainhash.NewHashFromStr(utxo.TxID)
+		if err != nil {
+			return nil, err
+		}
+		pkScript, err := hex.DecodeString(utxo.PkScript)
+		if err != nil {
+			return nil, err
+		}
+		unspentOutputs = append(unspentOutputs, &model.UnspentOutput{
+			Outpoint: wire.NewOutPoint(txHash, utxo.Vout),
+			Output:   wire.NewTxOut(utxo.Value, pkScript),
+		})
+	}
+	return unspentOutputs, nil
+}
+
+func (l *LocalUTXOSource) Reserve(store storage.Store, outpoints []wire.OutPoint, btcTxID string) error {
+	return store.VaultUTXOs().Reserve(outpoints, btcTxID)
+}
+
+func (l *LocalUTXOSource) Release(store storage.Store, btcTxID string) error {
+	return store.VaultUTXOs().Release(btcTxID)
+}
+
+// MempoolSpace fee rates and broadcast of the mempool.space api
+type MempoolSpace struct {
+	url string
+}
+
+func NewMempoolSpace(url string) *MempoolSpace {
+	return &MempoolSpace{url: url}
+}
+
+// FeeRate recommended fastest fee
+func (m *MempoolSpace) FeeRate() (int64, error) {
+	body, err := m.do(http.MethodGet, "/v1/fees/recommended", "")
+	if err != nil {
+		return 0, err
+	}
+	var feeRates model.FeeRates
+	if err := json.Unmarshal(body, &feeRates); err != nil {
+		return 0, err
+	}
+	if feeRates.FastestFee <= 0 {
+		return 0, errNoFeeEstimate
+	}
+	return int64(feeRates.FastestFee), nil
+}
+
+func (m *MempoolSpace) Broadcast(tx *wire.MsgTx) (*chainhash.Hash, error) {
+	var buf bytes.Buffer
+	if err := tx.Serialize(&buf); err != nil {
+		return nil, err
+	}
+	body, err := m.do(http.MethodPost, "/tx", hex.EncodeToString(buf.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	return chainhash.NewHashFromStr(strings.TrimSpace(string(body)))
+}
+
+func (m *MempoolSpace) do(method, path, body string) ([]byte, error) {
+	req, err := http.NewRequest(method, m.url+path, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("mempool %s %s: %s %s", method, path, resp.Status, respBody)
+	}
+	return respBody, nil
+}
+
+// RPCWithdrawBackend the bitcoind node, the vault address must be watched by its wallet to list the utxos
+type RPCWithdrawBackend struct {
+	client *rpcclient.Client
+}
+
+func NewRPCWithdrawBackend(client *rpcclient.Client) *RPCWithdrawBackend {
+	return &RPCWithdrawBackend{client: client}
+}
+
+func (r *RPCWithdrawBackend) ListUnspent(address btcutil.Address) ([]*model.UnspentOutput, error) {
+	results, err := r.client.ListUnspentMinMaxAddresses(1, math.MaxInt32, []btcutil.Address{address})
+	if err != nil {
+		return nil, err
+	}
+	unspentOutputs := make([]*model.UnspentOutput, 0, len(results))
+	for _, result := range results {
+		txHash, err := chainhash.NewHashFromStr(result.TxID)
+		if err != nil {
+			return nil, err
+		}
+		pkScript, err := hex.DecodeString(result.ScriptPubKey)
+		if err != nil {
+			return nil, err
+		}
+		value, err := btcutil.NewAmount(result.Amount)
+		if err != nil {
+			return nil, err
+		}
+		unspentOutputs = append(unspentOutputs, &model.UnspentOutput{
+			Outpoint: wire.NewOutPoint(txHash, result.Vout),
+			Output:   wire.NewTxOut(int64(value), pkScript),
+		})
+	}
+	return unspentOutputs, nil
+}
+
+// FeeRate estimatesmartfee, converted from btc/kvB
+func (r *RPCWithdrawBackend) FeeRate() (int64, error) {
+	result, err := r.client.EstimateSmartFee(feeConfTarget, nil)
+	if err != nil {
+		return 0, err
+	}
+	if result.FeeRate == nil {
+		return 0, fmt.Errorf("%w: %v", errNoFeeEstimate, result.Errors)
+	}
+	feeRate, err := btcutil.NewAmount(*result.FeeRate)
+	if err != nil {
+		return 0, err
+	}
+	return int64(math.Max(1, math.Ceil(float64(feeRate)/1000))), nil
+}
+
+func (r *RPCWithdrawBackend) Broadcast(tx *wire.MsgTx) (*chainhash.Hash, error) {
+	return r.client.SendRawTransaction(tx, true)
+}
+
+// StaticWithdrawBackend fixed utxos and fee rate, broadcast txs are kept
+type StaticWithdrawBackend struct {
+	mu      sync.Mutex
+	utxos   []*model.UnspentOutput
+	feeRate int64
+	txs     []*wire.MsgTx
+}
+
+func NewStaticWithdrawBackend(utxos []*model.UnspentOutput, feeRate int64) *StaticWithdrawBackend {
+	return &StaticWithdrawBackend{utxos: utxos, feeRate: feeRate}
+}
+
+// ListUnspent the utxos paying the address not spent by a broadcast tx
+func (s *StaticWithdrawBackend) ListUnspent(address btcutil.Address) ([]*model.UnspentOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unspentOutputs := make([]*model.UnspentOutput, 0)
+	for _, utxo := range s.utxos {
+		if paysAddress(utxo.Output.PkScript, address) {
+			unspentOutputs = append(unspentOutputs, utxo)
+		}
+	}
+	return unspentOutputs, nil
+}
+
+func (s *StaticWithdrawBackend) FeeRate() (int64, error) {
+	return s.feeRate, nil
+}
+
+func (s *StaticWithdrawBackend) Broadcast(tx *wire.MsgTx) (*chainhash.Hash, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	spent := make(map[wire.OutPoint]struct{}, len(tx.TxIn))
+	for _, in := range tx.TxIn {
+		spent[in.PreviousOutPoint] = struct{}{}
+	}
+	utxos := make([]*model.UnspentOutput, 0, len(s.utxos))
+	for _, utxo := range s.utxos {
+		if _, ok := spent[*utxo.Outpoint]; !ok {
+			utxos = append(utxos, utxo)
+		}
+	}
+	s.utxos = utxos
+	s.txs = append(s.txs, tx)
+	txHash := tx.TxHash()
+	return &txHash, nil
+}
+
+// Txs broadcast txs
+func (s *StaticWithdrawBackend) Txs() []*wire.MsgTx {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*wire.MsgTx(nil), s.txs...)
+}
+
+func paysAddress(pkScript []byte, address btcutil.Address) bool {
+	script, err := txscript.PayToAddrScript(address)
+	return err == nil && bytes.Equal(script, pkScript)
+}
+
+func unisatURL(cfg *config.BitcoinConfig) string {
+	if cfg.Bridge.UnisatURL != "" {
+		return cfg.Bridge.UnisatURL
+	}
+	switch cfg.NetworkName {
+	case chaincfg.MainNetParams.Name:
+		return "https://open-api.unisat.io"
+	case chaincfg.TestNet3Params.Name, "testnet":
+		return "https://open-api-testnet.unisat.io"
+	}
+	return ""
+}
+
+func mempoolURL(cfg *config.BitcoinConfig) string {
+	if cfg.Bridge.MempoolURL != "" {
+		return cfg.Bridge.MempoolURL
+	}
+	switch cfg.NetworkName {
+	case chaincfg.MainNetParams.Name:
+		return "https://mempool.space/api"
+	case chaincfg.TestNet3Params.Name, "testnet":
+		return "https://mempool.space/testnet/api"
+	case chaincfg.SigNetParams.Name:
+		return "https://mempool.space/signet/api"
+	}
+	return ""
+}
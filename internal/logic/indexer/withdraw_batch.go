@@ -0,0 +1,121 @@
+package indexer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/btcutil/txsort"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/qday-io/qday-abel-bridge-indexer/config"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+)
+
+// WithdrawBatch pending withdraws paid by the next withdraw tx
+type WithdrawBatch struct {
+	// Withdraws oldest first
+	Withdraws []*model.Withdraw
+	// Oversized withdraws of a b2 tx over the limits of a batch on their own, never batched, held by the withdraw service
+	Oversized []*model.Withdraw
+	// Full no more withdraws fit the batch
+	Full bool
+}
+
+// Ready the batch is built now, it is full or its oldest withdraw waited MinAge
+func (b WithdrawBatch) Ready(cfg config.WithdrawBatchConfig, now time.Time) bool {
+	if len(b.Withdraws) == 0 {
+		return false
+	}
+	return b.Full || cfg.MinAge <= 0 || now.Sub(b.Withdraws[0].CreatedAt) >= time.Duration(cfg.MinAge)*time.Second
+}
+
+// NewWithdrawBatch fill a batch with the pending withdraws in order, oldest first. The withdraws of a
+// b2 tx are batched together, their statuses follow the b2 tx hash. The batch stops at the first b2 tx
+// over the limits so the older withdraws are paid first, the withdraws of a b2 tx over the limits on
+// their own are set aside, also after the batch is full.
+func NewWithdrawBatch(withdraws []*model.Withdraw, cfg config.WithdrawBatchConfig) WithdrawBatch {
+	var batch WithdrawBatch
+	addresses := make(map[string]bool)
+	var value int64
+	for _, group := range groupByB2Tx(withdraws) {
+		if WithdrawLimitReason(group, cfg) != "" {
+			batch.Oversized = append(batch.Oversized, group...)
+			continue
+		}
+		if batch.Full {
+			continue
+		}
+		// withdraws to the same address share one output
+		outputs := len(addresses)
+		var groupValue int64
+		seen := make(map[string]bool)
+		for _, withdraw := range group {
+			if !addresses[withdraw.BtcTo] && !seen[withdraw.BtcTo] {
+				outputs++
+			}
+			seen[withdraw.BtcTo] = true
+			groupValue += withdraw.BtcValue
+		}
+		if (cfg.MaxOutputs > 0 && outputs > cfg.MaxOutputs) || (cfg.MaxValue > 0 && value+groupValue > cfg.MaxValue) {
+			batch.Full = true
+			continue
+		}
+		for address := range seen {
+			addresses[address] = true
+		}
+		value += groupValue
+		batch.Withdraws = append(batch.Withdraws, group...)
+	}
+	if cfg.MaxOutputs > 0 && len(addresses) == cfg.MaxOutputs {
+		batch.Full = true
+	}
+	return batch
+}
+
+// WithdrawLimitReason why the withdraws of a b2 tx never fit a batch, empty if they fit an empty batch
+func WithdrawLimitReason(withdraws []*model.Withdraw, cfg config.WithdrawBatchConfig) string {
+	var value int64
+	addresses := make(map[string]bool)
+	for _, withdraw := range withdraws {
+		value += withdraw.BtcValue
+		addresses[withdraw.BtcTo] = true
+	}
+	switch {
+	case cfg.MaxValue > 0 && value > cfg.MaxValue:
+		return fmt.Sprintf("value %d over the batch max value %d", value, cfg.MaxValue)
+	case cfg.MaxOutputs > 0 && len(addresses) > cfg.MaxOutputs:
+		return fmt.Sprintf("%d outputs over the batch max outputs %d", len(addresses), cfg.MaxOutputs)
+	}
+	return ""
+}
+
+// groupByB2Tx the withdraws by b2 tx hash, in the order of the first withdraw of each tx
+func groupByB2Tx(withdraws []*model.Withdraw) [][]*model.Withdraw {
+	var groups [][]*model.Withdraw
+	index := make(map[string]int)
+	for _, withdraw := range withdraws {
+		i, ok := index[withdraw.B2TxHash]
+		if !ok {
+			i = len(groups)
+			index[withdraw.B2TxHash] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], withdraw)
+	}
+	return groups
+}
+
+// sortWithdrawTx order the inputs and outputs of the withdraw tx by bip69, so the same
+// withdraws and utxos always build the same psbt
+func sortWithdrawTx(tx *wire.MsgTx, inputs []psbt.PInput) []psbt.PInput {
+	byOutpoint := make(map[wire.OutPoint]psbt.PInput, len(inputs))
+	for index, in := range tx.TxIn {
+		byOutpoint[in.PreviousOutPoint] = inputs[index]
+	}
+	txsort.InPlaceSort(tx)
+	sorted := make([]psbt.PInput, 0, len(inputs))
+	for _, in := range tx.TxIn {
+		sorted = append(sorted, byOutpoint[in.PreviousOutPoint])
+	}
+	return sorted
+}
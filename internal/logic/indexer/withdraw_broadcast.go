@@ -0,0 +1,108 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// outcomes of a failed broadcast
+const (
+	broadcastUnknown  = iota // the node may have the tx, it is broadcast again
+	broadcastKnown           // in the mempool or mined
+	broadcastRejected        // refused by the node, its inputs can be released
+)
+
+// alreadyKnownErrs broadcast errors of a tx the node has, bitcoind messages also relayed by mempool.space
+var alreadyKnownErrs = []string{
+	"txn-already-in-mempool",
+	"txn-already-known",
+	"already in block chain",
+	"outputs already in utxo set",
+}
+
+// checkBroadcastErr outcome of a failed broadcast, the reject reason of the node for a rejected tx.
+// A tx is rejected only when the node does not have it and testmempoolaccept refuses it,
+// a tx accepted or not checked is broadcast again.
+func (bis *BridgeWithdrawService) checkBroadcastErr(tx *wire.MsgTx, broadcastErr error) (int, string) {
+	message := strings.ToLower(broadcastErr.Error())
+	for _, known := range alreadyKnownErrs {
+		if strings.Contains(message, known) {
+			return broadcastKnown, ""
+		}
+	}
+	known, err := bis.txKnown(tx.TxHash().String())
+	if err != nil {
+		bis.log.Errorw("BridgeWithdrawService check broadcast tx err", "error", err, "txID", tx.TxHash().String())
+		return broadcastUnknown, ""
+	}
+	if known {
+		return broadcastKnown, ""
+	}
+	rejectReason, err := bis.testMempoolAccept(tx)
+	if err != nil {
+		bis.log.Errorw("BridgeWithdrawService testmempoolaccept err", "error", err, "txID", tx.TxHash().String())
+		return broadcastUnknown, ""
+	}
+	if rejectReason == "" {
+		return broadcastUnknown, ""
+	}
+	return broadcastRejected, rejectReason
+}
+
+// txKnown the tx is in the mempool or mined, the mined txs are found with the node -txindex like ConfirmBroadcast
+func (bis *BridgeWithdrawService) txKnown(txID string) (bool, error) {
+	txHash, err := chainhash.NewHashFromStr(txID)
+	if err != nil {
+		return false, err
+	}
+	_, err = bis.btcCli.GetRawTransactionVerbose(txHash)
+	var rpcErr *btcjson.RPCError
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.As(err, &rpcErr) && rpcErr.Code == btcjson.ErrRPCNoTxInfo:
+		return false, nil
+	}
+	return false, err
+}
+
+// testMempoolAccept reject reason of the node for the tx, empty when its mempool accepts it
+func (bis *BridgeWithdrawService) testMempoolAccept(tx *wire.MsgTx) (string, error) {
+	var buf bytes.Buffer
+	if err := tx.Serialize(&buf); err != nil {
+		return "", err
+	}
+	rawTxs, err := json.Marshal([]string{hex.EncodeToString(buf.Bytes())})
+	if err != nil {
+		return "", err
+	}
+	result, err := bis.btcCli.RawRequest("testmempoolaccept", []json.RawMessage{rawTxs})
+	if err != nil {
+		return "", err
+	}
+	var accepts []struct {
+		Allowed      bool   `json:"allowed"`
+		RejectReason string `json:"reject-reason"`
+	}
+	if err := json.Unmarshal(result, &accepts); err != nil {
+		return "", err
+	}
+	if len(accepts) != 1 {
+		return "", fmt.Errorf("testmempoolaccept: %d results for 1 tx", len(accepts))
+	}
+	if accepts[0].Allowed {
+		return "", nil
+	}
+	if accepts[0].RejectReason == "" {
+		return "rejected", nil
+	}
+	return accepts[0].RejectReason, nil
+}
@@ -0,0 +1,230 @@
+package indexer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/qday-io/qday-abel-bridge-indexer/config"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/coinselect"
+)
+
+// IncrementalRelayFeeRate fee rate in sat/vbyte a replacement pays above the replaced tx, bitcoind -incrementalrelayfee
+const IncrementalRelayFeeRate = 1
+
+var (
+	ErrWithdrawFeeCap  = errors.New("withdraw tx fee rate at the max fee rate")
+	ErrWithdrawFeeBump = errors.New("replacement does not pay the replaced tx fee and its own relay")
+)
+
+// inFlightWithdrawTxStatus statuses of a withdraw tx that may still be mined
+var inFlightWithdrawTxStatus = []int{
+	model.BtcTxWithdrawPending,
+	model.BtcTxWithdrawSignatureCompleted,
+	model.BtcTxWithdrawBroadcastSuccess,
+}
+
+// BumpFeeRate fee rate of the replacement of a tx paying oldRate, the estimate when it is higher
+func BumpFeeRate(oldRate, estimate int64, cfg config.WithdrawBroadcastConfig) (int64, error) {
+	if cfg.MaxFeeRate > 0 && oldRate >= cfg.MaxFeeRate {
+		return 0, ErrWithdrawFeeCap
+	}
+	rate := max(estimate, oldRate*(100+cfg.FeeBumpPercent)/100, oldRate+IncrementalRelayFeeRate)
+	if cfg.MaxFeeRate > 0 {
+		rate = min(rate, cfg.MaxFeeRate)
+	}
+	return rate, nil
+}
+
+// ReplaceStuck build a replacement of the withdraw txs not mined StuckTimeout after their broadcast,
+// the replacement goes to the co-signers like a new withdraw tx
+func (bis *BridgeWithdrawService) ReplaceStuck() error {
+	timeout := bis.config.Bridge.Broadcast.StuckTimeout
+	if timeout <= 0 {
+		return nil
+	}
+	withdrawTxList, err := bis.store.WithdrawTxs().ListStuck(bis.clock.Now().Unix() - timeout)
+	if err != nil {
+		bis.log.Errorw("BridgeWithdrawService get stuck tx failed", "error", err)
+		return err
+	}
+	for _, v := range withdrawTxList {
+		txHash, err := chainhash.NewHashFromStr(v.BtcTxHash)
+		if err != nil {
+			bis.log.Errorw("BridgeWithdrawService NewHashFromStr err", "error", err, "txhash", v.BtcTxHash)
+			continue
+		}
+		// a tx dropped from the mempool is replaced too
+		txRawResult, err := bis.btcCli.GetRawTransactionVerbose(txHash)
+		var rpcErr *btcjson.RPCError
+		switch {
+		case err == nil && txRawResult.Confirmations > 0:
+			continue
+		case err != nil && !(errors.As(err, &rpcErr) && rpcErr.Code == btcjson.ErrRPCNoTxInfo):
+			bis.log.Errorw("BridgeWithdrawService GetRawTransactionVerbose err", "error", err, "txID", v.BtcTxID)
+			continue
+		}
+		replacementID, err := bis.replaceTx(v)
+		if err != nil {
+			bis.log.Errorw("BridgeWithdrawService replace stuck tx err", "error", err, "txID", v.BtcTxID)
+			continue
+		}
+		bis.log.Infow("BridgeWithdrawService stuck tx replaced", "txID", v.BtcTxID, "replacement", replacementID,
+			"broadcastTime", v.BroadcastTime)
+	}
+	return nil
+}
+
+// replaceTx build a psbt spending the inputs of the withdraw tx at a higher fee rate, paying the same withdraws
+func (bis *BridgeWithdrawService) replaceTx(v *model.WithdrawTx) (string, error) {
+	pack, err := psbt.NewFromRawBytes(strings.NewReader(v.BtcTx), true)
+	if err != nil {
+		return "", err
+	}
+	multiSigScript, vault, err := bis.config.Bridge.MultisigVault(config.ChainParams(bis.config.NetworkName))
+	if err != nil {
+		return "", err
+	}
+	changeScript, err := txscript.PayToAddrScript(vault)
+	if err != nil {
+		return "", err
+	}
+
+	coins := make([]coinselect.Coin, 0, len(pack.UnsignedTx.TxIn))
+	utxos := make(map[wire.OutPoint]*wire.TxOut, len(pack.UnsignedTx.TxIn))
+	var oldFee int64
+	for index, in := range pack.UnsignedTx.TxIn {
+		utxo := pack.Inputs[index].WitnessUtxo
+		if utxo == nil {
+			return "", fmt.Errorf("%w: input %d has no witness utxo", ErrWithdrawUnverified, index)
+		}
+		coins = append(coins, coinselect.Coin{OutPoint: in.PreviousOutPoint, Value: utxo.Value})
+		utxos[in.PreviousOutPoint] = utxo
+		oldFee += utxo.Value
+	}
+	var payments []*wire.TxOut
+	for _, out := range pack.UnsignedTx.TxOut {
+		oldFee -= out.Value
+		if !bytes.Equal(out.PkScript, changeScript) {
+			payments = append(payments, out)
+		}
+	}
+	params := coinselect.Params{
+		Outputs:      payments,
+		ChangeScript: changeScript,
+		InputWeight:  coinselect.MultisigInputWeight(bis.config.Bridge.MultisigNum, len(bis.config.Bridge.PublicKeys)),
+	}
+	oldRate := oldFee / coinselect.VSize(coinselect.TxWeight(len(coins), params.InputWeight, pack.UnsignedTx.TxOut))
+	estimate, err := bis.backends.FeeEstimator.FeeRate()
+	if err != nil {
+		return "", err
+	}
+	if params.FeeRate, err = BumpFeeRate(oldRate, estimate, bis.config.Bridge.Broadcast); err != nil {
+		return "", err
+	}
+	selection, err := coinselect.SpendAll(coins, params)
+	if err != nil {
+		return "", err
+	}
+	// bip125: the replacement pays the fee of the replaced tx and the relay of its own size
+	if required := oldFee + selection.VSize*IncrementalRelayFeeRate; selection.Fee < required {
+		params.FeeRate = (required + selection.VSize - 1) / selection.VSize
+		if maxFeeRate := bis.config.Bridge.Broadcast.MaxFeeRate; maxFeeRate > 0 && params.FeeRate > maxFeeRate {
+			return "", ErrWithdrawFeeCap
+		}
+		if selection, err = coinselect.SpendAll(coins, params); err != nil {
+			return "", err
+		}
+		if selection.Fee < oldFee+selection.VSize*IncrementalRelayFeeRate {
+			return "", ErrWithdrawFeeBump
+		}
+	}
+
+	tx := wire.NewMsgTx(pack.UnsignedTx.Version)
+	pInputs := make([]psbt.PInput, 0, len(selection.Coins))
+	for _, coin := range selection.Coins {
+		txIn := wire.NewTxIn(&coin.OutPoint, nil, nil)
+		txIn.Sequence = WithdrawTxInSequence
+		tx.AddTxIn(txIn)
+		pInputs = append(pInputs, psbt.PInput{WitnessUtxo: utxos[coin.OutPoint], WitnessScript: multiSigScript})
+	}
+	for _, out := range payments {
+		tx.AddTxOut(wire.NewTxOut(out.Value, out.PkScript))
+	}
+	if selection.Change > 0 {
+		tx.AddTxOut(wire.NewTxOut(selection.Change, changeScript))
+	}
+	pInputs = sortWithdrawTx(tx, pInputs)
+	btcTx, err := newWithdrawPsbt(tx, pInputs, []byte(v.B2TxHashes))
+	if err != nil {
+		return "", err
+	}
+	txID := tx.TxHash().String()
+
+	err = bis.store.Transaction(func(tx storage.Store) error {
+		replaced, err := tx.WithdrawTxs().SetReplacedBy(v.ID, txID)
+		if err != nil {
+			return err
+		}
+		if !replaced {
+			return fmt.Errorf("withdraw tx %s changed", v.BtcTxID)
+		}
+		replacement, err := tx.WithdrawTxs().GetByBtcTxID(txID)
+		if errors.Is(err, storage.ErrNotFound) {
+			return tx.WithdrawTxs().Create(&model.WithdrawTx{
+				BtcTxID:      txID,
+				BtcTx:        btcTx,
+				B2TxHashes:   v.B2TxHashes,
+				Status:       model.BtcTxWithdrawPending,
+				ReplacesTxID: v.BtcTxID,
+			})
+		}
+		if err != nil {
+			return err
+		}
+		// the same replacement is built again after its broadcast failed, it goes back to the co-signers
+		return tx.WithdrawTxs().Update(replacement.ID, map[string]interface{}{
+			model.WithdrawTx{}.Column().Status:       model.BtcTxWithdrawPending,
+			model.WithdrawTx{}.Column().Reason:       "",
+			model.WithdrawTx{}.Column().ReplacesTxID: v.BtcTxID,
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	return txID, nil
+}
+
+// markReplaced mark the other in-flight txs paying the withdraws of the confirmed tx replaced
+func (bis *BridgeWithdrawService) markReplaced(tx storage.Store, confirmed *model.WithdrawTx) error {
+	replaced, err := tx.WithdrawTxs().UpdatePeers(confirmed, inFlightWithdrawTxStatus, map[string]interface{}{
+		model.WithdrawTx{}.Column().Status: model.BtcTxWithdrawReplaced,
+		model.WithdrawTx{}.Column().Reason: fmt.Sprintf("replaced by %s", confirmed.BtcTxID),
+	})
+	if err != nil {
+		return err
+	}
+	if replaced > 0 {
+		bis.log.Infow("BridgeWithdrawService withdraw txs replaced", "confirmed", confirmed.BtcTxID, "replaced", replaced)
+	}
+	return nil
+}
+
+// failReplacement a replacement not broadcast, the replaced tx may be replaced again
+func (bis *BridgeWithdrawService) failReplacement(v *model.WithdrawTx) error {
+	return bis.store.Transaction(func(tx storage.Store) error {
+		err := tx.WithdrawTxs().Update(v.ID, map[string]interface{}{model.WithdrawTx{}.Column().Status: model.BtcTxWithdrawFailed})
+		if err != nil {
+			return err
+		}
+		return tx.WithdrawTxs().ClearReplacedBy(v.ReplacesTxID, v.BtcTxID)
+	})
+}
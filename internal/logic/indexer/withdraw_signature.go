@@ -0,0 +1,327 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/qday-io/qday-abel-bridge-indexer/config"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
+)
+
+// B2TxHashesKey key of the psbt unknown field listing the b2 withdraw txs paid by the tx
+const B2TxHashesKey = "b2TxHashes"
+
+var (
+	ErrWithdrawTxNotFound       = errors.New("withdraw tx not found")
+	ErrWithdrawTxNotSigning     = errors.New("withdraw tx not waiting for signatures")
+	ErrWithdrawTxMismatch       = errors.New("signed psbt does not match the withdraw tx")
+	ErrWithdrawUnknownSigner    = errors.New("signature of a key not in the co-signers")
+	ErrWithdrawSignerMismatch   = errors.New("signature of another co-signer")
+	ErrWithdrawInvalidSignature = errors.New("invalid signature")
+	ErrWithdrawSignatureMissing = errors.New("not enough signatures")
+	ErrWithdrawUnverified       = errors.New("withdraw tx does not match the indexed withdraws")
+)
+
+// WithdrawSigningRequest withdraw tx waiting for the signature of a co-signer
+type WithdrawSigningRequest struct {
+	BtcTxID string `json:"btc_tx_id"`
+	// Psbt base64 psbt to sign, empty when the tx does not match the indexed withdraws
+	Psbt      string            `json:"psbt,omitempty"`
+	Withdraws []*model.Withdraw `json:"withdraws"`
+	// Signed the co-signer already signed every input
+	Signed bool   `json:"signed"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ListWithdrawSigningRequests withdraw txs waiting for signatures, each checked against the indexed withdraws it pays
+func ListWithdrawSigningRequests(store storage.Store, cfg *config.BitcoinConfig, signer string) ([]WithdrawSigningRequest, error) {
+	withdrawTxs, err := store.WithdrawTxs().ListByStatus([]int{model.BtcTxWithdrawPending}, 0)
+	if err != nil {
+		return nil, err
+	}
+	signerKey, err := hex.DecodeString(signer)
+	if err != nil {
+		return nil, err
+	}
+
+	requests := make([]WithdrawSigningRequest, 0, len(withdrawTxs))
+	for _, withdrawTx := range withdrawTxs {
+		request := WithdrawSigningRequest{BtcTxID: withdrawTx.BtcTxID}
+		pack, err := psbt.NewFromRawBytes(strings.NewReader(withdrawTx.BtcTx), true)
+		if err == nil {
+			request.Withdraws, err = VerifyWithdrawPsbt(store, cfg, pack)
+		}
+		if err != nil {
+			request.Error = err.Error()
+			requests = append(requests, request)
+			continue
+		}
+		request.Psbt = withdrawTx.BtcTx
+		request.Signed = len(signerKey) > 0
+		for index := range pack.Inputs {
+			if partialSig(&pack.Inputs[index], signerKey) == nil {
+				request.Signed = false
+			}
+		}
+		requests = append(requests, request)
+	}
+	return requests, nil
+}
+
+// VerifyWithdrawPsbt check the psbt spends the vault and pays exactly the indexed withdraws
+// listed in its b2TxHashes field, the rest goes back to the vault as change
+func VerifyWithdrawPsbt(store storage.Store, cfg *config.BitcoinConfig, pack *psbt.Packet) ([]*model.Withdraw, error) {
+	net := config.ChainParams(cfg.NetworkName)
+	witnessScript, vault, err := cfg.Bridge.MultisigVault(net)
+	if err != nil {
+		return nil, err
+	}
+	vaultScript, err := txscript.PayToAddrScript(vault)
+	if err != nil {
+		return nil, err
+	}
+
+	b2TxHashes, err := WithdrawB2TxHashes(pack)
+	if err != nil {
+		return nil, err
+	}
+	withdraws, err := store.Withdraws().ListByB2TxHashes(b2TxHashes)
+	if err != nil {
+		return nil, err
+	}
+	// a b2 tx emitting several withdraw logs is listed once and indexed once per log
+	listed := make(map[string]struct{}, len(b2TxHashes))
+	for _, b2TxHash := range b2TxHashes {
+		listed[b2TxHash] = struct{}{}
+	}
+	indexed := make(map[string]struct{}, len(listed))
+	expected := make(map[string]int64)
+	for _, withdraw := range withdraws {
+		if withdraw.Status != model.BtcTxWithdrawSubmitTxMsg {
+			return nil, fmt.Errorf("%w: b2 tx %s log %d status %d", ErrWithdrawUnverified, withdraw.B2TxHash,
+				withdraw.B2LogIndex, withdraw.Status)
+		}
+		indexed[withdraw.B2TxHash] = struct{}{}
+		expected[withdraw.BtcTo] += withdraw.BtcValue
+	}
+	if len(indexed) != len(listed) {
+		return nil, fmt.Errorf("%w: %d b2 txs, %d indexed", ErrWithdrawUnverified, len(listed), len(indexed))
+	}
+
+	for index := range pack.Inputs {
+		input := &pack.Inputs[index]
+		if input.WitnessUtxo == nil || !bytes.Equal(input.WitnessUtxo.PkScript, vaultScript) ||
+			!bytes.Equal(input.WitnessScript, witnessScript) {
+			return nil, fmt.Errorf("%w: input %d does not spend the vault", ErrWithdrawUnverified, index)
+		}
+	}
+	paid := make(map[string]int64)
+	for index, out := range pack.UnsignedTx.TxOut {
+		if bytes.Equal(out.PkScript, vaultScript) {
+			continue
+		}
+		_, addresses, _, err := txscript.ExtractPkScriptAddrs(out.PkScript, net)
+		if err != nil || len(addresses) != 1 {
+			return nil, fmt.Errorf("%w: output %d pays an unknown script", ErrWithdrawUnverified, index)
+		}
+		paid[addresses[0].EncodeAddress()] += out.Value
+	}
+	if len(paid) != len(expected) {
+		return nil, fmt.Errorf("%w: %d addresses paid, %d withdrawn", ErrWithdrawUnverified, len(paid), len(expected))
+	}
+	for address, value := range expected {
+		if paid[address] != value {
+			return nil, fmt.Errorf("%w: %s paid %d, withdrawn %d", ErrWithdrawUnverified, address, paid[address], value)
+		}
+	}
+	return withdraws, nil
+}
+
+// WithdrawB2TxHashes b2 withdraw txs listed in the psbt unknown field
+func WithdrawB2TxHashes(pack *psbt.Packet) ([]string, error) {
+	for _, unknown := range pack.Unknowns {
+		if string(unknown.Key) != B2TxHashesKey {
+			continue
+		}
+		var b2TxHashes []string
+		if err := json.Unmarshal(unknown.Value, &b2TxHashes); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrWithdrawUnverified, err)
+		}
+		if len(b2TxHashes) == 0 {
+			break
+		}
+		return b2TxHashes, nil
+	}
+	return nil, fmt.Errorf("%w: no %s", ErrWithdrawUnverified, B2TxHashesKey)
+}
+
+// MergeWithdrawSignatures merge the partial signatures of a psbt signed by co-signers into its withdraw tx.
+// Each signature is verified against its public key, a non empty signer only accepts its own signatures.
+// Once every input has MultisigNum signatures of the configured public keys the tx is ready to broadcast.
+func MergeWithdrawSignatures(store storage.Store, cfg *config.BitcoinConfig, signed string, signer string) (*model.WithdrawTx, error) {
+	signedPsbt, err := psbt.NewFromRawBytes(strings.NewReader(signed), true)
+	if err != nil {
+		return nil, err
+	}
+	txID := signedPsbt.UnsignedTx.TxHash().String()
+	signers := make(map[string]struct{}, len(cfg.Bridge.PublicKeys))
+	for _, pubKey := range cfg.Bridge.PublicKeys {
+		signers[strings.ToLower(pubKey)] = struct{}{}
+	}
+
+	var withdrawTx *model.WithdrawTx
+	err = store.Transaction(func(tx storage.Store) error {
+		withdrawTx, err = tx.WithdrawTxs().GetByBtcTxID(txID)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				return fmt.Errorf("%w: %s", ErrWithdrawTxNotFound, txID)
+			}
+			return err
+		}
+		if withdrawTx.Status != model.BtcTxWithdrawPending {
+			return fmt.Errorf("%w: %s status %d", ErrWithdrawTxNotSigning, txID, withdrawTx.Status)
+		}
+		pack, err := psbt.NewFromRawBytes(strings.NewReader(withdrawTx.BtcTx), true)
+		if err != nil {
+			return err
+		}
+		if len(pack.Inputs) != len(signedPsbt.Inputs) {
+			return fmt.Errorf("%w: %d inputs, %d signed", ErrWithdrawTxMismatch, len(pack.Inputs), len(signedPsbt.Inputs))
+		}
+		if _, err := VerifyWithdrawPsbt(tx, cfg, pack); err != nil {
+			return err
+		}
+
+		fetcher := txscript.NewMultiPrevOutFetcher(nil)
+		for index, in := range pack.UnsignedTx.TxIn {
+			fetcher.AddPrevOut(in.PreviousOutPoint, pack.Inputs[index].WitnessUtxo)
+		}
+		sigHashes := txscript.NewTxSigHashes(pack.UnsignedTx, fetcher)
+		complete := true
+		for index := range pack.Inputs {
+			input := &pack.Inputs[index]
+			for _, sig := range signedPsbt.Inputs[index].PartialSigs {
+				// signatures already merged come back with the psbt signed on top of them
+				if merged := partialSig(input, sig.PubKey); merged != nil && bytes.Equal(merged.Signature, sig.Signature) {
+					continue
+				}
+				pubKey := hex.EncodeToString(sig.PubKey)
+				if _, ok := signers[pubKey]; !ok {
+					return fmt.Errorf("%w: %s", ErrWithdrawUnknownSigner, pubKey)
+				}
+				if signer != "" && !strings.EqualFold(signer, pubKey) {
+					return fmt.Errorf("%w: %s", ErrWithdrawSignerMismatch, pubKey)
+				}
+				if err := verifyPartialSig(pack, sigHashes, index, sig); err != nil {
+					return fmt.Errorf("%w: input %d of %s: %v", ErrWithdrawInvalidSignature, index, pubKey, err)
+				}
+				if merged := partialSig(input, sig.PubKey); merged != nil {
+					merged.Signature = sig.Signature
+				} else {
+					input.PartialSigs = append(input.PartialSigs, sig)
+				}
+			}
+			if len(input.PartialSigs) < cfg.Bridge.MultisigNum {
+				complete = false
+			}
+		}
+		btcTx, err := pack.B64Encode()
+		if err != nil {
+			return err
+		}
+		status := model.BtcTxWithdrawPending
+		if complete {
+			status = model.BtcTxWithdrawSignatureCompleted
+		}
+		// the psbt is compared, a concurrent submission is not overwritten
+		updated, err := tx.WithdrawTxs().UpdateIfBtcTx(withdrawTx.ID, withdrawTx.BtcTx, map[string]interface{}{
+			model.WithdrawTx{}.Column().BtcTx:  btcTx,
+			model.WithdrawTx{}.Column().Status: status,
+		})
+		if err != nil {
+			return err
+		}
+		if !updated {
+			return fmt.Errorf("withdraw tx %s changed, submit again", txID)
+		}
+		withdrawTx.BtcTx = btcTx
+		withdrawTx.Status = status
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return withdrawTx, nil
+}
+
+// verifyPartialSig check the sighash all signature of the input against the witness script of the vault
+func verifyPartialSig(pack *psbt.Packet, sigHashes *txscript.TxSigHashes, index int, sig *psbt.PartialSig) error {
+	if len(sig.Signature) == 0 || txscript.SigHashType(sig.Signature[len(sig.Signature)-1]) != txscript.SigHashAll {
+		return errors.New("not a sighash all signature")
+	}
+	pubKey, err := btcec.ParsePubKey(sig.PubKey)
+	if err != nil {
+		return err
+	}
+	signature, err := ecdsa.ParseDERSignature(sig.Signature[:len(sig.Signature)-1])
+	if err != nil {
+		return err
+	}
+	input := &pack.Inputs[index]
+	hash, err := txscript.CalcWitnessSigHash(input.WitnessScript, sigHashes, txscript.SigHashAll,
+		pack.UnsignedTx, index, input.WitnessUtxo.Value)
+	if err != nil {
+		return err
+	}
+	if !signature.Verify(hash, pubKey) {
+		return errors.New("signature verification failed")
+	}
+	return nil
+}
+
+// FinalizeWithdrawTx signed tx of the psbt, the witness of every input holds
+// minSignNum signatures in the order of the public keys in the multisig script
+func FinalizeWithdrawTx(pack *psbt.Packet, pubKeys []string, minSignNum int) (*wire.MsgTx, error) {
+	tx := pack.UnsignedTx.Copy()
+	for index, in := range tx.TxIn {
+		input := &pack.Inputs[index]
+		// OP_CHECKMULTISIG pops one extra element
+		witness := wire.TxWitness{nil}
+		for _, pubKey := range pubKeys {
+			if len(witness) > minSignNum {
+				break
+			}
+			raw, err := hex.DecodeString(pubKey)
+			if err != nil {
+				return nil, err
+			}
+			if sig := partialSig(input, raw); sig != nil {
+				witness = append(witness, sig.Signature)
+			}
+		}
+		if len(witness) <= minSignNum {
+			return nil, fmt.Errorf("%w: input %d has %d of %d", ErrWithdrawSignatureMissing, index, len(witness)-1, minSignNum)
+		}
+		in.Witness = append(witness, input.WitnessScript)
+	}
+	return tx, nil
+}
+
+func partialSig(input *psbt.PInput, pubKey []byte) *psbt.PartialSig {
+	for _, sig := range input.PartialSigs {
+		if bytes.Equal(sig.PubKey, pubKey) {
+			return sig
+		}
+	}
+	return nil
+}
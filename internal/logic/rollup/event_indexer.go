@@ -0,0 +1,171 @@
+package rollup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/qday-io/qday-abel-bridge-indexer/config"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/event"
+)
+
+// bridge events, decoded by abi and handled by subscription like the configured events
+const (
+	DepositEventName   = "DepositEvent"
+	WithdrawEventName  = "WithdrawEvent"
+	BurnWAbelEventName = "BurnWAbel"
+)
+
+// ErrInvalidLog the log can never be handled, its data does not decode or its values are out of range.
+// Retrying it fails the same way, it is parked instead
+var ErrInvalidLog = errors.New("invalid log")
+
+// EventHandler custom handling of a new indexed event, run in the transaction storing it
+type EventHandler func(tx storage.Store, evt *model.EvmEvent, decoded *event.Event) error
+
+// EventIndexer store the logs of the configured abi events in evm_events, decoded by argument name.
+// The handlers subscribed to an event run once per log, when it is first stored.
+type EventIndexer struct {
+	decoder  *event.Decoder
+	store    storage.Store
+	handlers map[string][]EventHandler
+}
+
+// NewEventIndexer indexer of the configured events and the bridge events of the configured hashes,
+// nil when no event is configured. The configured events are decoded by the event abi file, else by
+// the bridge abi.
+func NewEventIndexer(cfg config.BridgeConfig, store storage.Store) (*EventIndexer, error) {
+	bridgeABI := config.DefaultDepositAbi
+	if cfg.ABI != "" {
+		bridgeABI = cfg.ABI
+	}
+	abiJSON := bridgeABI
+	if cfg.EventABIFile != "" {
+		data, err := os.ReadFile(cfg.EventABIFile)
+		if err != nil {
+			return nil, err
+		}
+		abiJSON = string(data)
+	}
+	decoder, err := event.NewDecoder(abiJSON, cfg.Events)
+	if err != nil {
+		return nil, err
+	}
+	withdrawHash := cfg.Withdraw
+	if !cfg.EnableWithdrawListener {
+		withdrawHash = ""
+	}
+	for _, e := range []struct {
+		abi, name, hash string
+	}{
+		{config.BridgeEventsAbi, DepositEventName, cfg.Deposit},
+		{config.BridgeEventsAbi, WithdrawEventName, withdrawHash},
+		{bridgeABI, BurnWAbelEventName, cfg.BurnWAbel},
+	} {
+		if e.hash == "" {
+			continue
+		}
+		if err := decoder.Register(e.abi, e.name, common.HexToHash(e.hash)); err != nil {
+			return nil, err
+		}
+	}
+	if len(decoder.Topics()) == 0 {
+		return nil, nil
+	}
+	return &EventIndexer{
+		decoder:  decoder,
+		store:    store,
+		handlers: make(map[string][]EventHandler),
+	}, nil
+}
+
+// Migrate create the evm_events and parked_logs tables
+func (ei *EventIndexer) Migrate() error {
+	return ei.store.Migrate(&model.EvmEvent{}, &model.ParkedLog{})
+}
+
+// Subscribe run the handler with every new log of the event, the event must be configured
+func (ei *EventIndexer) Subscribe(name string, handler EventHandler) error {
+	if !ei.decoder.Listed(name) {
+		return fmt.Errorf("%w: %s", event.ErrNotListed, name)
+	}
+	ei.handlers[name] = append(ei.handlers[name], handler)
+	return nil
+}
+
+// Topics event hashes of the configured events
+func (ei *EventIndexer) Topics() []common.Hash {
+	return ei.decoder.Topics()
+}
+
+// Handles the log is of a configured event
+func (ei *EventIndexer) Handles(vlog ethtypes.Log) bool {
+	return ei.decoder.Handles(vlog)
+}
+
+// Index decode and store the log, a log indexed again is skipped. ErrInvalidLog if the log does not decode
+// or a handler rejects it
+func (ei *EventIndexer) Index(vlog ethtypes.Log) error {
+	decoded, err := ei.decoder.Decode(vlog)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidLog, err.Error())
+	}
+	args, err := json.Marshal(decoded.TypedArgs())
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidLog, err.Error())
+	}
+	evt := &model.EvmEvent{
+		Contract:      vlog.Address.Hex(),
+		EventName:     decoded.Name,
+		EventHash:     vlog.Topics[0].Hex(),
+		Signature:     decoded.Signature,
+		Args:          model.JSONText(args),
+		B2BlockNumber: vlog.BlockNumber,
+		B2BlockHash:   vlog.BlockHash.String(),
+		B2TxHash:      vlog.TxHash.String(),
+		B2TxIndex:     vlog.TxIndex,
+		B2LogIndex:    vlog.Index,
+	}
+	return ei.store.Transaction(func(tx storage.Store) error {
+		created, err := tx.EvmEvents().CreateIfAbsent(evt)
+		if err != nil || !created {
+			return err
+		}
+		for _, handler := range ei.handlers[decoded.Name] {
+			if err := handler(tx, evt, decoded); err != nil {
+				return fmt.Errorf("%s handler: %w", decoded.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Park keep the log that can never be handled with the reason, a log parked again is skipped
+func (ei *EventIndexer) Park(vlog ethtypes.Log, reason string) error {
+	topics := make([]string, 0, len(vlog.Topics))
+	for _, topic := range vlog.Topics {
+		topics = append(topics, topic.Hex())
+	}
+	parked := &model.ParkedLog{
+		Contract:      vlog.Address.Hex(),
+		Topics:        strings.Join(topics, ","),
+		Data:          common.Bytes2Hex(vlog.Data),
+		Reason:        reason,
+		B2BlockNumber: vlog.BlockNumber,
+		B2BlockHash:   vlog.BlockHash.String(),
+		B2TxHash:      vlog.TxHash.String(),
+		B2TxIndex:     vlog.TxIndex,
+		B2LogIndex:    vlog.Index,
+	}
+	if len(vlog.Topics) > 0 {
+		parked.EventHash = vlog.Topics[0].Hex()
+	}
+	_, err := ei.store.ParkedLogs().CreateIfAbsent(parked)
+	return err
+}
@@ -0,0 +1,118 @@
+package rollup
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/qday-io/qday-abel-bridge-indexer/config"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+	gormlog "gorm.io/gorm/logger"
+)
+
+func newTestEventIndexer(t *testing.T, cfg config.BridgeConfig) (*EventIndexer, storage.Store) {
+	t.Helper()
+	db, err := storage.Open(storage.Options{Source: storage.SQLiteScheme + ":memory:", LogLevel: gormlog.Silent})
+	require.NoError(t, err)
+	store, err := storage.New(db)
+	require.NoError(t, err)
+	events, err := NewEventIndexer(cfg, store)
+	require.NoError(t, err)
+	if events != nil {
+		require.NoError(t, events.Migrate())
+	}
+	return events, store
+}
+
+func burnLog(t *testing.T, owner common.Address, amount int64, logIndex uint) ethtypes.Log {
+	t.Helper()
+	contractABI, err := abi.JSON(strings.NewReader(config.DefaultDepositAbi))
+	require.NoError(t, err)
+	data, err := contractABI.Events["BurnWAbel"].Inputs.NonIndexed().Pack(big.NewInt(amount))
+	require.NoError(t, err)
+	return ethtypes.Log{
+		Address:     common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		Topics:      []common.Hash{contractABI.Events["BurnWAbel"].ID, common.BytesToHash(owner.Bytes())},
+		Data:        data,
+		BlockNumber: 10,
+		TxHash:      common.HexToHash("0xb1"),
+		Index:       logIndex,
+	}
+}
+
+func TestEventIndexer(t *testing.T) {
+	events, store := newTestEventIndexer(t, config.BridgeConfig{Events: []string{"BurnWAbel"}})
+	require.NotNil(t, events)
+
+	var handled []string
+	require.NoError(t, events.Subscribe("BurnWAbel", func(tx storage.Store, evt *model.EvmEvent, decoded *event.Event) error {
+		handled = append(handled, decoded.Args["owner"].(common.Address).Hex())
+		return nil
+	}))
+	require.ErrorIs(t, events.Subscribe("MintWAbel", nil), event.ErrNotListed)
+
+	owner := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	vlog := burnLog(t, owner, 5000, 3)
+	assert.True(t, events.Handles(vlog))
+	require.NoError(t, events.Index(vlog))
+	// the log scanned again is neither stored nor handled twice
+	require.NoError(t, events.Index(vlog))
+	assert.Equal(t, []string{owner.Hex()}, handled)
+
+	burns, err := store.EvmEvents().ListByName("BurnWAbel", 0)
+	require.NoError(t, err)
+	require.Len(t, burns, 1)
+	assert.Equal(t, "BurnWAbel(address,uint256)", burns[0].Signature)
+	assert.Equal(t, uint(3), burns[0].B2LogIndex)
+	assert.Equal(t, "uint256", gjson.Get(string(burns[0].Args), "amount.type").String())
+	assert.Equal(t, "5000", gjson.Get(string(burns[0].Args), "amount.value").String())
+	assert.Equal(t, owner.Hex(), gjson.Get(string(burns[0].Args), "owner.value").String())
+}
+
+func TestEventIndexer_Config(t *testing.T) {
+	events, _ := newTestEventIndexer(t, config.BridgeConfig{})
+	assert.Nil(t, events)
+
+	// the abi file replaces the bridge abi
+	file := filepath.Join(t.TempDir(), "abi.json")
+	require.NoError(t, os.WriteFile(file, []byte(`[{"type":"event","name":"Ping","inputs":[]}]`), 0o600))
+	_, err := NewEventIndexer(config.BridgeConfig{Events: []string{"BurnWAbel"}, EventABIFile: file}, nil)
+	require.ErrorIs(t, err, event.ErrUnknownEvent)
+	events, err = NewEventIndexer(config.BridgeConfig{Events: []string{"Ping"}, EventABIFile: file}, nil)
+	require.NoError(t, err)
+	assert.Len(t, events.Topics(), 1)
+}
+
+func TestEventIndexer_HandlerRollback(t *testing.T) {
+	events, store := newTestEventIndexer(t, config.BridgeConfig{Events: []string{"BurnWAbel"}})
+	fail := true
+	require.NoError(t, events.Subscribe("BurnWAbel", func(storage.Store, *model.EvmEvent, *event.Event) error {
+		if fail {
+			return assert.AnError
+		}
+		return nil
+	}))
+
+	// a failed handler rolls the stored log back, indexing it again runs the handler again
+	vlog := burnLog(t, common.HexToAddress("0xaa"), 1, 0)
+	require.ErrorIs(t, events.Index(vlog), assert.AnError)
+	burns, err := store.EvmEvents().ListByName("BurnWAbel", 0)
+	require.NoError(t, err)
+	assert.Empty(t, burns)
+
+	fail = false
+	require.NoError(t, events.Index(vlog))
+	burns, err = store.EvmEvents().ListByName("BurnWAbel", 0)
+	require.NoError(t, err)
+	assert.Len(t, burns, 1)
+}
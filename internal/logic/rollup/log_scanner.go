@@ -0,0 +1,77 @@
+package rollup
+
+import (
+	"context"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+)
+
+// LogClient b2 rpc the bridge logs are scanned with, *ethclient.Client
+type LogClient interface {
+	BlockNumber(ctx context.Context) (uint64, error)
+	FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]ethtypes.Log, error)
+}
+
+// rangeTooLargeErrors messages of the nodes refusing a log query for its results or its block range
+var rangeTooLargeErrors = []string{
+	"query returned more than",
+	"too many",
+	"limit exceeded",
+	"response size exceeded",
+	"block range",
+	"range too large",
+}
+
+// isRangeTooLarge the node refused the log query for its size, a smaller range may be answered
+func isRangeTooLarge(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, s := range rangeTooLargeErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// safeHead last block deeper than the finality distance, false while the chain is shorter
+func safeHead(latest, finality uint64) (uint64, bool) {
+	if latest < finality {
+		return 0, false
+	}
+	return latest - finality, true
+}
+
+// filterRange the logs of the blocks from the first block up to at most the last block, returns the
+// last block queried. The range is halved while the node refuses it as too large and doubled back
+// up to the max range after it is answered.
+func (bis *IndexerService) filterRange(ctx context.Context, from, to uint64, topics [][]common.Hash, addresses []common.Address) ([]ethtypes.Log, uint64, error) {
+	maxRange := max(bis.config.Bridge.RollupScan.MaxRange, 1)
+	if bis.scanRange == 0 || bis.scanRange > maxRange {
+		bis.scanRange = maxRange
+	}
+	for {
+		end := min(to, from+bis.scanRange-1)
+		logs, err := bis.ethCli.FilterLogs(ctx, ethereum.FilterQuery{
+			FromBlock: new(big.Int).SetUint64(from),
+			ToBlock:   new(big.Int).SetUint64(end),
+			Topics:    topics,
+			Addresses: addresses,
+		})
+		if err == nil {
+			if end-from+1 == bis.scanRange {
+				bis.scanRange = min(bis.scanRange*2, maxRange)
+			}
+			return logs, end, nil
+		}
+		if bis.scanRange == 1 || !isRangeTooLarge(err) {
+			return nil, 0, err
+		}
+		bis.scanRange /= 2
+		bis.log.Warnw("IndexerService log range too large, shrinking", "from", from, "to", end,
+			"range", bis.scanRange, "error", err)
+	}
+}
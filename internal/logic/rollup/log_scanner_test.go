@@ -0,0 +1,293 @@
+package rollup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/qday-io/qday-abel-bridge-indexer/config"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	logger "github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLogClient b2 rpc refusing the queries of more than maxResults logs like geth
+type fakeLogClient struct {
+	mu         sync.Mutex
+	head       uint64
+	logs       []ethtypes.Log
+	maxResults int
+	fail       error // answer of the next query
+	queries    [][2]uint64
+}
+
+func (c *fakeLogClient) BlockNumber(context.Context) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.head, nil
+}
+
+func (c *fakeLogClient) FilterLogs(_ context.Context, q ethereum.FilterQuery) ([]ethtypes.Log, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	from, to := q.FromBlock.Uint64(), q.ToBlock.Uint64()
+	c.queries = append(c.queries, [2]uint64{from, to})
+	if err := c.fail; err != nil {
+		c.fail = nil
+		return nil, err
+	}
+	var logs []ethtypes.Log
+	for _, vlog := range c.logs {
+		if vlog.BlockNumber >= from && vlog.BlockNumber <= to {
+			logs = append(logs, vlog)
+		}
+	}
+	if len(logs) > c.maxResults {
+		return nil, fmt.Errorf("query returned more than %d results", c.maxResults)
+	}
+	return logs, nil
+}
+
+// takeQueries block ranges queried since the last call
+func (c *fakeLogClient) takeQueries() [][2]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	queries := c.queries
+	c.queries = nil
+	return queries
+}
+
+func TestIndexerService_ScanLogs(t *testing.T) {
+	cfg := &config.BitcoinConfig{
+		IndexerListenAddress: "abe1vault",
+		Bridge: config.BridgeConfig{
+			Events:     []string{"BurnWAbel"},
+			RollupScan: config.RollupScanConfig{FinalityDistance: 6, MaxRange: 8},
+		},
+	}
+	events, store := newTestEventIndexer(t, cfg.Bridge)
+	require.NoError(t, store.Migrate(&model.RollupIndex{}))
+	require.NoError(t, store.Cursors().SaveRollupIndex(&model.RollupIndex{Base: model.Base{ID: 1}}))
+
+	owner := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	client := &fakeLogClient{head: 20, maxResults: 2}
+	for i, block := range []uint64{3, 4, 5, 16} {
+		vlog := burnLog(t, owner, 100, uint(i))
+		vlog.BlockNumber = block
+		client.logs = append(client.logs, vlog)
+	}
+	bis := NewRollupService(nil, cfg, store, logger.NewNopLogger())
+	bis.ethCli = client
+	require.NoError(t, bis.SetEventIndexer(events))
+
+	// the range of three logs is halved, then grows back, the blocks above 14 are not final
+	require.NoError(t, bis.ScanLogs())
+	assert.Equal(t, [][2]uint64{{1, 8}, {1, 4}, {5, 12}, {13, 14}}, client.takeQueries())
+	index, err := store.Cursors().GetRollupIndex()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(14), index.B2IndexBlock)
+	indexed, err := store.EvmEvents().ListByName("BurnWAbel", 0)
+	require.NoError(t, err)
+	assert.Len(t, indexed, 3)
+
+	// a failed range is scanned again instead of being skipped
+	client.head = 30
+	client.fail = errors.New("connection refused")
+	require.Error(t, bis.ScanLogs())
+	index, err = store.Cursors().GetRollupIndex()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(14), index.B2IndexBlock)
+
+	require.NoError(t, bis.ScanLogs())
+	assert.Equal(t, [][2]uint64{{15, 22}, {15, 22}, {23, 24}}, client.takeQueries())
+	index, err = store.Cursors().GetRollupIndex()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(24), index.B2IndexBlock)
+	indexed, err = store.EvmEvents().ListByName("BurnWAbel", 0)
+	require.NoError(t, err)
+	assert.Len(t, indexed, 4)
+}
+
+func TestIndexerService_ScanLogsSingleBlockTooLarge(t *testing.T) {
+	cfg := &config.BitcoinConfig{IndexerListenAddress: "abe1vault", Bridge: config.BridgeConfig{
+		Events: []string{"BurnWAbel"}, RollupScan: config.RollupScanConfig{MaxRange: 4}}}
+	events, store := newTestEventIndexer(t, cfg.Bridge)
+	require.NoError(t, store.Migrate(&model.RollupIndex{}))
+	require.NoError(t, store.Cursors().SaveRollupIndex(&model.RollupIndex{Base: model.Base{ID: 1}}))
+
+	owner := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	client := &fakeLogClient{head: 4, maxResults: 1}
+	for i := 0; i < 2; i++ {
+		vlog := burnLog(t, owner, 100, uint(i))
+		vlog.BlockNumber = 2
+		client.logs = append(client.logs, vlog)
+	}
+	bis := NewRollupService(nil, cfg, store, logger.NewNopLogger())
+	bis.ethCli = client
+	require.NoError(t, bis.SetEventIndexer(events))
+
+	// block 2 can not be answered, the blocks before it are saved and block 2 is not skipped
+	require.Error(t, bis.ScanLogs())
+	assert.Equal(t, [][2]uint64{{1, 4}, {1, 2}, {1, 1}, {2, 3}, {2, 2}}, client.takeQueries())
+	index, err := store.Cursors().GetRollupIndex()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), index.B2IndexBlock)
+}
+
+func TestIndexerService_ScanLogsHandlerError(t *testing.T) {
+	burn := burnLog(t, common.Address{}, 0, 0)
+	cfg := &config.BitcoinConfig{
+		IndexerListenAddress: "abe1vault",
+		Bridge: config.BridgeConfig{
+			BurnWAbel:  burn.Topics[0].Hex(),
+			RollupScan: config.RollupScanConfig{MaxRange: 8},
+		},
+	}
+	events, store := newTestEventIndexer(t, cfg.Bridge)
+	require.NoError(t, store.Migrate(&model.RollupIndex{}, &model.AbelWithdrawAddress{}))
+	require.NoError(t, store.Cursors().SaveRollupIndex(&model.RollupIndex{Base: model.Base{ID: 1}}))
+
+	owner := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	client := &fakeLogClient{head: 6, maxResults: 10}
+	for i, block := range []uint64{2, 5} {
+		vlog := burnLog(t, owner, 100, uint(i))
+		vlog.BlockNumber = block
+		client.logs = append(client.logs, vlog)
+	}
+	bis := NewRollupService(nil, cfg, store, logger.NewNopLogger())
+	bis.ethCli = client
+	require.NoError(t, bis.SetEventIndexer(events))
+
+	// the withdraws can not be stored, the cursor stays before the range
+	require.Error(t, bis.ScanLogs())
+	index, err := store.Cursors().GetRollupIndex()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), index.B2IndexBlock)
+
+	require.NoError(t, store.Migrate(&model.Withdraw{}))
+	require.NoError(t, bis.ScanLogs())
+	index, err = store.Cursors().GetRollupIndex()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(6), index.B2IndexBlock)
+	withdraws, err := store.Withdraws().ListByB2TxHash(burn.TxHash.String())
+	require.NoError(t, err)
+	assert.Len(t, withdraws, 2)
+
+	// the logs handled before are skipped when their range is scanned again
+	index.B2IndexBlock = 3
+	require.NoError(t, store.Cursors().SaveRollupIndex(index))
+	require.NoError(t, bis.ScanLogs())
+	withdraws, err = store.Withdraws().ListByB2TxHash(burn.TxHash.String())
+	require.NoError(t, err)
+	assert.Len(t, withdraws, 2)
+}
+
+// a burned amount without a source chain value is recorded as a failed withdraw, the scan goes on
+func TestIndexerService_ScanLogsUnconvertibleBurn(t *testing.T) {
+	burn := burnLog(t, common.Address{}, 0, 0)
+	cfg := &config.BitcoinConfig{
+		IndexerListenAddress: "abe1vault",
+		Bridge: config.BridgeConfig{
+			BurnWAbel:      burn.Topics[0].Hex(),
+			SourceDecimals: 7,
+			TargetDecimals: 18,
+			RollupScan:     config.RollupScanConfig{MaxRange: 8},
+		},
+	}
+	events, store := newTestEventIndexer(t, cfg.Bridge)
+	require.NoError(t, store.Migrate(&model.RollupIndex{}, &model.Withdraw{}, &model.AbelWithdrawAddress{}))
+	require.NoError(t, store.Cursors().SaveRollupIndex(&model.RollupIndex{Base: model.Base{ID: 1}}))
+
+	owner := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(11), nil)
+	client := &fakeLogClient{head: 6, maxResults: 10}
+	for i, burned := range []*big.Int{
+		new(big.Int).Mul(big.NewInt(5), unit),
+		new(big.Int).Add(new(big.Int).Mul(big.NewInt(5), unit), big.NewInt(1)),
+		new(big.Int).Mul(new(big.Int).Lsh(big.NewInt(1), 64), unit),
+	} {
+		vlog := burnLog(t, owner, 0, uint(i))
+		vlog.Data = common.LeftPadBytes(burned.Bytes(), 32)
+		vlog.BlockNumber = uint64(i + 2)
+		client.logs = append(client.logs, vlog)
+	}
+	bis := NewRollupService(nil, cfg, store, logger.NewNopLogger())
+	bis.ethCli = client
+	require.NoError(t, bis.SetEventIndexer(events))
+
+	require.NoError(t, bis.ScanLogs())
+	index, err := store.Cursors().GetRollupIndex()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(6), index.B2IndexBlock)
+	withdraws, err := store.Withdraws().ListByB2TxHash(burn.TxHash.String())
+	require.NoError(t, err)
+	require.Len(t, withdraws, 3)
+	assert.Equal(t, model.BtcTxWithdrawAddressNotFound, withdraws[0].Status)
+	assert.Equal(t, int64(5), withdraws[0].BtcValue)
+	assert.Equal(t, model.BtcTxWithdrawFailed, withdraws[1].Status)
+	assert.Contains(t, withdraws[1].Reason, "precision loss")
+	assert.Equal(t, model.BtcTxWithdrawFailed, withdraws[2].Status)
+	assert.Contains(t, withdraws[2].Reason, "overflow")
+}
+
+// a log that does not decode is parked with the reason, the logs around it are handled and the cursor moves past it
+func TestIndexerService_ScanLogsParksInvalidLog(t *testing.T) {
+	burn := burnLog(t, common.Address{}, 0, 0)
+	cfg := &config.BitcoinConfig{
+		IndexerListenAddress: "abe1vault",
+		Bridge: config.BridgeConfig{
+			BurnWAbel:      burn.Topics[0].Hex(),
+			SourceDecimals: 8,
+			TargetDecimals: 8,
+			RollupScan:     config.RollupScanConfig{MaxRange: 8},
+		},
+	}
+	events, store := newTestEventIndexer(t, cfg.Bridge)
+	require.NoError(t, store.Migrate(&model.RollupIndex{}, &model.Withdraw{}, &model.AbelWithdrawAddress{}))
+	require.NoError(t, store.Cursors().SaveRollupIndex(&model.RollupIndex{Base: model.Base{ID: 1}}))
+
+	owner := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	client := &fakeLogClient{head: 6, maxResults: 10}
+	for i := 0; i < 3; i++ {
+		vlog := burnLog(t, owner, int64(100*(i+1)), uint(i))
+		vlog.BlockNumber = uint64(i + 2)
+		client.logs = append(client.logs, vlog)
+	}
+	// the amount is cut short
+	client.logs[1].Data = client.logs[1].Data[:8]
+	bis := NewRollupService(nil, cfg, store, logger.NewNopLogger())
+	bis.ethCli = client
+	require.NoError(t, bis.SetEventIndexer(events))
+
+	require.NoError(t, bis.ScanLogs())
+	index, err := store.Cursors().GetRollupIndex()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(6), index.B2IndexBlock)
+	withdraws, err := store.Withdraws().ListByB2TxHash(burn.TxHash.String())
+	require.NoError(t, err)
+	require.Len(t, withdraws, 2)
+	assert.Equal(t, int64(100), withdraws[0].BtcValue)
+	assert.Equal(t, int64(300), withdraws[1].BtcValue)
+
+	parked, err := store.ParkedLogs().List(0)
+	require.NoError(t, err)
+	require.Len(t, parked, 1)
+	assert.Equal(t, uint64(3), parked[0].B2BlockNumber)
+	assert.Equal(t, uint(1), parked[0].B2LogIndex)
+	assert.Equal(t, burn.Topics[0].Hex(), parked[0].EventHash)
+	assert.Contains(t, parked[0].Reason, ErrInvalidLog.Error())
+
+	// the range scanned again parks the log once
+	require.NoError(t, store.Cursors().SaveRollupIndex(&model.RollupIndex{Base: model.Base{ID: 1}}))
+	require.NoError(t, bis.ScanLogs())
+	parked, err = store.ParkedLogs().List(0)
+	require.NoError(t, err)
+	assert.Len(t, parked, 1)
+}
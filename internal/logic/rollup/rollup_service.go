@@ -3,19 +3,19 @@ package rollup
 import (
 	"context"
 	"errors"
+	"fmt"
 	"math/big"
 	"time"
 
 	"github.com/qday-io/qday-abel-bridge-indexer/config"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/amount"
 	"github.com/qday-io/qday-abel-bridge-indexer/pkg/event"
-	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/shopspring/decimal"
 
 	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/storage"
 	"github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
 	"github.com/cometbft/cometbft/libs/service"
-	"gorm.io/gorm"
 
 	ethtypes "github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
@@ -31,24 +31,63 @@ const (
 type IndexerService struct {
 	service.BaseService
 
-	ethCli *ethclient.Client
-	config *config.BitcoinConfig
-	db     *gorm.DB
-	log    log.Logger
+	ethCli    LogClient
+	config    *config.BitcoinConfig
+	converter *amount.Converter
+	store     storage.Store
+	log       log.Logger
+	events    *EventIndexer // nil when no event is indexed into evm_events
+	scanRange uint64        // blocks of the next log query, shrunk while the node refuses the range
 }
 
 // NewRollupService returns a new service instance.
 func NewRollupService(
 	ethCli *ethclient.Client,
 	config *config.BitcoinConfig,
-	db *gorm.DB,
+	store storage.Store,
 	log log.Logger,
 ) *IndexerService {
-	is := &IndexerService{ethCli: ethCli, config: config, db: db, log: log}
+	is := &IndexerService{
+		ethCli:    ethCli,
+		config:    config,
+		converter: amount.NewConverter(config.Bridge.SourceDecimals, config.Bridge.TargetDecimals),
+		store:     store,
+		log:       log,
+	}
 	is.BaseService = *service.NewBaseService(nil, IndexerServiceName, is)
 	return is
 }
 
+// SetEventIndexer index the configured events of the listened contracts, the bridge events are handled
+// by the handlers subscribed here
+func (bis *IndexerService) SetEventIndexer(events *EventIndexer) error {
+	subscriptions := []struct {
+		name       string
+		configured bool
+		handler    EventHandler
+	}{
+		{DepositEventName, bis.config.Bridge.Deposit != "", func(tx storage.Store, evt *model.EvmEvent, decoded *event.Event) error {
+			return handelDepositEvent(tx, evt, decoded, bis.converter)
+		}},
+		{WithdrawEventName, bis.config.Bridge.Withdraw != "" && bis.config.Bridge.EnableWithdrawListener, func(tx storage.Store, evt *model.EvmEvent, decoded *event.Event) error {
+			return handelWithdrawEvent(tx, evt, decoded, bis.converter, bis.withdrawVault())
+		}},
+		{BurnWAbelEventName, bis.config.Bridge.BurnWAbel != "", func(tx storage.Store, evt *model.EvmEvent, decoded *event.Event) error {
+			return handelBurnWAbelEvent(tx, evt, decoded, bis.converter)
+		}},
+	}
+	for _, subscription := range subscriptions {
+		if !subscription.configured {
+			continue
+		}
+		if err := events.Subscribe(subscription.name, subscription.handler); err != nil {
+			return err
+		}
+	}
+	bis.events = events
+	return nil
+}
+
 // OnStart implements service.Service by subscribing for new blocks
 // and indexing them by events.
 func (bis *IndexerService) OnStart() error {
@@ -57,161 +96,289 @@ func (bis *IndexerService) OnStart() error {
 			bis.log.Errorw("BridgeWithdrawService panic", "error", r)
 		}
 	}()
-	if !bis.db.Migrator().HasTable(&model.RollupIndex{}) {
-		err := bis.db.AutoMigrate(&model.RollupIndex{})
-		if err != nil {
-			bis.log.Errorw("IndexerService create WithdrawIndex table", "error", err.Error())
+	if err := bis.store.Migrate(&model.RollupIndex{}); err != nil {
+		bis.log.Errorw("IndexerService create WithdrawIndex table", "error", err.Error())
+		return err
+	}
+	if bis.config.Bridge.BurnWAbel != "" {
+		if err := bis.store.Migrate(&model.AbelWithdrawAddress{}); err != nil {
+			bis.log.Errorw("IndexerService create abelian withdraw address table", "error", err.Error())
+			return err
+		}
+	}
+	if bis.events != nil {
+		if err := bis.events.Migrate(); err != nil {
+			bis.log.Errorw("IndexerService create evm events table", "error", err.Error())
 			return err
 		}
 	}
 	for {
 		// listen server scan blocks
 		time.Sleep(time.Duration(WaitHandleTime) * time.Second)
-		var currentBlock uint64 // index current block number
-		var currentTxIndex uint // index current block tx index
-		var currentLogIndex uint
-		var rollupIndex model.RollupIndex
-		if err := bis.db.First(&rollupIndex, 1).Error; err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				latestBlock, err := bis.ethCli.BlockNumber(context.Background())
-				if err != nil {
-					bis.log.Errorw("IndexerService headerByNumber is failed:", "error", err)
-					continue
-				}
-				rollupIndex = model.RollupIndex{
-					Base: model.Base{
-						ID: 1,
-					},
-					B2IndexBlock: latestBlock,
-					B2IndexTx:    0,
-					B2LogIndex:   0,
-				}
-				if err := bis.db.Create(&rollupIndex).Error; err != nil {
-					bis.log.Errorw("IndexerService create rollupIndex is failed:", "error", err)
-					continue
-				}
-			} else {
-				bis.log.Errorw("IndexerService get first rollupIndex is failed:", "error", err)
-				continue
-			}
-		}
-		currentBlock = rollupIndex.B2IndexBlock
-		currentTxIndex = rollupIndex.B2IndexTx
-		currentLogIndex = rollupIndex.B2LogIndex
-		addresses := []common.Address{
-			common.HexToAddress(bis.config.Bridge.ContractAddress),
+		// errors are logged, the blocks are scanned again next round
+		_ = bis.ScanLogs()
+	}
+}
+
+// ScanLogs index the bridge events of the blocks deeper than the finality distance, from the block after the
+// saved rollup index. A range the node failed to answer is scanned again next round instead of being skipped.
+func (bis *IndexerService) ScanLogs() error {
+	latestBlock, err := bis.ethCli.BlockNumber(context.Background())
+	if err != nil {
+		bis.log.Errorw("IndexerService HeaderByNumber is failed:", "error", err)
+		return err
+	}
+	safeBlock, ok := safeHead(latestBlock, bis.config.Bridge.RollupScan.FinalityDistance)
+	if !ok {
+		return nil
+	}
+	rollupIndex, err := bis.store.Cursors().GetRollupIndex()
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			bis.log.Errorw("IndexerService get first rollupIndex is failed:", "error", err)
+			return err
 		}
-		topics := [][]common.Hash{
-			{
-				common.HexToHash(bis.config.Bridge.Deposit),
-				common.HexToHash(bis.config.Bridge.Withdraw),
+		// the index starts at the safe block, the earlier blocks are not scanned
+		rollupIndex = &model.RollupIndex{
+			Base: model.Base{
+				ID: 1,
 			},
+			B2IndexBlock: safeBlock,
+		}
+		if err := bis.store.Cursors().SaveRollupIndex(rollupIndex); err != nil {
+			bis.log.Errorw("IndexerService create rollupIndex is failed:", "error", err)
+			return err
 		}
+	}
+	if bis.events == nil {
+		bis.log.Warnw("IndexerService no event configured, the b2 logs are not scanned")
+		return nil
+	}
+	addresses := bis.listenContracts()
+	topics := [][]common.Hash{bis.events.Topics()}
 
-		latestBlock, err := bis.ethCli.BlockNumber(context.Background())
+	bis.log.Infow("IndexerService ethClient height", "height", latestBlock, "safeBlock", safeBlock,
+		"currentBlock", rollupIndex.B2IndexBlock)
+	for rollupIndex.B2IndexBlock < safeBlock {
+		from := rollupIndex.B2IndexBlock + 1
+		logs, to, err := bis.filterRange(context.Background(), from, safeBlock, topics, addresses)
 		if err != nil {
-			bis.log.Errorw("IndexerService HeaderByNumber is failed:", "error", err)
-			continue
-		}
-		bis.log.Infow("IndexerService ethClient height", "height", latestBlock, "currentBlock", currentBlock)
-		if latestBlock == currentBlock {
-			continue
+			bis.log.Errorw("IndexerService failed to fetch logs", "from", from, "to", safeBlock, "error", err)
+			return err
 		}
-		for i := currentBlock; i <= latestBlock; i++ {
-			bis.log.Infow("IndexerService get log height:", "height", i)
-			query := ethereum.FilterQuery{
-				FromBlock: big.NewInt(0).SetUint64(i),
-				ToBlock:   big.NewInt(0).SetUint64(i),
-				Topics:    topics,
-				Addresses: addresses,
-			}
-			logs, err := bis.ethCli.FilterLogs(context.Background(), query)
-			if err != nil {
-				bis.log.Errorw("IndexerService failed to fetch block", "height", i, "error", err)
-				continue
+		bis.log.Infow("IndexerService get logs:", "from", from, "to", to, "logs", len(logs))
+		for _, vlog := range logs {
+			// the range is scanned again, the logs already handled are skipped as duplicates
+			if err := bis.handleLog(vlog); err != nil {
+				bis.log.Errorw("IndexerService failed to handle log", "block", vlog.BlockNumber, "txHash", vlog.TxHash.String(),
+					"logIndex", vlog.Index, "error", err)
+				return err
 			}
+			rollupIndex.B2IndexTx = vlog.TxIndex
+			rollupIndex.B2LogIndex = vlog.Index
+		}
+		rollupIndex.B2IndexBlock = to
+		if err := bis.store.Cursors().SaveRollupIndex(rollupIndex); err != nil {
+			bis.log.Errorw("failed to save b2 index block", "error", err, "currentBlock", to,
+				"currentTxIndex", rollupIndex.B2IndexTx, "latestBlock", latestBlock)
+			return err
+		}
+	}
+	return nil
+}
 
-			for _, vlog := range logs {
-				if currentBlock == vlog.BlockNumber && currentTxIndex == vlog.TxIndex && currentLogIndex == vlog.Index {
-					continue
-				}
-				eventHash := common.BytesToHash(vlog.Topics[0].Bytes())
-				// if eventHash == common.HexToHash(bis.config.Bridge.Withdraw) {
-				// 	err = handelWithdrawEvent(vlog, bis.db, bis.config.IndexerListenAddress)
-				// 	if err != nil {
-				// 		bis.log.Errorw("IndexerService handelWithdrawEvent err: ", "error", err)
-				// 		continue
-				// 	}
-				// }
-				if eventHash == common.HexToHash(bis.config.Bridge.Deposit) {
-					bis.log.Warnw("vlog", "vlog", vlog)
-					err = handelDepositEvent(vlog, bis.db)
-					if err != nil {
-						bis.log.Errorw("IndexerService handelDepositEvent err: ", "error", err)
-						continue
-					}
-				}
-				currentTxIndex = vlog.TxIndex
-				currentLogIndex = vlog.Index
-			}
-			currentBlock = i
-			rollupIndex.B2IndexBlock = currentBlock
-			rollupIndex.B2IndexTx = currentTxIndex
-			rollupIndex.B2LogIndex = currentLogIndex
-			if err := bis.db.Save(&rollupIndex).Error; err != nil {
-				bis.log.Errorw("failed to save b2 index block", "error", err, "currentBlock", i,
-					"currentTxIndex", currentTxIndex, "latestBlock", latestBlock)
-				continue
-			}
+// handleLog index the log of a configured event, the bridge events are handled by their subscribed handlers
+// in the transaction storing the log. A log indexed before is skipped. A log that can never be handled is
+// parked with the reason and the scan goes on, any other error fails the log so its range is scanned again.
+func (bis *IndexerService) handleLog(vlog ethtypes.Log) error {
+	if bis.events == nil || !bis.events.Handles(vlog) {
+		return nil
+	}
+	err := bis.events.Index(vlog)
+	if !errors.Is(err, ErrInvalidLog) {
+		return err
+	}
+	bis.log.Errorw("IndexerService parked a log that can not be handled", "block", vlog.BlockNumber,
+		"txHash", vlog.TxHash.String(), "logIndex", vlog.Index, "reason", err.Error())
+	return bis.events.Park(vlog, err.Error())
+}
+
+// listenContracts bridge contract and the contract of every listen route
+func (bis *IndexerService) listenContracts() []common.Address {
+	addresses := []common.Address{
+		common.HexToAddress(bis.config.Bridge.ContractAddress),
+	}
+	routes, err := bis.config.ListenRoutes()
+	if err != nil {
+		bis.log.Errorw("IndexerService load listen routes err", "error", err)
+		return addresses
+	}
+	seen := map[common.Address]struct{}{addresses[0]: {}}
+	for _, route := range routes {
+		address := common.HexToAddress(route.ContractAddress)
+		if _, ok := seen[address]; ok {
+			continue
 		}
+		seen[address] = struct{}{}
+		addresses = append(addresses, address)
+	}
+	return addresses
+}
+
+// withdrawVault multisig vault address paying the withdraws
+func (bis *IndexerService) withdrawVault() string {
+	_, vault, err := bis.config.Bridge.MultisigVault(config.ChainParams(bis.config.NetworkName))
+	if err != nil {
+		bis.log.Errorw("IndexerService load withdraw vault err", "error", err)
+		return ""
 	}
+	return vault.EncodeAddress()
 }
 
-// handelWithdrawEvent
-//
-//lint:ignore U1000 Ignore unused function temporarily for debugging
-func handelWithdrawEvent(vlog ethtypes.Log, db *gorm.DB, listenAddress string) error {
-	amount := DataToBigInt(vlog, 1)
-	destAddrStr := DataToString(vlog, 0)
+// handelWithdrawEvent record the withdraw to the destination address, the burned amount in source chain unit.
+// A burned amount with no source chain value is recorded as failed with the reason, the scan goes on.
+func handelWithdrawEvent(tx storage.Store, evt *model.EvmEvent, decoded *event.Event, converter *amount.Converter, vaultAddress string) error {
+	burned, err := argBigInt(decoded, "amount")
+	if err != nil {
+		return err
+	}
+	destAddrStr, err := argString(decoded, "toAddress")
+	if err != nil {
+		return err
+	}
+	status := model.BtcTxWithdrawPending
+	value, reason := sourceValue(converter, burned)
+	if reason != "" {
+		log.Errorw("withdraw amount has no source chain value", "to", destAddrStr, "b2TxHash", evt.B2TxHash, "reason", reason)
+		status = model.BtcTxWithdrawFailed
+	}
 	withdrawData := model.Withdraw{
-		BtcFrom:       listenAddress,
+		BtcFrom:       vaultAddress,
 		BtcTo:         destAddrStr,
-		BtcValue:      amount.Int64(),
-		B2BlockNumber: vlog.BlockNumber,
-		B2BlockHash:   vlog.BlockHash.String(),
-		B2TxHash:      vlog.TxHash.String(),
-		B2TxIndex:     vlog.TxIndex,
-		B2LogIndex:    vlog.Index,
-	}
-	if err := db.Create(&withdrawData).Error; err != nil {
+		BtcValue:      value,
+		Status:        status,
+		Reason:        reason,
+		B2BlockNumber: evt.B2BlockNumber,
+		B2BlockHash:   evt.B2BlockHash,
+		B2TxHash:      evt.B2TxHash,
+		B2TxIndex:     evt.B2TxIndex,
+		B2LogIndex:    evt.B2LogIndex,
+	}
+	// a withdraw indexed before the event was stored in evm_events is kept
+	_, err = tx.Withdraws().CreateIfAbsent(&withdrawData)
+	return err
+}
+
+// handelBurnWAbelEvent record the abelian withdraw of the burned wabel, the burned amount in source chain unit.
+// BurnWAbel carries no destination, the wabel is withdrawn to the abelian address the burner registered by a
+// signed message. Without one the withdraw waits until the burner registers an address. A burned amount
+// with no source chain value is recorded as failed with the reason, the scan goes on.
+func handelBurnWAbelEvent(tx storage.Store, evt *model.EvmEvent, decoded *event.Event, converter *amount.Converter) error {
+	ownerAddress, err := argAddress(decoded, "owner")
+	if err != nil {
 		return err
 	}
-	return nil
+	burned, err := argBigInt(decoded, "amount")
+	if err != nil {
+		return err
+	}
+	owner := ownerAddress.Hex()
+	abelTo, err := registeredAddressOf(tx, owner)
+	if err != nil {
+		return err
+	}
+	status := model.BtcTxWithdrawPending
+	if abelTo == "" {
+		log.Warnw("burner has no registered abelian withdraw address", "owner", owner, "b2TxHash", evt.B2TxHash)
+		status = model.BtcTxWithdrawAddressNotFound
+	}
+	value, reason := sourceValue(converter, burned)
+	if reason != "" {
+		log.Errorw("burn amount can not be withdrawn", "owner", owner, "b2TxHash", evt.B2TxHash, "reason", reason)
+		status = model.BtcTxWithdrawFailed
+	}
+	withdrawData := model.Withdraw{
+		BtcTo:         abelTo,
+		BtcValue:      value,
+		Status:        status,
+		Reason:        reason,
+		Chain:         model.WithdrawChainAbelian,
+		B2TxFrom:      owner,
+		B2BlockNumber: evt.B2BlockNumber,
+		B2BlockHash:   evt.B2BlockHash,
+		B2TxHash:      evt.B2TxHash,
+		B2TxIndex:     evt.B2TxIndex,
+		B2LogIndex:    evt.B2LogIndex,
+	}
+	_, err = tx.Withdraws().CreateIfAbsent(&withdrawData)
+	return err
 }
 
-func handelDepositEvent(vlog ethtypes.Log, db *gorm.DB) error {
-	Caller := event.TopicToAddress(vlog, 1).Hex()
-	ToAddress := event.TopicToAddress(vlog, 2).Hex()
-	Amount := event.DataToDecimal(vlog, 0, 0)
-	TxHash := event.DataToHash(vlog, 1)
+// sourceValue the burned amount in source chain unit, else the reason it has none: not a multiple of
+// the source chain unit or over int64
+func sourceValue(converter *amount.Converter, burned *big.Int) (int64, string) {
+	value, err := converter.ToSource(burned)
+	if err != nil {
+		return 0, fmt.Sprintf("burned amount %s: %s", burned, err.Error())
+	}
+	if !value.IsInt64() {
+		return 0, fmt.Sprintf("burned amount %s: %s", burned, amount.ErrAmountOverflow.Error())
+	}
+	return value.Int64(), ""
+}
 
-	log.Errorw("deposit event ", "Caller", Caller, "ToAddress", ToAddress, "Amount", Amount.String(), "TxHash", TxHash.String())
+// registeredAddressOf abelian withdraw address registered by the l2 address, empty if none
+func registeredAddressOf(store storage.Store, l2Address string) (string, error) {
+	address, err := store.AbelAddresses().Get(l2Address)
+	if errors.Is(err, storage.ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return address.AbelAddress, nil
+}
 
-	depositData := model.RollupDeposit{
-		BtcTxHash:        remove0xPrefix(TxHash.String()),
-		BtcFromAAAddress: ToAddress,
-		BtcValue:         Amount.Div(decimal.NewFromInt(10000000000)).BigInt().Int64(),
-		B2TxFrom:         Caller,
-		B2BlockNumber:    vlog.BlockNumber,
-		B2BlockHash:      vlog.BlockHash.String(),
-		B2TxHash:         vlog.TxHash.String(),
-		B2TxIndex:        vlog.TxIndex,
-		B2LogIndex:       vlog.Index,
-	}
-	if err := db.Create(&depositData).Error; err != nil {
+// handelDepositEvent record the rollup deposit of the minted amount, in source chain unit
+func handelDepositEvent(tx storage.Store, evt *model.EvmEvent, decoded *event.Event, converter *amount.Converter) error {
+	caller, err := argAddress(decoded, "caller")
+	if err != nil {
 		return err
 	}
-	return nil
+	toAddress, err := argAddress(decoded, "toAddress")
+	if err != nil {
+		return err
+	}
+	minted, err := argBigInt(decoded, "amount")
+	if err != nil {
+		return err
+	}
+	txHash, err := argHash(decoded, "txHash")
+	if err != nil {
+		return err
+	}
+
+	log.Errorw("deposit event ", "Caller", caller.Hex(), "ToAddress", toAddress.Hex(), "Amount", minted.String(), "TxHash", txHash.String())
+
+	value, err := converter.ToSource(minted)
+	if err != nil {
+		return fmt.Errorf("%w: minted amount %s: %s", ErrInvalidLog, minted, err.Error())
+	}
+
+	depositData := model.RollupDeposit{
+		BtcTxHash:        remove0xPrefix(txHash.String()),
+		BtcFromAAAddress: toAddress.Hex(),
+		BtcValue:         model.NewBigInt(value),
+		B2TxFrom:         caller.Hex(),
+		B2BlockNumber:    evt.B2BlockNumber,
+		B2BlockHash:      evt.B2BlockHash,
+		B2TxHash:         evt.B2TxHash,
+		B2TxIndex:        evt.B2TxIndex,
+		B2LogIndex:       evt.B2LogIndex,
+	}
+	// a deposit indexed before the event was stored in evm_events is kept
+	_, err = tx.RollupDeposits().CreateIfAbsent(&depositData)
+	return err
 }
 
 func remove0xPrefix(input string) string {
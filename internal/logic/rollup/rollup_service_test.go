@@ -0,0 +1,129 @@
+package rollup
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/qday-io/qday-abel-bridge-indexer/config"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	logger "github.com/qday-io/qday-abel-bridge-indexer/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bridgeLog log of a bridge event of config.BridgeEventsAbi, the indexed arguments as topics
+func bridgeLog(t *testing.T, name string, topics []common.Hash, args ...interface{}) ethtypes.Log {
+	t.Helper()
+	contractABI, err := abi.JSON(strings.NewReader(config.BridgeEventsAbi))
+	require.NoError(t, err)
+	data, err := contractABI.Events[name].Inputs.NonIndexed().Pack(args...)
+	require.NoError(t, err)
+	return ethtypes.Log{
+		Address:     common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		Topics:      append([]common.Hash{contractABI.Events[name].ID}, topics...),
+		Data:        data,
+		BlockNumber: 10,
+		TxHash:      common.HexToHash("0xb2"),
+	}
+}
+
+func bridgeEventHash(t *testing.T, name string) string {
+	t.Helper()
+	contractABI, err := abi.JSON(strings.NewReader(config.BridgeEventsAbi))
+	require.NoError(t, err)
+	return contractABI.Events[name].ID.Hex()
+}
+
+func TestHandelBurnWAbelEvent(t *testing.T) {
+	cfg := &config.BitcoinConfig{Bridge: config.BridgeConfig{BurnWAbel: burnLog(t, common.Address{}, 0, 0).Topics[0].Hex(),
+		SourceDecimals: 8, TargetDecimals: 8}}
+	events, store := newTestEventIndexer(t, cfg.Bridge)
+	require.NoError(t, store.Migrate(&model.Withdraw{}, &model.AbelWithdrawAddress{}, &model.Deposit{}))
+	bis := NewRollupService(nil, cfg, store, logger.NewNopLogger())
+	require.NoError(t, bis.SetEventIndexer(events))
+	owner := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+
+	// a deposit of the burner tells nothing about the destination, the withdraw waits for a registration
+	require.NoError(t, store.Deposits().Create(&model.Deposit{BtcTxHash: "a", BtcFrom: "abe1memo", BtcFromAAAddress: owner.Hex(),
+		B2TxStatus: model.DepositB2TxStatusSuccess}))
+	require.NoError(t, bis.handleLog(burnLog(t, owner, 100, 0)))
+	withdraws, err := store.Withdraws().ListByB2TxHash(common.HexToHash("0xb1").String())
+	require.NoError(t, err)
+	require.Len(t, withdraws, 1)
+	assert.Equal(t, model.BtcTxWithdrawAddressNotFound, withdraws[0].Status)
+	assert.Empty(t, withdraws[0].BtcTo)
+	assert.Equal(t, owner.Hex(), withdraws[0].B2TxFrom)
+
+	require.NoError(t, store.AbelAddresses().Save(&model.AbelWithdrawAddress{L2Address: owner.Hex(), AbelAddress: "abe1registered", Nonce: 1}))
+	require.NoError(t, bis.handleLog(burnLog(t, owner, 200, 1)))
+	withdraws, err = store.Withdraws().ListByB2TxHash(common.HexToHash("0xb1").String())
+	require.NoError(t, err)
+	require.Len(t, withdraws, 2)
+	assert.Equal(t, model.BtcTxWithdrawPending, withdraws[1].Status)
+	assert.Equal(t, "abe1registered", withdraws[1].BtcTo)
+	assert.Equal(t, int64(200), withdraws[1].BtcValue)
+
+	// a log handled before is not recorded twice
+	require.NoError(t, bis.handleLog(burnLog(t, owner, 200, 1)))
+	withdraws, err = store.Withdraws().ListByB2TxHash(common.HexToHash("0xb1").String())
+	require.NoError(t, err)
+	assert.Len(t, withdraws, 2)
+}
+
+func TestHandelBridgeEvents(t *testing.T) {
+	cfg := &config.BitcoinConfig{Bridge: config.BridgeConfig{
+		Deposit:                bridgeEventHash(t, DepositEventName),
+		Withdraw:               bridgeEventHash(t, WithdrawEventName),
+		EnableWithdrawListener: true,
+		SourceDecimals:         8,
+		TargetDecimals:         18,
+	}}
+	events, store := newTestEventIndexer(t, cfg.Bridge)
+	require.NoError(t, store.Migrate(&model.Withdraw{}, &model.RollupDeposit{}))
+	bis := NewRollupService(nil, cfg, store, logger.NewNopLogger())
+	require.NoError(t, bis.SetEventIndexer(events))
+
+	caller := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	to := common.HexToAddress("0x00000000000000000000000000000000000000bb")
+	btcTxHash := common.HexToHash("0xdd")
+	deposit := bridgeLog(t, DepositEventName, []common.Hash{common.BytesToHash(caller.Bytes()), common.BytesToHash(to.Bytes())},
+		big.NewInt(3e10), [32]byte(btcTxHash))
+	require.NoError(t, bis.handleLog(deposit))
+	rollupDeposits, err := store.RollupDeposits().ListByBtcTxHash(remove0xPrefix(btcTxHash.Hex()))
+	require.NoError(t, err)
+	require.Len(t, rollupDeposits, 1)
+	rollupDeposit := rollupDeposits[0]
+	assert.Equal(t, caller.Hex(), rollupDeposit.B2TxFrom)
+	assert.Equal(t, to.Hex(), rollupDeposit.BtcFromAAAddress)
+	assert.Equal(t, "3", rollupDeposit.BtcValue.String())
+
+	withdraw := bridgeLog(t, WithdrawEventName, []common.Hash{common.BytesToHash(caller.Bytes())}, "tb1qdest", big.NewInt(5e10))
+	withdraw.Index = 1
+	require.NoError(t, bis.handleLog(withdraw))
+	withdraws, err := store.Withdraws().ListByB2TxHash(withdraw.TxHash.String())
+	require.NoError(t, err)
+	require.Len(t, withdraws, 1)
+	assert.Equal(t, "tb1qdest", withdraws[0].BtcTo)
+	assert.Equal(t, int64(5), withdraws[0].BtcValue)
+	assert.Equal(t, uint(1), withdraws[0].B2LogIndex)
+
+	// a withdraw without a source chain value is recorded as failed
+	withdraw = bridgeLog(t, WithdrawEventName, []common.Hash{common.BytesToHash(caller.Bytes())}, "tb1qdest", big.NewInt(5e10+1))
+	withdraw.Index = 2
+	require.NoError(t, bis.handleLog(withdraw))
+	withdraws, err = store.Withdraws().ListByB2TxHash(withdraw.TxHash.String())
+	require.NoError(t, err)
+	require.Len(t, withdraws, 2)
+	assert.Equal(t, model.BtcTxWithdrawFailed, withdraws[1].Status)
+	assert.Contains(t, withdraws[1].Reason, "precision loss")
+
+	// a mint without a source chain value can never be recorded, it is rejected as invalid
+	deposit = bridgeLog(t, DepositEventName, []common.Hash{common.BytesToHash(caller.Bytes()), common.BytesToHash(to.Bytes())},
+		big.NewInt(3e10+1), [32]byte(common.HexToHash("0xde")))
+	deposit.TxHash, deposit.Index = common.HexToHash("0xb3"), 0
+	require.ErrorIs(t, events.Index(deposit), ErrInvalidLog)
+}
@@ -1,40 +1,49 @@
 package rollup
 
 import (
+	"fmt"
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
-	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/event"
 )
 
-type WithdrawEvent struct {
-	FromAddress common.Address
-	ToAddress   string
-	Amount      *big.Int
+// argAddress address argument of the decoded event
+func argAddress(decoded *event.Event, name string) (common.Address, error) {
+	value, ok := decoded.Args[name].(common.Address)
+	if !ok {
+		return common.Address{}, argTypeError(decoded, name)
+	}
+	return value, nil
 }
 
-type DepositEvent struct {
-	Sender    common.Address
-	ToAddress common.Address
-	Amount    *big.Int
+// argBigInt integer argument of the decoded event
+func argBigInt(decoded *event.Event, name string) (*big.Int, error) {
+	value, ok := decoded.Args[name].(*big.Int)
+	if !ok {
+		return nil, argTypeError(decoded, name)
+	}
+	return value, nil
 }
 
-func TopicToHash(vLog ethtypes.Log, index int64) common.Hash {
-	return common.BytesToHash(vLog.Topics[index].Bytes())
+// argString string argument of the decoded event
+func argString(decoded *event.Event, name string) (string, error) {
+	value, ok := decoded.Args[name].(string)
+	if !ok {
+		return "", argTypeError(decoded, name)
+	}
+	return value, nil
 }
 
-func TopicToAddress(vLog ethtypes.Log, index int64) common.Address {
-	return common.BytesToAddress(vLog.Topics[index].Bytes())
+// argHash bytes32 argument of the decoded event
+func argHash(decoded *event.Event, name string) (common.Hash, error) {
+	value, ok := decoded.Args[name].([32]byte)
+	if !ok {
+		return common.Hash{}, argTypeError(decoded, name)
+	}
+	return value, nil
 }
 
-func DataToBigInt(vLog ethtypes.Log, index int64) *big.Int {
-	start := 32 * index
-	return big.NewInt(0).SetBytes(vLog.Data[start : start+32])
-}
-
-func DataToString(vLog ethtypes.Log, index int64) string {
-	start := 32 * index
-	offset := big.NewInt(0).SetBytes(vLog.Data[start : start+32]).Int64()
-	length := big.NewInt(0).SetBytes(vLog.Data[offset : offset+32]).Int64()
-	return string(vLog.Data[offset+32 : offset+32+length])
+func argTypeError(decoded *event.Event, name string) error {
+	return fmt.Errorf("%w: %s argument %s: unexpected %T", ErrInvalidLog, decoded.Name, name, decoded.Args[name])
 }
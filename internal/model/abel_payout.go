@@ -0,0 +1,51 @@
+package model
+
+// payout status sequence, the statuses of the withdraw tx
+// 1.1 BtcTxWithdrawPending
+// 1.2 BtcTxWithdrawSubmitTxMsg, claimed for the transfer, left to the operator if the wallet did not answer
+// 1.3 BtcTxWithdrawBroadcastSuccess, BtcTxWithdrawStuck if not confirmed before the stuck timeout
+// 1.4 BtcTxWithdrawSuccess, also from BtcTxWithdrawStuck if the tx confirms late
+
+// AbelPayout abelian transfer of the vault wallet paying an abelian withdraw, one payout per burn event
+type AbelPayout struct {
+	Base
+	IdempotencyKey string `json:"idempotency_key" gorm:"type:varchar(256);not null;default:'';uniqueIndex;comment:burn event of the withdraw"`
+	WithdrawID     int64  `json:"withdraw_id" gorm:"not null;default:0;index;comment:withdraw_history id"`
+	B2TxHash       string `json:"b2_tx_hash" gorm:"type:varchar(256);default:'';comment:b2 burn tx hash"`
+	AbelTo         string `json:"abel_to" gorm:"type:text;default:'';comment:abelian destination address"`
+	AbelValue      int64  `json:"abel_value" gorm:"type:bigint;default:0;comment:neutrino"`
+	AbelTxHash     string `json:"abel_tx_hash" gorm:"type:varchar(256);default:'';index;comment:abelian tx hash"`
+	Status         int    `json:"status" gorm:"type:smallint;default:1;index"`
+	Reason         string `json:"reason" gorm:"type:text;default:'';comment:error reason"`
+	BroadcastTime  int64  `json:"broadcast_time" gorm:"type:bigint;default:0;comment:unix time of the transfer"`
+}
+
+type AbelPayoutColumns struct {
+	IdempotencyKey string
+	WithdrawID     string
+	B2TxHash       string
+	AbelTo         string
+	AbelValue      string
+	AbelTxHash     string
+	Status         string
+	Reason         string
+	BroadcastTime  string
+}
+
+func (AbelPayout) TableName() string {
+	return "abel_payout"
+}
+
+func (AbelPayout) Column() AbelPayoutColumns {
+	return AbelPayoutColumns{
+		IdempotencyKey: "idempotency_key",
+		WithdrawID:     "withdraw_id",
+		B2TxHash:       "b2_tx_hash",
+		AbelTo:         "abel_to",
+		AbelValue:      "abel_value",
+		AbelTxHash:     "abel_tx_hash",
+		Status:         "status",
+		Reason:         "reason",
+		BroadcastTime:  "broadcast_time",
+	}
+}
@@ -0,0 +1,31 @@
+package model
+
+// AbelWithdrawAddress abelian address the wabel burned by an l2 address is withdrawn to, registered by a
+// message signed by the l2 address. A registration replaces the previous one only with a higher nonce.
+type AbelWithdrawAddress struct {
+	Base
+	L2Address   string `json:"l2_address" gorm:"type:varchar(42);not null;default:'';uniqueIndex;comment:checksummed l2 address"`
+	AbelAddress string `json:"abel_address" gorm:"type:text;default:'';comment:abelian withdraw address"`
+	Nonce       int64  `json:"nonce" gorm:"type:bigint;default:0;comment:nonce of the signed message"`
+	Signature   string `json:"signature" gorm:"type:text;default:'';comment:personal_sign signature of the l2 address"`
+}
+
+type AbelWithdrawAddressColumns struct {
+	L2Address   string
+	AbelAddress string
+	Nonce       string
+	Signature   string
+}
+
+func (AbelWithdrawAddress) TableName() string {
+	return "abel_withdraw_address"
+}
+
+func (AbelWithdrawAddress) Column() AbelWithdrawAddressColumns {
+	return AbelWithdrawAddressColumns{
+		L2Address:   "l2_address",
+		AbelAddress: "abel_address",
+		Nonce:       "nonce",
+		Signature:   "signature",
+	}
+}
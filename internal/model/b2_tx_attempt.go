@@ -0,0 +1,50 @@
+package model
+
+const (
+	B2TxAttemptStatusPending = iota // broadcast, no receipt yet
+	B2TxAttemptStatusSuccess        // mined, receipt status 1
+	B2TxAttemptStatusFailed         // mined, reverted
+	B2TxAttemptStatusDropped        // nonce used by another mined tx of the sender
+)
+
+// B2TxAttempt every mint tx broadcast for a deposit.
+// Retries overwrite Deposit.B2TxHash, a replaced tx that still gets mined is only found here.
+type B2TxAttempt struct {
+	Base
+	DepositID    int64  `json:"deposit_id" gorm:"index;not null"`
+	BtcTxHash    string `json:"btc_tx_hash" gorm:"type:text;not null;default:'';index"`
+	B2TxHash     string `json:"b2_tx_hash" gorm:"type:text;not null;default:'';uniqueIndex"`
+	B2TxNonce    uint64 `json:"b2_tx_nonce" gorm:"default:0"`
+	B2TxFrom     string `json:"b2_tx_from" gorm:"type:text;default:''"`
+	GasPrice     BigInt `json:"gas_price" gorm:"default:0;comment:fee cap for dynamic fee txs"`
+	Status       int    `json:"status" gorm:"type:SMALLINT;default:0;index"`
+	ReceiptBlock uint64 `json:"receipt_block" gorm:"default:0"`
+}
+
+type B2TxAttemptColumns struct {
+	DepositID    string
+	BtcTxHash    string
+	B2TxHash     string
+	B2TxNonce    string
+	B2TxFrom     string
+	GasPrice     string
+	Status       string
+	ReceiptBlock string
+}
+
+func (B2TxAttempt) TableName() string {
+	return "b2_tx_attempts"
+}
+
+func (B2TxAttempt) Column() B2TxAttemptColumns {
+	return B2TxAttemptColumns{
+		DepositID:    "deposit_id",
+		BtcTxHash:    "btc_tx_hash",
+		B2TxHash:     "b2_tx_hash",
+		B2TxNonce:    "b2_tx_nonce",
+		B2TxFrom:     "b2_tx_from",
+		GasPrice:     "gas_price",
+		Status:       "status",
+		ReceiptBlock: "receipt_block",
+	}
+}
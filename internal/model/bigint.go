@@ -0,0 +1,94 @@
+package model
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// BigInt lossless amount column, numeric(78,0) fits uint256
+type BigInt struct {
+	big.Int
+}
+
+func NewBigInt(v *big.Int) BigInt {
+	var b BigInt
+	if v != nil {
+		b.Int.Set(v)
+	}
+	return b
+}
+
+func NewBigIntFromInt64(v int64) BigInt {
+	var b BigInt
+	b.Int.SetInt64(v)
+	return b
+}
+
+// Big copy as *big.Int
+func (b BigInt) Big() *big.Int {
+	return new(big.Int).Set(&b.Int)
+}
+
+func (b BigInt) String() string {
+	return b.Int.String()
+}
+
+// Value implements driver.Valuer
+func (b BigInt) Value() (driver.Value, error) {
+	return b.Int.String(), nil
+}
+
+// Scan implements sql.Scanner
+func (b *BigInt) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		b.Int.SetInt64(0)
+	case int64:
+		b.Int.SetInt64(v)
+	case []byte:
+		return b.setString(string(v))
+	case string:
+		return b.setString(v)
+	default:
+		return fmt.Errorf("unsupported big int scan type: %T", src)
+	}
+	return nil
+}
+
+func (b *BigInt) setString(s string) error {
+	// numeric sum may carry a zero scale, e.g. 100.0
+	if i := strings.IndexByte(s, '.'); i >= 0 && strings.Trim(s[i+1:], "0") == "" {
+		s = s[:i]
+	}
+	if _, ok := b.Int.SetString(s, 10); !ok {
+		return fmt.Errorf("invalid big int: %s", s)
+	}
+	return nil
+}
+
+// GormDBDataType column type per dialect
+func (BigInt) GormDBDataType(db *gorm.DB, _ *schema.Field) string {
+	switch db.Dialector.Name() {
+	case "sqlite":
+		return "text"
+	default:
+		return "numeric(78,0)"
+	}
+}
+
+func (b BigInt) MarshalJSON() ([]byte, error) {
+	return []byte(b.Int.String()), nil
+}
+
+func (b *BigInt) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "null" {
+		return nil
+	}
+	return b.setString(s)
+}
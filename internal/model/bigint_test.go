@@ -0,0 +1,47 @@
+package model_test
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBigIntScanValue(t *testing.T) {
+	max, _ := new(big.Int).SetString("115792089237316195423570985008687907853269984665640564039457584007913129639935", 10)
+	v := model.NewBigInt(max)
+
+	dv, err := v.Value()
+	require.NoError(t, err)
+	assert.Equal(t, max.String(), dv)
+
+	var scanned model.BigInt
+	require.NoError(t, scanned.Scan([]byte(max.String())))
+	assert.Equal(t, 0, scanned.Big().Cmp(max))
+
+	require.NoError(t, scanned.Scan(int64(42)))
+	assert.Equal(t, "42", scanned.String())
+
+	require.NoError(t, scanned.Scan("100.000"))
+	assert.Equal(t, "100", scanned.String())
+
+	require.NoError(t, scanned.Scan(nil))
+	assert.Equal(t, "0", scanned.String())
+
+	require.Error(t, scanned.Scan("100.5"))
+	require.Error(t, scanned.Scan(1.5))
+}
+
+func TestBigIntJSON(t *testing.T) {
+	d := model.Deposit{BtcValue: model.NewBigIntFromInt64(100)}
+	data, err := json.Marshal(d)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"btc_value":100`)
+
+	var decoded model.Deposit
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, "100", decoded.BtcValue.String())
+}
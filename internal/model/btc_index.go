@@ -3,7 +3,8 @@ package model
 type BtcIndex struct {
 	Base
 	BtcIndexBlock int64 `json:"btc_index_block" gorm:"comment:bitcoin index block"`
-	BtcIndexTx    int64 `json:"btc_index_tx" gorm:"comment:bitcoin index tx"`
+	// BtcIndexTx tx index of a block indexed in part by the earlier tx by tx indexing, 0 once a block is committed
+	BtcIndexTx int64 `json:"btc_index_tx" gorm:"comment:bitcoin index tx"`
 }
 
 func (BtcIndex) TableName() string {
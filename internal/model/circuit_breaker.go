@@ -0,0 +1,38 @@
+package model
+
+import "time"
+
+// CircuitBreaker persisted mint pause state, single row with id 1
+type CircuitBreaker struct {
+	Base
+	Paused      bool      `json:"paused" gorm:"default:false"`
+	Reason      string    `json:"reason" gorm:"type:text;default:'';comment:pause reason"`
+	TriggeredBy string    `json:"triggered_by" gorm:"type:varchar(64);default:'';comment:auto check name or operator"`
+	PausedAt    time.Time `json:"paused_at"`
+	ResumedBy   string    `json:"resumed_by" gorm:"type:varchar(64);default:''"`
+	ResumedAt   time.Time `json:"resumed_at"`
+}
+
+type CircuitBreakerColumns struct {
+	Paused      string
+	Reason      string
+	TriggeredBy string
+	PausedAt    string
+	ResumedBy   string
+	ResumedAt   string
+}
+
+func (CircuitBreaker) TableName() string {
+	return "circuit_breaker"
+}
+
+func (CircuitBreaker) Column() CircuitBreakerColumns {
+	return CircuitBreakerColumns{
+		Paused:      "paused",
+		Reason:      "reason",
+		TriggeredBy: "triggered_by",
+		PausedAt:    "paused_at",
+		ResumedBy:   "resumed_by",
+		ResumedAt:   "resumed_at",
+	}
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"math/big"
 	"time"
 )
 
@@ -23,6 +24,16 @@ const (
 	DepositB2TxStatusAAAddressNotFound                 // aa address not found,  Start process processing separately
 	DepositB2TxStatusIsPending
 	DepositB2TxStatusNonceToLow
+	DepositB2TxStatusHeld           // deposit violates policy, held for operator review
+	DepositB2TxStatusValueMismatch  // memo value differs from the value received by the vault, not minted
+	DepositB2TxStatusSeenInMempool  // tx seen in the mempool, promoted to pending once mined
+	DepositB2TxStatusMempoolExpired // tx dropped from the mempool without being mined
+)
+
+const (
+	DepositPolicyStatusPending  = iota // policy not evaluated
+	DepositPolicyStatusApproved        // policy passed or released by operator
+	DepositPolicyStatusHeld            // policy violated
 )
 
 const (
@@ -39,29 +50,43 @@ const (
 	B2CheckStatusSuccess = iota
 	B2CheckStatusPending
 	B2CheckStatusFailed
+	B2CheckStatusDoubleMint     // more than one b2 tx of the deposit succeeded
+	B2CheckStatusUnexpectedMint // a b2 tx other than the deposit b2 tx succeeded
 )
 
 type Deposit struct {
 	Base
-	BtcBlockNumber   int64     `json:"btc_block_number" gorm:"index;comment:bitcoin block number"`
-	BtcTxIndex       int64     `json:"btc_tx_index" gorm:"comment:bitcoin tx index"`
-	BtcTxHash        string    `json:"btc_tx_hash" gorm:"type:text;not null;default:'';uniqueIndex;comment:bitcoin tx hash"`
-	BtcTxType        int       `json:"btc_tx_type" gorm:"type:SMALLINT;default:0;comment:btc tx type"`
-	BtcFroms         string    `json:"btc_froms" gorm:"type:jsonb;comment:bitcoin transfer, from may be multiple"`
-	BtcFrom          string    `json:"btc_from" gorm:"type:text;not null;default:'';index"`
-	BtcTos           string    `json:"btc_tos" gorm:"type:jsonb;comment:bitcoin transfer, to may be multiple"`
-	BtcTo            string    `json:"btc_to" gorm:"type:text;not null;default:'';index"`
-	BtcFromAAAddress string    `json:"btc_from_aa_address" gorm:"type:text;default:'';comment:from aa address"`
-	BtcValue         int64     `json:"btc_value" gorm:"default:0;comment:bitcoin transfer value"`
-	B2TxFrom         string    `json:"b2_tx_from" gorm:"type:text;default:'';comment:from address"`
-	B2TxHash         string    `json:"b2_tx_hash" gorm:"type:text;not null;default:'';index;comment:b2 network tx hash"`
-	B2TxNonce        uint64    `json:"b2_tx_nonce" gorm:"default:0"`
-	B2TxStatus       int       `json:"b2_tx_status" gorm:"type:SMALLINT;default:1"`
-	B2TxRetry        int       `json:"b2_tx_retry" gorm:"type:SMALLINT;default:0"`
-	BtcBlockTime     time.Time `json:"btc_block_time"`
-	CallbackStatus   int       `json:"callback_status" gorm:"type:SMALLINT;default:0"`
-	ListenerStatus   int       `json:"listener_status" gorm:"type:SMALLINT;default:0"`
-	B2TxCheck        int       `json:"b2_tx_check" gorm:"type:SMALLINT;default:1"`
+	BtcBlockNumber   int64      `json:"btc_block_number" gorm:"index;comment:bitcoin block number"`
+	BtcTxIndex       int64      `json:"btc_tx_index" gorm:"comment:bitcoin tx index"`
+	BtcTxHash        string     `json:"btc_tx_hash" gorm:"type:text;not null;default:'';uniqueIndex;comment:bitcoin tx hash"`
+	BtcTxType        int        `json:"btc_tx_type" gorm:"type:SMALLINT;default:0;comment:btc tx type"`
+	BtcFroms         JSONText   `json:"btc_froms" gorm:"comment:bitcoin transfer, from may be multiple"`
+	BtcFrom          string     `json:"btc_from" gorm:"type:text;not null;default:'';index"`
+	BtcTos           JSONText   `json:"btc_tos" gorm:"comment:bitcoin transfer, to may be multiple"`
+	BtcTo            string     `json:"btc_to" gorm:"type:text;not null;default:'';index"`
+	BtcFromAAAddress string     `json:"btc_from_aa_address" gorm:"type:text;default:'';comment:from aa address"`
+	BtcValue         BigInt     `json:"btc_value" gorm:"default:0;comment:bitcoin transfer value, source chain unit"`
+	B2TxFrom         string     `json:"b2_tx_from" gorm:"type:text;default:'';comment:from address"`
+	B2TxHash         string     `json:"b2_tx_hash" gorm:"type:text;not null;default:'';index;comment:b2 network tx hash"`
+	B2TxNonce        uint64     `json:"b2_tx_nonce" gorm:"default:0"`
+	B2TxStatus       int        `json:"b2_tx_status" gorm:"type:SMALLINT;default:1"`
+	B2TxRetry        int        `json:"b2_tx_retry" gorm:"type:SMALLINT;default:0"`
+	BtcBlockTime     time.Time  `json:"btc_block_time"`
+	CallbackStatus   int        `json:"callback_status" gorm:"type:SMALLINT;default:0"`
+	ListenerStatus   int        `json:"listener_status" gorm:"type:SMALLINT;default:0"`
+	B2TxCheck        int        `json:"b2_tx_check" gorm:"type:SMALLINT;default:1"`
+	PolicyStatus     int        `json:"policy_status" gorm:"type:SMALLINT;default:0"`
+	HoldReason       string     `json:"hold_reason" gorm:"type:text;default:'';comment:policy hold or value mismatch reason"`
+	BtcFee           BigInt     `json:"btc_fee" gorm:"default:0;comment:bridge fee deducted from btc_value"`
+	FeeTxHash        string     `json:"fee_tx_hash" gorm:"type:text;default:'';comment:b2 network treasury fee tx hash"`
+	FeeTxStatus      int        `json:"fee_tx_status" gorm:"type:SMALLINT;default:1"`
+	FeeTxNonce       uint64     `json:"fee_tx_nonce" gorm:"default:0"`
+	FeeTxRaw         string     `json:"fee_tx_raw" gorm:"type:text;default:'';comment:signed treasury fee tx, recorded before it is sent"`
+	FeeTxRetry       int        `json:"fee_tx_retry" gorm:"type:SMALLINT;default:0"`
+	EligibleAtHeight int64      `json:"eligible_at_height" gorm:"index;default:0;comment:bitcoin block height at which the tx reaches the target confirmations"`
+	MintedAt         *time.Time `json:"minted_at" gorm:"index;comment:time the b2 tx status became success"`
+	ApprovedAt       *time.Time `json:"approved_at" gorm:"index;comment:time the policy or the operator approved the deposit"`
+	FlaggedAt        *time.Time `json:"flagged_at" gorm:"index;comment:time the b2 tx check failed or found an unexpected mint"`
 }
 
 type DepositColumns struct {
@@ -84,6 +109,32 @@ type DepositColumns struct {
 	CallbackStatus   string
 	ListenerStatus   string
 	B2TxCheck        string
+	PolicyStatus     string
+	HoldReason       string
+	BtcFee           string
+	FeeTxHash        string
+	FeeTxStatus      string
+	FeeTxNonce       string
+	FeeTxRaw         string
+	FeeTxRetry       string
+	EligibleAtHeight string
+	MintedAt         string
+	ApprovedAt       string
+	FlaggedAt        string
+}
+
+// MintValue amount to mint, btc_value minus the bridge fee, source chain unit
+func (d Deposit) MintValue() *big.Int {
+	return new(big.Int).Sub(&d.BtcValue.Int, &d.BtcFee.Int)
+}
+
+// EligibleAt block height at which a tx in the block reaches the target confirmations,
+// the block itself counts as the first confirmation
+func EligibleAt(btcBlockNumber int64, targetConfirmations uint64) int64 {
+	if targetConfirmations == 0 {
+		return btcBlockNumber
+	}
+	return btcBlockNumber + int64(targetConfirmations) - 1
 }
 
 func (Deposit) TableName() string {
@@ -111,5 +162,17 @@ func (Deposit) Column() DepositColumns {
 		CallbackStatus:   "callback_status",
 		ListenerStatus:   "listener_status",
 		B2TxCheck:        "b2_tx_check",
+		PolicyStatus:     "policy_status",
+		HoldReason:       "hold_reason",
+		BtcFee:           "btc_fee",
+		FeeTxHash:        "fee_tx_hash",
+		FeeTxStatus:      "fee_tx_status",
+		FeeTxNonce:       "fee_tx_nonce",
+		FeeTxRaw:         "fee_tx_raw",
+		FeeTxRetry:       "fee_tx_retry",
+		EligibleAtHeight: "eligible_at_height",
+		MintedAt:         "minted_at",
+		ApprovedAt:       "approved_at",
+		FlaggedAt:        "flagged_at",
 	}
 }
@@ -0,0 +1,83 @@
+package model
+
+const (
+	DepositEventIndexed      = "indexed"       // deposit recorded from a block or the mempool
+	DepositEventStatus       = "status"        // b2 tx status changed
+	DepositEventBroadcast    = "broadcast"     // mint tx sent to the rollup
+	DepositEventReceipt      = "receipt"       // mint tx receipt observed
+	DepositEventFeeBroadcast = "fee_broadcast" // treasury fee tx sent to the rollup
+	DepositEventFeeReceipt   = "fee_receipt"   // treasury fee tx receipt observed
+	DepositEventCheck        = "check"         // mint checked against the rollup deposit event
+)
+
+// DepositEvent append-only audit trail of a deposit, written in the transaction changing the deposit.
+// Every broadcast tx is kept, a replaced tx is still found by its own broadcast event.
+type DepositEvent struct {
+	Base
+	DepositID  int64  `json:"deposit_id" gorm:"index;not null"`
+	BtcTxHash  string `json:"btc_tx_hash" gorm:"type:text;not null;default:'';index"`
+	Event      string `json:"event" gorm:"type:varchar(32);not null;default:''"`
+	FromStatus int    `json:"from_status" gorm:"type:SMALLINT;default:0;comment:b2 tx status before the event"`
+	ToStatus   int    `json:"to_status" gorm:"type:SMALLINT;default:0;comment:b2 tx status after the event"`
+	// B2TxHash rollup tx of the event, the fee tx for fee events
+	B2TxHash       string `json:"b2_tx_hash" gorm:"type:text;default:'';index"`
+	B2TxNonce      uint64 `json:"b2_tx_nonce" gorm:"default:0"`
+	GasPrice       BigInt `json:"gas_price" gorm:"default:0;comment:broadcast tx gas price, fee cap for dynamic fee txs"`
+	ReplacedTxHash string `json:"replaced_tx_hash" gorm:"type:text;default:'';comment:tx replaced by the broadcast tx"`
+	ReceiptStatus  uint64 `json:"receipt_status" gorm:"default:0"`
+	ReceiptBlock   uint64 `json:"receipt_block" gorm:"default:0"`
+	B2TxCheck      int    `json:"b2_tx_check" gorm:"type:SMALLINT;default:0"`
+	Error          string `json:"error" gorm:"type:text;default:'';comment:error or hold reason of the event"`
+}
+
+type DepositEventColumns struct {
+	DepositID      string
+	BtcTxHash      string
+	Event          string
+	FromStatus     string
+	ToStatus       string
+	B2TxHash       string
+	B2TxNonce      string
+	GasPrice       string
+	ReplacedTxHash string
+	ReceiptStatus  string
+	ReceiptBlock   string
+	B2TxCheck      string
+	Error          string
+}
+
+// NewDepositEvent event of the deposit moving from fromStatus to its current status
+func NewDepositEvent(deposit *Deposit, event string, fromStatus int) *DepositEvent {
+	return &DepositEvent{
+		DepositID:  deposit.ID,
+		BtcTxHash:  deposit.BtcTxHash,
+		Event:      event,
+		FromStatus: fromStatus,
+		ToStatus:   deposit.B2TxStatus,
+		B2TxHash:   deposit.B2TxHash,
+		B2TxNonce:  deposit.B2TxNonce,
+		B2TxCheck:  deposit.B2TxCheck,
+	}
+}
+
+func (DepositEvent) TableName() string {
+	return "deposit_events"
+}
+
+func (DepositEvent) Column() DepositEventColumns {
+	return DepositEventColumns{
+		DepositID:      "deposit_id",
+		BtcTxHash:      "btc_tx_hash",
+		Event:          "event",
+		FromStatus:     "from_status",
+		ToStatus:       "to_status",
+		B2TxHash:       "b2_tx_hash",
+		B2TxNonce:      "b2_tx_nonce",
+		GasPrice:       "gas_price",
+		ReplacedTxHash: "replaced_tx_hash",
+		ReceiptStatus:  "receipt_status",
+		ReceiptBlock:   "receipt_block",
+		B2TxCheck:      "b2_tx_check",
+		Error:          "error",
+	}
+}
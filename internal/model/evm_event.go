@@ -0,0 +1,48 @@
+package model
+
+// EvmEvent log of a listened contract decoded by the abi, keyed by the b2 tx hash and log index
+type EvmEvent struct {
+	Base
+	Contract      string   `json:"contract" gorm:"type:varchar(42);default:'';index;comment:emitting contract"`
+	EventName     string   `json:"event_name" gorm:"type:varchar(256);default:'';index"`
+	EventHash     string   `json:"event_hash" gorm:"type:varchar(66);default:'';comment:first topic"`
+	Signature     string   `json:"signature" gorm:"type:text;default:'';comment:canonical event signature"`
+	Args          JSONText `json:"args" gorm:"comment:arguments by name with their abi types"`
+	B2BlockNumber uint64   `json:"b2_block_number" gorm:"type:bigint;index;comment:b2 block number"`
+	B2BlockHash   string   `json:"b2_block_hash" gorm:"type:varchar(256);default:'';comment:b2 block hash"`
+	B2TxHash      string   `json:"b2_tx_hash" gorm:"type:varchar(256);not null;default:'';uniqueIndex:idx_evm_event_log;comment:b2 network tx hash"`
+	B2TxIndex     uint     `json:"b2_tx_index" gorm:"type:bigint;comment:b2 tx index"`
+	B2LogIndex    uint     `json:"b2_log_index" gorm:"type:int;not null;default:0;uniqueIndex:idx_evm_event_log;comment:b2 log index"`
+}
+
+type EvmEventColumns struct {
+	Contract      string
+	EventName     string
+	EventHash     string
+	Signature     string
+	Args          string
+	B2BlockNumber string
+	B2BlockHash   string
+	B2TxHash      string
+	B2TxIndex     string
+	B2LogIndex    string
+}
+
+func (EvmEvent) TableName() string {
+	return "evm_events"
+}
+
+func (EvmEvent) Column() EvmEventColumns {
+	return EvmEventColumns{
+		Contract:      "contract",
+		EventName:     "event_name",
+		EventHash:     "event_hash",
+		Signature:     "signature",
+		Args:          "args",
+		B2BlockNumber: "b2_block_number",
+		B2BlockHash:   "b2_block_hash",
+		B2TxHash:      "b2_tx_hash",
+		B2TxIndex:     "b2_tx_index",
+		B2LogIndex:    "b2_log_index",
+	}
+}
@@ -1,12 +1,19 @@
 package model
 
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/qday-io/qday-abel-bridge-indexer/config"
+)
+
 type BitcoinTxParseResult struct {
 	// from is l2 user address, by parse bitcoin get the address
 	From []BitcoinFrom
 	// to is listening address
 	To string
-	// value is from transfer amount
-	Value int64
+	// value is from transfer amount, source chain unit
+	Value *big.Int
 	// tx_id is the btc transaction id
 	TxID string
 	// tx_type is the type of the transaction, eg. "brc20_transfer","transfer"
@@ -15,6 +22,19 @@ type BitcoinTxParseResult struct {
 	Index int64
 	// tos tx all to info
 	Tos []BitcoinTo
+	// route of the listen address the tx is sent to
+	Route *config.ListenRoute
+	// received_value is the value the vault wallet actually received, nil if not verified
+	ReceivedValue *big.Int
+}
+
+// ValueMismatch reason if the declared value differs from the received value,
+// empty if they match or the value is not verified
+func (r *BitcoinTxParseResult) ValueMismatch() string {
+	if r.ReceivedValue == nil || (r.Value != nil && r.Value.Cmp(r.ReceivedValue) == 0) {
+		return ""
+	}
+	return fmt.Sprintf("declared value %s, received value %s", r.Value, r.ReceivedValue)
 }
 
 type BitcoinFrom struct {
@@ -23,7 +43,7 @@ type BitcoinFrom struct {
 
 type BitcoinTo struct {
 	Address string
-	Value   int64
+	Value   *big.Int
 	Memo    any
 }
 
@@ -0,0 +1,42 @@
+package model
+
+import (
+	"database/sql/driver"
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// JSONText json document column, jsonb on postgres
+type JSONText string
+
+// Value implements driver.Valuer
+func (j JSONText) Value() (driver.Value, error) {
+	return string(j), nil
+}
+
+// Scan implements sql.Scanner
+func (j *JSONText) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*j = ""
+	case []byte:
+		*j = JSONText(v)
+	case string:
+		*j = JSONText(v)
+	default:
+		return fmt.Errorf("unsupported json scan type: %T", src)
+	}
+	return nil
+}
+
+// GormDBDataType column type per dialect
+func (JSONText) GormDBDataType(db *gorm.DB, _ *schema.Field) string {
+	switch db.Dialector.Name() {
+	case "postgres":
+		return "jsonb"
+	default:
+		return "text"
+	}
+}
@@ -0,0 +1,49 @@
+package model
+
+// ParkedLog log of a listened contract that can never be handled, kept with the reason so the scan goes on
+// past it. Keyed by the b2 tx hash and log index
+type ParkedLog struct {
+	Base
+	Contract      string `json:"contract" gorm:"type:varchar(42);default:'';comment:emitting contract"`
+	EventHash     string `json:"event_hash" gorm:"type:varchar(66);default:'';comment:first topic"`
+	Topics        string `json:"topics" gorm:"type:text;default:'';comment:topics, comma separated hex"`
+	Data          string `json:"data" gorm:"type:text;default:'';comment:log data hex"`
+	Reason        string `json:"reason" gorm:"type:text;default:'';comment:why the log can not be handled"`
+	B2BlockNumber uint64 `json:"b2_block_number" gorm:"type:bigint;index;comment:b2 block number"`
+	B2BlockHash   string `json:"b2_block_hash" gorm:"type:varchar(256);default:'';comment:b2 block hash"`
+	B2TxHash      string `json:"b2_tx_hash" gorm:"type:varchar(256);not null;default:'';uniqueIndex:idx_parked_log;comment:b2 network tx hash"`
+	B2TxIndex     uint   `json:"b2_tx_index" gorm:"type:bigint;comment:b2 tx index"`
+	B2LogIndex    uint   `json:"b2_log_index" gorm:"type:int;not null;default:0;uniqueIndex:idx_parked_log;comment:b2 log index"`
+}
+
+type ParkedLogColumns struct {
+	Contract      string
+	EventHash     string
+	Topics        string
+	Data          string
+	Reason        string
+	B2BlockNumber string
+	B2BlockHash   string
+	B2TxHash      string
+	B2TxIndex     string
+	B2LogIndex    string
+}
+
+func (ParkedLog) TableName() string {
+	return "parked_logs"
+}
+
+func (ParkedLog) Column() ParkedLogColumns {
+	return ParkedLogColumns{
+		Contract:      "contract",
+		EventHash:     "event_hash",
+		Topics:        "topics",
+		Data:          "data",
+		Reason:        "reason",
+		B2BlockNumber: "b2_block_number",
+		B2BlockHash:   "b2_block_hash",
+		B2TxHash:      "b2_tx_hash",
+		B2TxIndex:     "b2_tx_index",
+		B2LogIndex:    "b2_log_index",
+	}
+}
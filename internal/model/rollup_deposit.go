@@ -4,7 +4,7 @@ type RollupDeposit struct {
 	Base
 	BtcTxHash        string `json:"btc_tx_hash" gorm:"type:varchar(64);not null;default:'';comment:bitcoin tx hash"`
 	BtcFromAAAddress string `json:"btc_from_aa_address" gorm:"type:varchar(42);default:'';comment:from aa address"`
-	BtcValue         int64  `json:"btc_value" gorm:"type:bigint;default:0;comment:bitcoin transfer value"`
+	BtcValue         BigInt `json:"btc_value" gorm:"default:0;comment:bitcoin transfer value, source chain unit"`
 	B2BlockNumber    uint64 `json:"b2_block_number" gorm:"type:bigint;comment:b2 block number"`
 	B2BlockHash      string `json:"b2_block_hash" gorm:"type:varchar(256);comment:b2 block hash"`
 	B2TxFrom         string `json:"b2_tx_from" gorm:"type:varchar(42);default:'';comment:from address"`
@@ -0,0 +1,11 @@
+package model
+
+// VaultIndex last bitcoin block scanned for the vault utxos
+type VaultIndex struct {
+	Base
+	BtcIndexBlock int64 `json:"btc_index_block" gorm:"comment:bitcoin index block"`
+}
+
+func (VaultIndex) TableName() string {
+	return "vault_index"
+}
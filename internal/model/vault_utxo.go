@@ -0,0 +1,51 @@
+package model
+
+const (
+	VaultUtxoUnspent  = iota // confirmed, free for coin selection
+	VaultUtxoReserved        // input of a withdraw tx not mined yet
+	VaultUtxoSpent           // spent by a mined tx
+)
+
+// VaultUtxo output paying the withdraw vault, tracked from the scanned bitcoin blocks
+type VaultUtxo struct {
+	Base
+	TxID        string `json:"tx_id" gorm:"type:varchar(64);not null;default:'';uniqueIndex:idx_vault_utxo_outpoint"`
+	Vout        uint32 `json:"vout" gorm:"not null;default:0;uniqueIndex:idx_vault_utxo_outpoint"`
+	Value       int64  `json:"value" gorm:"type:bigint;default:0;comment:satoshi"`
+	PkScript    string `json:"pk_script" gorm:"type:text;default:'';comment:hex output script"`
+	Address     string `json:"address" gorm:"type:varchar(256);default:'';index"`
+	BlockHeight int64  `json:"block_height" gorm:"type:bigint;default:0"`
+	Status      int    `json:"status" gorm:"type:smallint;default:0;index"`
+	ReservedBy  string `json:"reserved_by" gorm:"type:varchar(64);default:'';index;comment:withdraw btc tx id"`
+	SpentBy     string `json:"spent_by" gorm:"type:varchar(64);default:'';comment:spending btc tx id"`
+}
+
+type VaultUtxoColumns struct {
+	TxID        string
+	Vout        string
+	Value       string
+	PkScript    string
+	Address     string
+	BlockHeight string
+	Status      string
+	ReservedBy  string
+	SpentBy     string
+}
+
+func (VaultUtxo) TableName() string {
+	return "vault_utxos"
+}
+
+func (VaultUtxo) Column() VaultUtxoColumns {
+	return VaultUtxoColumns{
+		TxID:        "tx_id",
+		Vout:        "vout",
+		Value:       "value",
+		PkScript:    "pk_script",
+		Address:     "address",
+		BlockHeight: "block_height",
+		Status:      "status",
+		ReservedBy:  "reserved_by",
+		SpentBy:     "spent_by",
+	}
+}
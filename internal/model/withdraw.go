@@ -9,6 +9,11 @@ import "github.com/btcsuite/btcd/wire"
 // 1.4 BtcTxWithdrawBroadcastSuccess/BtcTxWithdrawBroadcastFailed
 // 1.5 BtcTxWithdrawConfirmed
 // 1.6 BtcTxWithdrawSuccess/BtcTxWithdrawFailed
+// a withdraw tx superseded by a confirmed replacement of the same withdraws is BtcTxWithdrawReplaced
+// an abelian withdraw burned by an address without a known abelian address is BtcTxWithdrawAddressNotFound
+// a withdraw over the batch max value is BtcTxWithdrawHeld until an operator releases it,
+// a withdraw no tx can pay, to an invalid address or below the dust threshold, is BtcTxWithdrawFailed
+// an abelian payout not confirmed before its deadline is BtcTxWithdrawStuck until an operator checks it
 const (
 	BtcTxWithdrawPending = iota + 1
 	BtcTxWithdrawSuccess
@@ -18,6 +23,16 @@ const (
 	BtcTxWithdrawBroadcastSuccess
 	BtcTxWithdrawBroadcastFailed
 	BtcTxWithdrawConfirmed
+	BtcTxWithdrawReplaced
+	BtcTxWithdrawAddressNotFound
+	BtcTxWithdrawHeld
+	BtcTxWithdrawStuck
+)
+
+// destination chains of the withdraws
+const (
+	WithdrawChainBitcoin = "bitcoin" // Withdraw event, paid by the multisig withdraw txs
+	WithdrawChainAbelian = "abelian" // BurnWAbel event, paid by the abelian payouts
 )
 
 type Withdraw struct {
@@ -27,10 +42,13 @@ type Withdraw struct {
 	BtcValue      int64  `json:"btc_value" gorm:"type:bigint;default:0;comment:bitcoin transfer value"`
 	B2BlockNumber uint64 `json:"b2_block_number" gorm:"type:bigint;comment:b2 block number"`
 	B2BlockHash   string `json:"b2_block_hash" gorm:"type:varchar(256);comment:b2 block hash"`
-	B2TxHash      string `json:"b2_tx_hash" gorm:"type:varchar(256);default:'';uniqueIndex;comment:b2 network tx hash"`
+	B2TxHash      string `json:"b2_tx_hash" gorm:"type:varchar(256);default:'';uniqueIndex:idx_withdraw_history_b2_log,priority:1;comment:b2 network tx hash"`
 	B2TxIndex     uint   `json:"b2_tx_index" gorm:"type:bigint;comment:b2 tx index"`
-	B2LogIndex    uint   `json:"b2_log_index" gorm:"type:int;comment:b2 log index"`
+	B2LogIndex    uint   `json:"b2_log_index" gorm:"type:int;default:0;uniqueIndex:idx_withdraw_history_b2_log,priority:2;comment:b2 log index"`
 	Status        int    `json:"status" gorm:"type:smallint;default:1"`
+	Chain         string `json:"chain" gorm:"type:varchar(32);default:'bitcoin';index;comment:destination chain"`
+	B2TxFrom      string `json:"b2_tx_from" gorm:"type:varchar(256);default:'';comment:b2 burner address"`
+	Reason        string `json:"reason" gorm:"type:text;default:'';comment:why the withdraw is held or failed"`
 }
 
 type Sign struct {
@@ -92,6 +110,9 @@ type WithdrawColumns struct {
 	B2BlockNumber string
 	B2LogIndex    string
 	Status        string
+	Chain         string
+	B2TxFrom      string
+	Reason        string
 }
 
 func (Withdraw) TableName() string {
@@ -110,5 +131,8 @@ func (Withdraw) Column() WithdrawColumns {
 		B2BlockNumber: "b2_block_number",
 		B2LogIndex:    "b2_log_index",
 		Status:        "status",
+		Chain:         "chain",
+		B2TxFrom:      "b2_tx_from",
+		Reason:        "reason",
 	}
 }
@@ -8,6 +8,10 @@ type WithdrawTx struct {
 	BtcTxHash  string `json:"btc_txHash" gorm:"type:varchar(256);default:'';comment:bitcoin tx hash"`
 	Status     int    `json:"status" gorm:"type:smallint;default:1"`
 	Reason     string `json:"reason" gorm:"type:varchar(256);default:'';comment:error reason"`
+	// replace by fee, the replacements of a tx pay the same B2TxHashes
+	ReplacesTxID   string `json:"replaces_tx_id" gorm:"type:varchar(256);default:'';comment:bitcoin tx id replaced by this tx"`
+	ReplacedByTxID string `json:"replaced_by_tx_id" gorm:"type:varchar(256);default:'';comment:bitcoin tx id of the replacement"`
+	BroadcastTime  int64  `json:"broadcast_time" gorm:"type:bigint;default:0;comment:unix time of the broadcast"`
 }
 
 type WithdrawTxColumns struct {
@@ -17,6 +21,10 @@ type WithdrawTxColumns struct {
 	BtcTxHash  string
 	Status     string
 	Reason     string
+	// replace by fee
+	ReplacesTxID   string
+	ReplacedByTxID string
+	BroadcastTime  string
 }
 
 func (WithdrawTx) TableName() string {
@@ -31,5 +39,9 @@ func (WithdrawTx) Column() WithdrawTxColumns {
 		BtcTxHash:  "btc_tx_hash",
 		Status:     "status",
 		Reason:     "reason",
+		// replace by fee
+		ReplacesTxID:   "replaces_tx_id",
+		ReplacedByTxID: "replaced_by_tx_id",
+		BroadcastTime:  "broadcast_time",
 	}
 }
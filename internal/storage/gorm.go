@@ -0,0 +1,897 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// gormStore repositories shared by the dialects, isDuplicate classifies the dialect errors
+// and sum adds up a big int column, less another one if not empty, over the rows of a query
+type gormStore struct {
+	db          *gorm.DB
+	isDuplicate func(error) bool
+	sum         func(query *gorm.DB, column, less string) (*big.Int, error)
+}
+
+func (s *gormStore) Deposits() DepositRepository              { return &depositRepo{s} }
+func (s *gormStore) DepositEvents() DepositEventRepository    { return &depositEventRepo{s} }
+func (s *gormStore) B2TxAttempts() B2TxAttemptRepository      { return &b2TxAttemptRepo{s} }
+func (s *gormStore) Cursors() CursorRepository                { return &cursorRepo{s} }
+func (s *gormStore) RollupDeposits() RollupDepositRepository  { return &rollupDepositRepo{s} }
+func (s *gormStore) Withdraws() WithdrawRepository            { return &withdrawRepo{s} }
+func (s *gormStore) VaultUTXOs() VaultUTXORepository          { return &vaultUTXORepo{s} }
+func (s *gormStore) AbelPayouts() AbelPayoutRepository        { return &abelPayoutRepo{s} }
+func (s *gormStore) AbelAddresses() AbelAddressRepository     { return &abelAddressRepo{s} }
+func (s *gormStore) EvmEvents() EvmEventRepository            { return &evmEventRepo{s} }
+func (s *gormStore) ParkedLogs() ParkedLogRepository          { return &parkedLogRepo{s} }
+func (s *gormStore) WithdrawTxs() WithdrawTxRepository        { return &withdrawTxRepo{s} }
+func (s *gormStore) CircuitBreaker() CircuitBreakerRepository { return &circuitBreakerRepo{s} }
+
+func (s *gormStore) Transaction(fn func(Store) error) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&gormStore{db: tx, isDuplicate: s.isDuplicate, sum: s.sum})
+	})
+}
+
+func (s *gormStore) Migrate(tables ...interface{}) error {
+	for _, table := range tables {
+		if err := s.migrateLegacy(table); err != nil {
+			return err
+		}
+		if err := s.db.AutoMigrate(table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// legacyWithdrawIndex unique index of withdraw_history on the b2 tx hash alone, a second event of a tx was dropped
+const legacyWithdrawIndex = "idx_withdraw_history_b2_tx_hash"
+
+// migrateLegacy change what AutoMigrate leaves alone in the existing tables before it adds the new columns and indexes
+func (s *gormStore) migrateLegacy(table interface{}) error {
+	if _, ok := table.(*model.Withdraw); !ok {
+		return nil
+	}
+	migrator := s.db.Migrator()
+	if !migrator.HasTable(table) {
+		return nil
+	}
+	if migrator.HasIndex(table, legacyWithdrawIndex) {
+		if err := migrator.DropIndex(table, legacyWithdrawIndex); err != nil {
+			return err
+		}
+	}
+	// the rows without a log index join the unique index on the b2 tx hash and log index
+	return s.db.Model(&model.Withdraw{}).
+		Where(fmt.Sprintf("%s IS NULL", model.Withdraw{}.Column().B2LogIndex)).
+		Update(model.Withdraw{}.Column().B2LogIndex, 0).Error
+}
+
+// translate map dialect errors to the storage errors
+func (s *gormStore) translate(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return fmt.Errorf("%w: %s", ErrNotFound, err.Error())
+	case s.isDuplicate(err):
+		return fmt.Errorf("%w: %s", ErrDuplicate, err.Error())
+	default:
+		return err
+	}
+}
+
+type depositRepo struct{ s *gormStore }
+
+func (r *depositRepo) Create(deposit *model.Deposit) error {
+	return r.s.translate(r.s.db.Create(deposit).Error)
+}
+
+func (r *depositRepo) CreateIfAbsent(deposit *model.Deposit) (bool, error) {
+	result := r.s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: model.Deposit{}.Column().BtcTxHash}},
+		DoNothing: true,
+	}).Create(deposit)
+	if result.Error != nil {
+		return false, r.s.translate(result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *depositRepo) GetByBtcTxHash(btcTxHash string) (*model.Deposit, error) {
+	var deposit model.Deposit
+	err := r.s.db.First(&deposit,
+		fmt.Sprintf("%s = ?", model.Deposit{}.Column().BtcTxHash),
+		btcTxHash).Error
+	if err != nil {
+		return nil, r.s.translate(err)
+	}
+	return &deposit, nil
+}
+
+func (r *depositRepo) ListByStatus(statuses []int, limit int) ([]*model.Deposit, error) {
+	var deposits []*model.Deposit
+	query := r.s.db.
+		Where(
+			fmt.Sprintf("%s.%s IN (?)", model.Deposit{}.TableName(), model.Deposit{}.Column().B2TxStatus),
+			statuses,
+		).
+		Order(fmt.Sprintf("%s.%s ASC", model.Deposit{}.TableName(), model.Deposit{}.Column().BtcBlockNumber)).
+		Order(fmt.Sprintf("%s.%s ASC", model.Deposit{}.TableName(), "id"))
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if err := query.Find(&deposits).Error; err != nil {
+		return nil, r.s.translate(err)
+	}
+	return deposits, nil
+}
+
+func (r *depositRepo) List(q DepositQuery) ([]*model.Deposit, error) {
+	var deposits []*model.Deposit
+	column := func(name string) string {
+		return fmt.Sprintf("%s.%s", model.Deposit{}.TableName(), name)
+	}
+	query := r.s.db.Model(&model.Deposit{})
+	if len(q.Statuses) > 0 {
+		query = query.Where(fmt.Sprintf("%s IN (?)", column(model.Deposit{}.Column().B2TxStatus)), q.Statuses)
+	}
+	if len(q.FeeStatuses) > 0 {
+		query = query.
+			Where(fmt.Sprintf("%s IN (?)", column(model.Deposit{}.Column().FeeTxStatus)), q.FeeStatuses).
+			Where(fmt.Sprintf("%s > 0", column(model.Deposit{}.Column().BtcFee)))
+	}
+	if len(q.Checks) > 0 {
+		query = query.Where(fmt.Sprintf("%s IN (?)", column(model.Deposit{}.Column().B2TxCheck)), q.Checks)
+	}
+	if len(q.ExcludeChecks) > 0 {
+		query = query.Where(fmt.Sprintf("%s NOT IN (?)", column(model.Deposit{}.Column().B2TxCheck)), q.ExcludeChecks)
+	}
+	if q.Notified {
+		query = query.
+			Where(fmt.Sprintf("%s = ?", column(model.Deposit{}.Column().CallbackStatus)), model.CallbackStatusSuccess).
+			Where(fmt.Sprintf("%s = ?", column(model.Deposit{}.Column().ListenerStatus)), model.ListenerStatusSuccess)
+	}
+	if q.MaxRetry > 0 {
+		query = query.Where(fmt.Sprintf("%s <= ?", column(model.Deposit{}.Column().B2TxRetry)), q.MaxRetry)
+	}
+	if q.EligibleAt != nil {
+		query = query.Where(fmt.Sprintf("%s <= ?", column(model.Deposit{}.Column().EligibleAtHeight)), *q.EligibleAt)
+	}
+	if len(q.BtcTxHashes) > 0 {
+		query = query.Where(fmt.Sprintf("%s IN (?)", column(model.Deposit{}.Column().BtcTxHash)), q.BtcTxHashes)
+	}
+	if !q.CreatedFrom.IsZero() {
+		query = query.Where(fmt.Sprintf("%s >= ?", column("created_at")), q.CreatedFrom)
+	}
+	if !q.CreatedBefore.IsZero() {
+		query = query.Where(fmt.Sprintf("%s < ?", column("created_at")), q.CreatedBefore)
+	}
+	if q.ByNonce {
+		query = query.Order(fmt.Sprintf("%s ASC", column(model.Deposit{}.Column().B2TxNonce)))
+	} else {
+		query = query.Order(fmt.Sprintf("%s ASC", column(model.Deposit{}.Column().BtcBlockNumber)))
+	}
+	query = query.Order(fmt.Sprintf("%s ASC", column("id")))
+	if q.Limit > 0 {
+		query = query.Limit(q.Limit)
+	}
+	if err := query.Find(&deposits).Error; err != nil {
+		return nil, r.s.translate(err)
+	}
+	return deposits, nil
+}
+
+func (r *depositRepo) ApprovedVolume(since time.Time, btcFrom string) (*big.Int, error) {
+	query := r.s.db.Model(&model.Deposit{}).
+		Where(fmt.Sprintf("%s = ?", model.Deposit{}.Column().PolicyStatus), model.DepositPolicyStatusApproved).
+		Where(fmt.Sprintf("%s >= ?", model.Deposit{}.Column().ApprovedAt), since)
+	if btcFrom != "" {
+		query = query.Where(fmt.Sprintf("%s = ?", model.Deposit{}.Column().BtcFrom), btcFrom)
+	}
+	volume, err := r.s.sum(query, model.Deposit{}.Column().BtcValue, "")
+	if err != nil {
+		return nil, r.s.translate(err)
+	}
+	return volume, nil
+}
+
+func (r *depositRepo) MintedVolume(since time.Time) (*big.Int, error) {
+	query := r.s.db.Model(&model.Deposit{}).
+		Where(fmt.Sprintf("%s = ?", model.Deposit{}.Column().B2TxStatus), model.DepositB2TxStatusSuccess)
+	if !since.IsZero() {
+		query = query.Where(fmt.Sprintf("%s >= ?", model.Deposit{}.Column().MintedAt), since)
+	}
+	volume, err := r.s.sum(query, model.Deposit{}.Column().BtcValue, model.Deposit{}.Column().BtcFee)
+	if err != nil {
+		return nil, r.s.translate(err)
+	}
+	return volume, nil
+}
+
+func (r *depositRepo) MintedFees() (*big.Int, error) {
+	query := r.s.db.Model(&model.Deposit{}).
+		Where(fmt.Sprintf("%s = ?", model.Deposit{}.Column().FeeTxStatus), model.DepositB2TxStatusSuccess).
+		Where(fmt.Sprintf("%s > 0", model.Deposit{}.Column().BtcFee))
+	fees, err := r.s.sum(query, model.Deposit{}.Column().BtcFee, "")
+	if err != nil {
+		return nil, r.s.translate(err)
+	}
+	return fees, nil
+}
+
+func (r *depositRepo) FirstFlagged(checks []int, since time.Time) (*model.Deposit, error) {
+	var deposit model.Deposit
+	err := r.s.db.
+		Where(fmt.Sprintf("%s IN (?)", model.Deposit{}.Column().B2TxCheck), checks).
+		Where(fmt.Sprintf("%s > ?", model.Deposit{}.Column().FlaggedAt), since).
+		Order("id ASC").
+		First(&deposit).Error
+	if err != nil {
+		return nil, r.s.translate(err)
+	}
+	return &deposit, nil
+}
+
+func (r *depositRepo) Update(id int64, fields map[string]interface{}) error {
+	return r.s.translate(r.s.db.Model(&model.Deposit{}).Where("id = ?", id).Updates(fields).Error)
+}
+
+func (r *depositRepo) UpdateIfStatus(id int64, status int, fields map[string]interface{}) (bool, error) {
+	result := r.s.db.Model(&model.Deposit{}).
+		Where("id = ?", id).
+		Where(fmt.Sprintf("%s = ?", model.Deposit{}.Column().B2TxStatus), status).
+		UpdateColumns(fields)
+	if result.Error != nil {
+		return false, r.s.translate(result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+type depositEventRepo struct{ s *gormStore }
+
+func (r *depositEventRepo) Append(event *model.DepositEvent) error {
+	return r.s.translate(r.s.db.Create(event).Error)
+}
+
+func (r *depositEventRepo) ListByBtcTxHash(btcTxHash string) ([]*model.DepositEvent, error) {
+	var events []*model.DepositEvent
+	err := r.s.db.
+		Where(fmt.Sprintf("%s = ?", model.DepositEvent{}.Column().BtcTxHash), btcTxHash).
+		Order("id ASC").
+		Find(&events).Error
+	if err != nil {
+		return nil, r.s.translate(err)
+	}
+	return events, nil
+}
+
+type b2TxAttemptRepo struct{ s *gormStore }
+
+func (r *b2TxAttemptRepo) Create(attempt *model.B2TxAttempt) error {
+	return r.s.translate(r.s.db.Create(attempt).Error)
+}
+
+func (r *b2TxAttemptRepo) CreateIfAbsent(attempt *model.B2TxAttempt) (bool, error) {
+	result := r.s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: model.B2TxAttempt{}.Column().B2TxHash}},
+		DoNothing: true,
+	}).Create(attempt)
+	if result.Error != nil {
+		return false, r.s.translate(result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *b2TxAttemptRepo) ListPending(limit int) ([]*model.B2TxAttempt, error) {
+	var attempts []*model.B2TxAttempt
+	query := r.s.db.
+		Where(fmt.Sprintf("%s = ?", model.B2TxAttempt{}.Column().Status), model.B2TxAttemptStatusPending).
+		Order("updated_at ASC").
+		Order("id ASC")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if err := query.Find(&attempts).Error; err != nil {
+		return nil, r.s.translate(err)
+	}
+	return attempts, nil
+}
+
+func (r *b2TxAttemptRepo) ListByBtcTxHash(btcTxHash string) ([]*model.B2TxAttempt, error) {
+	var attempts []*model.B2TxAttempt
+	err := r.s.db.
+		Where(fmt.Sprintf("%s = ?", model.B2TxAttempt{}.Column().BtcTxHash), btcTxHash).
+		Order("id ASC").
+		Find(&attempts).Error
+	if err != nil {
+		return nil, r.s.translate(err)
+	}
+	return attempts, nil
+}
+
+func (r *b2TxAttemptRepo) Update(id int64, fields map[string]interface{}) error {
+	// updated_at is set by the update, an empty update only bumps it
+	return r.s.translate(r.s.db.Model(&model.B2TxAttempt{}).Where("id = ?", id).Updates(fields).Error)
+}
+
+type cursorRepo struct{ s *gormStore }
+
+func (r *cursorRepo) GetBtcIndex() (*model.BtcIndex, error) {
+	var index model.BtcIndex
+	if err := r.s.db.First(&index, 1).Error; err != nil {
+		return nil, r.s.translate(err)
+	}
+	return &index, nil
+}
+
+func (r *cursorRepo) SaveBtcIndex(index *model.BtcIndex) error {
+	return r.s.translate(r.s.db.Save(index).Error)
+}
+
+func (r *cursorRepo) GetRollupIndex() (*model.RollupIndex, error) {
+	var index model.RollupIndex
+	if err := r.s.db.First(&index, 1).Error; err != nil {
+		return nil, r.s.translate(err)
+	}
+	return &index, nil
+}
+
+func (r *cursorRepo) SaveRollupIndex(index *model.RollupIndex) error {
+	return r.s.translate(r.s.db.Save(index).Error)
+}
+
+func (r *cursorRepo) GetVaultIndex() (*model.VaultIndex, error) {
+	var index model.VaultIndex
+	if err := r.s.db.First(&index, 1).Error; err != nil {
+		return nil, r.s.translate(err)
+	}
+	return &index, nil
+}
+
+func (r *cursorRepo) SaveVaultIndex(index *model.VaultIndex) error {
+	return r.s.translate(r.s.db.Save(index).Error)
+}
+
+type rollupDepositRepo struct{ s *gormStore }
+
+func (r *rollupDepositRepo) Create(deposit *model.RollupDeposit) error {
+	return r.s.translate(r.s.db.Create(deposit).Error)
+}
+
+func (r *rollupDepositRepo) CreateIfAbsent(deposit *model.RollupDeposit) (bool, error) {
+	result := r.s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: model.RollupDeposit{}.Column().B2TxHash}},
+		DoNothing: true,
+	}).Create(deposit)
+	if result.Error != nil {
+		return false, r.s.translate(result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *rollupDepositRepo) GetByB2TxHash(b2TxHash string) (*model.RollupDeposit, error) {
+	var deposit model.RollupDeposit
+	err := r.s.db.First(&deposit,
+		fmt.Sprintf("%s = ?", model.RollupDeposit{}.Column().B2TxHash),
+		b2TxHash).Error
+	if err != nil {
+		return nil, r.s.translate(err)
+	}
+	return &deposit, nil
+}
+
+func (r *rollupDepositRepo) ListByBtcTxHash(btcTxHash string) ([]*model.RollupDeposit, error) {
+	var deposits []*model.RollupDeposit
+	err := r.s.db.
+		Where(fmt.Sprintf("%s = ?", model.RollupDeposit{}.Column().BtcTxHash), btcTxHash).
+		Order("id ASC").
+		Find(&deposits).Error
+	if err != nil {
+		return nil, r.s.translate(err)
+	}
+	return deposits, nil
+}
+
+func (r *rollupDepositRepo) ListByBtcTxHashes(btcTxHashes []string) ([]*model.RollupDeposit, error) {
+	var deposits []*model.RollupDeposit
+	if len(btcTxHashes) == 0 {
+		return deposits, nil
+	}
+	err := r.s.db.
+		Where(fmt.Sprintf("%s IN (?)", model.RollupDeposit{}.Column().BtcTxHash), btcTxHashes).
+		Order("id ASC").
+		Find(&deposits).Error
+	if err != nil {
+		return nil, r.s.translate(err)
+	}
+	return deposits, nil
+}
+
+func (r *rollupDepositRepo) ListCreated(from, to time.Time) ([]*model.RollupDeposit, error) {
+	var deposits []*model.RollupDeposit
+	err := r.s.db.
+		Where("created_at >= ? AND created_at < ?", from, to).
+		Order("id ASC").
+		Find(&deposits).Error
+	if err != nil {
+		return nil, r.s.translate(err)
+	}
+	return deposits, nil
+}
+
+type withdrawRepo struct{ s *gormStore }
+
+func (r *withdrawRepo) Create(withdraw *model.Withdraw) error {
+	return r.s.translate(r.s.db.Create(withdraw).Error)
+}
+
+func (r *withdrawRepo) CreateIfAbsent(withdraw *model.Withdraw) (bool, error) {
+	result := r.s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: model.Withdraw{}.Column().B2TxHash}, {Name: model.Withdraw{}.Column().B2LogIndex}},
+		DoNothing: true,
+	}).Create(withdraw)
+	if result.Error != nil {
+		return false, r.s.translate(result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *withdrawRepo) ListByB2TxHash(b2TxHash string) ([]*model.Withdraw, error) {
+	var withdraws []*model.Withdraw
+	err := r.s.db.
+		Where(fmt.Sprintf("%s = ?", model.Withdraw{}.Column().B2TxHash), b2TxHash).
+		Order(model.Withdraw{}.Column().B2LogIndex).
+		Find(&withdraws).Error
+	if err != nil {
+		return nil, r.s.translate(err)
+	}
+	return withdraws, nil
+}
+
+func (r *withdrawRepo) ListByB2TxHashes(b2TxHashes []string) ([]*model.Withdraw, error) {
+	var withdraws []*model.Withdraw
+	if len(b2TxHashes) == 0 {
+		return withdraws, nil
+	}
+	err := r.s.db.
+		Where(fmt.Sprintf("%s IN (?)", model.Withdraw{}.Column().B2TxHash), b2TxHashes).
+		Order("id ASC").
+		Find(&withdraws).Error
+	if err != nil {
+		return nil, r.s.translate(err)
+	}
+	return withdraws, nil
+}
+
+func (r *withdrawRepo) ListByStatus(status int, limit int) ([]*model.Withdraw, error) {
+	var withdraws []*model.Withdraw
+	query := r.s.db.
+		Where(fmt.Sprintf("%s = ?", model.Withdraw{}.Column().Status), status).
+		Order("id ASC")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if err := query.Find(&withdraws).Error; err != nil {
+		return nil, r.s.translate(err)
+	}
+	return withdraws, nil
+}
+
+func (r *withdrawRepo) ListByChain(chain string, status int, limit int) ([]*model.Withdraw, error) {
+	var withdraws []*model.Withdraw
+	query := r.s.db.
+		Where(fmt.Sprintf("%s = ?", model.Withdraw{}.Column().Chain), chain).
+		Where(fmt.Sprintf("%s = ?", model.Withdraw{}.Column().Status), status).
+		Order("id ASC")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if err := query.Find(&withdraws).Error; err != nil {
+		return nil, r.s.translate(err)
+	}
+	return withdraws, nil
+}
+
+func (r *withdrawRepo) UpdateStatus(id int64, status int) error {
+	return r.s.translate(r.s.db.Model(&model.Withdraw{}).
+		Where("id = ?", id).
+		Update(model.Withdraw{}.Column().Status, status).Error)
+}
+
+func (r *withdrawRepo) UpdateStatusByB2TxHashes(b2TxHashes []string, status int) error {
+	return r.s.translate(r.s.db.Model(&model.Withdraw{}).
+		Where(fmt.Sprintf("%s IN (?)", model.Withdraw{}.Column().B2TxHash), b2TxHashes).
+		Update(model.Withdraw{}.Column().Status, status).Error)
+}
+
+func (r *withdrawRepo) UpdateIfStatus(id int64, status int, fields map[string]interface{}) (bool, error) {
+	result := r.s.db.Model(&model.Withdraw{}).
+		Where("id = ?", id).
+		Where(fmt.Sprintf("%s = ?", model.Withdraw{}.Column().Status), status).
+		Updates(fields)
+	if result.Error != nil {
+		return false, r.s.translate(result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+type withdrawTxRepo struct{ s *gormStore }
+
+func (r *withdrawTxRepo) Create(withdrawTx *model.WithdrawTx) error {
+	return r.s.translate(r.s.db.Create(withdrawTx).Error)
+}
+
+func (r *withdrawTxRepo) GetByBtcTxID(btcTxID string) (*model.WithdrawTx, error) {
+	var withdrawTx model.WithdrawTx
+	err := r.s.db.
+		Where(fmt.Sprintf("%s = ?", model.WithdrawTx{}.Column().BtcTxID), btcTxID).
+		Order("id ASC").
+		First(&withdrawTx).Error
+	if err != nil {
+		return nil, r.s.translate(err)
+	}
+	return &withdrawTx, nil
+}
+
+func (r *withdrawTxRepo) ListByStatus(statuses []int, limit int) ([]*model.WithdrawTx, error) {
+	var withdrawTxs []*model.WithdrawTx
+	query := r.s.db.
+		Where(fmt.Sprintf("%s IN (?)", model.WithdrawTx{}.Column().Status), statuses).
+		Order("id ASC")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if err := query.Find(&withdrawTxs).Error; err != nil {
+		return nil, r.s.translate(err)
+	}
+	return withdrawTxs, nil
+}
+
+func (r *withdrawTxRepo) ListStuck(broadcastBefore int64) ([]*model.WithdrawTx, error) {
+	var withdrawTxs []*model.WithdrawTx
+	err := r.s.db.
+		Where(fmt.Sprintf("%s = ?", model.WithdrawTx{}.Column().Status), model.BtcTxWithdrawBroadcastSuccess).
+		Where(fmt.Sprintf("%s = ?", model.WithdrawTx{}.Column().ReplacedByTxID), "").
+		Where(fmt.Sprintf("%s > 0", model.WithdrawTx{}.Column().BroadcastTime)).
+		Where(fmt.Sprintf("%s <= ?", model.WithdrawTx{}.Column().BroadcastTime), broadcastBefore).
+		Order("id ASC").
+		Find(&withdrawTxs).Error
+	if err != nil {
+		return nil, r.s.translate(err)
+	}
+	return withdrawTxs, nil
+}
+
+func (r *withdrawTxRepo) Update(id int64, fields map[string]interface{}) error {
+	return r.s.translate(r.s.db.Model(&model.WithdrawTx{}).Where("id = ?", id).Updates(fields).Error)
+}
+
+func (r *withdrawTxRepo) UpdateIfStatus(id int64, status int, fields map[string]interface{}) (bool, error) {
+	result := r.s.db.Model(&model.WithdrawTx{}).
+		Where("id = ?", id).
+		Where(fmt.Sprintf("%s = ?", model.WithdrawTx{}.Column().Status), status).
+		Updates(fields)
+	if result.Error != nil {
+		return false, r.s.translate(result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *withdrawTxRepo) UpdateIfBtcTx(id int64, btcTx string, fields map[string]interface{}) (bool, error) {
+	result := r.s.db.Model(&model.WithdrawTx{}).
+		Where("id = ?", id).
+		Where(fmt.Sprintf("%s = ?", model.WithdrawTx{}.Column().BtcTx), btcTx).
+		Updates(fields)
+	if result.Error != nil {
+		return false, r.s.translate(result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *withdrawTxRepo) SetReplacedBy(id int64, replacementID string) (bool, error) {
+	result := r.s.db.Model(&model.WithdrawTx{}).
+		Where("id = ?", id).
+		Where(fmt.Sprintf("%s = ?", model.WithdrawTx{}.Column().Status), model.BtcTxWithdrawBroadcastSuccess).
+		Where(fmt.Sprintf("%s = ?", model.WithdrawTx{}.Column().ReplacedByTxID), "").
+		Update(model.WithdrawTx{}.Column().ReplacedByTxID, replacementID)
+	if result.Error != nil {
+		return false, r.s.translate(result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *withdrawTxRepo) ClearReplacedBy(btcTxID string, replacementID string) error {
+	return r.s.translate(r.s.db.Model(&model.WithdrawTx{}).
+		Where(fmt.Sprintf("%s = ?", model.WithdrawTx{}.Column().BtcTxID), btcTxID).
+		Where(fmt.Sprintf("%s = ?", model.WithdrawTx{}.Column().ReplacedByTxID), replacementID).
+		Update(model.WithdrawTx{}.Column().ReplacedByTxID, "").Error)
+}
+
+func (r *withdrawTxRepo) UpdatePeers(withdrawTx *model.WithdrawTx, statuses []int, fields map[string]interface{}) (int64, error) {
+	result := r.s.db.Model(&model.WithdrawTx{}).
+		Where(fmt.Sprintf("%s = ?", model.WithdrawTx{}.Column().B2TxHashes), withdrawTx.B2TxHashes).
+		Where("id <> ?", withdrawTx.ID).
+		Where(fmt.Sprintf("%s IN (?)", model.WithdrawTx{}.Column().Status), statuses).
+		Updates(fields)
+	if result.Error != nil {
+		return 0, r.s.translate(result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// circuitBreakerID id of the single circuit breaker row
+const circuitBreakerID = 1
+
+type circuitBreakerRepo struct{ s *gormStore }
+
+func (r *circuitBreakerRepo) Get() (*model.CircuitBreaker, error) {
+	var state model.CircuitBreaker
+	if err := r.s.db.First(&state, circuitBreakerID).Error; err != nil {
+		return nil, r.s.translate(err)
+	}
+	return &state, nil
+}
+
+func (r *circuitBreakerRepo) Save(state *model.CircuitBreaker) error {
+	state.ID = circuitBreakerID
+	return r.s.translate(r.s.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(state).Error)
+}
+
+// vaultUTXOBatchSize tx ids per lookup of the spent outputs
+const vaultUTXOBatchSize = 500
+
+type vaultUTXORepo struct{ s *gormStore }
+
+func (r *vaultUTXORepo) CreateIfAbsent(utxo *model.VaultUtxo) (bool, error) {
+	result := r.s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: model.VaultUtxo{}.Column().TxID}, {Name: model.VaultUtxo{}.Column().Vout}},
+		DoNothing: true,
+	}).Create(utxo)
+	if result.Error != nil {
+		return false, r.s.translate(result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *vaultUTXORepo) ListUnspent(address string) ([]*model.VaultUtxo, error) {
+	var utxos []*model.VaultUtxo
+	err := r.s.db.
+		Where(fmt.Sprintf("%s = ?", model.VaultUtxo{}.Column().Address), address).
+		Where(fmt.Sprintf("%s = ?", model.VaultUtxo{}.Column().Status), model.VaultUtxoUnspent).
+		Order(fmt.Sprintf("%s ASC", model.VaultUtxo{}.Column().BlockHeight)).
+		Order("id ASC").
+		Find(&utxos).Error
+	if err != nil {
+		return nil, r.s.translate(err)
+	}
+	return utxos, nil
+}
+
+func (r *vaultUTXORepo) Reserve(outpoints []wire.OutPoint, btcTxID string) error {
+	return r.s.db.Transaction(func(tx *gorm.DB) error {
+		for _, outpoint := range outpoints {
+			result := r.outpoint(tx, outpoint).
+				Where(fmt.Sprintf("%s = ?", model.VaultUtxo{}.Column().Status), model.VaultUtxoUnspent).
+				Updates(map[string]interface{}{
+					model.VaultUtxo{}.Column().Status:     model.VaultUtxoReserved,
+					model.VaultUtxo{}.Column().ReservedBy: btcTxID,
+				})
+			if result.Error != nil {
+				return r.s.translate(result.Error)
+			}
+			if result.RowsAffected == 0 {
+				return fmt.Errorf("%w: %s is not unspent", ErrConflict, outpoint)
+			}
+		}
+		return nil
+	})
+}
+
+func (r *vaultUTXORepo) Release(btcTxID string) error {
+	return r.s.translate(r.s.db.Model(&model.VaultUtxo{}).
+		Where(fmt.Sprintf("%s = ?", model.VaultUtxo{}.Column().ReservedBy), btcTxID).
+		Where(fmt.Sprintf("%s = ?", model.VaultUtxo{}.Column().Status), model.VaultUtxoReserved).
+		Updates(map[string]interface{}{
+			model.VaultUtxo{}.Column().Status:     model.VaultUtxoUnspent,
+			model.VaultUtxo{}.Column().ReservedBy: "",
+		}).Error)
+}
+
+func (r *vaultUTXORepo) MarkSpent(spends map[wire.OutPoint]string) error {
+	txIDs := make([]string, 0, len(spends))
+	seen := make(map[string]struct{}, len(spends))
+	for outpoint := range spends {
+		if _, ok := seen[outpoint.Hash.String()]; !ok {
+			seen[outpoint.Hash.String()] = struct{}{}
+			txIDs = append(txIDs, outpoint.Hash.String())
+		}
+	}
+	// a block spends thousands of outputs, the few paying the vault are looked up in batches
+	for start := 0; start < len(txIDs); start += vaultUTXOBatchSize {
+		end := min(start+vaultUTXOBatchSize, len(txIDs))
+		var utxos []*model.VaultUtxo
+		err := r.s.db.
+			Where(fmt.Sprintf("%s IN ?", model.VaultUtxo{}.Column().TxID), txIDs[start:end]).
+			Where(fmt.Sprintf("%s <> ?", model.VaultUtxo{}.Column().Status), model.VaultUtxoSpent).
+			Find(&utxos).Error
+		if err != nil {
+			return r.s.translate(err)
+		}
+		for _, utxo := range utxos {
+			hash, err := chainhash.NewHashFromStr(utxo.TxID)
+			if err != nil {
+				return err
+			}
+			spentBy, ok := spends[wire.OutPoint{Hash: *hash, Index: utxo.Vout}]
+			if !ok {
+				continue
+			}
+			err = r.s.db.Model(&model.VaultUtxo{}).Where("id = ?", utxo.ID).Updates(map[string]interface{}{
+				model.VaultUtxo{}.Column().Status:  model.VaultUtxoSpent,
+				model.VaultUtxo{}.Column().SpentBy: spentBy,
+			}).Error
+			if err != nil {
+				return r.s.translate(err)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *vaultUTXORepo) outpoint(db *gorm.DB, outpoint wire.OutPoint) *gorm.DB {
+	return db.Model(&model.VaultUtxo{}).
+		Where(fmt.Sprintf("%s = ?", model.VaultUtxo{}.Column().TxID), outpoint.Hash.String()).
+		Where(fmt.Sprintf("%s = ?", model.VaultUtxo{}.Column().Vout), outpoint.Index)
+}
+
+type abelPayoutRepo struct{ s *gormStore }
+
+func (r *abelPayoutRepo) CreateIfAbsent(payout *model.AbelPayout) (bool, error) {
+	result := r.s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: model.AbelPayout{}.Column().IdempotencyKey}},
+		DoNothing: true,
+	}).Create(payout)
+	if result.Error != nil {
+		return false, r.s.translate(result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *abelPayoutRepo) GetByKey(key string) (*model.AbelPayout, error) {
+	var payout model.AbelPayout
+	err := r.s.db.First(&payout,
+		fmt.Sprintf("%s = ?", model.AbelPayout{}.Column().IdempotencyKey),
+		key).Error
+	if err != nil {
+		return nil, r.s.translate(err)
+	}
+	return &payout, nil
+}
+
+func (r *abelPayoutRepo) ListByStatus(status int, limit int) ([]*model.AbelPayout, error) {
+	var payouts []*model.AbelPayout
+	query := r.s.db.
+		Where(fmt.Sprintf("%s = ?", model.AbelPayout{}.Column().Status), status).
+		Order("id ASC")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if err := query.Find(&payouts).Error; err != nil {
+		return nil, r.s.translate(err)
+	}
+	return payouts, nil
+}
+
+func (r *abelPayoutRepo) UpdateIfStatus(id int64, status int, fields map[string]interface{}) (bool, error) {
+	result := r.s.db.Model(&model.AbelPayout{}).
+		Where("id = ?", id).
+		Where(fmt.Sprintf("%s = ?", model.AbelPayout{}.Column().Status), status).
+		Updates(fields)
+	if result.Error != nil {
+		return false, r.s.translate(result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+type abelAddressRepo struct{ s *gormStore }
+
+func (r *abelAddressRepo) Get(l2Address string) (*model.AbelWithdrawAddress, error) {
+	var address model.AbelWithdrawAddress
+	err := r.s.db.First(&address,
+		fmt.Sprintf("%s = ?", model.AbelWithdrawAddress{}.Column().L2Address),
+		l2Address).Error
+	if err != nil {
+		return nil, r.s.translate(err)
+	}
+	return &address, nil
+}
+
+func (r *abelAddressRepo) Save(address *model.AbelWithdrawAddress) error {
+	result := r.s.db.Model(&model.AbelWithdrawAddress{}).
+		Where(fmt.Sprintf("%s = ?", model.AbelWithdrawAddress{}.Column().L2Address), address.L2Address).
+		Where(fmt.Sprintf("%s < ?", model.AbelWithdrawAddress{}.Column().Nonce), address.Nonce).
+		Updates(map[string]interface{}{
+			model.AbelWithdrawAddress{}.Column().AbelAddress: address.AbelAddress,
+			model.AbelWithdrawAddress{}.Column().Nonce:       address.Nonce,
+			model.AbelWithdrawAddress{}.Column().Signature:   address.Signature,
+		})
+	if result.Error != nil {
+		return r.s.translate(result.Error)
+	}
+	if result.RowsAffected > 0 {
+		return nil
+	}
+	_, err := r.Get(address.L2Address)
+	switch {
+	case err == nil:
+		return fmt.Errorf("%w: nonce %d of %s not above the registered one", ErrConflict, address.Nonce, address.L2Address)
+	case !errors.Is(err, ErrNotFound):
+		return err
+	}
+	return r.s.translate(r.s.db.Create(address).Error)
+}
+
+type evmEventRepo struct{ s *gormStore }
+
+func (r *evmEventRepo) CreateIfAbsent(event *model.EvmEvent) (bool, error) {
+	result := r.s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: model.EvmEvent{}.Column().B2TxHash}, {Name: model.EvmEvent{}.Column().B2LogIndex}},
+		DoNothing: true,
+	}).Create(event)
+	if result.Error != nil {
+		return false, r.s.translate(result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *evmEventRepo) ListByName(name string, limit int) ([]*model.EvmEvent, error) {
+	var events []*model.EvmEvent
+	query := r.s.db.
+		Where(fmt.Sprintf("%s = ?", model.EvmEvent{}.Column().EventName), name).
+		Order(fmt.Sprintf("%s ASC", model.EvmEvent{}.Column().B2BlockNumber)).
+		Order(fmt.Sprintf("%s ASC", model.EvmEvent{}.Column().B2LogIndex))
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if err := query.Find(&events).Error; err != nil {
+		return nil, r.s.translate(err)
+	}
+	return events, nil
+}
+
+type parkedLogRepo struct{ s *gormStore }
+
+func (r *parkedLogRepo) CreateIfAbsent(log *model.ParkedLog) (bool, error) {
+	result := r.s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: model.ParkedLog{}.Column().B2TxHash}, {Name: model.ParkedLog{}.Column().B2LogIndex}},
+		DoNothing: true,
+	}).Create(log)
+	if result.Error != nil {
+		return false, r.s.translate(result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *parkedLogRepo) List(limit int) ([]*model.ParkedLog, error) {
+	var logs []*model.ParkedLog
+	query := r.s.db.
+		Order(fmt.Sprintf("%s ASC", model.ParkedLog{}.Column().B2BlockNumber)).
+		Order(fmt.Sprintf("%s ASC", model.ParkedLog{}.Column().B2LogIndex))
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if err := query.Find(&logs).Error; err != nil {
+		return nil, r.s.translate(err)
+	}
+	return logs, nil
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	gormlog "gorm.io/gorm/logger"
+)
+
+const (
+	// SQLiteScheme database source prefix of the sqlite backend, e.g. sqlite://./data/indexer.db
+	SQLiteScheme = "sqlite://"
+
+	OpenRetry        = 3
+	OpenRetryBackoff = 5 * time.Second
+)
+
+// Options database connection options
+type Options struct {
+	// Source postgres dsn, or sqlite://<file path>, sqlite://:memory: for an in-memory database
+	Source          string
+	MaxIdleConns    int
+	MaxOpenConns    int
+	ConnMaxLifetime time.Duration
+	LogLevel        gormlog.LogLevel
+}
+
+// Open connect to the database of the source, retrying while the server is starting
+func Open(opts Options) (*gorm.DB, error) {
+	dialector := openPostgres(opts.Source)
+	retry := OpenRetry
+	if path, ok := strings.CutPrefix(opts.Source, SQLiteScheme); ok {
+		dialector = openSQLite(path)
+		retry = 1
+	}
+	logLevel := opts.LogLevel
+	if logLevel == 0 {
+		logLevel = gormlog.Info
+	}
+
+	var (
+		db  *gorm.DB
+		err error
+	)
+	backoff := OpenRetryBackoff
+	for i := 0; i < retry; i++ {
+		if i > 0 {
+			// waiting for db server start complete
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		db, err = gorm.Open(dialector, &gorm.Config{
+			Logger: gormlog.Default.LogMode(logLevel),
+		})
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	// set db conn limit
+	if db.Dialector.Name() == DialectSQLite {
+		// one long lived connection, sqlite serializes writes and an in-memory database lives with its connection
+		sqlDB.SetMaxOpenConns(1)
+		return db, nil
+	}
+	sqlDB.SetMaxIdleConns(opts.MaxIdleConns)
+	sqlDB.SetMaxOpenConns(opts.MaxOpenConns)
+	sqlDB.SetConnMaxLifetime(opts.ConnMaxLifetime)
+	return db, nil
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const DialectPostgres = "postgres"
+
+// pgUniqueViolation duplicate key value violates unique constraint
+const pgUniqueViolation = "23505"
+
+func newPostgresStore(db *gorm.DB) Store {
+	return &gormStore{db: db, isDuplicate: isPostgresDuplicate, sum: postgresSum}
+}
+
+// postgresSum big ints are numeric in postgres, summed exactly by the database
+func postgresSum(query *gorm.DB, column, less string) (*big.Int, error) {
+	expr := column
+	if less != "" {
+		expr = fmt.Sprintf("%s - %s", column, less)
+	}
+	var sum model.BigInt
+	if err := query.Select(fmt.Sprintf("COALESCE(SUM(%s), 0)", expr)).Row().Scan(&sum); err != nil {
+		return nil, err
+	}
+	return sum.Big(), nil
+}
+
+func isPostgresDuplicate(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation
+}
+
+func openPostgres(dsn string) gorm.Dialector {
+	return postgres.Open(dsn)
+}
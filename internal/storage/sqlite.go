@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/glebarez/go-sqlite"
+	gormsqlite "github.com/glebarez/sqlite"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"gorm.io/gorm"
+)
+
+const DialectSQLite = "sqlite"
+
+const (
+	sqliteConstraintPrimaryKey = 1555 // SQLITE_CONSTRAINT_PRIMARYKEY
+	sqliteConstraintUnique     = 2067 // SQLITE_CONSTRAINT_UNIQUE
+)
+
+func newSQLiteStore(db *gorm.DB) Store {
+	return &gormStore{db: db, isDuplicate: isSQLiteDuplicate, sum: sqliteSum}
+}
+
+// sqliteSum big ints are text in sqlite, their sql sum turns into a float past int64, the rows are summed here
+func sqliteSum(query *gorm.DB, column, less string) (*big.Int, error) {
+	selected := column
+	if less != "" {
+		selected = fmt.Sprintf("%s, %s", column, less)
+	}
+	rows, err := query.Select(selected).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sum := new(big.Int)
+	for rows.Next() {
+		var value, lessValue model.BigInt
+		dest := []interface{}{&value}
+		if less != "" {
+			dest = append(dest, &lessValue)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		sum.Add(sum, value.Big()).Sub(sum, lessValue.Big())
+	}
+	return sum, rows.Err()
+}
+
+func isSQLiteDuplicate(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.Code() == sqliteConstraintUnique || sqliteErr.Code() == sqliteConstraintPrimaryKey
+}
+
+func openSQLite(path string) gorm.Dialector {
+	// busy timeout for the concurrent services sharing the file
+	return gormsqlite.Open(path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+}
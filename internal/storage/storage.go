@@ -0,0 +1,267 @@
+// Package storage repositories of the indexer aggregates, with a postgres and a sqlite backend.
+// Dialect specific column types and error codes stay behind this package.
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrNotFound record not found
+	ErrNotFound = errors.New("record not found")
+	// ErrDuplicate unique constraint violated
+	ErrDuplicate = errors.New("duplicate key")
+	// ErrConflict rows changed by a concurrent update
+	ErrConflict = errors.New("conflicting update")
+	// ErrUnsupportedDialect database dialect without a store implementation
+	ErrUnsupportedDialect = errors.New("unsupported database dialect")
+)
+
+// DepositQuery filter of DepositRepository.List, a zero field does not filter
+type DepositQuery struct {
+	// Statuses b2 tx status is one of
+	Statuses []int
+	// FeeStatuses fee tx status is one of, deposits paying a fee only
+	FeeStatuses []int
+	// Checks b2 tx check is one of
+	Checks []int
+	// ExcludeChecks b2 tx check is none of
+	ExcludeChecks []int
+	// Notified callback and listener statuses are success
+	Notified bool
+	// MaxRetry b2 tx retried at most
+	MaxRetry int
+	// EligibleAt reached the target confirmations at the btc height
+	EligibleAt *int64
+	// BtcTxHashes btc tx hash is one of
+	BtcTxHashes []string
+	// CreatedFrom created at or after
+	CreatedFrom time.Time
+	// CreatedBefore created before
+	CreatedBefore time.Time
+	// ByNonce oldest b2 tx nonce first, else oldest btc block first
+	ByNonce bool
+	// Limit <= 0 for all
+	Limit int
+}
+
+// DepositRepository l1 -> l2 deposits, keyed by btc tx hash
+type DepositRepository interface {
+	// Create insert the deposit, ErrDuplicate if the btc tx hash exists
+	Create(deposit *model.Deposit) error
+	// CreateIfAbsent insert the deposit, false if the btc tx hash exists
+	CreateIfAbsent(deposit *model.Deposit) (bool, error)
+	// GetByBtcTxHash ErrNotFound if absent
+	GetByBtcTxHash(btcTxHash string) (*model.Deposit, error)
+	// ListByStatus deposits with one of the b2 tx statuses, oldest block first, limit <= 0 for all
+	ListByStatus(statuses []int, limit int) ([]*model.Deposit, error)
+	// List deposits matching the query
+	List(query DepositQuery) ([]*model.Deposit, error)
+	// ApprovedVolume sum of the values of the deposits approved by the policy or the operator since the time,
+	// of every address if btcFrom is empty
+	ApprovedVolume(since time.Time, btcFrom string) (*big.Int, error)
+	// MintedVolume sum of the minted values, value less fee, of the deposits minted since the time,
+	// of every minted deposit if since is zero
+	MintedVolume(since time.Time) (*big.Int, error)
+	// MintedFees sum of the fees minted to the treasury
+	MintedFees() (*big.Int, error)
+	// FirstFlagged oldest deposit flagged with one of the b2 tx checks after the time, ErrNotFound if none
+	FirstFlagged(checks []int, since time.Time) (*model.Deposit, error)
+	// Update update the given columns
+	Update(id int64, fields map[string]interface{}) error
+	// UpdateIfStatus update the given columns if the b2 tx status is unchanged, false if it changed
+	UpdateIfStatus(id int64, status int, fields map[string]interface{}) (bool, error)
+}
+
+// DepositEventRepository append-only audit trail of the deposits
+type DepositEventRepository interface {
+	Append(event *model.DepositEvent) error
+	// ListByBtcTxHash events of the deposit, oldest first
+	ListByBtcTxHash(btcTxHash string) ([]*model.DepositEvent, error)
+}
+
+// B2TxAttemptRepository every mint tx broadcast for a deposit, keyed by b2 tx hash
+type B2TxAttemptRepository interface {
+	// Create insert the attempt, ErrDuplicate if the b2 tx hash exists
+	Create(attempt *model.B2TxAttempt) error
+	// CreateIfAbsent insert the attempt, false if the b2 tx hash exists
+	CreateIfAbsent(attempt *model.B2TxAttempt) (bool, error)
+	// ListPending attempts without a receipt, least recently checked first, limit <= 0 for all
+	ListPending(limit int) ([]*model.B2TxAttempt, error)
+	// ListByBtcTxHash attempts of the deposit, oldest first
+	ListByBtcTxHash(btcTxHash string) ([]*model.B2TxAttempt, error)
+	// Update update the given columns, an empty update only marks the attempt checked
+	Update(id int64, fields map[string]interface{}) error
+}
+
+// CursorRepository indexer progress
+type CursorRepository interface {
+	// GetBtcIndex ErrNotFound before the first block is indexed
+	GetBtcIndex() (*model.BtcIndex, error)
+	SaveBtcIndex(index *model.BtcIndex) error
+	// GetRollupIndex ErrNotFound before the first log is indexed
+	GetRollupIndex() (*model.RollupIndex, error)
+	SaveRollupIndex(index *model.RollupIndex) error
+	// GetVaultIndex ErrNotFound before the first block is scanned
+	GetVaultIndex() (*model.VaultIndex, error)
+	SaveVaultIndex(index *model.VaultIndex) error
+}
+
+// VaultUTXORepository outputs paying the withdraw vault, keyed by outpoint
+type VaultUTXORepository interface {
+	// CreateIfAbsent insert the output, false if the outpoint exists
+	CreateIfAbsent(utxo *model.VaultUtxo) (bool, error)
+	// ListUnspent unspent outputs of the address, oldest block first
+	ListUnspent(address string) ([]*model.VaultUtxo, error)
+	// Reserve mark the outputs reserved by the withdraw tx, ErrConflict unless every one is unspent
+	Reserve(outpoints []wire.OutPoint, btcTxID string) error
+	// Release return the outputs reserved by the withdraw tx to the unspent set
+	Release(btcTxID string) error
+	// MarkSpent mark the outputs spent, spends maps each outpoint to its spending tx id,
+	// outpoints not paying the vault are ignored
+	MarkSpent(spends map[wire.OutPoint]string) error
+}
+
+// RollupDepositRepository deposit events observed on the rollup, keyed by b2 tx hash
+type RollupDepositRepository interface {
+	// Create insert the rollup deposit, ErrDuplicate if the b2 tx hash exists
+	Create(deposit *model.RollupDeposit) error
+	// CreateIfAbsent insert the rollup deposit, false if the b2 tx hash exists
+	CreateIfAbsent(deposit *model.RollupDeposit) (bool, error)
+	// GetByB2TxHash ErrNotFound if absent
+	GetByB2TxHash(b2TxHash string) (*model.RollupDeposit, error)
+	ListByBtcTxHash(btcTxHash string) ([]*model.RollupDeposit, error)
+	// ListByBtcTxHashes rollup deposits of any of the btc txs, oldest first
+	ListByBtcTxHashes(btcTxHashes []string) ([]*model.RollupDeposit, error)
+	// ListCreated rollup deposits created in [from, to), oldest first
+	ListCreated(from, to time.Time) ([]*model.RollupDeposit, error)
+}
+
+// WithdrawRepository l2 -> l1 withdraws, keyed by b2 tx hash
+type WithdrawRepository interface {
+	// Create insert the withdraw, ErrDuplicate if the b2 tx hash and log index exist
+	Create(withdraw *model.Withdraw) error
+	// CreateIfAbsent insert the withdraw, false if the b2 tx hash and log index exist
+	CreateIfAbsent(withdraw *model.Withdraw) (bool, error)
+	// ListByB2TxHash withdraws of the events of the b2 tx, by log index
+	ListByB2TxHash(b2TxHash string) ([]*model.Withdraw, error)
+	// ListByB2TxHashes withdraws of the events of any of the b2 txs, oldest first
+	ListByB2TxHashes(b2TxHashes []string) ([]*model.Withdraw, error)
+	// ListByStatus withdraws with the status, oldest first, limit <= 0 for all
+	ListByStatus(status int, limit int) ([]*model.Withdraw, error)
+	// ListByChain withdraws to the chain with the status, oldest first, limit <= 0 for all
+	ListByChain(chain string, status int, limit int) ([]*model.Withdraw, error)
+	UpdateStatus(id int64, status int) error
+	// UpdateStatusByB2TxHashes set the status of the withdraws of the b2 txs
+	UpdateStatusByB2TxHashes(b2TxHashes []string, status int) error
+	// UpdateIfStatus update the given columns if the status is unchanged, false if it changed
+	UpdateIfStatus(id int64, status int, fields map[string]interface{}) (bool, error)
+}
+
+// WithdrawTxRepository btc txs paying the withdraws, keyed by btc tx id
+type WithdrawTxRepository interface {
+	Create(withdrawTx *model.WithdrawTx) error
+	// GetByBtcTxID ErrNotFound if absent
+	GetByBtcTxID(btcTxID string) (*model.WithdrawTx, error)
+	// ListByStatus withdraw txs with one of the statuses, oldest first, limit <= 0 for all
+	ListByStatus(statuses []int, limit int) ([]*model.WithdrawTx, error)
+	// ListStuck broadcast txs not replaced yet, broadcast at or before the unix time, oldest first
+	ListStuck(broadcastBefore int64) ([]*model.WithdrawTx, error)
+	// Update update the given columns
+	Update(id int64, fields map[string]interface{}) error
+	// UpdateIfStatus update the given columns if the status is unchanged, false if it changed
+	UpdateIfStatus(id int64, status int, fields map[string]interface{}) (bool, error)
+	// UpdateIfBtcTx update the given columns if the psbt is unchanged, false if it changed
+	UpdateIfBtcTx(id int64, btcTx string, fields map[string]interface{}) (bool, error)
+	// SetReplacedBy record the replacement of the broadcast tx, false if it is no longer broadcast or already replaced
+	SetReplacedBy(id int64, replacementID string) (bool, error)
+	// ClearReplacedBy forget the replacement of the tx, it may be replaced again
+	ClearReplacedBy(btcTxID string, replacementID string) error
+	// UpdatePeers update the given columns of the other txs with one of the statuses paying the same b2 txs,
+	// the number of txs updated
+	UpdatePeers(withdrawTx *model.WithdrawTx, statuses []int, fields map[string]interface{}) (int64, error)
+}
+
+// CircuitBreakerRepository persisted mint pause state, a single row
+type CircuitBreakerRepository interface {
+	// Get ErrNotFound before the breaker is first saved
+	Get() (*model.CircuitBreaker, error)
+	// Save insert or replace the state
+	Save(state *model.CircuitBreaker) error
+}
+
+// AbelPayoutRepository abelian transfers paying the abelian withdraws, keyed by idempotency key
+type AbelPayoutRepository interface {
+	// CreateIfAbsent insert the payout, false if the idempotency key exists
+	CreateIfAbsent(payout *model.AbelPayout) (bool, error)
+	// GetByKey the payout of the idempotency key, ErrNotFound if absent
+	GetByKey(key string) (*model.AbelPayout, error)
+	// ListByStatus payouts with the status, oldest first, limit <= 0 for all
+	ListByStatus(status int, limit int) ([]*model.AbelPayout, error)
+	// UpdateIfStatus update the given columns if the status is unchanged, false if it changed
+	UpdateIfStatus(id int64, status int, fields map[string]interface{}) (bool, error)
+}
+
+// AbelAddressRepository abelian withdraw addresses registered by the l2 addresses
+type AbelAddressRepository interface {
+	// Get the registration of the l2 address, ErrNotFound if absent
+	Get(l2Address string) (*model.AbelWithdrawAddress, error)
+	// Save insert the registration or replace the one of the l2 address, ErrConflict if its nonce is not lower
+	Save(address *model.AbelWithdrawAddress) error
+}
+
+// EvmEventRepository decoded logs of the listened contracts, keyed by b2 tx hash and log index
+type EvmEventRepository interface {
+	// CreateIfAbsent insert the event, false if the log exists
+	CreateIfAbsent(event *model.EvmEvent) (bool, error)
+	// ListByName events of the name, oldest block first, limit <= 0 for all
+	ListByName(name string, limit int) ([]*model.EvmEvent, error)
+}
+
+// ParkedLogRepository logs that can never be handled, keyed by b2 tx hash and log index
+type ParkedLogRepository interface {
+	// CreateIfAbsent insert the parked log, false if the log exists
+	CreateIfAbsent(log *model.ParkedLog) (bool, error)
+	// List parked logs, oldest block first, limit <= 0 for all
+	List(limit int) ([]*model.ParkedLog, error)
+}
+
+// Store repositories of one database, bound to a transaction inside Transaction
+type Store interface {
+	Deposits() DepositRepository
+	DepositEvents() DepositEventRepository
+	B2TxAttempts() B2TxAttemptRepository
+	Cursors() CursorRepository
+	RollupDeposits() RollupDepositRepository
+	Withdraws() WithdrawRepository
+	VaultUTXOs() VaultUTXORepository
+	AbelPayouts() AbelPayoutRepository
+	AbelAddresses() AbelAddressRepository
+	EvmEvents() EvmEventRepository
+	ParkedLogs() ParkedLogRepository
+	WithdrawTxs() WithdrawTxRepository
+	CircuitBreaker() CircuitBreakerRepository
+	// Transaction run fn in one transaction, the store passed to fn is bound to it
+	Transaction(fn func(Store) error) error
+	// Migrate create missing tables and columns
+	Migrate(tables ...interface{}) error
+}
+
+// New store of the connection, the implementation is chosen by the dialect
+func New(db *gorm.DB) (Store, error) {
+	switch db.Dialector.Name() {
+	case DialectPostgres:
+		return newPostgresStore(db), nil
+	case DialectSQLite:
+		return newSQLiteStore(db), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedDialect, db.Dialector.Name())
+	}
+}
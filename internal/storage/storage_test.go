@@ -0,0 +1,542 @@
+package storage
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/qday-io/qday-abel-bridge-indexer/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gormlog "gorm.io/gorm/logger"
+)
+
+func newTestStore(t *testing.T) Store {
+	t.Helper()
+	db, err := Open(Options{Source: SQLiteScheme + ":memory:", LogLevel: gormlog.Silent})
+	require.NoError(t, err)
+	store, err := New(db)
+	require.NoError(t, err)
+	require.NoError(t, store.Migrate(&model.Deposit{}, &model.DepositEvent{}, &model.B2TxAttempt{}, &model.BtcIndex{}, &model.RollupDeposit{}, &model.Withdraw{}, &model.VaultUtxo{}, &model.AbelPayout{}, &model.AbelWithdrawAddress{}, &model.EvmEvent{}, &model.ParkedLog{}, &model.WithdrawTx{}, &model.CircuitBreaker{}))
+	return store
+}
+
+func TestDepositRepository(t *testing.T) {
+	store := newTestStore(t)
+	deposits := store.Deposits()
+
+	_, err := deposits.GetByBtcTxHash("a")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	deposit := &model.Deposit{
+		BtcTxHash:  "a",
+		BtcValue:   model.NewBigIntFromInt64(100),
+		BtcTos:     `[{"Address":"x"}]`,
+		B2TxStatus: model.DepositB2TxStatusPending,
+	}
+	created, err := deposits.CreateIfAbsent(deposit)
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	created, err = deposits.CreateIfAbsent(&model.Deposit{BtcTxHash: "a", BtcValue: model.NewBigIntFromInt64(1)})
+	require.NoError(t, err)
+	assert.False(t, created)
+	require.ErrorIs(t, deposits.Create(&model.Deposit{BtcTxHash: "a"}), ErrDuplicate)
+
+	got, err := deposits.GetByBtcTxHash("a")
+	require.NoError(t, err)
+	assert.Equal(t, "100", got.BtcValue.String())
+	assert.Equal(t, model.JSONText(`[{"Address":"x"}]`), got.BtcTos)
+
+	updated, err := deposits.UpdateIfStatus(got.ID, model.DepositB2TxStatusSeenInMempool,
+		map[string]interface{}{model.Deposit{}.Column().B2TxStatus: model.DepositB2TxStatusSuccess})
+	require.NoError(t, err)
+	assert.False(t, updated)
+
+	require.NoError(t, deposits.Update(got.ID, map[string]interface{}{model.Deposit{}.Column().B2TxStatus: model.DepositB2TxStatusSuccess}))
+	list, err := deposits.ListByStatus([]int{model.DepositB2TxStatusSuccess}, 0)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, "a", list[0].BtcTxHash)
+}
+
+func TestDepositRepository_Queries(t *testing.T) {
+	store := newTestStore(t)
+	deposits := store.Deposits()
+	for _, deposit := range []*model.Deposit{
+		{BtcTxHash: "a", BtcBlockNumber: 3, B2TxNonce: 1, BtcFrom: "abe1", BtcFromAAAddress: "0xAA", BtcValue: model.NewBigIntFromInt64(100), EligibleAtHeight: 10},
+		{BtcTxHash: "b", BtcBlockNumber: 2, B2TxNonce: 2, BtcFrom: "abe1", BtcFromAAAddress: "0xaa", BtcValue: model.NewBigIntFromInt64(200), BtcFee: model.NewBigIntFromInt64(1)},
+		{BtcTxHash: "c", BtcBlockNumber: 1, B2TxNonce: 3, BtcFrom: "abe2", BtcValue: model.NewBigIntFromInt64(400), B2TxRetry: 5},
+	} {
+		require.NoError(t, deposits.Create(deposit))
+	}
+	// the zero statuses are replaced by the column defaults on create
+	for hash, fields := range map[string]map[string]interface{}{
+		"a": {model.Deposit{}.Column().B2TxStatus: model.DepositB2TxStatusSuccess, model.Deposit{}.Column().PolicyStatus: model.DepositPolicyStatusApproved,
+			model.Deposit{}.Column().CallbackStatus: model.CallbackStatusPending, model.Deposit{}.Column().ApprovedAt: time.Now()},
+		"b": {model.Deposit{}.Column().B2TxStatus: model.DepositB2TxStatusSuccess, model.Deposit{}.Column().PolicyStatus: model.DepositPolicyStatusApproved,
+			model.Deposit{}.Column().ApprovedAt: time.Now()},
+		"c": {model.Deposit{}.Column().B2TxCheck: model.B2CheckStatusDoubleMint, model.Deposit{}.Column().PolicyStatus: model.DepositPolicyStatusApproved,
+			model.Deposit{}.Column().ListenerStatus: model.ListenerStatusPending, model.Deposit{}.Column().ApprovedAt: time.Now()},
+	} {
+		deposit, err := deposits.GetByBtcTxHash(hash)
+		require.NoError(t, err)
+		require.NoError(t, deposits.Update(deposit.ID, fields))
+	}
+	hashes := func(query DepositQuery) []string {
+		list, err := deposits.List(query)
+		require.NoError(t, err)
+		var hashes []string
+		for _, deposit := range list {
+			hashes = append(hashes, deposit.BtcTxHash)
+		}
+		return hashes
+	}
+
+	assert.Equal(t, []string{"c", "b", "a"}, hashes(DepositQuery{}))
+	assert.Equal(t, []string{"a", "b", "c"}, hashes(DepositQuery{ByNonce: true}))
+	assert.Equal(t, []string{"b", "a"}, hashes(DepositQuery{Statuses: []int{model.DepositB2TxStatusSuccess}}))
+	assert.Equal(t, []string{"b"}, hashes(DepositQuery{Statuses: []int{model.DepositB2TxStatusSuccess}, Limit: 1}))
+	assert.Equal(t, []string{"b"}, hashes(DepositQuery{FeeStatuses: []int{model.DepositB2TxStatusPending}}))
+	assert.Equal(t, []string{"c"}, hashes(DepositQuery{Checks: []int{model.B2CheckStatusDoubleMint}}))
+	assert.Equal(t, []string{"b", "a"}, hashes(DepositQuery{ExcludeChecks: []int{model.B2CheckStatusDoubleMint}}))
+	assert.Equal(t, []string{"b"}, hashes(DepositQuery{Notified: true}))
+	assert.Equal(t, []string{"b", "a"}, hashes(DepositQuery{MaxRetry: 1}))
+	height := int64(5)
+	assert.Equal(t, []string{"c", "b"}, hashes(DepositQuery{EligibleAt: &height}))
+	assert.Equal(t, []string{"c", "a"}, hashes(DepositQuery{BtcTxHashes: []string{"a", "c"}}))
+	assert.Equal(t, []string{"c", "b", "a"}, hashes(DepositQuery{CreatedFrom: time.Now().Add(-time.Hour), CreatedBefore: time.Now().Add(time.Hour)}))
+	assert.Empty(t, hashes(DepositQuery{CreatedBefore: time.Now().Add(-time.Hour)}))
+
+	since := time.Now().Add(-time.Hour)
+	volume, err := deposits.ApprovedVolume(since, "abe1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(300), volume.Int64())
+	volume, err = deposits.ApprovedVolume(since, "")
+	require.NoError(t, err)
+	assert.Equal(t, int64(700), volume.Int64())
+	volume, err = deposits.ApprovedVolume(time.Now().Add(time.Hour), "")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), volume.Int64())
+}
+
+func TestDepositEventRepository(t *testing.T) {
+	store := newTestStore(t)
+	events := store.DepositEvents()
+
+	require.NoError(t, events.Append(&model.DepositEvent{DepositID: 1, BtcTxHash: "a", Event: model.DepositEventBroadcast, B2TxHash: "0x1"}))
+	require.NoError(t, events.Append(&model.DepositEvent{DepositID: 1, BtcTxHash: "a", Event: model.DepositEventBroadcast, B2TxHash: "0x2", ReplacedTxHash: "0x1"}))
+	require.NoError(t, events.Append(&model.DepositEvent{DepositID: 2, BtcTxHash: "b", Event: model.DepositEventIndexed}))
+
+	list, err := events.ListByBtcTxHash("a")
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, "0x1", list[0].B2TxHash)
+	assert.Equal(t, "0x1", list[1].ReplacedTxHash)
+}
+
+func TestB2TxAttemptRepository(t *testing.T) {
+	store := newTestStore(t)
+	attempts := store.B2TxAttempts()
+
+	require.NoError(t, attempts.Create(&model.B2TxAttempt{DepositID: 1, BtcTxHash: "a", B2TxHash: "0x1"}))
+	require.NoError(t, attempts.Create(&model.B2TxAttempt{DepositID: 1, BtcTxHash: "a", B2TxHash: "0x2"}))
+	require.ErrorIs(t, attempts.Create(&model.B2TxAttempt{DepositID: 2, BtcTxHash: "b", B2TxHash: "0x2"}), ErrDuplicate)
+
+	created, err := attempts.CreateIfAbsent(&model.B2TxAttempt{DepositID: 2, BtcTxHash: "b", B2TxHash: "0x2"})
+	require.NoError(t, err)
+	assert.False(t, created)
+
+	pending, err := attempts.ListPending(0)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	assert.Equal(t, "0x1", pending[0].B2TxHash)
+
+	// a checked attempt goes behind the others
+	require.NoError(t, attempts.Update(pending[0].ID, map[string]interface{}{}))
+	pending, err = attempts.ListPending(1)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, "0x2", pending[0].B2TxHash)
+
+	require.NoError(t, attempts.Update(pending[0].ID, map[string]interface{}{
+		model.B2TxAttempt{}.Column().Status:       model.B2TxAttemptStatusSuccess,
+		model.B2TxAttempt{}.Column().ReceiptBlock: 7,
+	}))
+	list, err := attempts.ListByBtcTxHash("a")
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, model.B2TxAttemptStatusPending, list[0].Status)
+	assert.Equal(t, model.B2TxAttemptStatusSuccess, list[1].Status)
+	assert.Equal(t, uint64(7), list[1].ReceiptBlock)
+}
+
+func TestVaultUTXORepository(t *testing.T) {
+	store := newTestStore(t)
+	utxos := store.VaultUTXOs()
+
+	outpoint := func(i byte) wire.OutPoint { return wire.OutPoint{Hash: chainhash.Hash{i}, Index: uint32(i)} }
+	for i := byte(1); i <= 3; i++ {
+		created, err := utxos.CreateIfAbsent(&model.VaultUtxo{
+			TxID:        outpoint(i).Hash.String(),
+			Vout:        uint32(i),
+			Value:       int64(i) * 1000,
+			Address:     "vault",
+			BlockHeight: int64(10 - i),
+		})
+		require.NoError(t, err)
+		assert.True(t, created)
+	}
+	created, err := utxos.CreateIfAbsent(&model.VaultUtxo{TxID: outpoint(1).Hash.String(), Vout: 1, Address: "vault"})
+	require.NoError(t, err)
+	assert.False(t, created)
+
+	unspent, err := utxos.ListUnspent("vault")
+	require.NoError(t, err)
+	require.Len(t, unspent, 3)
+	assert.Equal(t, int64(7), unspent[0].BlockHeight)
+
+	// a conflicting reservation reserves none of the outputs
+	require.NoError(t, utxos.Reserve([]wire.OutPoint{outpoint(1)}, "tx1"))
+	require.ErrorIs(t, utxos.Reserve([]wire.OutPoint{outpoint(2), outpoint(1)}, "tx2"), ErrConflict)
+	unspent, err = utxos.ListUnspent("vault")
+	require.NoError(t, err)
+	assert.Len(t, unspent, 2)
+
+	require.NoError(t, utxos.Release("tx1"))
+	require.NoError(t, utxos.Reserve([]wire.OutPoint{outpoint(2), outpoint(1)}, "tx2"))
+	require.NoError(t, utxos.MarkSpent(map[wire.OutPoint]string{outpoint(2): "tx2", outpoint(1): "tx2", outpoint(9): "tx2"}))
+	// spent outputs stay spent
+	require.NoError(t, utxos.Release("tx2"))
+	unspent, err = utxos.ListUnspent("vault")
+	require.NoError(t, err)
+	require.Len(t, unspent, 1)
+	assert.Equal(t, uint32(3), unspent[0].Vout)
+}
+
+func TestStoreTransaction(t *testing.T) {
+	store := newTestStore(t)
+
+	rollback := errors.New("rollback")
+	err := store.Transaction(func(tx Store) error {
+		require.NoError(t, tx.Deposits().Create(&model.Deposit{BtcTxHash: "a"}))
+		require.NoError(t, tx.Cursors().SaveBtcIndex(&model.BtcIndex{Base: model.Base{ID: 1}, BtcIndexBlock: 10}))
+		return rollback
+	})
+	require.ErrorIs(t, err, rollback)
+
+	_, err = store.Deposits().GetByBtcTxHash("a")
+	require.ErrorIs(t, err, ErrNotFound)
+	_, err = store.Cursors().GetBtcIndex()
+	require.ErrorIs(t, err, ErrNotFound)
+
+	err = store.Transaction(func(tx Store) error {
+		return tx.Cursors().SaveBtcIndex(&model.BtcIndex{Base: model.Base{ID: 1}, BtcIndexBlock: 11})
+	})
+	require.NoError(t, err)
+	index, err := store.Cursors().GetBtcIndex()
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), index.BtcIndexBlock)
+}
+
+// legacyWithdraw withdraw_history unique on the b2 tx hash alone, rows without a log index
+type legacyWithdraw struct {
+	model.Base
+	B2TxHash   string `gorm:"type:varchar(256);default:'';uniqueIndex"`
+	B2LogIndex *uint  `gorm:"type:int"`
+	Status     int    `gorm:"type:smallint;default:1"`
+}
+
+func (legacyWithdraw) TableName() string {
+	return "withdraw_history"
+}
+
+func TestMigrateWithdrawIndex(t *testing.T) {
+	db, err := Open(Options{Source: SQLiteScheme + ":memory:", LogLevel: gormlog.Silent})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&legacyWithdraw{}))
+	require.NoError(t, db.Create(&legacyWithdraw{B2TxHash: "0x1"}).Error)
+	require.True(t, db.Migrator().HasIndex(&model.Withdraw{}, legacyWithdrawIndex))
+
+	store, err := New(db)
+	require.NoError(t, err)
+	require.NoError(t, store.Migrate(&model.Withdraw{}))
+	assert.False(t, db.Migrator().HasIndex(&model.Withdraw{}, legacyWithdrawIndex))
+
+	// the existing row gets log index 0, the other events of its tx are recorded
+	require.ErrorIs(t, store.Withdraws().Create(&model.Withdraw{B2TxHash: "0x1", B2LogIndex: 0}), ErrDuplicate)
+	require.NoError(t, store.Withdraws().Create(&model.Withdraw{B2TxHash: "0x1", B2LogIndex: 1}))
+	withdraws, err := store.Withdraws().ListByB2TxHash("0x1")
+	require.NoError(t, err)
+	require.Len(t, withdraws, 2)
+	assert.Equal(t, []uint{0, 1}, []uint{withdraws[0].B2LogIndex, withdraws[1].B2LogIndex})
+
+	// migrating again changes nothing
+	require.NoError(t, store.Migrate(&model.Withdraw{}))
+}
+
+func TestWithdrawAndRollupRepository(t *testing.T) {
+	store := newTestStore(t)
+
+	require.NoError(t, store.Withdraws().Create(&model.Withdraw{B2TxHash: "0x1", B2LogIndex: 4, Status: model.BtcTxWithdrawPending}))
+	require.ErrorIs(t, store.Withdraws().Create(&model.Withdraw{B2TxHash: "0x1", B2LogIndex: 4}), ErrDuplicate)
+	// another event of the same tx
+	require.NoError(t, store.Withdraws().Create(&model.Withdraw{B2TxHash: "0x1", B2LogIndex: 2, Status: model.BtcTxWithdrawHeld}))
+	pending, err := store.Withdraws().ListByStatus(model.BtcTxWithdrawPending, 10)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.NoError(t, store.Withdraws().UpdateStatus(pending[0].ID, model.BtcTxWithdrawSuccess))
+	withdraws, err := store.Withdraws().ListByB2TxHash("0x1")
+	require.NoError(t, err)
+	require.Len(t, withdraws, 2)
+	assert.Equal(t, uint(2), withdraws[0].B2LogIndex)
+	assert.Equal(t, model.BtcTxWithdrawSuccess, withdraws[1].Status)
+	assert.Equal(t, model.WithdrawChainBitcoin, withdraws[1].Chain)
+
+	require.NoError(t, store.Withdraws().Create(&model.Withdraw{B2TxHash: "0x2", Chain: model.WithdrawChainAbelian, Status: model.BtcTxWithdrawPending}))
+	require.NoError(t, store.Withdraws().Create(&model.Withdraw{B2TxHash: "0x3", Status: model.BtcTxWithdrawPending}))
+	abelian, err := store.Withdraws().ListByChain(model.WithdrawChainAbelian, model.BtcTxWithdrawPending, 0)
+	require.NoError(t, err)
+	require.Len(t, abelian, 1)
+	assert.Equal(t, "0x2", abelian[0].B2TxHash)
+	require.NoError(t, store.Withdraws().UpdateStatusByB2TxHashes([]string{"0x2", "0x3"}, model.BtcTxWithdrawSubmitTxMsg))
+	submitted, err := store.Withdraws().ListByStatus(model.BtcTxWithdrawSubmitTxMsg, 0)
+	require.NoError(t, err)
+	assert.Len(t, submitted, 2)
+	withdraws, err = store.Withdraws().ListByB2TxHashes([]string{"0x1", "0x3"})
+	require.NoError(t, err)
+	assert.Len(t, withdraws, 3)
+	withdraws, err = store.Withdraws().ListByB2TxHashes(nil)
+	require.NoError(t, err)
+	assert.Empty(t, withdraws)
+
+	require.NoError(t, store.RollupDeposits().Create(&model.RollupDeposit{BtcTxHash: "a", B2TxHash: "0xa1"}))
+	require.NoError(t, store.RollupDeposits().Create(&model.RollupDeposit{BtcTxHash: "a", B2TxHash: "0xa2"}))
+	require.ErrorIs(t, store.RollupDeposits().Create(&model.RollupDeposit{B2TxHash: "0xa2"}), ErrDuplicate)
+	rollups, err := store.RollupDeposits().ListByBtcTxHash("a")
+	require.NoError(t, err)
+	assert.Len(t, rollups, 2)
+	require.NoError(t, store.RollupDeposits().Create(&model.RollupDeposit{BtcTxHash: "b", B2TxHash: "0xb1"}))
+	rollups, err = store.RollupDeposits().ListByBtcTxHashes([]string{"b", "c"})
+	require.NoError(t, err)
+	require.Len(t, rollups, 1)
+	assert.Equal(t, "0xb1", rollups[0].B2TxHash)
+	rollups, err = store.RollupDeposits().ListCreated(time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, rollups, 3)
+	rollups, err = store.RollupDeposits().ListCreated(time.Now().Add(time.Hour), time.Now().Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, rollups)
+}
+
+// the big int sums stay exact past int64, sqlite keeps them as text
+func TestDepositRepository_Sums(t *testing.T) {
+	store := newTestStore(t)
+	deposits := store.Deposits()
+	now := time.Now()
+	for _, deposit := range []struct {
+		hash       string
+		value, fee int64
+		mintedAt   time.Time
+	}{
+		{"a", 1000, 10, now.Add(-2 * time.Hour)},
+		{"b", 500, 5, now},
+		{"c", 300, 0, now},
+	} {
+		require.NoError(t, deposits.Create(&model.Deposit{BtcTxHash: deposit.hash, BtcValue: model.NewBigIntFromInt64(deposit.value),
+			BtcFee: model.NewBigIntFromInt64(deposit.fee)}))
+		got, err := deposits.GetByBtcTxHash(deposit.hash)
+		require.NoError(t, err)
+		require.NoError(t, deposits.Update(got.ID, map[string]interface{}{
+			model.Deposit{}.Column().B2TxStatus:   model.DepositB2TxStatusSuccess,
+			model.Deposit{}.Column().FeeTxStatus:  model.DepositB2TxStatusSuccess,
+			model.Deposit{}.Column().PolicyStatus: model.DepositPolicyStatusApproved,
+			model.Deposit{}.Column().ApprovedAt:   deposit.mintedAt,
+			model.Deposit{}.Column().MintedAt:     deposit.mintedAt,
+		}))
+	}
+	// not minted
+	require.NoError(t, deposits.Create(&model.Deposit{BtcTxHash: "d", BtcValue: model.NewBigIntFromInt64(7000), B2TxStatus: model.DepositB2TxStatusPending}))
+
+	volume, err := deposits.MintedVolume(time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, "1785", volume.String())
+	volume, err = deposits.MintedVolume(now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, "795", volume.String())
+	fees, err := deposits.MintedFees()
+	require.NoError(t, err)
+	assert.Equal(t, "15", fees.String())
+	approved, err := deposits.ApprovedVolume(now.Add(-3*time.Hour), "")
+	require.NoError(t, err)
+	assert.Equal(t, "1800", approved.String())
+
+	huge, ok := new(big.Int).SetString("99999999999999999999", 10)
+	require.True(t, ok)
+	require.NoError(t, deposits.Create(&model.Deposit{BtcTxHash: "e", BtcValue: model.NewBigInt(huge), BtcFee: model.NewBigIntFromInt64(1)}))
+	got, err := deposits.GetByBtcTxHash("e")
+	require.NoError(t, err)
+	require.NoError(t, deposits.Update(got.ID, map[string]interface{}{
+		model.Deposit{}.Column().B2TxStatus: model.DepositB2TxStatusSuccess,
+		model.Deposit{}.Column().MintedAt:   now,
+	}))
+	volume, err = deposits.MintedVolume(time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, "100000000000000001783", volume.String())
+}
+
+func TestWithdrawTxRepository(t *testing.T) {
+	store := newTestStore(t)
+	withdrawTxs := store.WithdrawTxs()
+
+	_, err := withdrawTxs.GetByBtcTxID("a")
+	require.ErrorIs(t, err, ErrNotFound)
+	require.NoError(t, withdrawTxs.Create(&model.WithdrawTx{BtcTxID: "a", BtcTx: "psbt", B2TxHashes: `["0x1"]`,
+		Status: model.BtcTxWithdrawBroadcastSuccess, BroadcastTime: 100}))
+	require.NoError(t, withdrawTxs.Create(&model.WithdrawTx{BtcTxID: "b", B2TxHashes: `["0x1"]`, Status: model.BtcTxWithdrawPending}))
+	a, err := withdrawTxs.GetByBtcTxID("a")
+	require.NoError(t, err)
+
+	updated, err := withdrawTxs.UpdateIfBtcTx(a.ID, "other", map[string]interface{}{model.WithdrawTx{}.Column().BtcTx: "signed"})
+	require.NoError(t, err)
+	assert.False(t, updated)
+	updated, err = withdrawTxs.UpdateIfBtcTx(a.ID, "psbt", map[string]interface{}{model.WithdrawTx{}.Column().BtcTx: "signed"})
+	require.NoError(t, err)
+	assert.True(t, updated)
+
+	stuck, err := withdrawTxs.ListStuck(99)
+	require.NoError(t, err)
+	assert.Empty(t, stuck)
+	stuck, err = withdrawTxs.ListStuck(100)
+	require.NoError(t, err)
+	require.Len(t, stuck, 1)
+	replaced, err := withdrawTxs.SetReplacedBy(a.ID, "b")
+	require.NoError(t, err)
+	assert.True(t, replaced)
+	replaced, err = withdrawTxs.SetReplacedBy(a.ID, "c")
+	require.NoError(t, err)
+	assert.False(t, replaced)
+	stuck, err = withdrawTxs.ListStuck(100)
+	require.NoError(t, err)
+	assert.Empty(t, stuck)
+	require.NoError(t, withdrawTxs.ClearReplacedBy("a", "b"))
+	stuck, err = withdrawTxs.ListStuck(100)
+	require.NoError(t, err)
+	assert.Len(t, stuck, 1)
+
+	peers, err := withdrawTxs.UpdatePeers(a, []int{model.BtcTxWithdrawPending},
+		map[string]interface{}{model.WithdrawTx{}.Column().Status: model.BtcTxWithdrawReplaced})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), peers)
+	list, err := withdrawTxs.ListByStatus([]int{model.BtcTxWithdrawReplaced, model.BtcTxWithdrawBroadcastSuccess}, 0)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, "signed", list[0].BtcTx)
+	assert.Equal(t, model.BtcTxWithdrawReplaced, list[1].Status)
+}
+
+func TestCircuitBreakerRepository(t *testing.T) {
+	store := newTestStore(t)
+	_, err := store.CircuitBreaker().Get()
+	require.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, store.CircuitBreaker().Save(&model.CircuitBreaker{Paused: true, Reason: "manual"}))
+	require.NoError(t, store.CircuitBreaker().Save(&model.CircuitBreaker{Paused: true, Reason: "again"}))
+	state, err := store.CircuitBreaker().Get()
+	require.NoError(t, err)
+	assert.True(t, state.Paused)
+	assert.Equal(t, "again", state.Reason)
+}
+
+func TestAbelPayoutRepository(t *testing.T) {
+	store := newTestStore(t)
+	payouts := store.AbelPayouts()
+
+	created, err := payouts.CreateIfAbsent(&model.AbelPayout{IdempotencyKey: "0x1-0", AbelValue: 100, Status: model.BtcTxWithdrawPending})
+	require.NoError(t, err)
+	assert.True(t, created)
+	created, err = payouts.CreateIfAbsent(&model.AbelPayout{IdempotencyKey: "0x1-0", AbelValue: 200, Status: model.BtcTxWithdrawPending})
+	require.NoError(t, err)
+	assert.False(t, created)
+
+	pending, err := payouts.ListByStatus(model.BtcTxWithdrawPending, 10)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, int64(100), pending[0].AbelValue)
+
+	// only one claim of the payout succeeds
+	claimed, err := payouts.UpdateIfStatus(pending[0].ID, model.BtcTxWithdrawPending,
+		map[string]interface{}{model.AbelPayout{}.Column().Status: model.BtcTxWithdrawSubmitTxMsg})
+	require.NoError(t, err)
+	assert.True(t, claimed)
+	claimed, err = payouts.UpdateIfStatus(pending[0].ID, model.BtcTxWithdrawPending,
+		map[string]interface{}{model.AbelPayout{}.Column().Status: model.BtcTxWithdrawSubmitTxMsg})
+	require.NoError(t, err)
+	assert.False(t, claimed)
+}
+
+func TestAbelAddressRepository(t *testing.T) {
+	store := newTestStore(t)
+	addresses := store.AbelAddresses()
+
+	_, err := addresses.Get("0xAa")
+	require.ErrorIs(t, err, ErrNotFound)
+	require.NoError(t, addresses.Save(&model.AbelWithdrawAddress{L2Address: "0xAa", AbelAddress: "abe1", Nonce: 1}))
+	// replaced only by a higher nonce
+	require.ErrorIs(t, addresses.Save(&model.AbelWithdrawAddress{L2Address: "0xAa", AbelAddress: "abe2", Nonce: 1}), ErrConflict)
+	require.NoError(t, addresses.Save(&model.AbelWithdrawAddress{L2Address: "0xAa", AbelAddress: "abe3", Nonce: 2}))
+	address, err := addresses.Get("0xAa")
+	require.NoError(t, err)
+	assert.Equal(t, "abe3", address.AbelAddress)
+	assert.Equal(t, int64(2), address.Nonce)
+}
+
+func TestEvmEventRepository(t *testing.T) {
+	store := newTestStore(t)
+	events := store.EvmEvents()
+
+	for _, e := range []*model.EvmEvent{
+		{EventName: "MintWAbel", B2BlockNumber: 9, B2TxHash: "0x2", B2LogIndex: 0, Args: `{}`},
+		{EventName: "MintWAbel", B2BlockNumber: 8, B2TxHash: "0x1", B2LogIndex: 1, Args: `{}`},
+		{EventName: "BurnWAbel", B2BlockNumber: 8, B2TxHash: "0x1", B2LogIndex: 2, Args: `{}`},
+	} {
+		created, err := events.CreateIfAbsent(e)
+		require.NoError(t, err)
+		assert.True(t, created)
+	}
+	// the same log indexed again
+	created, err := events.CreateIfAbsent(&model.EvmEvent{EventName: "MintWAbel", B2TxHash: "0x1", B2LogIndex: 1, Args: `{}`})
+	require.NoError(t, err)
+	assert.False(t, created)
+
+	mints, err := events.ListByName("MintWAbel", 0)
+	require.NoError(t, err)
+	require.Len(t, mints, 2)
+	assert.Equal(t, "0x1", mints[0].B2TxHash)
+}
+
+func TestParkedLogRepository(t *testing.T) {
+	store := newTestStore(t)
+	logs := store.ParkedLogs()
+
+	for _, l := range []*model.ParkedLog{
+		{B2BlockNumber: 9, B2TxHash: "0x2", B2LogIndex: 0, Reason: "bad data"},
+		{B2BlockNumber: 8, B2TxHash: "0x1", B2LogIndex: 1, Reason: "bad data"},
+	} {
+		created, err := logs.CreateIfAbsent(l)
+		require.NoError(t, err)
+		assert.True(t, created)
+	}
+	// the same log parked again
+	created, err := logs.CreateIfAbsent(&model.ParkedLog{B2TxHash: "0x1", B2LogIndex: 1, Reason: "bad data"})
+	require.NoError(t, err)
+	assert.False(t, created)
+
+	parked, err := logs.List(0)
+	require.NoError(t, err)
+	require.Len(t, parked, 2)
+	assert.Equal(t, "0x1", parked[0].B2TxHash)
+}
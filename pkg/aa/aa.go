@@ -17,10 +17,14 @@ type Response struct {
 	AAAddress string
 }
 
-func GetPubKey(api, txId, btcFromAddress string, btcFromNetwork string) (*Response, error) {
+// GetPubKey get the aa address bound to the tx, nil client uses http.DefaultClient
+func GetPubKey(client *http.Client, api, txId, btcFromAddress string, btcFromNetwork string) (*Response, error) {
 	uri := fmt.Sprintf("%v/api/bridge/hash?hash=%v", api, txId)
 
-	res, err := http.Get(uri) //nolint
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Get(uri) //nolint
 	if err != nil {
 		return nil, err
 	}
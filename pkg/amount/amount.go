@@ -0,0 +1,155 @@
+// Package amount converts amounts between the source chain unit and the target token unit.
+// All amounts are integers in the smallest unit of their chain, carried as *big.Int.
+package amount
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const (
+	// MaxDigits max decimal digits of an amount, 2^256-1 has 78 digits
+	MaxDigits = 78
+)
+
+var (
+	ErrInvalidAmount  = errors.New("invalid amount")
+	ErrAmountOverflow = errors.New("amount overflow")
+	ErrPrecisionLoss  = errors.New("amount precision loss")
+
+	// MaxAmount max amount, uint256
+	MaxAmount = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+)
+
+// Converter source chain amount <-> target token amount
+type Converter struct {
+	sourceDecimals uint8
+	targetDecimals uint8
+	scale          *big.Int
+}
+
+// NewConverter source decimals of the source chain unit, target decimals of the target token
+func NewConverter(sourceDecimals uint8, targetDecimals uint8) *Converter {
+	diff := int64(targetDecimals) - int64(sourceDecimals)
+	if diff < 0 {
+		diff = -diff
+	}
+	return &Converter{
+		sourceDecimals: sourceDecimals,
+		targetDecimals: targetDecimals,
+		scale:          new(big.Int).Exp(big.NewInt(10), big.NewInt(diff), nil),
+	}
+}
+
+func (c *Converter) SourceDecimals() uint8 {
+	return c.sourceDecimals
+}
+
+func (c *Converter) TargetDecimals() uint8 {
+	return c.targetDecimals
+}
+
+// ToTarget source chain amount to target token amount
+func (c *Converter) ToTarget(v *big.Int) (*big.Int, error) {
+	return c.convert(v, c.targetDecimals >= c.sourceDecimals)
+}
+
+// ToSource target token amount to source chain amount
+func (c *Converter) ToSource(v *big.Int) (*big.Int, error) {
+	return c.convert(v, c.sourceDecimals >= c.targetDecimals)
+}
+
+func (c *Converter) convert(v *big.Int, up bool) (*big.Int, error) {
+	if v == nil || v.Sign() < 0 {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAmount, v)
+	}
+	if up {
+		result := new(big.Int).Mul(v, c.scale)
+		if result.Cmp(MaxAmount) > 0 {
+			return nil, fmt.Errorf("%w: %s", ErrAmountOverflow, result)
+		}
+		return result, nil
+	}
+	result, rem := new(big.Int).QuoRem(v, c.scale, new(big.Int))
+	if rem.Sign() != 0 {
+		return nil, fmt.Errorf("%w: %s not a multiple of %s", ErrPrecisionLoss, v, c.scale)
+	}
+	return result, nil
+}
+
+// Parse parse a non-negative integer amount
+// supported: hex "0x1f", decimal "100" or "100.00", scientific "1.5e8" or "15E+7".
+// A fractional part left after applying the exponent is rejected instead of truncated.
+func Parse(s string) (*big.Int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, fmt.Errorf("%w: empty", ErrInvalidAmount)
+	}
+	s = strings.TrimPrefix(s, "+")
+
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		hex := s[2:]
+		if hex == "" || len(hex) > 64 {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidAmount, s)
+		}
+		v, ok := new(big.Int).SetString(hex, 16)
+		if !ok || v.Sign() < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidAmount, s)
+		}
+		return v, nil
+	}
+
+	mantissa, exponent := s, int64(0)
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		mantissa = s[:i]
+		exp, ok := new(big.Int).SetString(strings.TrimPrefix(s[i+1:], "+"), 10)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidAmount, s)
+		}
+		if !exp.IsInt64() || exp.Int64() > MaxDigits || exp.Int64() < -MaxDigits {
+			return nil, fmt.Errorf("%w: %s", ErrAmountOverflow, s)
+		}
+		exponent = exp.Int64()
+	}
+
+	intPart, fracPart := mantissa, ""
+	if i := strings.IndexByte(mantissa, '.'); i >= 0 {
+		intPart, fracPart = mantissa[:i], mantissa[i+1:]
+	}
+	if intPart == "" && fracPart == "" {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidAmount, s)
+	}
+	digits := intPart + fracPart
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidAmount, s)
+		}
+	}
+	digits = strings.TrimLeft(digits, "0")
+	// shift the decimal point, remaining fraction digits must be zero
+	shift := exponent - int64(len(fracPart))
+	if shift < 0 {
+		cut := int64(len(digits)) + shift
+		if cut < 0 {
+			cut = 0
+		}
+		if strings.Trim(digits[cut:], "0") != "" {
+			return nil, fmt.Errorf("%w: %s", ErrPrecisionLoss, s)
+		}
+		digits = digits[:cut]
+		shift = 0
+	}
+	if digits == "" {
+		return new(big.Int), nil
+	}
+	if int64(len(digits))+shift > MaxDigits {
+		return nil, fmt.Errorf("%w: %s", ErrAmountOverflow, s)
+	}
+	v, _ := new(big.Int).SetString(digits+strings.Repeat("0", int(shift)), 10)
+	if v.Cmp(MaxAmount) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrAmountOverflow, s)
+	}
+	return v, nil
+}
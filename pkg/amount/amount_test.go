@@ -0,0 +1,90 @@
+package amount
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		input  string
+		expect string
+		err    error
+	}{
+		{input: "100", expect: "100"},
+		{input: " 100 ", expect: "100"},
+		{input: "+100", expect: "100"},
+		{input: "100.000", expect: "100"},
+		{input: "0", expect: "0"},
+		{input: "0x1f", expect: "31"},
+		{input: "0XFF", expect: "255"},
+		{input: "2e10", expect: "20000000000"},
+		{input: "1.5e8", expect: "150000000"},
+		{input: "15E+7", expect: "150000000"},
+		{input: "1.23456789e8", expect: "123456789"},
+		{input: "1200e-2", expect: "12"},
+		{input: "9.2233720368547758079e19", expect: "92233720368547758079"},
+		{input: "1.15792089237316195423570985008687907853269984665640564039457584007913129639935e77",
+			expect: MaxAmount.String()},
+		{input: "", err: ErrInvalidAmount},
+		{input: "-1", err: ErrInvalidAmount},
+		{input: "abc", err: ErrInvalidAmount},
+		{input: "0x", err: ErrInvalidAmount},
+		{input: "0xzz", err: ErrInvalidAmount},
+		{input: "0x-1", err: ErrInvalidAmount},
+		{input: "1e", err: ErrInvalidAmount},
+		{input: ".", err: ErrInvalidAmount},
+		{input: "100.5", err: ErrPrecisionLoss},
+		{input: "1.5e-1", err: ErrPrecisionLoss},
+		{input: "1e79", err: ErrAmountOverflow},
+		{input: "1e100000000000000000000", err: ErrAmountOverflow},
+		{input: "2e77", err: ErrAmountOverflow},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			v, err := Parse(tc.input)
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expect, v.String())
+		})
+	}
+}
+
+func TestConverter(t *testing.T) {
+	c := NewConverter(7, 18)
+
+	target, err := c.ToTarget(big.NewInt(11000000))
+	require.NoError(t, err)
+	assert.Equal(t, "1100000000000000000", target.String())
+
+	source, err := c.ToSource(target)
+	require.NoError(t, err)
+	assert.Equal(t, "11000000", source.String())
+
+	_, err = c.ToSource(big.NewInt(1))
+	require.ErrorIs(t, err, ErrPrecisionLoss)
+
+	_, err = c.ToTarget(big.NewInt(-1))
+	require.ErrorIs(t, err, ErrInvalidAmount)
+
+	_, err = c.ToTarget(MaxAmount)
+	require.ErrorIs(t, err, ErrAmountOverflow)
+
+	// target with fewer decimals than source
+	down := NewConverter(18, 7)
+	v, err := down.ToTarget(target)
+	require.NoError(t, err)
+	assert.Equal(t, "11000000", v.String())
+
+	same := NewConverter(8, 8)
+	v, err = same.ToSource(big.NewInt(5))
+	require.NoError(t, err)
+	assert.Equal(t, "5", v.String())
+}
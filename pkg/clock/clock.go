@@ -0,0 +1,60 @@
+// Package clock abstracts the wall clock, so the services can run against a fake
+// clock when recorded traffic is replayed.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock wall clock used by the services
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	Sleep(d time.Duration)
+}
+
+// Real clock backed by the time package
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+
+// Fake clock advancing instantly, a wait moves the clock forward by its duration
+// and returns at once
+type Fake struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFake new fake clock starting at now
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Advance move the clock forward by d
+func (f *Fake) Advance(d time.Duration) time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	return f.now
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	ch <- f.Advance(d)
+	return ch
+}
+
+func (f *Fake) Sleep(d time.Duration) {
+	f.Advance(d)
+}
@@ -0,0 +1,25 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+	assert.Equal(t, start, c.Now())
+
+	c.Sleep(time.Minute)
+	assert.Equal(t, start.Add(time.Minute), c.Now())
+
+	select {
+	case now := <-c.After(time.Hour):
+		assert.Equal(t, start.Add(time.Hour+time.Minute), now)
+	case <-time.After(time.Second):
+		t.Fatal("fake clock After blocked")
+	}
+	assert.Equal(t, start.Add(time.Hour+time.Minute), c.Now())
+}
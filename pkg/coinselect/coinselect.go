@@ -0,0 +1,270 @@
+// Package coinselect picks the inputs of a segwit transaction and sizes its fee and change.
+// Sizes are counted in weight units, fees are paid on the virtual size, ceil(weight / 4).
+package coinselect
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Strategy coin selection strategy
+type Strategy string
+
+const (
+	// StrategyBnB branch and bound search for an input set without change,
+	// falls back to largest first when there is none
+	StrategyBnB Strategy = "bnb"
+	// StrategyLargestFirst the largest coins first until the outputs and fee are paid
+	StrategyLargestFirst Strategy = "largest-first"
+)
+
+const (
+	// WitnessScaleFactor weight units per non-witness byte
+	WitnessScaleFactor = 4
+	// DustRelayFeeRate fee rate in sat/vbyte the dust threshold is counted at, bitcoind -dustrelayfee
+	DustRelayFeeRate = 3
+	// MaxSignatureSize der ecdsa signature with the sighash byte
+	MaxSignatureSize = 73
+
+	// inputBaseSize outpoint 36 + script sig length 1 + sequence 4
+	inputBaseSize = 32 + 4 + 1 + 4
+	// txOverheadSize version 4 + locktime 4, the input and output counts are var ints
+	txOverheadSize = 4 + 4
+	// segwitMarkerWeight marker and flag bytes, witness data
+	segwitMarkerWeight = 2
+	// bnbMaxTries branches searched before branch and bound gives up
+	bnbMaxTries = 100000
+)
+
+var (
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrMaxInputs         = errors.New("max inputs exceeded")
+	ErrUnknownStrategy   = errors.New("unknown coin selection strategy")
+)
+
+// Coin spendable output
+type Coin struct {
+	OutPoint wire.OutPoint
+	Value    int64
+}
+
+// Params outputs paid and how the inputs and change are sized
+type Params struct {
+	// Outputs payment outputs
+	Outputs []*wire.TxOut
+	// ChangeScript pk script of the change output
+	ChangeScript []byte
+	// InputWeight weight of one input, all the coins are of the same script type
+	InputWeight int64
+	// FeeRate sat/vbyte
+	FeeRate int64
+	// MaxInputs max inputs of the tx, 0 means no limit
+	MaxInputs int
+}
+
+// Result the coins to spend, Change is 0 when the change is dust and left to the fee
+type Result struct {
+	Coins  []Coin
+	Change int64
+	Fee    int64
+	VSize  int64
+}
+
+// MultisigInputWeight weight of an input spending a p2wsh m-of-n multisig with compressed keys
+//   - non-witness: outpoint 36, script sig length 1, sequence 4
+//   - witness: item count, empty dummy item, m signatures, witness script
+//   - witness script: OP_m, n * (OP_DATA_33 + key), OP_n, OP_CHECKMULTISIG
+func MultisigInputWeight(m, n int) int64 {
+	scriptSize := 3 + n*(1+33)
+	witnessSize := wire.VarIntSerializeSize(uint64(m+2)) + 1 + m*(1+MaxSignatureSize) +
+		wire.VarIntSerializeSize(uint64(scriptSize)) + scriptSize
+	return int64(inputBaseSize*WitnessScaleFactor + witnessSize)
+}
+
+// TxWeight weight of a segwit tx with the inputs of inputWeight paying the outputs
+func TxWeight(inputs int, inputWeight int64, outputs []*wire.TxOut) int64 {
+	size := txOverheadSize + wire.VarIntSerializeSize(uint64(inputs)) + wire.VarIntSerializeSize(uint64(len(outputs)))
+	for _, out := range outputs {
+		size += out.SerializeSize()
+	}
+	return int64(size*WitnessScaleFactor+segwitMarkerWeight) + int64(inputs)*inputWeight
+}
+
+// VSize virtual size of the weight
+func VSize(weight int64) int64 {
+	return (weight + WitnessScaleFactor - 1) / WitnessScaleFactor
+}
+
+// DustThreshold smallest value of an output paying pkScript that is not dust,
+// the relay fee of the output and of the input spending it, as bitcoind counts it
+func DustThreshold(pkScript []byte, relayFeeRate int64) int64 {
+	size := wire.NewTxOut(0, pkScript).SerializeSize()
+	if txscript.IsWitnessProgram(pkScript) {
+		size += inputBaseSize + 107/WitnessScaleFactor
+	} else {
+		size += inputBaseSize + 107
+	}
+	return int64(size) * relayFeeRate
+}
+
+// Select the coins paying params.Outputs at params.FeeRate
+func Select(strategy Strategy, coins []Coin, params Params) (*Result, error) {
+	switch strategy {
+	case StrategyBnB:
+		if selected := BranchAndBound(coins, params); selected != nil {
+			return finalize(selected, params)
+		}
+		return LargestFirst(coins, params)
+	case StrategyLargestFirst:
+		return LargestFirst(coins, params)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownStrategy, strategy)
+	}
+}
+
+// SpendAll spend every coin, the inputs of a replacement conflicting with all the inputs of the tx it replaces
+func SpendAll(coins []Coin, params Params) (*Result, error) {
+	return finalize(coins, params)
+}
+
+// LargestFirst spend the largest coins until the outputs and the fee without change are paid
+func LargestFirst(coins []Coin, params Params) (*Result, error) {
+	sorted := effectiveCoins(coins, params)
+	target := targetValue(params)
+	var selected []Coin
+	var value int64
+	for _, coin := range sorted {
+		if value >= target {
+			break
+		}
+		if params.MaxInputs > 0 && len(selected) == params.MaxInputs {
+			return nil, fmt.Errorf("%w: %d inputs pay %d of %d", ErrMaxInputs, len(selected), value, target)
+		}
+		selected = append(selected, coin.Coin)
+		value += coin.effective
+	}
+	if value < target {
+		return nil, fmt.Errorf("%w: %d available, %d needed", ErrInsufficientFunds, value, target)
+	}
+	return finalize(selected, params)
+}
+
+// BranchAndBound search the coins for a set paying the outputs without change, wasting less than the
+// cost of creating and spending a change output. Returns the set with the least excess, nil if none is found.
+func BranchAndBound(coins []Coin, params Params) []Coin {
+	sorted := effectiveCoins(coins, params)
+	target := targetValue(params)
+	changeWeight := int64(wire.NewTxOut(0, params.ChangeScript).SerializeSize() * WitnessScaleFactor)
+	costOfChange := feeOf(changeWeight, params.FeeRate) + feeOf(params.InputWeight, params.FeeRate)
+
+	var available int64
+	for _, coin := range sorted {
+		available += coin.effective
+	}
+	var best []int
+	bestExcess := int64(math.MaxInt64)
+	tries := 0
+	var search func(depth int, selected []int, value, available int64, prevExcluded bool)
+	search = func(depth int, selected []int, value, available int64, prevExcluded bool) {
+		tries++
+		if tries > bnbMaxTries || value > target+costOfChange {
+			return
+		}
+		if value >= target {
+			if excess := value - target; excess < bestExcess || (excess == bestExcess && len(selected) < len(best)) {
+				best, bestExcess = append([]int(nil), selected...), excess
+			}
+			return
+		}
+		if depth == len(sorted) || value+available < target ||
+			(params.MaxInputs > 0 && len(selected) == params.MaxInputs) {
+			return
+		}
+		effective := sorted[depth].effective
+		// including a coin equal to the excluded previous one searches the same sets again
+		if !(prevExcluded && depth > 0 && sorted[depth-1].effective == effective) {
+			search(depth+1, append(selected, depth), value+effective, available-effective, false)
+		}
+		search(depth+1, selected, value, available-effective, true)
+	}
+	search(0, nil, 0, available, false)
+	if best == nil {
+		return nil
+	}
+	selected := make([]Coin, 0, len(best))
+	for _, index := range best {
+		selected = append(selected, sorted[index].Coin)
+	}
+	return selected
+}
+
+type effectiveCoin struct {
+	Coin
+	effective int64
+}
+
+// effectiveCoins value of the coins less the fee of spending them, largest first, equal values by outpoint
+// so the selection does not depend on the order the coins are listed in. Coins not paying for their own input are left out.
+func effectiveCoins(coins []Coin, params Params) []effectiveCoin {
+	inputFee := feeOf(params.InputWeight, params.FeeRate)
+	sorted := make([]effectiveCoin, 0, len(coins))
+	for _, coin := range coins {
+		if effective := coin.Value - inputFee; effective > 0 {
+			sorted = append(sorted, effectiveCoin{Coin: coin, effective: effective})
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].effective != sorted[j].effective {
+			return sorted[i].effective > sorted[j].effective
+		}
+		if c := bytes.Compare(sorted[i].OutPoint.Hash[:], sorted[j].OutPoint.Hash[:]); c != 0 {
+			return c < 0
+		}
+		return sorted[i].OutPoint.Index < sorted[j].OutPoint.Index
+	})
+	return sorted
+}
+
+// targetValue outputs and the fee of the tx without inputs and change
+func targetValue(params Params) int64 {
+	target := feeOf(TxWeight(0, 0, params.Outputs), params.FeeRate)
+	for _, out := range params.Outputs {
+		target += out.Value
+	}
+	return target
+}
+
+// finalize size the fee of the selected coins exactly, the change is added unless it is dust
+func finalize(selected []Coin, params Params) (*Result, error) {
+	var inputValue, outputValue int64
+	for _, coin := range selected {
+		inputValue += coin.Value
+	}
+	for _, out := range params.Outputs {
+		outputValue += out.Value
+	}
+	weight := TxWeight(len(selected), params.InputWeight, params.Outputs)
+	fee := VSize(weight) * params.FeeRate
+	if inputValue < outputValue+fee {
+		return nil, fmt.Errorf("%w: %d available, %d needed", ErrInsufficientFunds, inputValue, outputValue+fee)
+	}
+	result := &Result{Coins: selected, Fee: inputValue - outputValue, VSize: VSize(weight)}
+
+	outputs := append(append([]*wire.TxOut(nil), params.Outputs...), wire.NewTxOut(0, params.ChangeScript))
+	changeWeight := TxWeight(len(selected), params.InputWeight, outputs)
+	changeFee := VSize(changeWeight) * params.FeeRate
+	if change := inputValue - outputValue - changeFee; change >= DustThreshold(params.ChangeScript, DustRelayFeeRate) {
+		result.Change, result.Fee, result.VSize = change, changeFee, VSize(changeWeight)
+	}
+	return result, nil
+}
+
+func feeOf(weight int64, feeRate int64) int64 {
+	return VSize(weight) * feeRate
+}
@@ -0,0 +1,181 @@
+package coinselect
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	p2pkhScript  = append(append([]byte{txscript.OP_DUP, txscript.OP_HASH160, txscript.OP_DATA_20}, make([]byte, 20)...), txscript.OP_EQUALVERIFY, txscript.OP_CHECKSIG)
+	p2wpkhScript = append([]byte{txscript.OP_0, txscript.OP_DATA_20}, make([]byte, 20)...)
+	p2wshScript  = append([]byte{txscript.OP_0, txscript.OP_DATA_32}, make([]byte, 32)...)
+)
+
+func coins(values ...int64) []Coin {
+	result := make([]Coin, 0, len(values))
+	for i, value := range values {
+		result = append(result, Coin{OutPoint: wire.OutPoint{Hash: chainhash.Hash{byte(i)}, Index: uint32(i)}, Value: value})
+	}
+	return result
+}
+
+func values(coins []Coin) []int64 {
+	result := make([]int64, 0, len(coins))
+	for _, coin := range coins {
+		result = append(result, coin.Value)
+	}
+	return result
+}
+
+func TestDustThreshold(t *testing.T) {
+	assert.Equal(t, int64(546), DustThreshold(p2pkhScript, DustRelayFeeRate))
+	assert.Equal(t, int64(294), DustThreshold(p2wpkhScript, DustRelayFeeRate))
+	assert.Equal(t, int64(330), DustThreshold(p2wshScript, DustRelayFeeRate))
+}
+
+func TestMultisigInputWeight(t *testing.T) {
+	testCases := []struct {
+		m, n   int
+		weight int64
+	}{
+		// 164 + 1 + 1 + 74 + 1 + 71
+		{m: 1, n: 2, weight: 312},
+		// 164 + 1 + 1 + 148 + 1 + 105
+		{m: 2, n: 3, weight: 420},
+		// 164 + 1 + 1 + 222 + 1 + 173
+		{m: 3, n: 5, weight: 562},
+		// 164 + 1 + 1 + 814 + 3 + 513, the witness script length takes 3 bytes
+		{m: 11, n: 15, weight: 1496},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.weight, MultisigInputWeight(tc.m, tc.n), "%d-of-%d", tc.m, tc.n)
+	}
+}
+
+// the estimate matches the weight of a tx with max size signatures
+func TestTxWeight(t *testing.T) {
+	witnessScript := append([]byte{txscript.OP_2}, bytes.Repeat(append([]byte{txscript.OP_DATA_33}, make([]byte, 33)...), 3)...)
+	witnessScript = append(witnessScript, txscript.OP_3, txscript.OP_CHECKMULTISIG)
+	tx := wire.NewMsgTx(wire.TxVersion)
+	for i := 0; i < 2; i++ {
+		in := wire.NewTxIn(&wire.OutPoint{Index: uint32(i)}, nil, nil)
+		in.Witness = wire.TxWitness{nil, make([]byte, MaxSignatureSize), make([]byte, MaxSignatureSize), witnessScript}
+		tx.AddTxIn(in)
+	}
+	tx.AddTxOut(wire.NewTxOut(1000, p2wpkhScript))
+	tx.AddTxOut(wire.NewTxOut(1000, p2pkhScript))
+	tx.AddTxOut(wire.NewTxOut(1000, p2wshScript))
+
+	weight := TxWeight(2, MultisigInputWeight(2, 3), tx.TxOut)
+	assert.Equal(t, blockchain.GetTransactionWeight(btcutil.NewTx(tx)), weight)
+	// 10 + 31 + 34 + 43 non-witness bytes, 2 marker and flag, 2 inputs
+	assert.Equal(t, int64(118*4+2+2*420), weight)
+	assert.Equal(t, int64(329), VSize(weight))
+}
+
+func TestSelect(t *testing.T) {
+	params := Params{
+		Outputs:      []*wire.TxOut{wire.NewTxOut(50000, p2wpkhScript)},
+		ChangeScript: p2wshScript,
+		InputWeight:  MultisigInputWeight(2, 3),
+		FeeRate:      10,
+	}
+	// the fee of a tx spending n inputs without change, 10 sat/vbyte
+	feeWithout := func(n int) int64 { return VSize(TxWeight(n, params.InputWeight, params.Outputs)) * 10 }
+	feeWith := func(n int) int64 {
+		return VSize(TxWeight(n, params.InputWeight, append(params.Outputs, wire.NewTxOut(0, p2wshScript)))) * 10
+	}
+	// 41 + 43 change bytes, 2 marker and flag, 420 input
+	require.Equal(t, int64(1470), feeWithout(1))
+	require.Equal(t, int64(1900), feeWith(1))
+
+	t.Run("bnb exact match", func(t *testing.T) {
+		// 50000 + fee of 2 inputs, paid exactly by 30000 and 22520 without change
+		result, err := Select(StrategyBnB, coins(100000, 30000, 40000, 50000+feeWithout(2)-30000), params)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []int64{30000, 50000 + feeWithout(2) - 30000}, values(result.Coins))
+		assert.Zero(t, result.Change)
+		assert.Equal(t, feeWithout(2), result.Fee)
+	})
+
+	t.Run("bnb falls back to largest first", func(t *testing.T) {
+		result, err := Select(StrategyBnB, coins(20000, 100000, 70000), params)
+		require.NoError(t, err)
+		assert.Equal(t, []int64{100000}, values(result.Coins))
+		assert.Equal(t, 100000-50000-feeWith(1), result.Change)
+		assert.Equal(t, feeWith(1), result.Fee)
+	})
+
+	t.Run("largest first", func(t *testing.T) {
+		result, err := Select(StrategyLargestFirst, coins(20000, 30000, 10000, 25000), params)
+		require.NoError(t, err)
+		assert.Equal(t, []int64{30000, 25000}, values(result.Coins))
+		assert.Equal(t, 55000-50000-feeWith(2), result.Change)
+		assert.Equal(t, feeWith(2), result.Fee)
+	})
+
+	t.Run("dust change left to the fee", func(t *testing.T) {
+		value := 50000 + feeWith(1) + DustThreshold(p2wshScript, DustRelayFeeRate) - 1
+		result, err := Select(StrategyLargestFirst, coins(value), params)
+		require.NoError(t, err)
+		assert.Zero(t, result.Change)
+		assert.Equal(t, value-50000, result.Fee)
+
+		result, err = Select(StrategyLargestFirst, coins(value+1), params)
+		require.NoError(t, err)
+		assert.Equal(t, DustThreshold(p2wshScript, DustRelayFeeRate), result.Change)
+		assert.Equal(t, feeWith(1), result.Fee)
+	})
+
+	t.Run("uneconomic coins are not spent", func(t *testing.T) {
+		result, err := Select(StrategyLargestFirst, coins(1050, 60000, 1000), params)
+		require.NoError(t, err)
+		assert.Equal(t, []int64{60000}, values(result.Coins))
+	})
+
+	t.Run("equal coins by outpoint", func(t *testing.T) {
+		listed := coins(20000, 20000, 20000, 20000, 20000)
+		reversed := make([]Coin, 0, len(listed))
+		for i := len(listed) - 1; i >= 0; i-- {
+			reversed = append(reversed, listed[i])
+		}
+		result, err := Select(StrategyLargestFirst, listed, params)
+		require.NoError(t, err)
+		again, err := Select(StrategyLargestFirst, reversed, params)
+		require.NoError(t, err)
+		assert.Equal(t, result.Coins, again.Coins)
+		assert.Equal(t, listed[:3], result.Coins)
+	})
+
+	t.Run("max inputs", func(t *testing.T) {
+		limited := params
+		limited.MaxInputs = 2
+		_, err := Select(StrategyLargestFirst, coins(20000, 20000, 20000), limited)
+		require.ErrorIs(t, err, ErrMaxInputs)
+		_, err = Select(StrategyBnB, coins(20000, 20000, 20000), limited)
+		require.ErrorIs(t, err, ErrMaxInputs)
+		result, err := Select(StrategyBnB, coins(20000, 20000, 20000), params)
+		require.NoError(t, err)
+		assert.Len(t, result.Coins, 3)
+	})
+
+	t.Run("insufficient funds", func(t *testing.T) {
+		_, err := Select(StrategyBnB, coins(20000, 30000), params)
+		require.ErrorIs(t, err, ErrInsufficientFunds)
+		_, err = Select(StrategyBnB, nil, params)
+		require.ErrorIs(t, err, ErrInsufficientFunds)
+	})
+
+	t.Run("unknown strategy", func(t *testing.T) {
+		_, err := Select("random", coins(100000), params)
+		require.ErrorIs(t, err, ErrUnknownStrategy)
+	})
+}
@@ -12,7 +12,7 @@ import (
 
 var (
 	DepositName = "depositV3"
-	DepositHash = crypto.Keccak256([]byte("DepositEvent(address,address,uint256,byte32)"))
+	DepositHash = crypto.Keccak256([]byte("DepositEvent(address,address,uint256,bytes32)"))
 )
 
 type Deposit struct {
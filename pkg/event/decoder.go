@@ -0,0 +1,176 @@
+package event
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+var (
+	ErrUnknownEvent = errors.New("event not in the abi")
+	ErrNotListed    = errors.New("log of an event not listed")
+)
+
+// Decoder decode the logs of the listed events of an abi by name, instead of by argument position
+type Decoder struct {
+	events map[common.Hash]abi.Event
+}
+
+// Event decoded log, the arguments by name, indexed ones included
+type Event struct {
+	Name string
+	// Signature canonical signature, e.g. Transfer(address,address,uint256)
+	Signature string
+	Args      map[string]interface{}
+	// argTypes abi type of every argument
+	argTypes map[string]string
+}
+
+// TypedArg argument with its abi type, the value as json: integers as decimal strings, bytes as hex
+type TypedArg struct {
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// NewDecoder decoder of the named events of the abi json
+func NewDecoder(abiJSON string, names []string) (*Decoder, error) {
+	contractABI, err := abi.JSON(strings.NewReader(abiJSON))
+	if err != nil {
+		return nil, err
+	}
+	d := &Decoder{events: make(map[common.Hash]abi.Event, len(names))}
+	for _, name := range names {
+		e, ok := contractABI.Events[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownEvent, name)
+		}
+		d.events[e.ID] = e
+	}
+	return d, nil
+}
+
+// Register decode the logs whose first topic is the topic as the named event of the abi json, a zero topic
+// is the event id. A deployment configures the hash of an event whose abi is known this way.
+func (d *Decoder) Register(abiJSON string, name string, topic common.Hash) error {
+	contractABI, err := abi.JSON(strings.NewReader(abiJSON))
+	if err != nil {
+		return err
+	}
+	e, ok := contractABI.Events[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownEvent, name)
+	}
+	if topic == (common.Hash{}) {
+		topic = e.ID
+	}
+	d.events[topic] = e
+	return nil
+}
+
+// Topics event hashes of the listed events, the first topic of their logs
+func (d *Decoder) Topics() []common.Hash {
+	topics := make([]common.Hash, 0, len(d.events))
+	for id := range d.events {
+		topics = append(topics, id)
+	}
+	return topics
+}
+
+// Listed the event of the name is decoded
+func (d *Decoder) Listed(name string) bool {
+	for _, e := range d.events {
+		if e.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Handles the log is of a listed event
+func (d *Decoder) Handles(log types.Log) bool {
+	if len(log.Topics) == 0 {
+		return false
+	}
+	_, ok := d.events[log.Topics[0]]
+	return ok
+}
+
+// Decode the arguments of the log, the data with abi.UnpackIntoMap and the indexed arguments from the topics
+func (d *Decoder) Decode(log types.Log) (*Event, error) {
+	if !d.Handles(log) {
+		return nil, ErrNotListed
+	}
+	e := d.events[log.Topics[0]]
+	args := make(map[string]interface{}, len(e.Inputs))
+	if err := e.Inputs.UnpackIntoMap(args, log.Data); err != nil {
+		return nil, fmt.Errorf("%s data: %w", e.Name, err)
+	}
+	var indexed abi.Arguments
+	for _, input := range e.Inputs {
+		if input.Indexed {
+			indexed = append(indexed, input)
+		}
+	}
+	if err := abi.ParseTopicsIntoMap(args, indexed, log.Topics[1:]); err != nil {
+		return nil, fmt.Errorf("%s topics: %w", e.Name, err)
+	}
+	argTypes := make(map[string]string, len(e.Inputs))
+	for _, input := range e.Inputs {
+		argTypes[input.Name] = input.Type.String()
+	}
+	return &Event{Name: e.Name, Signature: e.Sig, Args: args, argTypes: argTypes}, nil
+}
+
+// TypedArgs the arguments with their abi types, ready to be stored as json
+func (e *Event) TypedArgs() map[string]TypedArg {
+	typed := make(map[string]TypedArg, len(e.Args))
+	for name, value := range e.Args {
+		typed[name] = TypedArg{Type: e.argTypes[name], Value: jsonValue(value)}
+	}
+	return typed
+}
+
+// jsonValue value of an unpacked argument that survives json: big integers would lose precision
+// as json numbers and byte arrays would be lists of numbers
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *big.Int:
+		return v.String()
+	case common.Address:
+		return v.Hex()
+	case common.Hash:
+		return v.Hex()
+	case []byte:
+		return hexutil.Encode(v)
+	case string, bool:
+		return v
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return big.NewInt(rv.Int()).String()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return new(big.Int).SetUint64(rv.Uint()).String()
+	case reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, rv.Len())
+			reflect.Copy(reflect.ValueOf(b), rv)
+			return hexutil.Encode(b)
+		}
+		fallthrough
+	case reflect.Slice:
+		values := make([]interface{}, 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			values = append(values, jsonValue(rv.Index(i).Interface()))
+		}
+		return values
+	}
+	return value
+}
@@ -0,0 +1,93 @@
+package event_test
+
+import (
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/qday-io/qday-abel-bridge-indexer/config"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/event"
+	"github.com/qday-io/qday-abel-bridge-indexer/pkg/event/bridge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// depositEventABI the rollup deposit event, not part of the default abi
+const depositEventABI = `[{"anonymous":false,"type":"event","name":"DepositEvent","inputs":[
+	{"indexed":true,"internalType":"address","name":"caller","type":"address"},
+	{"indexed":true,"internalType":"address","name":"toAddress","type":"address"},
+	{"indexed":false,"internalType":"uint256","name":"amount","type":"uint256"},
+	{"indexed":false,"internalType":"bytes32","name":"txHash","type":"bytes32"}]}]`
+
+func eventLog(t *testing.T, abiJSON string, name string, topics []common.Hash, data ...interface{}) types.Log {
+	t.Helper()
+	contractABI, err := abi.JSON(strings.NewReader(abiJSON))
+	require.NoError(t, err)
+	e := contractABI.Events[name]
+	packed, err := e.Inputs.NonIndexed().Pack(data...)
+	require.NoError(t, err)
+	return types.Log{Topics: append([]common.Hash{e.ID}, topics...), Data: packed}
+}
+
+func TestDecoder(t *testing.T) {
+	decoder, err := event.NewDecoder(config.DefaultDepositAbi, []string{"MintWAbel", "BurnWAbel"})
+	require.NoError(t, err)
+	assert.Len(t, decoder.Topics(), 2)
+	assert.True(t, decoder.Listed("BurnWAbel"))
+	assert.False(t, decoder.Listed("Stake"))
+
+	to := common.HexToAddress("0x1111111254fb6c44bAC0beD2854e76F90643097d")
+	amount, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	vlog := eventLog(t, config.DefaultDepositAbi, "MintWAbel", []common.Hash{common.BytesToHash(to.Bytes())}, amount, big.NewInt(30))
+
+	decoded, err := decoder.Decode(vlog)
+	require.NoError(t, err)
+	assert.Equal(t, "MintWAbel", decoded.Name)
+	assert.Equal(t, "MintWAbel(address,uint256,uint256)", decoded.Signature)
+	assert.Equal(t, to, decoded.Args["to"])
+	assert.Equal(t, 0, amount.Cmp(decoded.Args["amount"].(*big.Int)))
+
+	// integers as decimal strings, no precision lost
+	typed, err := json.Marshal(decoded.TypedArgs())
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"to":{"type":"address","value":"0x1111111254fb6c44bAC0beD2854e76F90643097d"},
+		"amount":{"type":"uint256","value":"123456789012345678901234567890"},
+		"lockDay":{"type":"uint256","value":"30"}}`, string(typed))
+
+	stake := eventLog(t, config.DefaultDepositAbi, "Stake", nil, to, big.NewInt(1), big.NewInt(2))
+	assert.False(t, decoder.Handles(stake))
+	_, err = decoder.Decode(stake)
+	require.ErrorIs(t, err, event.ErrNotListed)
+
+	_, err = event.NewDecoder(config.DefaultDepositAbi, []string{"DepositEvent"})
+	require.ErrorIs(t, err, event.ErrUnknownEvent)
+}
+
+func TestDecoder_Bytes32(t *testing.T) {
+	decoder, err := event.NewDecoder(depositEventABI, []string{"DepositEvent"})
+	require.NoError(t, err)
+	// the positional decoder hashes the same signature
+	assert.Equal(t, []common.Hash{(&bridge.Deposit{}).EventHash()}, decoder.Topics())
+
+	caller, to := common.HexToAddress("0x01"), common.HexToAddress("0x02")
+	txHash := common.HexToHash("0xc0ffee")
+	vlog := eventLog(t, depositEventABI, "DepositEvent",
+		[]common.Hash{common.BytesToHash(caller.Bytes()), common.BytesToHash(to.Bytes())}, big.NewInt(16), txHash)
+	decoded, err := decoder.Decode(vlog)
+	require.NoError(t, err)
+	typed := decoded.TypedArgs()
+	assert.Equal(t, event.TypedArg{Type: "bytes32", Value: txHash.Hex()}, typed["txHash"])
+	assert.Equal(t, event.TypedArg{Type: "address", Value: to.Hex()}, typed["toAddress"])
+
+	data, err := (&bridge.Deposit{}).Data(vlog)
+	require.NoError(t, err)
+	var positional bridge.Deposit
+	require.NoError(t, positional.ToObj(data))
+	assert.Equal(t, txHash, positional.TxHash)
+	assert.Equal(t, to.Hex(), positional.ToAddress)
+}
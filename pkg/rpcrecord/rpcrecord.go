@@ -0,0 +1,334 @@
+// Package rpcrecord records the http traffic of the rpc clients to a file and replays it,
+// so what the indexer and the bridge saw can be reproduced without a live node.
+//
+// The file holds one json Exchange per line. On replay a request is answered with the
+// first exchange not replayed yet of its source and method whose request matches, the
+// params and the url query, ignoring the json-rpc id. The id of the response is
+// rewritten to the id of the request.
+package rpcrecord
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	// SourceAbec abec node json-rpc
+	SourceAbec = "abec"
+	// SourceVault vault abewallet json-rpc
+	SourceVault = "vault"
+	// SourceEVM rollup evm json-rpc
+	SourceEVM = "evm"
+	// SourceAA aa address api
+	SourceAA = "aa"
+)
+
+var (
+	// ErrExhausted no recorded exchange left for the request
+	ErrExhausted = errors.New("rpcrecord: recorded exchanges exhausted")
+	// ErrMismatch exchanges of the method left, none recorded for the request params
+	ErrMismatch = errors.New("rpcrecord: request does not match the recording")
+)
+
+// Exchange one recorded request and its response
+type Exchange struct {
+	Source string `json:"source"`
+	// Method json-rpc method, comma joined for a batch, the url path for a plain request
+	Method  string `json:"method"`
+	Request string `json:"request"`
+	// Query url query of the request
+	Query    string `json:"query,omitempty"`
+	Status   int    `json:"status"`
+	Response string `json:"response"`
+}
+
+// Recorder writes the exchanges of its clients
+type Recorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewRecorder new recorder writing to w
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+// Client http client recording its exchanges under source, sent with next, nil is http.DefaultTransport
+func (r *Recorder) Client(source string, next http.RoundTripper) *http.Client {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &http.Client{Transport: &recordTransport{source: source, next: next, rec: r}}
+}
+
+func (r *Recorder) write(ex *Exchange) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enc.Encode(ex)
+}
+
+type recordTransport struct {
+	source string
+	next   http.RoundTripper
+	rec    *Recorder
+}
+
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readBody(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	out := req.Clone(req.Context())
+	if reqBody != nil {
+		out.Body = io.NopCloser(bytes.NewReader(reqBody))
+	}
+	resp, err := t.next.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := readBody(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	err = t.rec.write(&Exchange{
+		Source:   t.source,
+		Method:   methodOf(req, reqBody),
+		Request:  string(reqBody),
+		Query:    req.URL.RawQuery,
+		Status:   resp.StatusCode,
+		Response: string(respBody),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("rpcrecord: write exchange: %w", err)
+	}
+	return resp, nil
+}
+
+// Replayer answers requests with the recorded exchanges
+type Replayer struct {
+	mu sync.Mutex
+	// queues source and method -> exchanges not replayed yet
+	queues    map[string][]*Exchange
+	remaining int
+	// err first request the recording could not answer
+	err      error
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+// Load read the exchanges written by a Recorder
+func Load(r io.Reader) (*Replayer, error) {
+	p := &Replayer{
+		queues: make(map[string][]*Exchange),
+		done:   make(chan struct{}),
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		ex := &Exchange{}
+		if err := json.Unmarshal(scanner.Bytes(), ex); err != nil {
+			return nil, fmt.Errorf("rpcrecord: line %d: %w", line, err)
+		}
+		key := queueKey(ex.Source, ex.Method)
+		p.queues[key] = append(p.queues[key], ex)
+		p.remaining++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Client http client answered with the exchanges recorded under source
+func (p *Replayer) Client(source string) *http.Client {
+	return &http.Client{Transport: &replayTransport{source: source, replayer: p}}
+}
+
+// Remaining number of exchanges not replayed yet
+func (p *Replayer) Remaining() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.remaining
+}
+
+// Done closed once every exchange is replayed or a request finds none to answer it
+func (p *Replayer) Done() <-chan struct{} {
+	return p.done
+}
+
+// Err first request the recording could not answer, nil while every request was answered
+func (p *Replayer) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+func (p *Replayer) next(source, method, query string, body []byte) (*Exchange, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := queueKey(source, method)
+	queue := p.queues[key]
+	if len(queue) == 0 {
+		return nil, p.fail(fmt.Errorf("%w: %s %s", ErrExhausted, source, method))
+	}
+	params := paramsOf(body)
+	for i, ex := range queue {
+		if ex.Query != query || paramsOf([]byte(ex.Request)) != params {
+			continue
+		}
+		p.queues[key] = append(queue[:i:i], queue[i+1:]...)
+		p.remaining--
+		if p.remaining == 0 {
+			p.doneOnce.Do(func() { close(p.done) })
+		}
+		return ex, nil
+	}
+	return nil, p.fail(fmt.Errorf("%w: %s %s, query %q, request %s", ErrMismatch, source, method, query, body))
+}
+
+// fail record err and stop the replay, the caller holds mu
+func (p *Replayer) fail(err error) error {
+	if p.err == nil {
+		p.err = err
+	}
+	p.doneOnce.Do(func() { close(p.done) })
+	return err
+}
+
+type replayTransport struct {
+	source   string
+	replayer *Replayer
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readBody(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	ex, err := t.replayer.next(t.source, methodOf(req, reqBody), req.URL.RawQuery, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	body := rewriteIDs(reqBody, []byte(ex.Response))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", ex.Status, http.StatusText(ex.Status)),
+		StatusCode:    ex.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+type rpcMessage struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+}
+
+// methodOf json-rpc method of the request body, the url path for other requests
+func methodOf(req *http.Request, body []byte) string {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var batch []rpcMessage
+		if err := json.Unmarshal(trimmed, &batch); err == nil {
+			methods := make([]string, 0, len(batch))
+			for _, msg := range batch {
+				methods = append(methods, msg.Method)
+			}
+			return strings.Join(methods, ",")
+		}
+	}
+	var msg rpcMessage
+	if err := json.Unmarshal(trimmed, &msg); err == nil && msg.Method != "" {
+		return msg.Method
+	}
+	return req.URL.Path
+}
+
+// paramsOf the request body without the json-rpc ids, the body itself when it is not json
+func paramsOf(body []byte) string {
+	trimmed := bytes.TrimSpace(body)
+	var msg interface{}
+	if err := json.Unmarshal(trimmed, &msg); err != nil {
+		return string(trimmed)
+	}
+	switch v := msg.(type) {
+	case map[string]interface{}:
+		delete(v, "id")
+	case []interface{}:
+		for _, item := range v {
+			if m, ok := item.(map[string]interface{}); ok {
+				delete(m, "id")
+			}
+		}
+	}
+	// map keys marshal sorted
+	params, err := json.Marshal(msg)
+	if err != nil {
+		return string(trimmed)
+	}
+	return string(params)
+}
+
+// rewriteIDs set the ids of the recorded response to the ids of the request, in order for a batch
+func rewriteIDs(reqBody, respBody []byte) []byte {
+	reqBody, respBody = bytes.TrimSpace(reqBody), bytes.TrimSpace(respBody)
+	if len(reqBody) == 0 || len(respBody) == 0 || reqBody[0] != respBody[0] {
+		return respBody
+	}
+	if reqBody[0] == '[' {
+		var reqs []rpcMessage
+		var resps []map[string]json.RawMessage
+		if json.Unmarshal(reqBody, &reqs) != nil || json.Unmarshal(respBody, &resps) != nil || len(reqs) != len(resps) {
+			return respBody
+		}
+		for i := range resps {
+			if reqs[i].ID != nil {
+				resps[i]["id"] = reqs[i].ID
+			}
+		}
+		rewritten, err := json.Marshal(resps)
+		if err != nil {
+			return respBody
+		}
+		return rewritten
+	}
+	var req rpcMessage
+	var resp map[string]json.RawMessage
+	if json.Unmarshal(reqBody, &req) != nil || req.ID == nil || json.Unmarshal(respBody, &resp) != nil {
+		return respBody
+	}
+	resp["id"] = req.ID
+	rewritten, err := json.Marshal(resp)
+	if err != nil {
+		return respBody
+	}
+	return rewritten
+}
+
+// readBody read and close the body
+func readBody(body io.ReadCloser) ([]byte, error) {
+	if body == nil || body == http.NoBody {
+		return nil, nil
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+func queueKey(source, method string) string {
+	return source + " " + method
+}
@@ -0,0 +1,146 @@
+package rpcrecord
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func call(t *testing.T, client *http.Client, url, body string) (int, string, error) {
+	t.Helper()
+	resp, err := client.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(data), nil
+}
+
+func TestRecordReplay(t *testing.T) {
+	count := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		count++
+		fmt.Fprintf(w, `{"id":%s,"result":%d}`, req.ID, count)
+	}))
+	defer server.Close()
+
+	var file bytes.Buffer
+	recorder := NewRecorder(&file)
+	abec := recorder.Client(SourceAbec, nil)
+	evm := recorder.Client(SourceEVM, nil)
+
+	_, body, err := call(t, abec, server.URL, `{"id":"a","method":"getblockcount"}`)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":"a","result":1}`, body)
+	_, _, err = call(t, evm, server.URL, `{"id":1,"method":"eth_chainId"}`)
+	require.NoError(t, err)
+	_, body, err = call(t, abec, server.URL, `{"id":"b","method":"getblockcount"}`)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":"b","result":3}`, body)
+
+	replayer, err := Load(&file)
+	require.NoError(t, err)
+	assert.Equal(t, 3, replayer.Remaining())
+
+	// the sources replay independently, the ids follow the request
+	_, body, err = call(t, replayer.Client(SourceAbec), "http://replay", `{"id":"x","method":"getblockcount"}`)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":"x","result":1}`, body)
+	_, body, err = call(t, replayer.Client(SourceAbec), "http://replay", `{"id":"y","method":"getblockcount"}`)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":"y","result":3}`, body)
+
+	_, _, err = call(t, replayer.Client(SourceAbec), "http://replay", `{"id":"z","method":"getblockcount"}`)
+	assert.True(t, errors.Is(err, ErrExhausted))
+	select {
+	case <-replayer.Done():
+	default:
+		t.Fatal("replayer not done after a request found no exchange")
+	}
+
+	status, body, err := call(t, replayer.Client(SourceEVM), "http://replay", `{"id":7,"method":"eth_chainId"}`)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"id":7,"result":2}`, body)
+	assert.Equal(t, 0, replayer.Remaining())
+}
+
+func TestRewriteIDsBatch(t *testing.T) {
+	body := rewriteIDs(
+		[]byte(`[{"id":5,"method":"a"},{"id":6,"method":"b"}]`),
+		[]byte(`[{"id":1,"result":"x"},{"id":2,"result":"y"}]`),
+	)
+	assert.JSONEq(t, `[{"id":5,"result":"x"},{"id":6,"result":"y"}]`, string(body))
+
+	// not json-rpc, left untouched
+	assert.Equal(t, "plain", string(rewriteIDs(nil, []byte("plain"))))
+}
+
+func TestReplayMatchesParams(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     json.RawMessage   `json:"id"`
+			Params []json.RawMessage `json:"params"`
+		}
+		if r.Method == http.MethodGet {
+			fmt.Fprintf(w, `{"hash":%q}`, r.URL.Query().Get("hash"))
+			return
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		fmt.Fprintf(w, `{"id":%s,"result":%s}`, req.ID, req.Params[0])
+	}))
+	defer server.Close()
+
+	var file bytes.Buffer
+	recorder := NewRecorder(&file)
+	abec := recorder.Client(SourceAbec, nil)
+	_, _, err := call(t, abec, server.URL, `{"id":1,"method":"getblockhash","params":[10]}`)
+	require.NoError(t, err)
+	_, _, err = call(t, abec, server.URL, `{"id":2,"method":"getblockhash","params":[11]}`)
+	require.NoError(t, err)
+	resp, err := recorder.Client(SourceAA, nil).Get(server.URL + "/api/bridge/hash?hash=a")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	replayer, err := Load(&file)
+	require.NoError(t, err)
+
+	// answered by params, not by the recorded order
+	_, body, err := call(t, replayer.Client(SourceAbec), "http://replay", `{"id":"x","method":"getblockhash","params":[11]}`)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":"x","result":11}`, body)
+
+	// a url query not recorded fails the replay
+	_, err = replayer.Client(SourceAA).Get("http://replay/api/bridge/hash?hash=b")
+	assert.True(t, errors.Is(err, ErrMismatch))
+	assert.True(t, errors.Is(replayer.Err(), ErrMismatch))
+	select {
+	case <-replayer.Done():
+	default:
+		t.Fatal("replayer not done after a mismatched request")
+	}
+
+	// params not recorded
+	_, _, err = call(t, replayer.Client(SourceAbec), "http://replay", `{"id":"y","method":"getblockhash","params":[12]}`)
+	assert.True(t, errors.Is(err, ErrMismatch))
+	_, body, err = call(t, replayer.Client(SourceAbec), "http://replay", `{"id":"z","method":"getblockhash","params":[10]}`)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":"z","result":10}`, body)
+	assert.Equal(t, 1, replayer.Remaining())
+}